@@ -0,0 +1,85 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auth provides the middleware protecting the API with a basic auth or a bearer token.
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/perses/metrics-usage/config"
+)
+
+const apiPrefix = "/api/"
+
+// Middleware returns a middleware checking that the requests sent to the API are coming from one of the configured users,
+// and that the user has been granted the scope required by the request.
+// The GET and HEAD requests require the scope read, the other ones the scope write.
+// The routes outside the API, like the one exposing the Prometheus metrics, are not protected.
+func Middleware(cfg config.APIAuth) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			if !strings.HasPrefix(req.URL.Path, apiPrefix) {
+				return next(ctx)
+			}
+			user := authenticate(cfg.Users, req)
+			if user == nil {
+				ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="metrics-usage"`)
+				return ctx.JSON(http.StatusUnauthorized, echo.Map{"message": "missing or invalid credentials"})
+			}
+			scope := requiredScope(req.Method)
+			if !user.HasScope(scope) {
+				return ctx.JSON(http.StatusForbidden, echo.Map{"message": "the scope " + string(scope) + " is required"})
+			}
+			return next(ctx)
+		}
+	}
+}
+
+func requiredScope(method string) config.AuthScope {
+	if method == http.MethodGet || method == http.MethodHead {
+		return config.ReadScope
+	}
+	return config.WriteScope
+}
+
+// authenticate returns the user matching the credentials of the request, or nil if there is none.
+func authenticate(users []*config.APIUser, req *http.Request) *config.APIUser {
+	if username, password, ok := req.BasicAuth(); ok {
+		for _, user := range users {
+			if len(user.Username) > 0 && secureEqual(user.Username, username) && secureEqual(user.Password, password) {
+				return user
+			}
+		}
+		return nil
+	}
+	authorization := req.Header.Get(echo.HeaderAuthorization)
+	token, found := strings.CutPrefix(authorization, "Bearer ")
+	if !found || len(token) == 0 {
+		return nil
+	}
+	for _, user := range users {
+		if len(user.Token) > 0 && secureEqual(user.Token, token) {
+			return user
+		}
+	}
+	return nil
+}
+
+func secureEqual(expected string, actual string) bool {
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(actual)) == 1
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/perses/metrics-usage/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Use(Middleware(config.APIAuth{
+		Users: []*config.APIUser{
+			{Username: "admin", Password: "secret", Scopes: []config.AuthScope{config.ReadScope, config.WriteScope}},
+			{Token: "reader-token", Scopes: []config.AuthScope{config.ReadScope}},
+			{Token: "writer-token", Scopes: []config.AuthScope{config.WriteScope}},
+		},
+	}))
+	ok := func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+	e.GET("/api/v1/metrics", ok)
+	e.POST("/api/v1/metrics", ok)
+	e.GET("/metrics", ok)
+
+	testSuite := []struct {
+		title         string
+		method        string
+		path          string
+		authorization func(req *http.Request)
+		status        int
+	}{
+		{
+			title:  "no credentials",
+			method: http.MethodGet,
+			path:   "/api/v1/metrics",
+			status: http.StatusUnauthorized,
+		},
+		{
+			title:  "route outside the API",
+			method: http.MethodGet,
+			path:   "/metrics",
+			status: http.StatusOK,
+		},
+		{
+			title:  "basic auth",
+			method: http.MethodPost,
+			path:   "/api/v1/metrics",
+			authorization: func(req *http.Request) {
+				req.SetBasicAuth("admin", "secret")
+			},
+			status: http.StatusOK,
+		},
+		{
+			title:  "wrong password",
+			method: http.MethodGet,
+			path:   "/api/v1/metrics",
+			authorization: func(req *http.Request) {
+				req.SetBasicAuth("admin", "wrong")
+			},
+			status: http.StatusUnauthorized,
+		},
+		{
+			title:  "token with the scope read",
+			method: http.MethodGet,
+			path:   "/api/v1/metrics",
+			authorization: func(req *http.Request) {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer reader-token")
+			},
+			status: http.StatusOK,
+		},
+		{
+			title:  "token without the scope write",
+			method: http.MethodPost,
+			path:   "/api/v1/metrics",
+			authorization: func(req *http.Request) {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer reader-token")
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			title:  "token without the scope read",
+			method: http.MethodGet,
+			path:   "/api/v1/metrics",
+			authorization: func(req *http.Request) {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer writer-token")
+			},
+			status: http.StatusForbidden,
+		},
+		{
+			title:  "unknown token",
+			method: http.MethodGet,
+			path:   "/api/v1/metrics",
+			authorization: func(req *http.Request) {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer unknown")
+			},
+			status: http.StatusUnauthorized,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			if test.authorization != nil {
+				test.authorization(req)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package check verifies the metric hygiene, like in a CI pipeline: the number of unused metrics
+// and the metrics used by some dashboards but not existing.
+package check
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/perses/metrics-usage/pkg/analyze/grafana"
+	persesAnalyzer "github.com/perses/metrics-usage/pkg/analyze/perses"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	persesV1 "github.com/perses/perses/pkg/model/api/v1"
+	"gopkg.in/yaml.v2"
+)
+
+// Options are the thresholds of the check.
+type Options struct {
+	// MaxUnused is the maximum number of unused metrics accepted. A negative value disables the check.
+	MaxUnused int
+	// FailOnMissing makes the check fail when a dashboard uses a metric that doesn't exist.
+	FailOnMissing bool
+	// Dashboards is the list of the Grafana or Perses dashboard files to analyze.
+	Dashboards []string
+}
+
+// Result is the summary of the check.
+type Result struct {
+	Unused []string
+	// Missing is the list of the metrics not existing, per dashboard file.
+	Missing map[string][]string
+	// Warnings are the queries of the dashboards that couldn't be analyzed. The metrics they use are not checked.
+	Warnings []string
+	// Failures is the list of the thresholds violated. The check passes when it is empty.
+	Failures []string
+}
+
+// Run checks the metrics against the thresholds. The dashboards that cannot be read are returned as an error.
+func Run(metrics map[string]*modelAPIV1.Metric, opts Options) (*Result, error) {
+	result := &Result{Missing: make(map[string][]string)}
+	for name, metric := range metrics {
+		if metric.Usage == nil {
+			result.Unused = append(result.Unused, name)
+		}
+	}
+	slices.Sort(result.Unused)
+	if opts.MaxUnused >= 0 && len(result.Unused) > opts.MaxUnused {
+		result.Failures = append(result.Failures, fmt.Sprintf("%d metrics are unused, the maximum is %d", len(result.Unused), opts.MaxUnused))
+	}
+	for _, file := range opts.Dashboards {
+		used, errs, err := analyzeFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the dashboard in the file %q: %w", file, err)
+		}
+		for _, logErr := range errs {
+			if logErr.Error != nil {
+				result.Warnings = append(result.Warnings, fmt.Sprintf("%s: %s", logErr.Message, logErr.Error))
+			}
+		}
+		for name := range used {
+			if _, exists := metrics[name]; !exists {
+				result.Missing[file] = append(result.Missing[file], name)
+			}
+		}
+		slices.Sort(result.Missing[file])
+		if opts.FailOnMissing && len(result.Missing[file]) > 0 {
+			result.Failures = append(result.Failures, fmt.Sprintf("the dashboard in the file %q uses %d metrics not existing", file, len(result.Missing[file])))
+		}
+	}
+	return result, nil
+}
+
+// Print writes the summary of the check.
+func (r *Result) Print(w io.Writer) {
+	fmt.Fprintf(w, "unused metrics: %d\n", len(r.Unused))
+	for _, file := range slices.Sorted(maps.Keys(r.Missing)) {
+		for _, name := range r.Missing[file] {
+			fmt.Fprintf(w, "missing metric %q used in the file %q\n", name, file)
+		}
+	}
+	for _, warning := range r.Warnings {
+		fmt.Fprintf(w, "WARNING: %s\n", warning)
+	}
+	for _, failure := range r.Failures {
+		fmt.Fprintf(w, "FAIL: %s\n", failure)
+	}
+	if len(r.Failures) == 0 {
+		fmt.Fprintln(w, "OK")
+	}
+}
+
+// FetchMetrics returns the metrics of a running instance of metrics-usage.
+func FetchMetrics(instanceURL string) (map[string]*modelAPIV1.Metric, error) {
+	u, err := url.Parse(instanceURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, "/api/v1/metrics")
+	httpClient := &http.Client{Timeout: time.Minute}
+	resp, err := httpClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("when listing the metrics, unexpected status code: %d", resp.StatusCode)
+	}
+	metrics := make(map[string]*modelAPIV1.Metric)
+	return metrics, json.NewDecoder(resp.Body).Decode(&metrics)
+}
+
+// analyzeFile returns the metrics used by the dashboard in the file. The partial metrics are ignored, as they cannot be missing.
+// The YAML files are read as Perses dashboards, the JSON files as Perses dashboards when they have the kind Dashboard, as Grafana dashboards otherwise.
+func analyzeFile(file string) (modelAPIV1.Set[string], []*modelAPIV1.LogError, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch filepath.Ext(file) {
+	case ".yaml", ".yml":
+		dash := &persesV1.Dashboard{}
+		if err := yaml.Unmarshal(data, dash); err != nil {
+			return nil, nil, err
+		}
+		metrics, _, errs := persesAnalyzer.Analyze(dash)
+		return metrics, errs, nil
+	case ".json":
+		var kind struct {
+			Kind string `json:"kind"`
+		}
+		if err := json.Unmarshal(data, &kind); err != nil {
+			return nil, nil, err
+		}
+		if kind.Kind == string(persesV1.KindDashboard) {
+			dash := &persesV1.Dashboard{}
+			if err := json.Unmarshal(data, dash); err != nil {
+				return nil, nil, err
+			}
+			metrics, _, errs := persesAnalyzer.Analyze(dash)
+			return metrics, errs, nil
+		}
+		dash := &grafana.SimplifiedDashboard{}
+		if err := json.Unmarshal(data, dash); err != nil {
+			return nil, nil, err
+		}
+		metrics, _, errs := grafana.Analyze(dash, nil)
+		return metrics, errs, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported file extension %q, only JSON and YAML files are supported", filepath.Ext(file))
+	}
+}
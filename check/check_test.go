@@ -0,0 +1,144 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const grafanaDashboard = `{
+  "uid": "api",
+  "title": "API",
+  "panels": [{"type": "timeseries", "targets": [{"expr": "sum(rate(http_requests_total[5m])) / sum(rate(http_requests_typo_total[5m]))"}]}]
+}`
+
+const persesDashboard = `kind: Dashboard
+metadata:
+  name: node
+  project: perses
+spec:
+  panels:
+    load:
+      kind: Panel
+      spec:
+        display:
+          name: load
+        plugin:
+          kind: TimeSeriesChart
+          spec: {}
+        queries:
+          - kind: TimeSeriesQuery
+            spec:
+              plugin:
+                kind: PrometheusTimeSeriesQuery
+                spec:
+                  query: node_load1
+`
+
+func writeFile(t *testing.T, name string, content string) string {
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func TestRun(t *testing.T) {
+	grafanaFile := writeFile(t, "api.json", grafanaDashboard)
+	persesFile := writeFile(t, "node.yaml", persesDashboard)
+	metrics := map[string]*modelAPIV1.Metric{
+		"http_requests_total": {Usage: &modelAPIV1.MetricUsage{Dashboards: modelAPIV1.NewSet(modelAPIV1.DashboardUsage{ID: "api"})}},
+		"node_load1":          {},
+		"node_load5":          {},
+	}
+	testSuite := []struct {
+		title            string
+		opts             Options
+		expectedFailures int
+	}{
+		{
+			title: "no threshold",
+			opts:  Options{MaxUnused: -1, Dashboards: []string{grafanaFile, persesFile}},
+		},
+		{
+			title:            "too many unused metrics",
+			opts:             Options{MaxUnused: 1},
+			expectedFailures: 1,
+		},
+		{
+			title: "unused metrics under the maximum",
+			opts:  Options{MaxUnused: 2},
+		},
+		{
+			title:            "missing metric",
+			opts:             Options{MaxUnused: -1, FailOnMissing: true, Dashboards: []string{grafanaFile, persesFile}},
+			expectedFailures: 1,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			result, err := Run(metrics, test.opts)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"node_load1", "node_load5"}, result.Unused)
+			assert.Len(t, result.Failures, test.expectedFailures)
+			if len(test.opts.Dashboards) > 0 {
+				assert.Equal(t, map[string][]string{grafanaFile: {"http_requests_typo_total"}}, result.Missing)
+			}
+		})
+	}
+}
+
+func TestRunUnsupportedFile(t *testing.T) {
+	file := writeFile(t, "dashboard.txt", "")
+	_, err := Run(nil, Options{Dashboards: []string{file}})
+	assert.EqualError(t, err, `unable to read the dashboard in the file "`+file+`": unsupported file extension ".txt", only JSON and YAML files are supported`)
+}
+
+func TestPrint(t *testing.T) {
+	result := &Result{
+		Unused:   []string{"node_load5"},
+		Missing:  map[string][]string{"api.json": {"http_requests_typo_total"}},
+		Failures: []string{"1 metrics are unused, the maximum is 0"},
+	}
+	buffer := &bytes.Buffer{}
+	result.Print(buffer)
+	assert.Equal(t, `unused metrics: 1
+missing metric "http_requests_typo_total" used in the file "api.json"
+FAIL: 1 metrics are unused, the maximum is 0
+`, buffer.String())
+}
+
+func TestFetchMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/metrics-usage/api/v1/metrics" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]*modelAPIV1.Metric{"up": {}})
+	}))
+	defer server.Close()
+	metrics, err := FetchMetrics(server.URL + "/metrics-usage")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*modelAPIV1.Metric{"up": {}}, metrics)
+	_, err = FetchMetrics(server.URL)
+	assert.EqualError(t, err, "when listing the metrics, unexpected status code: 404")
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/perses/metrics-usage/pkg/analyze/prometheus"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// EngineDifference is a query whose metrics are not the same when it is analyzed as PromQL and as MetricsQL.
+type EngineDifference struct {
+	Query     string
+	PromQL    EngineResult
+	MetricsQL EngineResult
+}
+
+// EngineResult is what an engine has extracted from a query. Error is set when the query is not valid for the engine.
+type EngineResult struct {
+	Metrics        []string
+	PartialMetrics []string
+	Error          string
+}
+
+func (r EngineResult) String() string {
+	if len(r.Error) > 0 {
+		return "error: " + r.Error
+	}
+	s := fmt.Sprintf("metrics [%s]", strings.Join(r.Metrics, ", "))
+	if len(r.PartialMetrics) > 0 {
+		s += fmt.Sprintf(", partial metrics [%s]", strings.Join(r.PartialMetrics, ", "))
+	}
+	return s
+}
+
+// CollectQueries returns the expressions of the dashboards and of the rules using the metrics, sorted and deduplicated.
+// The expressions of the dashboards are only known when their collector is recording them.
+func CollectQueries(metrics map[string]*modelAPIV1.Metric) []string {
+	queries := modelAPIV1.NewSet[string]()
+	for _, metric := range metrics {
+		if metric.Usage == nil {
+			continue
+		}
+		for dashboard := range metric.Usage.Dashboards {
+			if len(dashboard.Expression) > 0 {
+				queries.Add(dashboard.Expression)
+			}
+		}
+		for rule := range metric.Usage.RecordingRules {
+			queries.Add(rule.Expression)
+		}
+		for rule := range metric.Usage.AlertRules {
+			queries.Add(rule.Expression)
+		}
+	}
+	queries.Remove("")
+	result := queries.TransformAsSlice()
+	slices.Sort(result)
+	return result
+}
+
+// CompareEngines analyzes every query with the PromQL and the MetricsQL analyzers, and returns the queries where the metrics extracted differ.
+// A query valid for only one of them is a difference, as the other one doesn't extract anything from it.
+func CompareEngines(queries []string) []EngineDifference {
+	var result []EngineDifference
+	for _, query := range queries {
+		promQL := newEngineResult(prometheus.AnalyzePromQLExpression(query))
+		metricsQL := newEngineResult(prometheus.AnalyzeMetricsQLExpression(query))
+		if len(promQL.Error) > 0 && len(metricsQL.Error) > 0 {
+			// Nothing is extracted by any of them.
+			continue
+		}
+		if len(promQL.Error) == 0 && len(metricsQL.Error) == 0 && slices.Equal(promQL.Metrics, metricsQL.Metrics) && slices.Equal(promQL.PartialMetrics, metricsQL.PartialMetrics) {
+			continue
+		}
+		result = append(result, EngineDifference{Query: query, PromQL: promQL, MetricsQL: metricsQL})
+	}
+	return result
+}
+
+func newEngineResult(metrics modelAPIV1.Set[string], partialMetrics modelAPIV1.Set[string], err error) EngineResult {
+	if err != nil {
+		return EngineResult{Error: err.Error()}
+	}
+	result := EngineResult{Metrics: metrics.TransformAsSlice(), PartialMetrics: partialMetrics.TransformAsSlice()}
+	slices.Sort(result.Metrics)
+	slices.Sort(result.PartialMetrics)
+	return result
+}
+
+// PrintEngineDifferences writes the queries where the engines disagree, with what each of them has extracted.
+func PrintEngineDifferences(w io.Writer, queries int, differences []EngineDifference) {
+	fmt.Fprintf(w, "queries compared: %d, differences: %d\n", queries, len(differences))
+	for _, difference := range differences {
+		fmt.Fprintf(w, "query %q\n  PromQL: %s\n  MetricsQL: %s\n", difference.Query, difference.PromQL, difference.MetricsQL)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package check
+
+import (
+	"bytes"
+	"testing"
+
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompareEngines(t *testing.T) {
+	testSuite := []struct {
+		title    string
+		query    string
+		expected []EngineDifference
+	}{
+		{
+			title: "same metrics for both engines",
+			query: `sum by (job) (rate(http_requests_total{job="api"}[5m]))`,
+		},
+		{
+			title: "same partial metrics for both engines",
+			query: `{__name__=~"node_cpu_.+"}`,
+		},
+		{
+			title: "MetricsQL only function",
+			query: `label_set(sum(rate(http_requests_total[5m])), "env", "prod")`,
+			expected: []EngineDifference{{
+				Query:     `label_set(sum(rate(http_requests_total[5m])), "env", "prod")`,
+				PromQL:    EngineResult{Error: `1:1: parse error: unknown function with name "label_set"`},
+				MetricsQL: EngineResult{Metrics: []string{"http_requests_total"}},
+			}},
+		},
+		{
+			title: "invalid for both engines",
+			query: `sum(rate(http_requests_total[5m])`,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.expected, CompareEngines([]string{test.query}))
+		})
+	}
+}
+
+func TestCollectQueries(t *testing.T) {
+	metrics := map[string]*modelAPIV1.Metric{
+		"up": {Usage: &modelAPIV1.MetricUsage{
+			Dashboards: modelAPIV1.NewSet(
+				modelAPIV1.DashboardUsage{ID: "a", Expression: "sum(up)"},
+				modelAPIV1.DashboardUsage{ID: "b"},
+			),
+			AlertRules: modelAPIV1.NewSet(modelAPIV1.RuleUsage{Name: "down", Expression: "up == 0"}),
+		}},
+		"node_load1": {Usage: &modelAPIV1.MetricUsage{
+			RecordingRules: modelAPIV1.NewSet(modelAPIV1.RuleUsage{Name: "load", Expression: "sum(up) by (job) + node_load1"}),
+			AlertRules:     modelAPIV1.NewSet(modelAPIV1.RuleUsage{Name: "down", Expression: "up == 0"}),
+		}},
+		"node_load5": {},
+	}
+	assert.Equal(t, []string{"sum(up)", "sum(up) by (job) + node_load1", "up == 0"}, CollectQueries(metrics))
+}
+
+func TestPrintEngineDifferences(t *testing.T) {
+	differences := CompareEngines([]string{`label_del(up, "instance")`, "up"})
+	require.Len(t, differences, 1)
+	buffer := &bytes.Buffer{}
+	PrintEngineDifferences(buffer, 2, differences)
+	assert.Equal(t, `queries compared: 2, differences: 1
+query "label_del(up, \"instance\")"
+  PromQL: error: 1:1: parse error: unknown function with name "label_del"
+  MetricsQL: metrics [up]
+`, buffer.String())
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"gopkg.in/yaml.v3"
+)
+
+// Classification defines the rules used to flag a metric as internal.
+// Internal metrics are still stored, but they can be hidden when listing the metrics.
+type Classification struct {
+	// InternalPrefixes is the list of prefixes of the internal metrics.
+	InternalPrefixes []string `yaml:"internal_prefixes,omitempty"`
+	// InternalRegexps is the list of regexps matching the internal metrics.
+	InternalRegexps []common.Regexp `yaml:"internal_regexps,omitempty"`
+	// RuntimeMetricsAsInternal flags as internal the metrics exposed by the Prometheus client libraries (like the Go runtime or the process metrics)
+	// and the metrics generated by Prometheus when scraping a target.
+	RuntimeMetricsAsInternal bool `yaml:"runtime_metrics_as_internal,omitempty"`
+	// OwnershipFile is the path to a YAML file mapping the metric prefixes to the team owning them.
+	// The owner of a metric is the team of the longest prefix matching its name.
+	OwnershipFile string `yaml:"ownership_file,omitempty"`
+}
+
+func (c *Classification) Verify() error {
+	var errs verifyErrors
+	if len(c.OwnershipFile) > 0 {
+		if _, err := LoadOwnership(c.OwnershipFile); err != nil {
+			errs.add("ownership_file", err.Error())
+		}
+	}
+	return errs.err()
+}
+
+// LoadOwnership reads the YAML file mapping the metric prefixes to their owner.
+func LoadOwnership(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ownership := make(map[string]string)
+	if err := yaml.Unmarshal(data, &ownership); err != nil {
+		return nil, fmt.Errorf("unable to decode the ownership file %q: %w", path, err)
+	}
+	for prefix, owner := range ownership {
+		if len(owner) == 0 {
+			return nil, fmt.Errorf("the owner of the prefix %q is empty", prefix)
+		}
+	}
+	return ownership, nil
+}
@@ -17,70 +17,156 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"maps"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/perses/perses/pkg/client/config"
 	"github.com/perses/perses/pkg/model/api/v1/common"
 	"github.com/perses/perses/pkg/model/api/v1/secret"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
 
 const (
 	defaultMetricCollectorPeriodDuration = 12 * time.Hour
-	connectionTimeout                    = 30 * time.Second
+	defaultConnectionTimeout             = 30 * time.Second
+	defaultCollectorRunTimeout           = 10 * time.Minute
+	requestIDHeader                      = "X-Request-ID"
 )
 
+// defaultGrafanaDeepScanPaths are the parts of a panel where the panel plugins are usually storing their custom settings.
+var defaultGrafanaDeepScanPaths = []string{"options", "fieldConfig.defaults.custom"}
+
+// tokenSourceKey identifies an OAuth2 client-credentials flow.
+// Collectors sharing the same key are talking to the same issuer with the same identity and can reuse the same token.
+// The secret is part of the key, so a collector with a wrong or a rotated secret doesn't get the token obtained by another one.
+// The settings of the transport are also part of the key, so the token is always requested with the TLS configuration,
+// the proxy and the timeout of the collector asking for it, and not with the ones of the first collector.
+type tokenSourceKey struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       string
+	tlsConfig    secret.TLSConfig
+	proxyURL     string
+	timeout      time.Duration
+	userAgent    string
+}
+
+var (
+	tokenSourcesMutex sync.Mutex
+	tokenSources      = make(map[tokenSourceKey]oauth2.TokenSource)
+)
+
+// sharedTokenSource returns a cached token source for the given OAuth configuration.
+// The first call for a given tokenSourceKey creates the token source, the following ones reuse it.
+// Like that, multiple collectors hitting the same OAuth-protected endpoint don't multiply the token requests.
+// The token is requested with its own transport, identified by the component "oauth" instead of the collector that asked first.
+func sharedTokenSource(cfg HTTPClient, timeout time.Duration) (oauth2.TokenSource, error) {
+	oauthConfig := &clientcredentials.Config{
+		ClientID:     cfg.OAuth.ClientID,
+		ClientSecret: cfg.OAuth.ClientSecret,
+		TokenURL:     cfg.OAuth.TokenURL,
+		Scopes:       cfg.OAuth.Scopes,
+		AuthStyle:    cfg.OAuth.AuthStyle,
+	}
+	scopes := slices.Clone(oauthConfig.Scopes)
+	slices.Sort(scopes)
+	key := tokenSourceKey{
+		tokenURL:     oauthConfig.TokenURL,
+		clientID:     oauthConfig.ClientID,
+		clientSecret: oauthConfig.ClientSecret,
+		scopes:       strings.Join(scopes, " "),
+		timeout:      timeout,
+		userAgent:    cfg.UserAgent,
+	}
+	if cfg.TLSConfig != nil {
+		key.tlsConfig = *cfg.TLSConfig
+	}
+	if cfg.ProxyURL != nil {
+		key.proxyURL = cfg.ProxyURL.String()
+	}
+	tokenSourcesMutex.Lock()
+	defer tokenSourcesMutex.Unlock()
+	if ts, ok := tokenSources[key]; ok {
+		return ts, nil
+	}
+	roundTripper, err := newRoundTripper(cfg, "oauth", timeout)
+	if err != nil {
+		return nil, err
+	}
+	// The context is used by the OAuth client to get the token, so it must also use the TLS configuration.
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
+		Transport: roundTripper,
+		Timeout:   timeout,
+	})
+	ts := oauth2.ReuseTokenSource(nil, oauthConfig.TokenSource(ctx))
+	tokenSources[key] = ts
+	return ts, nil
+}
+
 type HTTPClient struct {
 	URL           *common.URL           `yaml:"url"`
 	OAuth         *config.OAuth         `yaml:"oauth,omitempty"`
 	BasicAuth     *secret.BasicAuth     `yaml:"basic_auth,omitempty"`
 	Authorization *secret.Authorization `yaml:"authorization,omitempty"`
 	TLSConfig     *secret.TLSConfig     `yaml:"tls_config,omitempty"`
+	// Timeout is the maximum duration of a request, including the time to read the response.
+	// Default to 30 seconds.
+	Timeout model.Duration `yaml:"timeout,omitempty"`
+	// UserAgent overrides the User-Agent header sent with every request.
+	// Default to metrics-usage/<version> (<component>).
+	UserAgent string `yaml:"user_agent,omitempty"`
+	// ProxyURL is the proxy used to send every request. The schemes http, https and socks5 are supported.
+	// Default to the proxy given by the environment variables HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+	ProxyURL *common.URL `yaml:"proxy_url,omitempty"`
 }
 
-func NewHTTPClient(cfg HTTPClient) (*http.Client, error) {
-	roundTripper, err := config.NewRoundTripper(connectionTimeout, cfg.TLSConfig)
+// NewHTTPClient returns a client using the given configuration.
+// The component is the part of metrics-usage using the client, like the name of the collector. It is sent in the User-Agent header.
+func NewHTTPClient(cfg HTTPClient, component string) (*http.Client, error) {
+	connectionTimeout := time.Duration(cfg.Timeout)
+	if connectionTimeout <= 0 {
+		connectionTimeout = defaultConnectionTimeout
+	}
+	roundTripper, err := newRoundTripper(cfg, component, connectionTimeout)
 	if err != nil {
 		return nil, err
 	}
-	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
-		Transport: roundTripper,
-		Timeout:   connectionTimeout,
-	})
 	if cfg.OAuth != nil {
-		oauthConfig := &clientcredentials.Config{
-			ClientID:     cfg.OAuth.ClientID,
-			ClientSecret: cfg.OAuth.ClientSecret,
-			TokenURL:     cfg.OAuth.TokenURL,
-			Scopes:       cfg.OAuth.Scopes,
-			AuthStyle:    cfg.OAuth.AuthStyle,
+		tokenSource, tokenSourceErr := sharedTokenSource(cfg, connectionTimeout)
+		if tokenSourceErr != nil {
+			return nil, tokenSourceErr
 		}
-		return oauthConfig.Client(ctx), nil
+		return newAuthenticatedHTTPClient(roundTripper, connectionTimeout, tokenSource), nil
 	}
 	if cfg.BasicAuth != nil {
-		c := oauth2.Config{}
 		password, getPasswordErr := cfg.BasicAuth.GetPassword()
 		if getPasswordErr != nil {
 			return nil, getPasswordErr
 		}
-		return c.Client(ctx, &oauth2.Token{
+		return newAuthenticatedHTTPClient(roundTripper, connectionTimeout, oauth2.StaticTokenSource(&oauth2.Token{
 			AccessToken: base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", cfg.BasicAuth.Username, password))),
 			TokenType:   "basic",
-		}), nil
+		})), nil
 	}
 	if cfg.Authorization != nil {
-		c := oauth2.Config{}
 		credential, getCredentialErr := cfg.Authorization.GetCredentials()
 		if getCredentialErr != nil {
 			return nil, getCredentialErr
 		}
-		return c.Client(ctx, &oauth2.Token{
+		return newAuthenticatedHTTPClient(roundTripper, connectionTimeout, oauth2.StaticTokenSource(&oauth2.Token{
 			AccessToken: credential,
 			TokenType:   cfg.Authorization.Type,
-		}), nil
+		})), nil
 	}
 	return &http.Client{
 		Transport: roundTripper,
@@ -88,12 +174,135 @@ func NewHTTPClient(cfg HTTPClient) (*http.Client, error) {
 	}, nil
 }
 
+// newRoundTripper returns the transport using the TLS configuration and the proxy, and identifying every request sent by the component.
+func newRoundTripper(cfg HTTPClient, component string, timeout time.Duration) (http.RoundTripper, error) {
+	tlsRoundTripper, err := config.NewRoundTripper(timeout, cfg.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.ProxyURL != nil {
+		if err = setProxy(tlsRoundTripper, cfg.ProxyURL.URL); err != nil {
+			return nil, err
+		}
+	}
+	return &identifiedRoundTripper{
+		base:      tlsRoundTripper,
+		userAgent: userAgent(cfg, component),
+	}, nil
+}
+
+// setProxy replaces the proxy of the transport, taken by default from the environment.
+func setProxy(roundTripper http.RoundTripper, proxyURL *url.URL) error {
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported scheme %q for the proxy URL, it must be http, https or socks5", proxyURL.Scheme)
+	}
+	transport, ok := roundTripper.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("unable to set the proxy on a transport of type %T", roundTripper)
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return nil
+}
+
+// newAuthenticatedHTTPClient returns a client adding the token to every request.
+// The round tripper holding the TLS configuration is always used as the base transport,
+// so the client certificate is still sent when an authentication is configured.
+func newAuthenticatedHTTPClient(roundTripper http.RoundTripper, timeout time.Duration, source oauth2.TokenSource) *http.Client {
+	return &http.Client{
+		Transport: &oauth2.Transport{
+			Base:   roundTripper,
+			Source: source,
+		},
+		Timeout: timeout,
+	}
+}
+
+func userAgent(cfg HTTPClient, component string) string {
+	if len(cfg.UserAgent) > 0 {
+		return cfg.UserAgent
+	}
+	v := version.Version
+	if len(v) == 0 {
+		// the version is only set when the binary is built with the Makefile.
+		v = "dev"
+	}
+	return fmt.Sprintf("metrics-usage/%s (%s)", v, component)
+}
+
+// identifiedRoundTripper sets the User-Agent and a unique X-Request-ID on every request,
+// so the load generated by metrics-usage can be attributed in the access logs of the remote servers.
+type identifiedRoundTripper struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (rt *identifiedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it receives.
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", rt.userAgent)
+	if len(req.Header.Get(requestIDHeader)) == 0 {
+		req.Header.Set(requestIDHeader, uuid.NewString())
+	}
+	return rt.base.RoundTrip(req)
+}
+
+// MetricUsageClient is the client used to send the metrics usage to a remote metrics_usage server.
+type MetricUsageClient struct {
+	HTTPClient `yaml:",inline"`
+	// BatchSize is the maximum number of metrics sent in a single request. 0 means everything is sent in one request.
+	BatchSize uint `yaml:"batch_size,omitempty"`
+	// BatchConcurrency is the number of batches sent in parallel.
+	BatchConcurrency uint `yaml:"batch_concurrency,omitempty"`
+	// Retry is the number of times a batch is sent again when the server is not reachable, busy or failing.
+	// Between each retry, the client waits for the delay asked by the server, or first 1 second, then 2 seconds, then 3 seconds ...etc.
+	Retry uint `yaml:"retry,omitempty"`
+}
+
+func (c *MetricUsageClient) Verify() error {
+	if c.BatchConcurrency == 0 {
+		c.BatchConcurrency = 1
+	}
+	if c.Retry == 0 {
+		c.Retry = 3
+	}
+	return nil
+}
+
 type MetricCollector struct {
-	Enable     bool           `yaml:"enable"`
-	Period     model.Duration `yaml:"period,omitempty"`
-	HTTPClient HTTPClient     `yaml:"http_client"`
+	Enable bool           `yaml:"enable"`
+	Period model.Duration `yaml:"period,omitempty"`
+	// StartOffset delays the first run of the collector, to stagger the collectors sharing the same period.
+	StartOffset model.Duration `yaml:"start_offset,omitempty"`
+	// RunTimeout is the maximum duration of a run. When it is reached, the run is interrupted until the next period.
+	RunTimeout model.Duration `yaml:"run_timeout,omitempty"`
+	// Lookback is the time range queried to get the metrics. Default to the period.
+	// It can be larger than the period to find the metrics that are not scraped frequently.
+	Lookback model.Duration `yaml:"lookback,omitempty"`
+	// Agent is telling that the endpoint is a Prometheus running in agent mode, which cannot be queried.
+	// The metric names are then derived from the metadata API.
+	Agent bool `yaml:"agent,omitempty"`
+	// Discovery is the way the metric names are retrieved. Default to label_values, or to metadata in agent mode.
+	Discovery MetricDiscovery `yaml:"discovery,omitempty"`
+	// SeriesMatchers are the series selectors used with the discovery series. Default to {__name__=~".+"}.
+	SeriesMatchers []string   `yaml:"series_matchers,omitempty"`
+	HTTPClient     HTTPClient `yaml:"http_client"`
 }
 
+// MetricDiscovery is the Prometheus API used by the metric collector to get the metric names.
+// Depending on the backend, one can be much cheaper than the others on a large instance.
+type MetricDiscovery string
+
+const (
+	// LabelValuesDiscovery gets the values of the label __name__.
+	LabelValuesDiscovery MetricDiscovery = "label_values"
+	// SeriesDiscovery gets the series matching the series matchers, and keeps their name.
+	SeriesDiscovery MetricDiscovery = "series"
+	// MetadataDiscovery derives the metric names from the metric families returned by the metadata API.
+	MetadataDiscovery MetricDiscovery = "metadata"
+)
+
 func (c *MetricCollector) Verify() error {
 	if !c.Enable {
 		return nil
@@ -101,21 +310,113 @@ func (c *MetricCollector) Verify() error {
 	if c.Period <= 0 {
 		c.Period = model.Duration(defaultMetricCollectorPeriodDuration)
 	}
+	if c.RunTimeout <= 0 {
+		c.RunTimeout = model.Duration(defaultCollectorRunTimeout)
+	}
+	if c.Lookback <= 0 {
+		c.Lookback = c.Period
+	}
+	var errs verifyErrors
+	if len(c.Discovery) == 0 {
+		c.Discovery = LabelValuesDiscovery
+		if c.Agent {
+			c.Discovery = MetadataDiscovery
+		}
+	}
+	switch c.Discovery {
+	case LabelValuesDiscovery, SeriesDiscovery, MetadataDiscovery:
+		if c.Agent && c.Discovery != MetadataDiscovery {
+			errs.add("discovery", fmt.Sprintf("a Prometheus in agent mode cannot be queried, only the discovery %q is supported", MetadataDiscovery))
+		}
+	default:
+		errs.add("discovery", fmt.Sprintf("unknown discovery %q, it must be one of %q, %q or %q", c.Discovery, LabelValuesDiscovery, SeriesDiscovery, MetadataDiscovery))
+	}
+	if c.Discovery == SeriesDiscovery && len(c.SeriesMatchers) == 0 {
+		c.SeriesMatchers = []string{`{__name__=~".+"}`}
+	} else if c.Discovery != SeriesDiscovery && len(c.SeriesMatchers) > 0 {
+		errs.add("series_matchers", fmt.Sprintf("the series matchers can only be used with the discovery %q", SeriesDiscovery))
+	}
 	if c.HTTPClient.URL == nil {
-		return fmt.Errorf("missing Prometheus URL for the metric collector")
+		errs.add("http_client.url", "missing Prometheus URL for the metric collector")
+	}
+	return errs.err()
+}
+
+type RulesEngine string
+
+const (
+	PromQLEngine    RulesEngine = "promql"
+	MetricsQLEngine RulesEngine = "metricsql"
+)
+
+// Flavor is the Prometheus compatible backend serving the rules.
+// It selects the decoder of the rules API and the validation of the metric names.
+type Flavor string
+
+const (
+	PrometheusFlavor      Flavor = "prometheus"
+	ThanosFlavor          Flavor = "thanos"
+	CortexFlavor          Flavor = "cortex"
+	VictoriaMetricsFlavor Flavor = "victoriametrics"
+)
+
+func (f Flavor) verify() error {
+	switch f {
+	case PrometheusFlavor, ThanosFlavor, CortexFlavor, VictoriaMetricsFlavor:
+		return nil
+	default:
+		return fmt.Errorf("unknown flavor %q, it must be one of %q, %q, %q or %q", f, PrometheusFlavor, ThanosFlavor, CortexFlavor, VictoriaMetricsFlavor)
 	}
-	return nil
 }
 
 type RulesCollector struct {
 	Enable bool           `yaml:"enable"`
 	Period model.Duration `yaml:"period,omitempty"`
+	// StartOffset delays the first run of the collector, to stagger the collectors sharing the same period.
+	StartOffset model.Duration `yaml:"start_offset,omitempty"`
+	// RunTimeout is the maximum duration of a run. When it is reached, the run is interrupted until the next period.
+	RunTimeout model.Duration `yaml:"run_timeout,omitempty"`
 	// MetricUsageClient is a client to send the metrics usage to a remote metrics_usage server.
-	MetricUsageClient *HTTPClient `yaml:"metric_usage_client,omitempty"`
+	MetricUsageClient *MetricUsageClient `yaml:"metric_usage_client,omitempty"`
 	// RetryToGetRules is the number of retries the collector will do to get the rules from Prometheus before actually failing.
 	// Between each retry, the collector will wait first 10 seconds, then 20 seconds, then 30 seconds ...etc.
-	RetryToGetRules uint       `yaml:"retry_to_get_rules,omitempty"`
-	HTTPClient      HTTPClient `yaml:"prometheus_client"`
+	RetryToGetRules uint `yaml:"retry_to_get_rules,omitempty"`
+	// Engine is the engine evaluating the rules. It defines the format of the rules API.
+	// Use "metricsql" to get the rules from VictoriaMetrics vmalert.
+	Engine RulesEngine `yaml:"engine,omitempty"`
+	// Flavor is the backend serving the rules. It defaults to "victoriametrics" with the metricsql engine, to "prometheus" otherwise.
+	// Thanos and Cortex are serving the same rules API and accepting the same metric names as Prometheus.
+	Flavor Flavor `yaml:"flavor,omitempty"`
+	// Chunks is used to get the rules with multiple smaller requests, each one filtered on some rule groups or rule files.
+	// The rules not matching any chunk are not collected. It is only supported with the promql engine.
+	Chunks []RulesChunk `yaml:"chunks,omitempty"`
+	// GroupLimit is the maximum number of rule groups returned by a single request, the next ones are then fetched page by page.
+	// It requires a Prometheus supporting the pagination of the rules API. It is only supported with the promql engine.
+	GroupLimit uint `yaml:"group_limit,omitempty"`
+	// ExcludeGroups drops the rule groups whose name is matching this regexp. The regexp is not anchored.
+	ExcludeGroups *common.Regexp `yaml:"exclude_groups,omitempty"`
+	// ExcludeRules drops the alerting and recording rules whose name is matching this regexp. The regexp is not anchored.
+	ExcludeRules *common.Regexp `yaml:"exclude_rules,omitempty"`
+	// Reconcile makes every run replace the usage collected previously from the same Prometheus, instead of merging into it.
+	// Like that, the usage of the deleted rules is removed. It is not supported with metric_usage_client.
+	Reconcile bool `yaml:"reconcile,omitempty"`
+	// ReconcileWindow is how long a usage not collected anymore is kept before being removed. Default to 0, it is removed immediately.
+	ReconcileWindow model.Duration `yaml:"reconcile_window,omitempty"`
+	// SkipUnchangedFor is how long the analysis of the rules is skipped while they are not changing.
+	// The rules are still fetched at every run, but they are only analyzed again when their fingerprint has changed,
+	// or when their last analysis is older than this duration. Default to 0, the rules are analyzed at every run.
+	SkipUnchangedFor model.Duration `yaml:"skip_unchanged_for,omitempty"`
+	// UsageTags are set on every usage collected, like the cluster or the environment. The usage can then be filtered on them with the API.
+	UsageTags  map[string]string `yaml:"usage_tags,omitempty"`
+	HTTPClient HTTPClient        `yaml:"prometheus_client"`
+}
+
+// RulesChunk is a subset of the rules fetched with a dedicated request.
+type RulesChunk struct {
+	// RuleGroups is the list of the names of the rule groups to get.
+	RuleGroups []string `yaml:"rule_groups,omitempty"`
+	// Files is the list of the rule files to get.
+	Files []string `yaml:"files,omitempty"`
 }
 
 func (c *RulesCollector) Verify() error {
@@ -125,24 +426,121 @@ func (c *RulesCollector) Verify() error {
 	if c.Period <= 0 {
 		c.Period = model.Duration(defaultMetricCollectorPeriodDuration)
 	}
+	if c.RunTimeout <= 0 {
+		c.RunTimeout = model.Duration(defaultCollectorRunTimeout)
+	}
 	if c.RetryToGetRules == 0 {
 		c.RetryToGetRules = 3
 	}
+	if len(c.Flavor) == 0 {
+		c.Flavor = PrometheusFlavor
+		if c.Engine == MetricsQLEngine {
+			c.Flavor = VictoriaMetricsFlavor
+		}
+	}
+	if len(c.Engine) == 0 {
+		c.Engine = PromQLEngine
+		if c.Flavor == VictoriaMetricsFlavor {
+			c.Engine = MetricsQLEngine
+		}
+	}
+	var errs verifyErrors
+	if c.Engine != PromQLEngine && c.Engine != MetricsQLEngine {
+		errs.add("engine", fmt.Sprintf("unknown engine %q, it must be one of %q or %q", c.Engine, PromQLEngine, MetricsQLEngine))
+	}
+	if err := c.Flavor.verify(); err != nil {
+		errs.add("flavor", err.Error())
+	} else if (c.Engine == MetricsQLEngine) != (c.Flavor == VictoriaMetricsFlavor) {
+		errs.add("flavor", fmt.Sprintf("the flavor %q cannot be used with the engine %q", c.Flavor, c.Engine))
+	}
+	if c.Engine == MetricsQLEngine && (len(c.Chunks) > 0 || c.GroupLimit > 0) {
+		errs.add("engine", "chunks and group_limit are not supported with the metricsql engine")
+	}
+	for i, chunk := range c.Chunks {
+		if len(chunk.RuleGroups) == 0 && len(chunk.Files) == 0 {
+			errs.add(fmt.Sprintf("chunks[%d]", i), "a chunk must define at least one rule group or one file")
+		}
+	}
 	if c.HTTPClient.URL == nil {
-		return fmt.Errorf("missing Prometheus URL for the rules collector")
+		errs.add("prometheus_client.url", "missing Prometheus URL for the rules collector")
 	}
 	if c.MetricUsageClient != nil && c.MetricUsageClient.URL == nil {
-		return fmt.Errorf("missing Metrics Usage URL for the rules collector")
+		errs.add("metric_usage_client.url", "missing Metrics Usage URL for the rules collector")
 	}
-	return nil
+	if c.SkipUnchangedFor < 0 {
+		errs.add("skip_unchanged_for", fmt.Sprintf("invalid duration %s, it must be positive", c.SkipUnchangedFor))
+	}
+	verifyUsageTags(&errs, c.UsageTags)
+	verifyReconcile(&errs, c.Reconcile, c.MetricUsageClient)
+	return errs.err()
+}
+
+// MetricFileCollector is reading the list of metrics from a file or from a URL.
+// It is an alternative to the MetricCollector when Prometheus can't be queried directly but is publishing the list of its metrics.
+type MetricFileCollector struct {
+	Enable bool           `yaml:"enable"`
+	Period model.Duration `yaml:"period,omitempty"`
+	// StartOffset delays the first run of the collector, to stagger the collectors sharing the same period.
+	StartOffset model.Duration `yaml:"start_offset,omitempty"`
+	// RunTimeout is the maximum duration of a run. When it is reached, the run is interrupted until the next period.
+	RunTimeout model.Duration `yaml:"run_timeout,omitempty"`
+	// Path is the path to the file containing the metric names. It cannot be used with HTTPClient.
+	Path string `yaml:"path,omitempty"`
+	// HTTPClient is the client used to download the file containing the metric names. It cannot be used with Path.
+	HTTPClient *HTTPClient `yaml:"http_client,omitempty"`
+}
+
+func (c *MetricFileCollector) Verify() error {
+	if !c.Enable {
+		return nil
+	}
+	if c.Period <= 0 {
+		c.Period = model.Duration(defaultMetricCollectorPeriodDuration)
+	}
+	if c.RunTimeout <= 0 {
+		c.RunTimeout = model.Duration(defaultCollectorRunTimeout)
+	}
+	var errs verifyErrors
+	if len(c.Path) == 0 && c.HTTPClient == nil {
+		errs.add("path", "missing path or http_client for the metric file collector")
+	}
+	if len(c.Path) > 0 && c.HTTPClient != nil {
+		errs.add("path", "path and http_client cannot be used together for the metric file collector")
+	}
+	if c.HTTPClient != nil && c.HTTPClient.URL == nil {
+		errs.add("http_client.url", "missing URL for the metric file collector")
+	}
+	return errs.err()
 }
 
 type LabelsCollector struct {
 	Enable bool           `yaml:"enable"`
 	Period model.Duration `yaml:"period,omitempty"`
+	// StartOffset delays the first run of the collector, to stagger the collectors sharing the same period.
+	StartOffset model.Duration `yaml:"start_offset,omitempty"`
+	// RunTimeout is the maximum duration of a run. When it is reached, the run is interrupted until the next period.
+	RunTimeout model.Duration `yaml:"run_timeout,omitempty"`
+	// Lookback is the time range queried to get the metrics. Default to the period.
+	// It can be larger than the period to find the metrics that are not scraped frequently.
+	Lookback model.Duration `yaml:"lookback,omitempty"`
 	// MetricUsageClient is a client to send the metrics usage to a remote metrics_usage server.
-	MetricUsageClient *HTTPClient `yaml:"metric_usage_client,omitempty"`
-	HTTPClient        HTTPClient  `yaml:"prometheus_client"`
+	MetricUsageClient *MetricUsageClient `yaml:"metric_usage_client,omitempty"`
+	// RetryToGetMetrics is the number of retries the collector will do to get the list of metrics from Prometheus before actually failing.
+	// Between each retry, the collector will wait first 10 seconds, then 20 seconds, then 30 seconds ...etc.
+	RetryToGetMetrics uint `yaml:"retry_to_get_metrics,omitempty"`
+	// RetryToGetLabels is the number of retries the collector will do to get the labels of a single metric before giving up on it.
+	RetryToGetLabels uint `yaml:"retry_to_get_labels,omitempty"`
+	// Concurrency is the number of metrics whose labels are queried in parallel. Default to 1.
+	Concurrency uint `yaml:"concurrency,omitempty"`
+	// IncludeLabels keeps only the label names matching this regexp. The regexp is not anchored.
+	IncludeLabels *common.Regexp `yaml:"include_labels,omitempty"`
+	// ExcludeLabels drops the label names matching this regexp, like the high-churn labels. The regexp is not anchored.
+	// A label name matching both IncludeLabels and ExcludeLabels is dropped.
+	ExcludeLabels *common.Regexp `yaml:"exclude_labels,omitempty"`
+	// Reconcile makes every run replace the labels of each metric collected, instead of adding them to the previous ones.
+	// Like that, the labels dropped from a metric are removed. It is not supported with metric_usage_client.
+	Reconcile  bool       `yaml:"reconcile,omitempty"`
+	HTTPClient HTTPClient `yaml:"prometheus_client"`
 }
 
 func (c *LabelsCollector) Verify() error {
@@ -152,20 +550,50 @@ func (c *LabelsCollector) Verify() error {
 	if c.Period <= 0 {
 		c.Period = model.Duration(defaultMetricCollectorPeriodDuration)
 	}
+	if c.RunTimeout <= 0 {
+		c.RunTimeout = model.Duration(defaultCollectorRunTimeout)
+	}
+	if c.Lookback <= 0 {
+		c.Lookback = c.Period
+	}
+	if c.RetryToGetMetrics == 0 {
+		c.RetryToGetMetrics = 3
+	}
+	if c.RetryToGetLabels == 0 {
+		c.RetryToGetLabels = 3
+	}
+	if c.Concurrency == 0 {
+		c.Concurrency = 1
+	}
+	var errs verifyErrors
 	if c.HTTPClient.URL == nil {
-		return fmt.Errorf("missing Prometheus URL for the rules collector")
+		errs.add("prometheus_client.url", "missing Prometheus URL for the labels collector")
 	}
 	if c.MetricUsageClient != nil && c.MetricUsageClient.URL == nil {
-		return fmt.Errorf("missing Metrics Usage URL for the rules collector")
+		errs.add("metric_usage_client.url", "missing Metrics Usage URL for the labels collector")
 	}
-	return nil
+	verifyReconcile(&errs, c.Reconcile, c.MetricUsageClient)
+	return errs.err()
 }
 
 type PersesCollector struct {
-	Enable            bool                    `yaml:"enable"`
-	Period            model.Duration          `yaml:"period,omitempty"`
-	MetricUsageClient *HTTPClient             `yaml:"metric_usage_client,omitempty"`
-	HTTPClient        config.RestConfigClient `yaml:"perses_client"`
+	Enable bool           `yaml:"enable"`
+	Period model.Duration `yaml:"period,omitempty"`
+	// StartOffset delays the first run of the collector, to stagger the collectors sharing the same period.
+	StartOffset model.Duration `yaml:"start_offset,omitempty"`
+	// RunTimeout is the maximum duration of a run. When it is reached, the run is interrupted until the next period.
+	RunTimeout        model.Duration     `yaml:"run_timeout,omitempty"`
+	MetricUsageClient *MetricUsageClient `yaml:"metric_usage_client,omitempty"`
+	// RecordExpressions adds to the usage of the dashboards the query using each metric. A dashboard is then reported once per query.
+	RecordExpressions bool `yaml:"record_expressions,omitempty"`
+	// Reconcile makes every run replace the usage collected previously from the same Perses, instead of merging into it.
+	// Like that, the usage of the deleted dashboards is removed. It is not supported with metric_usage_client.
+	Reconcile bool `yaml:"reconcile,omitempty"`
+	// ReconcileWindow is how long a usage not collected anymore is kept before being removed. Default to 0, it is removed immediately.
+	ReconcileWindow model.Duration `yaml:"reconcile_window,omitempty"`
+	// UsageTags are set on every usage collected, like the cluster or the environment. The usage can then be filtered on them with the API.
+	UsageTags  map[string]string       `yaml:"usage_tags,omitempty"`
+	HTTPClient config.RestConfigClient `yaml:"perses_client"`
 }
 
 func (c *PersesCollector) Verify() error {
@@ -175,20 +603,118 @@ func (c *PersesCollector) Verify() error {
 	if c.Period <= 0 {
 		c.Period = model.Duration(defaultMetricCollectorPeriodDuration)
 	}
+	if c.RunTimeout <= 0 {
+		c.RunTimeout = model.Duration(defaultCollectorRunTimeout)
+	}
+	var errs verifyErrors
 	if c.HTTPClient.URL == nil {
-		return fmt.Errorf("missing Rest URL for the perses collector")
+		errs.add("perses_client.url", "missing Rest URL for the perses collector")
 	}
 	if c.MetricUsageClient != nil && c.MetricUsageClient.URL == nil {
-		return fmt.Errorf("missing Metrics Usage URL for the rules collector")
+		errs.add("metric_usage_client.url", "missing Metrics Usage URL for the perses collector")
 	}
-	return nil
+	verifyUsageTags(&errs, c.UsageTags)
+	verifyReconcile(&errs, c.Reconcile, c.MetricUsageClient)
+	return errs.err()
+}
+
+// verifyReconcile checks the reconciliation is only used when the usage is stored in the local database.
+func verifyReconcile(errs *verifyErrors, reconcile bool, metricUsageClient *MetricUsageClient) {
+	if reconcile && metricUsageClient != nil {
+		errs.add("reconcile", "reconcile is not supported with metric_usage_client")
+	}
+}
+
+// verifyUsageTags checks the name of the tags can be given to the query parameter tag of the API, as key=value.
+func verifyUsageTags(errs *verifyErrors, tags map[string]string) {
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		if len(key) == 0 || strings.Contains(key, "=") {
+			errs.add("usage_tags", fmt.Sprintf("invalid tag name %q, it must not be empty nor contain '='", key))
+		}
+	}
+}
+
+type PersesFileCollector struct {
+	Enable bool           `yaml:"enable"`
+	Period model.Duration `yaml:"period,omitempty"`
+	// StartOffset delays the first run of the collector, to stagger the collectors sharing the same period.
+	StartOffset model.Duration `yaml:"start_offset,omitempty"`
+	// RunTimeout is the maximum duration of a run. When it is reached, the run is interrupted until the next period.
+	RunTimeout        model.Duration     `yaml:"run_timeout,omitempty"`
+	MetricUsageClient *MetricUsageClient `yaml:"metric_usage_client,omitempty"`
+	// Paths is a list of glob patterns matching the Perses dashboards files. Files can be in JSON or in YAML.
+	Paths []string `yaml:"paths"`
+	// RecordExpressions adds to the usage of the dashboards the query using each metric. A dashboard is then reported once per query.
+	RecordExpressions bool `yaml:"record_expressions,omitempty"`
+	// UsageTags are set on every usage collected, like the cluster or the environment. The usage can then be filtered on them with the API.
+	UsageTags map[string]string `yaml:"usage_tags,omitempty"`
+}
+
+func (c *PersesFileCollector) Verify() error {
+	if !c.Enable {
+		return nil
+	}
+	if c.Period <= 0 {
+		c.Period = model.Duration(defaultMetricCollectorPeriodDuration)
+	}
+	if c.RunTimeout <= 0 {
+		c.RunTimeout = model.Duration(defaultCollectorRunTimeout)
+	}
+	var errs verifyErrors
+	if len(c.Paths) == 0 {
+		errs.add("paths", "missing paths for the perses file collector")
+	}
+	if c.MetricUsageClient != nil && c.MetricUsageClient.URL == nil {
+		errs.add("metric_usage_client.url", "missing Metrics Usage URL for the perses file collector")
+	}
+	verifyUsageTags(&errs, c.UsageTags)
+	return errs.err()
 }
 
 type GrafanaCollector struct {
-	Enable            bool           `yaml:"enable"`
-	Period            model.Duration `yaml:"period,omitempty"`
-	MetricUsageClient *HTTPClient    `yaml:"metric_usage_client,omitempty"`
-	HTTPClient        HTTPClient     `yaml:"grafana_client"`
+	Enable bool           `yaml:"enable"`
+	Period model.Duration `yaml:"period,omitempty"`
+	// StartOffset delays the first run of the collector, to stagger the collectors sharing the same period.
+	StartOffset model.Duration `yaml:"start_offset,omitempty"`
+	// RunTimeout is the maximum duration of a run. When it is reached, the run is interrupted until the next period.
+	RunTimeout        model.Duration     `yaml:"run_timeout,omitempty"`
+	MetricUsageClient *MetricUsageClient `yaml:"metric_usage_client,omitempty"`
+	// Tags is used to only collect the dashboards having all the given tags.
+	Tags []string `yaml:"tags,omitempty"`
+	// FolderUIDs is used to only collect the dashboards stored in one of the given folders.
+	FolderUIDs []string `yaml:"folder_uids,omitempty"`
+	// DatasourceFilter is used to ignore the queries using some datasources, like the ones that are not Prometheus compatible.
+	DatasourceFilter *DatasourceFilter `yaml:"datasource_filter,omitempty"`
+	// CollectAlertRules is used to also collect the Grafana-managed alert rules (unified alerting).
+	// FolderUIDs and DatasourceFilter are applied on the alert rules as well.
+	CollectAlertRules bool `yaml:"collect_alert_rules,omitempty"`
+	// DeepScan is used to also look for PromQL expressions in other parts of the panels, given by DeepScanPaths.
+	// It is useful for the panel plugins storing a query in their options.
+	DeepScan bool `yaml:"deep_scan,omitempty"`
+	// DeepScanPaths is the list of the JSON paths, relative to a panel, scanned when DeepScan is enabled.
+	DeepScanPaths []string `yaml:"deep_scan_paths,omitempty"`
+	// ScanTextPanels is used to also look for PromQL expressions in the links of the text panels, like the links to Grafana Explore.
+	ScanTextPanels bool `yaml:"scan_text_panels,omitempty"`
+	// RecordExpressions adds to the usage of the dashboards the query using each metric. A dashboard is then reported once per query.
+	RecordExpressions bool `yaml:"record_expressions,omitempty"`
+	// Reconcile makes every run replace the usage collected previously from the same Grafana, instead of merging into it.
+	// Like that, the usage of the deleted dashboards and alert rules is removed. It is not supported with metric_usage_client.
+	Reconcile bool `yaml:"reconcile,omitempty"`
+	// ReconcileWindow is how long a usage not collected anymore is kept before being removed. Default to 0, it is removed immediately.
+	ReconcileWindow model.Duration `yaml:"reconcile_window,omitempty"`
+	// UsageTags are set on every usage collected, like the cluster or the environment. The usage can then be filtered on them with the API.
+	UsageTags  map[string]string `yaml:"usage_tags,omitempty"`
+	HTTPClient HTTPClient        `yaml:"grafana_client"`
+}
+
+// DatasourceFilter defines the datasources whose queries must be ignored.
+// When a datasource is referenced through a datasource variable, the default value of the variable is used.
+type DatasourceFilter struct {
+	// IgnoreUIDs is the list of the datasource UIDs to ignore.
+	// Legacy dashboards referencing the datasource by its name are matched with this name.
+	IgnoreUIDs []string `yaml:"ignore_uids,omitempty"`
+	// IgnoreTypes is the list of the datasource types to ignore, like "loki" or "elasticsearch".
+	IgnoreTypes []string `yaml:"ignore_types,omitempty"`
 }
 
 func (c *GrafanaCollector) Verify() error {
@@ -198,11 +724,28 @@ func (c *GrafanaCollector) Verify() error {
 	if c.Period <= 0 {
 		c.Period = model.Duration(defaultMetricCollectorPeriodDuration)
 	}
+	if c.RunTimeout <= 0 {
+		c.RunTimeout = model.Duration(defaultCollectorRunTimeout)
+	}
+	var errs verifyErrors
 	if c.HTTPClient.URL == nil {
-		return fmt.Errorf("missing Rest URL for the perses collector")
+		errs.add("grafana_client.url", "missing Rest URL for the grafana collector")
 	}
 	if c.MetricUsageClient != nil && c.MetricUsageClient.URL == nil {
-		return fmt.Errorf("missing Metrics Usage URL for the rules collector")
+		errs.add("metric_usage_client.url", "missing Metrics Usage URL for the grafana collector")
 	}
-	return nil
+	if c.DeepScan && len(c.DeepScanPaths) == 0 {
+		c.DeepScanPaths = slices.Clone(defaultGrafanaDeepScanPaths)
+	}
+	if !c.DeepScan && len(c.DeepScanPaths) > 0 {
+		errs.add("deep_scan_paths", "deep_scan_paths cannot be used without deep_scan")
+	}
+	for i, path := range c.DeepScanPaths {
+		if len(path) == 0 || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") || strings.Contains(path, "..") {
+			errs.add(fmt.Sprintf("deep_scan_paths[%d]", i), fmt.Sprintf("invalid path %q, it must be a list of keys separated by dots, like options.query", path))
+		}
+	}
+	verifyUsageTags(&errs, c.UsageTags)
+	verifyReconcile(&errs, c.Reconcile, c.MetricUsageClient)
+	return errs.err()
 }
@@ -0,0 +1,243 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/perses/perses/pkg/client/config"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/perses/perses/pkg/model/api/v1/secret"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func generateClientCertificate(t *testing.T) ([]byte, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "metrics-usage"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return der, string(certPEM), string(keyPEM)
+}
+
+func TestNewHTTPClientUsesTLSConfig(t *testing.T) {
+	der, cert, key := generateClientCertificate(t)
+	tlsConfig := &secret.TLSConfig{Cert: cert, Key: key}
+	tests := []struct {
+		title string
+		cfg   HTTPClient
+	}{
+		{
+			title: "no authentication",
+			cfg:   HTTPClient{TLSConfig: tlsConfig},
+		},
+		{
+			title: "basic auth",
+			cfg: HTTPClient{
+				BasicAuth: &secret.BasicAuth{Username: "foo", Password: "bar"},
+				TLSConfig: tlsConfig,
+			},
+		},
+		{
+			title: "authorization",
+			cfg: HTTPClient{
+				Authorization: &secret.Authorization{Type: "Bearer", Credentials: "token"},
+				TLSConfig:     tlsConfig,
+			},
+		},
+		{
+			title: "oauth",
+			cfg: HTTPClient{
+				OAuth:     &config.OAuth{ClientID: "foo", ClientSecret: "bar", TokenURL: "https://localhost/token"},
+				TLSConfig: tlsConfig,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			client, err := NewHTTPClient(test.cfg, "test")
+			require.NoError(t, err)
+			roundTripper := client.Transport
+			if oauthTransport, ok := roundTripper.(*oauth2.Transport); ok {
+				roundTripper = oauthTransport.Base
+			}
+			identified, ok := roundTripper.(*identifiedRoundTripper)
+			require.True(t, ok, "unexpected transport %T", roundTripper)
+			roundTripper = identified.base
+			transport, ok := roundTripper.(*http.Transport)
+			require.True(t, ok, "unexpected base transport %T", roundTripper)
+			require.NotNil(t, transport.TLSClientConfig.GetClientCertificate)
+			clientCert, err := transport.TLSClientConfig.GetClientCertificate(&tls.CertificateRequestInfo{})
+			require.NoError(t, err)
+			assert.Equal(t, [][]byte{der}, clientCert.Certificate)
+		})
+	}
+}
+
+func TestNewHTTPClientTimeout(t *testing.T) {
+	client, err := NewHTTPClient(HTTPClient{}, "test")
+	require.NoError(t, err)
+	assert.Equal(t, defaultConnectionTimeout, client.Timeout)
+
+	client, err = NewHTTPClient(HTTPClient{
+		Authorization: &secret.Authorization{Type: "Bearer", Credentials: "token"},
+		Timeout:       model.Duration(2 * time.Minute),
+	}, "test")
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Minute, client.Timeout)
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	var proxiedHosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxiedHosts = append(proxiedHosts, r.URL.Host)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+	proxyURL, err := common.ParseURL(proxy.URL)
+	require.NoError(t, err)
+
+	client, err := NewHTTPClient(HTTPClient{
+		Authorization: &secret.Authorization{Type: "Bearer", Credentials: "token"},
+		ProxyURL:      proxyURL,
+	}, "grafana")
+	require.NoError(t, err)
+	resp, err := client.Get("http://grafana.isolated.example.com/api/search")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{"grafana.isolated.example.com"}, proxiedHosts)
+
+	invalidProxyURL, err := common.ParseURL("ftp://proxy.example.com")
+	require.NoError(t, err)
+	_, err = NewHTTPClient(HTTPClient{ProxyURL: invalidProxyURL}, "grafana")
+	assert.Error(t, err)
+}
+
+func TestNewHTTPClientIdentifiesRequests(t *testing.T) {
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Clone())
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		title             string
+		cfg               HTTPClient
+		expectedUserAgent string
+	}{
+		{
+			title:             "default user agent",
+			cfg:               HTTPClient{},
+			expectedUserAgent: "metrics-usage/dev (rules)",
+		},
+		{
+			title:             "user agent overridden",
+			cfg:               HTTPClient{UserAgent: "my-agent"},
+			expectedUserAgent: "my-agent",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			headers = nil
+			client, err := NewHTTPClient(test.cfg, "rules")
+			require.NoError(t, err)
+			for i := 0; i < 2; i++ {
+				resp, getErr := client.Get(server.URL)
+				require.NoError(t, getErr)
+				resp.Body.Close()
+			}
+			require.Len(t, headers, 2)
+			for _, header := range headers {
+				assert.Equal(t, test.expectedUserAgent, header.Get("User-Agent"))
+				assert.NotEmpty(t, header.Get(requestIDHeader))
+			}
+			assert.NotEqual(t, headers[0].Get(requestIDHeader), headers[1].Get(requestIDHeader))
+		})
+	}
+}
+
+func TestSharedTokenSource(t *testing.T) {
+	var requests []string
+	var userAgents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		requests = append(requests, clientID+":"+clientSecret)
+		userAgents = append(userAgents, r.UserAgent())
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "token-` + clientSecret + `", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+	t.Cleanup(func() {
+		tokenSourcesMutex.Lock()
+		defer tokenSourcesMutex.Unlock()
+		tokenSources = make(map[tokenSourceKey]oauth2.TokenSource)
+	})
+
+	newConfig := func(clientSecret string, scopes ...string) HTTPClient {
+		return HTTPClient{OAuth: &config.OAuth{ClientID: "metrics-usage", ClientSecret: clientSecret, TokenURL: server.URL, Scopes: scopes}}
+	}
+	getToken := func(cfg HTTPClient, timeout time.Duration) string {
+		ts, err := sharedTokenSource(cfg, timeout)
+		require.NoError(t, err)
+		token, err := ts.Token()
+		require.NoError(t, err)
+		return token.AccessToken
+	}
+	assert.Equal(t, "token-secret", getToken(newConfig("secret", "read", "write"), time.Minute))
+	// The same identity reuses the token, whatever the order of the scopes.
+	assert.Equal(t, "token-secret", getToken(newConfig("secret", "write", "read"), time.Minute))
+	assert.Equal(t, []string{"metrics-usage:secret"}, requests)
+	// The token is not requested on behalf of a collector.
+	assert.Contains(t, userAgents[0], "(oauth)")
+
+	// Another secret must not get the token obtained with the first one.
+	assert.Equal(t, "token-other", getToken(newConfig("other", "read", "write"), time.Minute))
+	assert.Equal(t, []string{"metrics-usage:secret", "metrics-usage:other"}, requests)
+
+	// Another transport must request its own token, with its own settings.
+	assert.Equal(t, "token-secret", getToken(newConfig("secret", "read", "write"), time.Hour))
+	withTLS := newConfig("secret", "read", "write")
+	withTLS.TLSConfig = &secret.TLSConfig{InsecureSkipVerify: true}
+	assert.Equal(t, "token-secret", getToken(withTLS, time.Minute))
+	withUserAgent := newConfig("secret", "read", "write")
+	withUserAgent.UserAgent = "custom"
+	assert.Equal(t, "token-secret", getToken(withUserAgent, time.Minute))
+	assert.Len(t, requests, 5)
+	assert.Equal(t, "custom", userAgents[4])
+}
@@ -21,7 +21,11 @@ import (
 	"github.com/prometheus/common/model"
 )
 
-const defaultFlushPeriod = time.Minute * 5
+const (
+	defaultFlushPeriod       = time.Minute * 5
+	defaultAnalyzerCacheSize = 10000
+	defaultMaxSnapshots      = 20
+)
 
 type Database struct {
 	// Define if the database is stored in a file or in memory
@@ -30,6 +34,25 @@ type Database struct {
 	Path string `yaml:"path,omitempty"`
 	// FlushPeriod defines the frequency the system will flush the data into the JSON file
 	FlushPeriod model.Duration `yaml:"flush_period,omitempty"`
+	// ReadFromSnapshot is used to list the metrics from a snapshot refreshed at every flush period,
+	// instead of contending with the writers on the live data. The list returned can be outdated by up to one flush period.
+	ReadFromSnapshot bool `yaml:"read_from_snapshot,omitempty"`
+	// ImportPaths is the list of JSON files, in the format returned by /api/v1/metrics, merged into the database at startup.
+	// Unlike Path, these files are only read, so they can be used to seed the database with usage collected elsewhere.
+	ImportPaths []string `yaml:"import_paths,omitempty"`
+	// Retention is used to drop, at every flush, the metrics that are not useful anymore, so the file doesn't grow forever.
+	Retention *Retention `yaml:"retention,omitempty"`
+	// MaxMatchingMetrics is the maximum number of metrics a partial metric can match.
+	// Beyond it, the partial metric is flagged as too broad and its list of matching metrics is dropped. Zero means no limit.
+	MaxMatchingMetrics int `yaml:"max_matching_metrics,omitempty"`
+	// MaxSnapshots is the maximum number of snapshots saved with the API. Beyond it, the oldest ones are removed.
+	MaxSnapshots int `yaml:"max_snapshots,omitempty"`
+}
+
+// Retention defines which metrics are removed from the database when it is flushed in the file.
+type Retention struct {
+	// UnusedMaxAge is how long a metric without any usage is kept once it is not reported anymore by the collectors.
+	UnusedMaxAge model.Duration `yaml:"unused_max_age"`
 }
 
 func (d *Database) Verify() error {
@@ -37,32 +60,167 @@ func (d *Database) Verify() error {
 	if d.InMemory == nil {
 		d.InMemory = &inMemory
 	}
-	if *d.InMemory {
-		return nil
-	}
-	if len(d.Path) == 0 {
-		return fmt.Errorf("database path is required")
-	}
 	if d.FlushPeriod == 0 {
 		d.FlushPeriod = model.Duration(defaultFlushPeriod)
 	}
-	return nil
+	if d.MaxSnapshots == 0 {
+		d.MaxSnapshots = defaultMaxSnapshots
+	}
+	var errs verifyErrors
+	for i, importPath := range d.ImportPaths {
+		if len(importPath) == 0 {
+			errs.add(fmt.Sprintf("import_paths[%d]", i), "import path cannot be empty")
+		}
+	}
+	if !*d.InMemory && len(d.Path) == 0 {
+		errs.add("path", "database path is required")
+	}
+	if d.Retention != nil {
+		if *d.InMemory {
+			errs.add("retention", "the retention is only supported when the database is stored in a file")
+		}
+		if d.Retention.UnusedMaxAge <= 0 {
+			errs.add("retention.unused_max_age", "the maximum age of the unused metrics must be set")
+		}
+	}
+	if d.MaxMatchingMetrics < 0 {
+		errs.add("max_matching_metrics", fmt.Sprintf("invalid maximum number of matching metrics %d, it must be positive", d.MaxMatchingMetrics))
+	}
+	if d.MaxSnapshots < 0 {
+		errs.add("max_snapshots", fmt.Sprintf("invalid maximum number of snapshots %d, it must be positive", d.MaxSnapshots))
+	}
+	return errs.err()
+}
+
+type Analyzer struct {
+	// DisableCache is used to not keep in memory the result of the PromQL expressions analysis.
+	// The cache avoids parsing again the same expressions at every collection, at the cost of more memory.
+	DisableCache bool `yaml:"disable_cache,omitempty"`
+	// CacheSize is the maximum number of PromQL expressions kept in the cache.
+	CacheSize int `yaml:"cache_size,omitempty"`
+	// PersesPlugins is the list of the Perses query and variable plugins, other than the Prometheus ones, containing a PromQL-compatible expression.
+	PersesPlugins []PersesPlugin `yaml:"perses_plugins,omitempty"`
+	// GrafanaPanels is the list of the Grafana panel types storing PromQL expressions outside their targets, like in their options.
+	GrafanaPanels []GrafanaPanel `yaml:"grafana_panels,omitempty"`
+	// NormalizePartialMetrics is used to name the partial metrics after the anchored regexp matching them, like ^foo_.+$.
+	// Like that, a partial metric has the same name whether it comes from a variable (foo_${suffix}) or from a regexp (foo_.*).
+	NormalizePartialMetrics bool `yaml:"normalize_partial_metrics,omitempty"`
+	// ExpandRegexpMatchers is used to replace a regexp matcher on the metric name by the metric names it matches,
+	// when the regexp is an alternation of literals like {__name__=~"foo_(a|b)"}. The other regexps stay partial metrics.
+	ExpandRegexpMatchers bool `yaml:"expand_regexp_matchers,omitempty"`
+	// UTF8MetricNames is used to accept the metric names allowed by Prometheus 3.0, like the OpenTelemetry dotted names,
+	// including in the expressions that can only be read by the fallback parser.
+	UTF8MetricNames bool `yaml:"utf8_metric_names,omitempty"`
+}
+
+// PersesPlugin is a Perses plugin whose spec contains a PromQL-compatible expression.
+type PersesPlugin struct {
+	// Kind is the kind of the plugin, like PrometheusTimeSeriesQuery.
+	Kind string `yaml:"kind"`
+	// ExpressionField is the field of the plugin spec containing the expression.
+	ExpressionField string `yaml:"expression_field,omitempty"`
+}
+
+type GrafanaPanel struct {
+	// Type is the type of the panel, like stat or table.
+	Type string `yaml:"type"`
+	// ExpressionPaths are the JSON paths of the panel containing an expression, like options.reduceOptions.expr.
+	ExpressionPaths []string `yaml:"expression_paths"`
+}
+
+func (a *Analyzer) Verify() error {
+	if a.CacheSize <= 0 {
+		a.CacheSize = defaultAnalyzerCacheSize
+	}
+	var errs verifyErrors
+	for i := range a.PersesPlugins {
+		plugin := &a.PersesPlugins[i]
+		if len(plugin.Kind) == 0 {
+			errs.add(fmt.Sprintf("perses_plugins[%d].kind", i), "the kind of the plugin must be set")
+		}
+		if len(plugin.ExpressionField) == 0 {
+			plugin.ExpressionField = "query"
+		}
+	}
+	for i, panel := range a.GrafanaPanels {
+		if len(panel.Type) == 0 {
+			errs.add(fmt.Sprintf("grafana_panels[%d].type", i), "the type of the panel must be set")
+		}
+		if len(panel.ExpressionPaths) == 0 {
+			errs.add(fmt.Sprintf("grafana_panels[%d].expression_paths", i), "at least one path must be set")
+		}
+	}
+	return errs.err()
 }
 
 type Config struct {
-	Database         Database           `yaml:"database"`
-	MetricCollector  MetricCollector    `yaml:"metric_collector,omitempty"`
-	RulesCollectors  []*RulesCollector  `yaml:"rules_collectors,omitempty"`
-	LabelsCollectors []*LabelsCollector `yaml:"labels_collectors,omitempty"`
-	PersesCollector  PersesCollector    `yaml:"perses_collector,omitempty"`
-	GrafanaCollector GrafanaCollector   `yaml:"grafana_collector,omitempty"`
+	Server         Server         `yaml:"server,omitempty"`
+	Database       Database       `yaml:"database"`
+	Analyzer       Analyzer       `yaml:"analyzer,omitempty"`
+	Classification Classification `yaml:"classification,omitempty"`
+	// CollectorJitter delays every run of the collectors by a random duration lower than it,
+	// so the collectors sharing the same period don't query their backend at the same time.
+	CollectorJitter      model.Duration         `yaml:"collector_jitter,omitempty"`
+	MetricCollector      MetricCollector        `yaml:"metric_collector,omitempty"`
+	MetricFileCollectors []*MetricFileCollector `yaml:"metric_file_collectors,omitempty"`
+	RulesCollectors      []*RulesCollector      `yaml:"rules_collectors,omitempty"`
+	LabelsCollectors     []*LabelsCollector     `yaml:"labels_collectors,omitempty"`
+	PersesCollector      PersesCollector        `yaml:"perses_collector,omitempty"`
+	PersesFileCollector  PersesFileCollector    `yaml:"perses_file_collector,omitempty"`
+	GrafanaCollectors    []*GrafanaCollector    `yaml:"grafana_collectors,omitempty"`
+	Notifier             Notifier               `yaml:"notifier,omitempty"`
 }
 
-func Resolve(configFile string) (Config, error) {
+// Verify is verifying every part of the configuration and returns all the errors found at once,
+// each with the path of the field concerned (like rules_collectors[2].prometheus_client.url).
+func (c *Config) Verify() error {
+	var errs verifyErrors
+	errs.addNested("server", c.Server.Verify())
+	errs.addNested("database", c.Database.Verify())
+	errs.addNested("analyzer", c.Analyzer.Verify())
+	errs.addNested("classification", c.Classification.Verify())
+	errs.addNested("metric_collector", c.MetricCollector.Verify())
+	for i, metricFileCollector := range c.MetricFileCollectors {
+		if metricFileCollector != nil {
+			errs.addNested(fmt.Sprintf("metric_file_collectors[%d]", i), metricFileCollector.Verify())
+		}
+	}
+	for i, rulesCollector := range c.RulesCollectors {
+		if rulesCollector != nil {
+			errs.addNested(fmt.Sprintf("rules_collectors[%d]", i), rulesCollector.Verify())
+		}
+	}
+	for i, labelsCollector := range c.LabelsCollectors {
+		if labelsCollector != nil {
+			errs.addNested(fmt.Sprintf("labels_collectors[%d]", i), labelsCollector.Verify())
+		}
+	}
+	errs.addNested("perses_collector", c.PersesCollector.Verify())
+	errs.addNested("perses_file_collector", c.PersesFileCollector.Verify())
+	for i, grafanaCollector := range c.GrafanaCollectors {
+		if grafanaCollector != nil {
+			errs.addNested(fmt.Sprintf("grafana_collectors[%d]", i), grafanaCollector.Verify())
+		}
+	}
+	errs.addNested("notifier", c.Notifier.Verify())
+	return errs.err()
+}
+
+// Resolve reads the configuration from the given files, merged in order, the later files overriding the earlier ones.
+// The environment variables are then overriding the result.
+func Resolve(configFiles ...string) (Config, error) {
 	c := Config{}
-	return c, config.NewResolver[Config]().
-		SetConfigFile(configFile).
-		SetEnvPrefix("METRICS_USAGE").
+	resolver := config.NewResolver[Config]().SetEnvPrefix("METRICS_USAGE")
+	if len(configFiles) > 1 {
+		data, err := mergeConfigFiles(configFiles)
+		if err != nil {
+			return c, err
+		}
+		resolver.SetConfigData(data)
+	} else if len(configFiles) == 1 {
+		resolver.SetConfigFile(configFiles[0])
+	}
+	return c, resolver.
 		Resolve(&c).
 		Verify()
 }
@@ -0,0 +1,313 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigVerify(t *testing.T) {
+	inMemory := false
+	promURL, err := common.ParseURL("https://prometheus.demo.do.prometheus.io")
+	require.NoError(t, err)
+	testSuite := []struct {
+		title  string
+		config *Config
+		result []string
+	}{
+		{
+			title:  "empty config",
+			config: &Config{},
+		},
+		{
+			title: "every error is reported",
+			config: &Config{
+				Database: Database{InMemory: &inMemory, Retention: &Retention{}},
+				RulesCollectors: []*RulesCollector{
+					{Enable: true, HTTPClient: HTTPClient{URL: promURL}},
+					{Enable: false},
+					{Enable: true, MetricUsageClient: &MetricUsageClient{}},
+				},
+				GrafanaCollectors: []*GrafanaCollector{
+					{Enable: true, UsageTags: map[string]string{"cluster": "prod-eu", "team=a": "b"}},
+				},
+				Notifier: Notifier{Enable: true},
+			},
+			result: []string{
+				"database.path: database path is required",
+				"database.retention.unused_max_age: the maximum age of the unused metrics must be set",
+				"rules_collectors[2].prometheus_client.url: missing Prometheus URL for the rules collector",
+				"rules_collectors[2].metric_usage_client.url: missing Metrics Usage URL for the rules collector",
+				"grafana_collectors[0].grafana_client.url: missing Rest URL for the grafana collector",
+				`grafana_collectors[0].usage_tags: invalid tag name "team=a", it must not be empty nor contain '='`,
+				"notifier.webhook.url: missing webhook URL for the notifier",
+			},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			err := test.config.Verify()
+			if len(test.result) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			var messages []string
+			for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+				messages = append(messages, e.Error())
+			}
+			assert.Equal(t, test.result, messages)
+		})
+	}
+}
+
+func TestCollectorLookbackDefault(t *testing.T) {
+	promURL, err := common.ParseURL("https://prometheus.demo.do.prometheus.io")
+	require.NoError(t, err)
+	c := &MetricCollector{Enable: true, Period: model.Duration(time.Hour), HTTPClient: HTTPClient{URL: promURL}}
+	require.NoError(t, c.Verify())
+	assert.Equal(t, model.Duration(time.Hour), c.Lookback)
+
+	l := &LabelsCollector{Enable: true, Lookback: model.Duration(7 * 24 * time.Hour), HTTPClient: HTTPClient{URL: promURL}}
+	require.NoError(t, l.Verify())
+	assert.Equal(t, model.Duration(defaultMetricCollectorPeriodDuration), l.Period)
+	assert.Equal(t, model.Duration(7*24*time.Hour), l.Lookback)
+}
+
+func TestCollectorRunTimeoutDefault(t *testing.T) {
+	promURL, err := common.ParseURL("https://prometheus.demo.do.prometheus.io")
+	require.NoError(t, err)
+	c := &MetricCollector{Enable: true, HTTPClient: HTTPClient{URL: promURL}}
+	require.NoError(t, c.Verify())
+	assert.Equal(t, model.Duration(defaultCollectorRunTimeout), c.RunTimeout)
+
+	r := &RulesCollector{Enable: true, RunTimeout: model.Duration(time.Hour), HTTPClient: HTTPClient{URL: promURL}}
+	require.NoError(t, r.Verify())
+	assert.Equal(t, model.Duration(time.Hour), r.RunTimeout)
+}
+
+func TestMetricCollectorDiscovery(t *testing.T) {
+	promURL, err := common.ParseURL("https://prometheus.demo.do.prometheus.io")
+	require.NoError(t, err)
+	c := &MetricCollector{Enable: true, HTTPClient: HTTPClient{URL: promURL}}
+	require.NoError(t, c.Verify())
+	assert.Equal(t, LabelValuesDiscovery, c.Discovery)
+
+	c = &MetricCollector{Enable: true, Agent: true, HTTPClient: HTTPClient{URL: promURL}}
+	require.NoError(t, c.Verify())
+	assert.Equal(t, MetadataDiscovery, c.Discovery)
+
+	c = &MetricCollector{Enable: true, Discovery: SeriesDiscovery, HTTPClient: HTTPClient{URL: promURL}}
+	require.NoError(t, c.Verify())
+	assert.Equal(t, []string{`{__name__=~".+"}`}, c.SeriesMatchers)
+
+	c = &MetricCollector{Enable: true, Agent: true, Discovery: SeriesDiscovery, HTTPClient: HTTPClient{URL: promURL}}
+	assert.Error(t, c.Verify())
+	c = &MetricCollector{Enable: true, SeriesMatchers: []string{"up"}, HTTPClient: HTTPClient{URL: promURL}}
+	assert.Error(t, c.Verify())
+	c = &MetricCollector{Enable: true, Discovery: "federate", HTTPClient: HTTPClient{URL: promURL}}
+	assert.Error(t, c.Verify())
+}
+
+func TestPersesFileCollectorVerify(t *testing.T) {
+	testSuite := []struct {
+		title     string
+		collector *PersesFileCollector
+		result    string
+	}{
+		{
+			title:     "disabled collector is not verified",
+			collector: &PersesFileCollector{},
+		},
+		{
+			title:     "default period and run timeout",
+			collector: &PersesFileCollector{Enable: true, Paths: []string{"/dashboards/*.json"}},
+		},
+		{
+			title:     "missing paths",
+			collector: &PersesFileCollector{Enable: true},
+			result:    "paths: missing paths for the perses file collector",
+		},
+		{
+			title:     "missing metric usage URL",
+			collector: &PersesFileCollector{Enable: true, Paths: []string{"/dashboards/*.json"}, MetricUsageClient: &MetricUsageClient{}},
+			result:    "metric_usage_client.url: missing Metrics Usage URL for the perses file collector",
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			err := test.collector.Verify()
+			if len(test.result) > 0 {
+				assert.EqualError(t, err, test.result)
+				return
+			}
+			require.NoError(t, err)
+			if test.collector.Enable {
+				assert.Equal(t, model.Duration(defaultMetricCollectorPeriodDuration), test.collector.Period)
+				assert.Equal(t, model.Duration(defaultCollectorRunTimeout), test.collector.RunTimeout)
+			}
+		})
+	}
+}
+
+func TestRulesCollectorFlavor(t *testing.T) {
+	promURL, err := common.ParseURL("https://prometheus.demo.do.prometheus.io")
+	require.NoError(t, err)
+	testSuite := []struct {
+		title          string
+		engine         RulesEngine
+		flavor         Flavor
+		expectedEngine RulesEngine
+		expectedFlavor Flavor
+		expectedErr    bool
+	}{
+		{
+			title:          "default",
+			expectedEngine: PromQLEngine,
+			expectedFlavor: PrometheusFlavor,
+		},
+		{
+			title:          "flavor from the metricsql engine",
+			engine:         MetricsQLEngine,
+			expectedEngine: MetricsQLEngine,
+			expectedFlavor: VictoriaMetricsFlavor,
+		},
+		{
+			title:          "engine from the victoriametrics flavor",
+			flavor:         VictoriaMetricsFlavor,
+			expectedEngine: MetricsQLEngine,
+			expectedFlavor: VictoriaMetricsFlavor,
+		},
+		{
+			title:          "thanos",
+			flavor:         ThanosFlavor,
+			expectedEngine: PromQLEngine,
+			expectedFlavor: ThanosFlavor,
+		},
+		{
+			title:       "metricsql engine with another flavor",
+			engine:      MetricsQLEngine,
+			flavor:      CortexFlavor,
+			expectedErr: true,
+		},
+		{
+			title:       "unknown flavor",
+			flavor:      "mimir",
+			expectedErr: true,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			c := &RulesCollector{Enable: true, Engine: test.engine, Flavor: test.flavor, HTTPClient: HTTPClient{URL: promURL}}
+			err := c.Verify()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedEngine, c.Engine)
+			assert.Equal(t, test.expectedFlavor, c.Flavor)
+		})
+	}
+}
+
+func TestServerPathPrefix(t *testing.T) {
+	s := &Server{PathPrefix: "/metrics-usage/"}
+	require.NoError(t, s.Verify())
+	assert.Equal(t, "/metrics-usage", s.PathPrefix)
+
+	s = &Server{PathPrefix: "metrics-usage"}
+	assert.EqualError(t, s.Verify(), `path_prefix: the path prefix "metrics-usage" must start with a /`)
+}
+
+func TestServerEvents(t *testing.T) {
+	s := &Server{Events: &Events{}}
+	require.NoError(t, s.Verify())
+	assert.Equal(t, 10, s.Events.MaxSubscribers)
+
+	s = &Server{Events: &Events{MaxSubscribers: -1}}
+	assert.EqualError(t, s.Verify(), `events.max_subscribers: invalid number of subscribers -1, it must be greater than 0`)
+}
+
+func TestGrafanaCollectorDeepScan(t *testing.T) {
+	grafanaURL, err := common.ParseURL("https://grafana.example.com")
+	require.NoError(t, err)
+	testSuite := []struct {
+		title         string
+		deepScan      bool
+		paths         []string
+		expectedPaths []string
+		expectedErr   bool
+	}{
+		{
+			title: "disabled",
+		},
+		{
+			title:         "default paths",
+			deepScan:      true,
+			expectedPaths: []string{"options", "fieldConfig.defaults.custom"},
+		},
+		{
+			title:         "custom paths",
+			deepScan:      true,
+			paths:         []string{"options.query"},
+			expectedPaths: []string{"options.query"},
+		},
+		{
+			title:       "paths without deep scan",
+			paths:       []string{"options.query"},
+			expectedErr: true,
+		},
+		{
+			title:       "invalid path",
+			deepScan:    true,
+			paths:       []string{"options..query"},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			c := &GrafanaCollector{Enable: true, DeepScan: test.deepScan, DeepScanPaths: test.paths, HTTPClient: HTTPClient{URL: grafanaURL}}
+			err := c.Verify()
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedPaths, c.DeepScanPaths)
+		})
+	}
+}
+
+func TestClassificationOwnershipFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "owners.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("kube_: platform\n"), 0600))
+	c := &Classification{OwnershipFile: path}
+	require.NoError(t, c.Verify())
+
+	require.NoError(t, os.WriteFile(path, []byte("kube_: \"\"\n"), 0600))
+	assert.EqualError(t, c.Verify(), `ownership_file: the owner of the prefix "kube_" is empty`)
+
+	c = &Classification{OwnershipFile: filepath.Join(dir, "missing.yaml")}
+	assert.Error(t, c.Verify())
+}
@@ -0,0 +1,62 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// fieldError is an error in the configuration with the path of the field concerned.
+type fieldError struct {
+	path    string
+	message string
+}
+
+func (e *fieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.path, e.message)
+}
+
+// verifyErrors collects every error found when verifying the configuration, so they can all be reported at once.
+type verifyErrors []error
+
+func (v *verifyErrors) add(path string, message string) {
+	*v = append(*v, &fieldError{path: path, message: message})
+}
+
+// addNested collects the errors returned by the verification of a nested part of the configuration.
+// The path of every error is prefixed by the path of the nested part.
+func (v *verifyErrors) addNested(prefix string, err error) {
+	if err == nil {
+		return
+	}
+	var errs []error
+	if joinedErr, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joinedErr.Unwrap()
+	} else {
+		errs = []error{err}
+	}
+	for _, e := range errs {
+		var fe *fieldError
+		if errors.As(e, &fe) {
+			*v = append(*v, &fieldError{path: fmt.Sprintf("%s.%s", prefix, fe.path), message: fe.message})
+		} else {
+			*v = append(*v, &fieldError{path: prefix, message: e.Error()})
+		}
+	}
+}
+
+func (v verifyErrors) err() error {
+	return errors.Join(v...)
+}
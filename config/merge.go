@@ -0,0 +1,52 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mergeConfigFiles reads the given YAML files and merges them in order, the later files overriding the earlier ones.
+// The mappings are merged recursively, while any other value, like a list, is replaced as a whole.
+func mergeConfigFiles(files []string) ([]byte, error) {
+	merged := make(map[string]any)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var content map[string]any
+		if err := yaml.Unmarshal(data, &content); err != nil {
+			return nil, fmt.Errorf("unable to parse the configuration file %q: %w", file, err)
+		}
+		mergeYAML(merged, content)
+	}
+	return yaml.Marshal(merged)
+}
+
+// mergeYAML merges src into dst.
+func mergeYAML(dst map[string]any, src map[string]any) {
+	for key, srcValue := range src {
+		srcMap, srcIsMap := srcValue.(map[string]any)
+		dstMap, dstIsMap := dst[key].(map[string]any)
+		if srcIsMap && dstIsMap {
+			mergeYAML(dstMap, srcMap)
+			continue
+		}
+		dst[key] = srcValue
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestResolveMultipleFiles(t *testing.T) {
+	commonFile := writeConfigFile(t, "common.yaml", `
+database:
+  in_memory: false
+  path: /data/metrics-usage.json
+  flush_period: 10m
+metric_collector:
+  enable: true
+  period: 1h
+  http_client:
+    url: https://prometheus.demo.do.prometheus.io
+perses_file_collector:
+  enable: true
+  paths: ["/dashboards/common/*.json"]
+`)
+	overlay := writeConfigFile(t, "production.yaml", `
+database:
+  path: /prod/metrics-usage.json
+metric_collector:
+  http_client:
+    url: https://prometheus.prod.example.com
+perses_file_collector:
+  paths: ["/dashboards/prod/*.json"]
+`)
+	t.Setenv("METRICS_USAGE_DATABASE_FLUSH_PERIOD", "1m")
+	c, err := Resolve(commonFile, overlay)
+	require.NoError(t, err)
+	assert.False(t, *c.Database.InMemory)
+	assert.Equal(t, "/prod/metrics-usage.json", c.Database.Path)
+	// The environment variables are applied after the merge of the files.
+	assert.Equal(t, model.Duration(time.Minute), c.Database.FlushPeriod)
+	assert.True(t, c.MetricCollector.Enable)
+	assert.Equal(t, model.Duration(time.Hour), c.MetricCollector.Period)
+	assert.Equal(t, "https://prometheus.prod.example.com", c.MetricCollector.HTTPClient.URL.String())
+	// The lists are replaced, not appended.
+	assert.Equal(t, []string{"/dashboards/prod/*.json"}, c.PersesFileCollector.Paths)
+}
+
+func TestResolveMultipleFilesUnknownField(t *testing.T) {
+	commonFile := writeConfigFile(t, "common.yaml", "database:\n  in_memory: true\n")
+	overlay := writeConfigFile(t, "overlay.yaml", "database:\n  unknown_field: true\n")
+	_, err := Resolve(commonFile, overlay)
+	assert.Error(t, err)
+}
+
+func TestMergeYAML(t *testing.T) {
+	dst := map[string]any{
+		"a": map[string]any{"b": 1, "c": 2},
+		"d": []any{1, 2},
+		"e": map[string]any{"f": 1},
+	}
+	mergeYAML(dst, map[string]any{
+		"a": map[string]any{"c": 3, "g": 4},
+		"d": []any{3},
+		"e": "replaced",
+	})
+	assert.Equal(t, map[string]any{
+		"a": map[string]any{"b": 1, "c": 3, "g": 4},
+		"d": []any{3},
+		"e": "replaced",
+	}, dst)
+}
@@ -0,0 +1,49 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "github.com/prometheus/common/model"
+
+const defaultNotifierTopN = 10
+
+type Notifier struct {
+	Enable bool `yaml:"enable"`
+	// Period is the frequency the notifier will check the number of unused metrics.
+	// It is only used when neither the metric collector nor a metric file collector is enabled,
+	// otherwise the notifier is triggered at the end of each of their runs.
+	Period model.Duration `yaml:"period,omitempty"`
+	// Delta is the minimum increase of the number of unused metrics between two checks to fire a notification.
+	Delta uint `yaml:"delta,omitempty"`
+	// TopN is the maximum number of newly unused metric names included in the notification.
+	TopN uint `yaml:"top_n,omitempty"`
+	// Webhook is the client used to send the notification. The payload is compatible with the Slack incoming webhooks.
+	Webhook HTTPClient `yaml:"webhook"`
+}
+
+func (n *Notifier) Verify() error {
+	if !n.Enable {
+		return nil
+	}
+	if n.Period <= 0 {
+		n.Period = model.Duration(defaultMetricCollectorPeriodDuration)
+	}
+	if n.TopN == 0 {
+		n.TopN = defaultNotifierTopN
+	}
+	var errs verifyErrors
+	if n.Webhook.URL == nil {
+		errs.add("webhook.url", "missing webhook URL for the notifier")
+	}
+	return errs.err()
+}
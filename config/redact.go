@@ -0,0 +1,104 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+
+	"gopkg.in/yaml.v3"
+)
+
+// redactedSecret replaces the secrets when the configuration is exposed.
+const redactedSecret = "<secret>"
+
+// secretFields are the keys of the configuration holding a secret, whatever the section they are in.
+// The TLS certificates are not secrets, but they are hidden as well, like Perses does, as they can be long inline PEM.
+var secretFields = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"client_secret": true,
+	"credentials":   true,
+	"ca":            true,
+	"cert":          true,
+	"key":           true,
+}
+
+// urlFields are the keys of the configuration holding a URL, that can contain a password.
+var urlFields = map[string]bool{
+	"url":       true,
+	"proxy_url": true,
+	"token_url": true,
+}
+
+// Redacted returns the configuration as a generic document, with the same keys as the configuration file,
+// where every secret (passwords, tokens, OAuth client secrets, TLS keys, headers values, the passwords in the URLs and the path of the webhooks) is replaced.
+// It is the way to expose the configuration actually used, once the defaults are set.
+func (c *Config) Redacted() (map[string]any, error) {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]any)
+	if err = yaml.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	redact(result)
+	return result, nil
+}
+
+func redact(value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			switch {
+			case field == nil:
+				continue
+			case secretFields[key]:
+				if secret, ok := field.(string); !ok || len(secret) > 0 {
+					v[key] = redactedSecret
+				}
+			case key == "headers":
+				// The headers can carry a token, like the header Authorization, so only their name is kept.
+				if headers, ok := field.(map[string]any); ok {
+					for name := range headers {
+						headers[name] = redactedSecret
+					}
+				}
+			case key == "webhook":
+				// The webhooks, like the Slack incoming webhooks, are usually authenticated by a token in their path or in their query.
+				redact(field)
+				if webhook, ok := field.(map[string]any); ok {
+					if rawURL, isString := webhook["url"].(string); isString {
+						if u, err := url.Parse(rawURL); err == nil {
+							webhook["url"] = fmt.Sprintf("%s://%s/%s", u.Scheme, u.Host, redactedSecret)
+						}
+					}
+				}
+			case urlFields[key]:
+				if rawURL, ok := field.(string); ok {
+					if u, err := url.Parse(rawURL); err == nil {
+						v[key] = u.Redacted()
+					}
+				}
+			default:
+				redact(field)
+			}
+		}
+	case []any:
+		for _, item := range v {
+			redact(item)
+		}
+	}
+}
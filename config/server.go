@@ -0,0 +1,167 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+type AuthScope string
+
+const (
+	// ReadScope gives access to the endpoints reading the data (GET requests).
+	ReadScope AuthScope = "read"
+	// WriteScope gives access to the endpoints modifying the data, like the ones used to push the usage.
+	WriteScope AuthScope = "write"
+)
+
+// APIUser is a user allowed to call the API, either with a basic auth (username and password) or with a bearer token.
+type APIUser struct {
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+	// Scopes is the list of scopes granted to the user. Default to read and write.
+	Scopes []AuthScope `yaml:"scopes,omitempty"`
+}
+
+func (u *APIUser) Verify() error {
+	var errs verifyErrors
+	if len(u.Token) == 0 && len(u.Username) == 0 {
+		errs.add("token", "either a token or a username must be defined")
+	}
+	if len(u.Token) > 0 && len(u.Username) > 0 {
+		errs.add("token", "a user cannot have both a token and a username")
+	}
+	if len(u.Username) > 0 && len(u.Password) == 0 {
+		errs.add("password", "missing password for the user")
+	}
+	if len(u.Scopes) == 0 {
+		u.Scopes = []AuthScope{ReadScope, WriteScope}
+	}
+	for i, scope := range u.Scopes {
+		if scope != ReadScope && scope != WriteScope {
+			errs.add(fmt.Sprintf("scopes[%d]", i), fmt.Sprintf("unknown scope %q, it must be one of %q or %q", scope, ReadScope, WriteScope))
+		}
+	}
+	return errs.err()
+}
+
+// HasScope returns true if the scope has been granted to the user.
+func (u *APIUser) HasScope(scope AuthScope) bool {
+	return slices.Contains(u.Scopes, scope)
+}
+
+// APIAuth is the authentication required to call the API.
+type APIAuth struct {
+	Users []*APIUser `yaml:"users"`
+}
+
+func (a *APIAuth) Verify() error {
+	var errs verifyErrors
+	if len(a.Users) == 0 {
+		errs.add("users", "at least one user must be defined when the authentication is enabled")
+	}
+	for i, user := range a.Users {
+		if user != nil {
+			errs.addNested(fmt.Sprintf("users[%d]", i), user.Verify())
+		}
+	}
+	return errs.err()
+}
+
+// defaultEventsMaxSubscribers is the number of clients allowed to follow the events at the same time.
+const defaultEventsMaxSubscribers = 10
+
+// Events enables the endpoint streaming the events of the collectors and of the database.
+type Events struct {
+	// MaxSubscribers is the number of clients allowed to follow the events at the same time. Default to 10.
+	MaxSubscribers int `yaml:"max_subscribers,omitempty"`
+}
+
+type Server struct {
+	// Auth is the authentication required to call the API. When not set, the API is open.
+	Auth *APIAuth `yaml:"auth,omitempty"`
+	// PathPrefix is the path under which the server is mounted, like /metrics-usage when it is exposed behind a reverse proxy.
+	PathPrefix string `yaml:"path_prefix,omitempty"`
+	// Events enables the endpoint /api/v1/events when it is set.
+	Events *Events `yaml:"events,omitempty"`
+	// Push limits the number of push requests processed at the same time when it is set.
+	Push *Push `yaml:"push,omitempty"`
+	// ResponseCache caches the responses of the endpoints computing a report over the whole database when it is set.
+	ResponseCache *ResponseCache `yaml:"response_cache,omitempty"`
+}
+
+func (s *Server) Verify() error {
+	var errs verifyErrors
+	if s.Auth != nil {
+		errs.addNested("auth", s.Auth.Verify())
+	}
+	if len(s.PathPrefix) > 0 {
+		if !strings.HasPrefix(s.PathPrefix, "/") {
+			errs.add("path_prefix", fmt.Sprintf("the path prefix %q must start with a /", s.PathPrefix))
+		}
+		s.PathPrefix = strings.TrimRight(s.PathPrefix, "/")
+	}
+	if s.Events != nil {
+		if s.Events.MaxSubscribers == 0 {
+			s.Events.MaxSubscribers = defaultEventsMaxSubscribers
+		}
+		if s.Events.MaxSubscribers < 0 {
+			errs.add("events.max_subscribers", fmt.Sprintf("invalid number of subscribers %d, it must be greater than 0", s.Events.MaxSubscribers))
+		}
+	}
+	if s.Push != nil {
+		if s.Push.MaxInFlight <= 0 {
+			errs.add("push.max_in_flight", fmt.Sprintf("invalid number of requests %d, it must be greater than 0", s.Push.MaxInFlight))
+		}
+		if s.Push.RetryAfter <= 0 {
+			s.Push.RetryAfter = model.Duration(defaultPushRetryAfter)
+		}
+	}
+	if s.ResponseCache != nil {
+		if s.ResponseCache.TTL == 0 {
+			s.ResponseCache.TTL = model.Duration(defaultResponseCacheTTL)
+		}
+		if s.ResponseCache.TTL < 0 {
+			errs.add("response_cache.ttl", fmt.Sprintf("invalid duration %s, it must be positive", s.ResponseCache.TTL))
+		}
+	}
+	return errs.err()
+}
+
+// defaultPushRetryAfter is the delay after which the clients are asked to retry a push request rejected.
+const defaultPushRetryAfter = 5 * time.Second
+
+// Push limits the requests pushing data, like the ones sent by the metric_usage_client of the collectors.
+type Push struct {
+	// MaxInFlight is the maximum number of push requests processed at the same time. The others are rejected with the status 503.
+	MaxInFlight int `yaml:"max_in_flight"`
+	// RetryAfter is the delay sent in the header Retry-After of the requests rejected. Default to 5s.
+	RetryAfter model.Duration `yaml:"retry_after,omitempty"`
+}
+
+// defaultResponseCacheTTL is how long a response is cached by default.
+const defaultResponseCacheTTL = time.Minute
+
+// ResponseCache caches the responses of the endpoints like /api/v1/graph, that are computed from the whole database at every call.
+// A response is only served from the cache as long as the data hasn't changed since it has been computed.
+type ResponseCache struct {
+	// TTL is how long a response is kept, even if the data didn't change. Default to 1m.
+	TTL model.Duration `yaml:"ttl,omitempty"`
+}
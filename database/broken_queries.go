@@ -0,0 +1,98 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// maxBrokenQueriesPerSource is the maximum number of broken queries kept for each source, so a source full of broken dashboards doesn't grow the memory without limit.
+const maxBrokenQueriesPerSource = 1000
+
+// SetBrokenQueries replaces the broken queries found by the previous run of the collector of the source.
+// The broken queries are only kept in memory, they are found again by the next run after a restart.
+func (d *db) SetBrokenQueries(source string, dashboards []v1.DashboardBrokenQueries) {
+	defer d.generation.Add(1)
+	d.brokenQueriesMutex.Lock()
+	defer d.brokenQueriesMutex.Unlock()
+	d.storeBrokenQueries(source, dashboards)
+}
+
+// MergeBrokenQueries replaces the broken queries of the dashboards analyzed by a run of the collector of the source that didn't complete.
+// The broken queries of the dashboards that haven't been analyzed are kept, as the run doesn't know if they are fixed.
+func (d *db) MergeBrokenQueries(source string, analyzedURLs []string, dashboards []v1.DashboardBrokenQueries) {
+	analyzed := make(map[string]bool, len(analyzedURLs)+len(dashboards))
+	for _, u := range analyzedURLs {
+		analyzed[u] = true
+	}
+	for _, dashboard := range dashboards {
+		analyzed[dashboard.Dashboard.URL] = true
+	}
+	defer d.generation.Add(1)
+	d.brokenQueriesMutex.Lock()
+	defer d.brokenQueriesMutex.Unlock()
+	var merged []v1.DashboardBrokenQueries
+	for _, dashboard := range d.brokenQueries[source] {
+		if !analyzed[dashboard.Dashboard.URL] {
+			merged = append(merged, dashboard)
+		}
+	}
+	d.storeBrokenQueries(source, append(merged, dashboards...))
+}
+
+// storeBrokenQueries stores the broken queries of the source, up to maxBrokenQueriesPerSource. The lock must be held by the caller.
+func (d *db) storeBrokenQueries(source string, dashboards []v1.DashboardBrokenQueries) {
+	var kept []v1.DashboardBrokenQueries
+	nbQueries := 0
+	truncated := false
+	for _, dashboard := range dashboards {
+		if remaining := maxBrokenQueriesPerSource - nbQueries; len(dashboard.Queries) > remaining {
+			dashboard.Queries = dashboard.Queries[:remaining]
+			truncated = true
+		}
+		if len(dashboard.Queries) == 0 {
+			continue
+		}
+		dashboard.Source = source
+		kept = append(kept, dashboard)
+		nbQueries += len(dashboard.Queries)
+	}
+	if truncated {
+		logrus.Warningf("too many broken queries found in %s, only the first %d are kept", source, maxBrokenQueriesPerSource)
+	}
+	if len(kept) == 0 {
+		delete(d.brokenQueries, source)
+		return
+	}
+	d.brokenQueries[source] = kept
+}
+
+// ListBrokenQueries returns the broken queries of every source, sorted by source and by dashboard.
+func (d *db) ListBrokenQueries() []v1.DashboardBrokenQueries {
+	d.brokenQueriesMutex.RLock()
+	defer d.brokenQueriesMutex.RUnlock()
+	var result []v1.DashboardBrokenQueries
+	for _, source := range slices.Sorted(maps.Keys(d.brokenQueries)) {
+		result = append(result, d.brokenQueries[source]...)
+	}
+	slices.SortStableFunc(result, func(a, b v1.DashboardBrokenQueries) int {
+		return cmp.Or(cmp.Compare(a.Source, b.Source), cmp.Compare(a.Dashboard.URL, b.Dashboard.URL))
+	})
+	return result
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetBrokenQueries(t *testing.T) {
+	d := &db{brokenQueries: make(map[string][]v1.DashboardBrokenQueries)}
+	query := v1.BrokenQuery{Message: "failed to extract metric names", Error: "parse error", Expression: "up{"}
+	d.SetBrokenQueries("http://perses", []v1.DashboardBrokenQueries{{Dashboard: v1.DashboardUsage{URL: "http://perses/b"}, Queries: []v1.BrokenQuery{query}}})
+	d.SetBrokenQueries("http://grafana", []v1.DashboardBrokenQueries{
+		{Dashboard: v1.DashboardUsage{URL: "http://grafana/d/b"}, Queries: []v1.BrokenQuery{query}},
+		{Dashboard: v1.DashboardUsage{URL: "http://grafana/d/a"}, Queries: []v1.BrokenQuery{query, query}},
+	})
+	result := d.ListBrokenQueries()
+	assert.Len(t, result, 3)
+	assert.Equal(t, "http://grafana", result[0].Source)
+	assert.Equal(t, "http://grafana/d/a", result[0].Dashboard.URL)
+	assert.Equal(t, "http://grafana/d/b", result[1].Dashboard.URL)
+	assert.Equal(t, "http://perses", result[2].Source)
+
+	// The next run replaces the broken queries of the source.
+	d.SetBrokenQueries("http://grafana", nil)
+	result = d.ListBrokenQueries()
+	assert.Len(t, result, 1)
+	assert.Equal(t, "http://perses", result[0].Source)
+
+	queries := make([]v1.BrokenQuery, maxBrokenQueriesPerSource)
+	d.SetBrokenQueries("http://grafana", []v1.DashboardBrokenQueries{
+		{Dashboard: v1.DashboardUsage{URL: "http://grafana/d/a"}, Queries: queries[:maxBrokenQueriesPerSource-1]},
+		{Dashboard: v1.DashboardUsage{URL: "http://grafana/d/b"}, Queries: queries[:2]},
+		{Dashboard: v1.DashboardUsage{URL: "http://grafana/d/c"}, Queries: queries[:1]},
+	})
+	result = d.ListBrokenQueries()
+	assert.Len(t, result, 3)
+	assert.Len(t, result[1].Queries, 1)
+}
+
+func TestMergeBrokenQueries(t *testing.T) {
+	d := &db{brokenQueries: make(map[string][]v1.DashboardBrokenQueries)}
+	query := v1.BrokenQuery{Message: "failed to extract metric names", Error: "parse error", Expression: "up{"}
+	d.SetBrokenQueries("http://grafana", []v1.DashboardBrokenQueries{
+		{Dashboard: v1.DashboardUsage{URL: "http://grafana/d/a"}, Queries: []v1.BrokenQuery{query}},
+		{Dashboard: v1.DashboardUsage{URL: "http://grafana/d/b"}, Queries: []v1.BrokenQuery{query}},
+		{Dashboard: v1.DashboardUsage{URL: "http://grafana/d/c"}, Queries: []v1.BrokenQuery{query}},
+	})
+	// The run has been interrupted after analyzing the dashboards a and b: a is fixed, b is still broken, c is kept as is.
+	d.MergeBrokenQueries("http://grafana", []string{"http://grafana/d/a", "http://grafana/d/b"}, []v1.DashboardBrokenQueries{
+		{Dashboard: v1.DashboardUsage{URL: "http://grafana/d/b"}, Queries: []v1.BrokenQuery{query, query}},
+	})
+	result := d.ListBrokenQueries()
+	assert.Len(t, result, 2)
+	assert.Equal(t, "http://grafana/d/b", result[0].Dashboard.URL)
+	assert.Len(t, result[0].Queries, 2)
+	assert.Equal(t, "http://grafana/d/c", result[1].Dashboard.URL)
+	assert.Equal(t, "http://grafana", result[1].Source)
+}
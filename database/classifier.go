@@ -0,0 +1,73 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/perses/metrics-usage/config"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+)
+
+var (
+	// runtimeMetricPrefixes are the prefixes of the metrics exposed by default by the Prometheus client libraries.
+	runtimeMetricPrefixes = []string{"go_", "process_", "promhttp_"}
+	// scrapeMetrics are the metrics generated by Prometheus for every target scraped.
+	scrapeMetrics = v1.NewSet(
+		"up",
+		"scrape_duration_seconds",
+		"scrape_samples_scraped",
+		"scrape_samples_post_metric_relabeling",
+		"scrape_series_added",
+	)
+)
+
+// classifier is flagging the metrics as internal based on the classification configuration.
+type classifier struct {
+	prefixes []string
+	regexps  []common.Regexp
+	names    v1.Set[string]
+}
+
+func newClassifier(cfg config.Classification) *classifier {
+	c := &classifier{
+		prefixes: slices.Clone(cfg.InternalPrefixes),
+		regexps:  cfg.InternalRegexps,
+		names:    v1.Set[string]{},
+	}
+	if cfg.RuntimeMetricsAsInternal {
+		c.prefixes = append(c.prefixes, runtimeMetricPrefixes...)
+		c.names.Merge(scrapeMetrics)
+	}
+	return c
+}
+
+func (c *classifier) isInternal(metricName string) bool {
+	if c.names.Contains(metricName) {
+		return true
+	}
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(metricName, prefix) {
+			return true
+		}
+	}
+	for _, re := range c.regexps {
+		if re.MatchString(metricName) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifierIsInternal(t *testing.T) {
+	c := newClassifier(config.Classification{
+		InternalPrefixes:         []string{"kube_"},
+		InternalRegexps:          []common.Regexp{common.MustNewRegexp("^.+_exporter_.+$")},
+		RuntimeMetricsAsInternal: true,
+	})
+	testSuite := []struct {
+		metric   string
+		internal bool
+	}{
+		{metric: "kube_pod_info", internal: true},
+		{metric: "node_exporter_build_info", internal: true},
+		{metric: "go_goroutines", internal: true},
+		{metric: "process_cpu_seconds_total", internal: true},
+		{metric: "up", internal: true},
+		{metric: "upstream_requests_total", internal: false},
+		{metric: "http_requests_total", internal: false},
+	}
+	for _, test := range testSuite {
+		t.Run(test.metric, func(t *testing.T) {
+			assert.Equal(t, test.internal, c.isInternal(test.metric))
+		})
+	}
+	assert.False(t, newClassifier(config.Classification{}).isInternal("go_goroutines"))
+}
@@ -14,54 +14,139 @@
 package database
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"maps"
 	"os"
-	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/brunoga/deep"
 	"github.com/perses/metrics-usage/config"
 	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/perses/metrics-usage/utils/pubsub"
 	"github.com/perses/perses/pkg/model/api/v1/common"
 	"github.com/sirupsen/logrus"
 )
 
-var replaceVariableRegexp = regexp.MustCompile(`\$\{[a-zA-Z0-9_:]+}`)
-
 type Database interface {
 	GetMetric(name string) *v1.Metric
+	GetMetricUsage(name string) (*v1.MetricUsage, map[string]*v1.MetricUsage, bool)
 	ListMetrics() (map[string]*v1.Metric, error)
+	// ListLiveMetrics is like ListMetrics, except that the metrics are always read from the live data, even when read_from_snapshot is set.
+	ListLiveMetrics() (map[string]*v1.Metric, error)
+	IterateMetrics(fn func(name string, metric *v1.Metric) error) error
 	ListPartialMetrics() (map[string]*v1.PartialMetric, error)
 	ListPendingUsage() map[string]*v1.MetricUsage
 	EnqueueMetricList(metrics []string)
+	// EnqueueMetricListFrom is like EnqueueMetricList, except that the metrics are recorded as collected from the given Prometheus.
+	EnqueueMetricListFrom(source string, metrics []string)
+	// WaitForMetricLists waits until every list of metrics enqueued before the call has been written in the database.
+	WaitForMetricLists(ctx context.Context) error
 	EnqueuePartialMetricsUsage(usages map[string]*v1.MetricUsage)
 	EnqueueUsage(usages map[string]*v1.MetricUsage)
 	EnqueueLabels(labels map[string][]string)
+	// EnqueueLabelsReplacement is like EnqueueLabels, except that the labels of each metric replace the previous ones instead of being added to them.
+	EnqueueLabelsReplacement(labels map[string][]string)
+	EnqueueUsedLabels(usedLabels *v1.UsedLabels)
+	// EnqueueMetadata stores the metadata of the metric families, like returned by the Prometheus metadata API.
+	// The metadata of a histogram or of a summary is set on its series (like _bucket, _count and _sum), not on the family name.
+	EnqueueMetadata(metadata map[string]v1.MetricMetadata)
+	EnqueueReconciliation(r *Reconciliation)
+	RecomputePartialMetrics() (int, int)
+	Reset() error
+	// SaveSnapshot stores a copy of the current metrics under the given name, so it can be compared later with another state.
+	SaveSnapshot(name string) error
+	GetSnapshot(name string) map[string]*v1.Metric
+	ListSnapshots() []string
+	// DeleteSnapshot removes the snapshot saved under the given name. It returns false if there is no such snapshot.
+	DeleteSnapshot(name string) (bool, error)
+	// ClaimIdempotencyKey records the key of a request writing data. It returns false if the key has already been claimed recently,
+	// meaning the request is a retry of a request already received. In this case, it also tells if this request has been processed, or is still being processed.
+	ClaimIdempotencyKey(key string) (claimed bool, processed bool)
+	// CompleteIdempotencyKey records that the request having this key has been processed.
+	CompleteIdempotencyKey(key string)
+	// ReleaseIdempotencyKey forgets the key, so the request can be retried when it couldn't be processed.
+	ReleaseIdempotencyKey(key string)
+	// SetBrokenQueries replaces the queries of the dashboards of the source that couldn't be analyzed.
+	SetBrokenQueries(source string, dashboards []v1.DashboardBrokenQueries)
+	// MergeBrokenQueries replaces the queries of the dashboards analyzed by a run that didn't complete. The dashboards are identified by their URL.
+	MergeBrokenQueries(source string, analyzedURLs []string, dashboards []v1.DashboardBrokenQueries)
+	ListBrokenQueries() []v1.DashboardBrokenQueries
+	// Generation is a counter increased every time the data is changed.
+	// Two reads getting the same generation are reading the same data, it is used to invalidate the responses cached.
+	Generation() uint64
 }
 
-func New(cfg config.Database) Database {
+func New(cfg config.Database, classification config.Classification) Database {
 	d := &db{
+		classifier:               newClassifier(classification),
+		ownership:                newOwnership(classification.OwnershipFile),
 		metrics:                  make(map[string]*v1.Metric),
 		partialMetrics:           make(map[string]*v1.PartialMetric),
 		usage:                    make(map[string]*v1.MetricUsage),
+		globalUsedLabels:         v1.NewSet[string](),
+		savedSnapshots:           make(map[string]*savedSnapshot),
+		idempotencyKeys:          make(map[string]*idempotencyKey),
+		brokenQueries:            make(map[string][]v1.DashboardBrokenQueries),
 		usageQueue:               make(chan map[string]*v1.MetricUsage, 250),
 		partialMetricsUsageQueue: make(chan map[string]*v1.MetricUsage, 250),
-		labelsQueue:              make(chan map[string][]string, 250),
-		metricsQueue:             make(chan []string, 10),
+		labelsQueue:              make(chan *labelsBatch, 250),
+		usedLabelsQueue:          make(chan *v1.UsedLabels, 250),
+		metadataQueue:            make(chan *metadataBatch, 10),
+		metricsQueue:             make(chan *metricsBatch, 10),
+		reconcileQueue:           make(chan *Reconciliation, 10),
 		path:                     cfg.Path,
+		inMemory:                 *cfg.InMemory,
+		readFromSnapshot:         cfg.ReadFromSnapshot,
+		maxMatchingMetrics:       cfg.MaxMatchingMetrics,
+		maxSnapshots:             cfg.MaxSnapshots,
+	}
+	if cfg.Retention != nil {
+		d.unusedMaxAge = time.Duration(cfg.Retention.UnusedMaxAge)
 	}
 
+	stats.db.Store(d)
 	go d.watchUsageQueue()
 	go d.watchMetricsQueue()
 	go d.watchPartialMetricsUsageQueue()
 	go d.watchLabelsQueue()
+	go d.watchUsedLabelsQueue()
+	go d.watchMetadataQueue()
+	go d.watchReconcileQueue()
+	go d.pruneIdempotencyKeys()
+	if d.ownership != nil {
+		go d.ownership.watch()
+	}
 	if !*cfg.InMemory {
 		if err := d.readMetricsInJSONFile(); err != nil {
 			logrus.WithError(err).Warning("failed to read metrics file")
 		}
+		if err := d.readSnapshotFiles(); err != nil {
+			logrus.WithError(err).Warning("failed to read the saved snapshots")
+		}
+		// The classification may have changed since the file has been written.
+		// The metrics written before the LastSeen was recorded are considered seen now,
+		// so they are only dropped by the retention once they haven't been reported for the whole period.
+		now := time.Now()
+		for metricName, metric := range d.metrics {
+			metric.IsInternal = d.classifier.isInternal(metricName)
+			if metric.LastSeen == nil {
+				d.markSeen(metric, now)
+			}
+		}
+	}
+	// The imported metrics are merged with the ones read from the database file.
+	d.importFiles(cfg.ImportPaths)
+	if cfg.ReadFromSnapshot {
+		if err := d.refreshSnapshot(); err != nil {
+			logrus.WithError(err).Error("unable to create the snapshot of the metrics")
+		}
+	}
+	if !*cfg.InMemory || cfg.ReadFromSnapshot {
 		go d.flush(time.Duration(cfg.FlushPeriod))
 	}
 	return d
@@ -76,13 +161,20 @@ type db struct {
 	partialMetrics map[string]*v1.PartialMetric
 	// usage is a buffer in case the metric name has not yet been collected
 	usage map[string]*v1.MetricUsage
+	// globalUsedLabels are the labels used without a metric, like in label_values(label). They are used by every metric having them.
+	// Like metrics, it is protected by metricsMutex.
+	globalUsedLabels v1.Set[string]
 	// metricsQueue is the channel that should be used to send and receive the list of metric name to keep in memory.
 	// Based on this list, we will then collect their usage.
-	metricsQueue chan []string
+	metricsQueue chan *metricsBatch
 	// labelsQueue is the way to send the labels per metric to write in the database.
 	// There will be no other way to write in it.
 	// Doing that allows us to accept more HTTP requests to write data and to delay the actual writing.
-	labelsQueue chan map[string][]string
+	labelsQueue chan *labelsBatch
+	// usedLabelsQueue is the way to send the labels used by the dashboards to write in the database.
+	usedLabelsQueue chan *v1.UsedLabels
+	// metadataQueue is the way to send the metadata (type and help) per metric family or per metric to write in the database.
+	metadataQueue chan *metadataBatch
 	// usageQueue is the way to send the usage per metric to write in the database.
 	// There will be no other way to write in it.
 	// Doing that allows us to accept more HTTP requests to write data and to delay the actual writing.
@@ -91,9 +183,41 @@ type db struct {
 	// There will be no other way to write in it.
 	// Doing that allows us to accept more HTTP requests to write data and to delay the actual writing.
 	partialMetricsUsageQueue chan map[string]*v1.MetricUsage
+	// reconcileQueue is the way to send the whole usage collected from a source, replacing the previous one.
+	reconcileQueue chan *Reconciliation
+	// classifier is used to flag the internal metrics when they are added.
+	classifier *classifier
+	// ownership is used to set the owner of the metrics when they are read. It is nil when no ownership file is configured.
+	ownership *ownership
 	// path is the path to the JSON file where metrics is flushed periodically
 	// It is empty if the database is purely in memory.
-	path string
+	path     string
+	inMemory bool
+	// unusedMaxAge is how long a metric without usage is kept once it is not seen anymore. Zero means forever.
+	unusedMaxAge time.Duration
+	// maxMatchingMetrics is the maximum number of metrics a partial metric can match before being flagged as too broad. Zero means no limit.
+	maxMatchingMetrics int
+	// readFromSnapshot is true when the metrics are read from snapshot instead of the live data.
+	readFromSnapshot bool
+	// snapshot is a copy of the metrics, refreshed at every flush, used to list the metrics without contending with the writers.
+	snapshot atomic.Pointer[map[string]*v1.Metric]
+	// savedSnapshots are the copies of the metrics saved under a name, to compare the usage between two points in time.
+	// They are not touched by Reset.
+	savedSnapshots      map[string]*savedSnapshot
+	savedSnapshotsMutex sync.RWMutex
+	// maxSnapshots is the maximum number of saved snapshots. Beyond it, the oldest ones are evicted.
+	maxSnapshots int
+	// idempotencyKeys are the keys of the requests recently received, with the time they have been claimed and whether they have been processed.
+	idempotencyKeys      map[string]*idempotencyKey
+	idempotencyKeysMutex sync.Mutex
+	// brokenQueries are the queries that couldn't be analyzed by the last run of each dashboard collector, indexed by the source.
+	brokenQueries      map[string][]v1.DashboardBrokenQueries
+	brokenQueriesMutex sync.RWMutex
+	// generation is increased once a change has been applied, after the locks are released.
+	generation atomic.Uint64
+	// metricListsEnqueued and metricListsApplied count the lists of metrics sent to metricsQueue, and the ones written (or dropped by Reset).
+	metricListsEnqueued atomic.Uint64
+	metricListsApplied  atomic.Uint64
 	// We are expecting to spend more time to write data than actually read.
 	// Which result having too many writers,
 	// and so unable to read the data because the lock queue is too long to be able to access to the data.
@@ -101,36 +225,172 @@ type db struct {
 	// 1. Then let's flush the data into a file periodically (or once the queue is empty (if it happens))
 	// 2. Read the file directly when a read query is coming
 	// Like that we have two different ways to read and write the data.
-	metricsMutex             sync.Mutex
-	partialMetricsUsageMutex sync.Mutex
+	//
+	// The readers only take a read lock, so they don't block each other.
+	// To avoid any deadlock, when both locks are held, partialMetricsUsageMutex is always taken first, then metricsMutex.
+	// The opposite order must never happen: a function holding metricsMutex must release it before taking partialMetricsUsageMutex.
+	// lockAll is the way to take both locks at once. matchPartialMetric and metricSources are the only other places taking metricsMutex
+	// while partialMetricsUsageMutex is already held.
+	metricsMutex             sync.RWMutex
+	partialMetricsUsageMutex sync.RWMutex
 }
 
-func (d *db) GetMetric(name string) *v1.Metric {
+// lockAll takes both locks, in the order required to avoid any deadlock. It must be released with unlockAll.
+func (d *db) lockAll() {
+	d.partialMetricsUsageMutex.Lock()
 	d.metricsMutex.Lock()
-	defer d.metricsMutex.Unlock()
-	return d.metrics[name]
+}
+
+// unlockAll releases the locks taken by lockAll, in the reverse order.
+func (d *db) unlockAll() {
+	d.metricsMutex.Unlock()
+	d.partialMetricsUsageMutex.Unlock()
+}
+
+// GetMetric returns a copy of the metric, or nil if the metric is not known.
+func (d *db) GetMetric(name string) *v1.Metric {
+	d.metricsMutex.RLock()
+	metric, exists := d.metrics[name]
+	if exists {
+		metric = deep.MustCopy(metric)
+	}
+	d.metricsMutex.RUnlock()
+	if !exists {
+		return nil
+	}
+	d.ownership.setOwner(name, metric)
+	return metric
+}
+
+// GetMetricUsage returns the usage of the given metric and the usage of every partial metric matching it, indexed by the partial metric name.
+// The last value returned is false if the metric is not known.
+func (d *db) GetMetricUsage(name string) (*v1.MetricUsage, map[string]*v1.MetricUsage, bool) {
+	d.metricsMutex.RLock()
+	metric, exists := d.metrics[name]
+	var usage *v1.MetricUsage
+	if exists && metric.Usage != nil {
+		usage = deep.MustCopy(metric.Usage)
+	}
+	d.metricsMutex.RUnlock()
+	if !exists {
+		return nil, nil, false
+	}
+	// metricsMutex is released before taking partialMetricsUsageMutex to respect the lock order.
+	d.partialMetricsUsageMutex.RLock()
+	defer d.partialMetricsUsageMutex.RUnlock()
+	partialUsages := make(map[string]*v1.MetricUsage)
+	for partialMetricName, partialMetric := range d.partialMetrics {
+		if partialMetric.Usage == nil {
+			continue
+		}
+		if partialMetric.MatchingMetrics.Contains(name) || (partialMetric.MatchingRegexp != nil && isMatching(partialMetric.MatchingRegexp, name)) {
+			partialUsages[partialMetricName] = deep.MustCopy(partialMetric.Usage)
+		}
+	}
+	return usage, partialUsages, true
 }
 
 func (d *db) ListMetrics() (map[string]*v1.Metric, error) {
-	d.metricsMutex.Lock()
-	defer d.metricsMutex.Unlock()
-	return deep.Copy(d.metrics)
+	if snapshot := d.snapshot.Load(); snapshot != nil {
+		// The snapshot is never modified, so it doesn't need any lock.
+		// It is copied anyway as the caller can modify the result.
+		metrics, err := deep.Copy(*snapshot)
+		if err != nil {
+			return nil, err
+		}
+		d.ownership.setOwners(metrics)
+		return metrics, nil
+	}
+	return d.ListLiveMetrics()
+}
+
+func (d *db) ListLiveMetrics() (map[string]*v1.Metric, error) {
+	d.metricsMutex.RLock()
+	metrics, err := deep.Copy(d.metrics)
+	d.metricsMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	d.ownership.setOwners(metrics)
+	return metrics, nil
+}
+
+// IterateMetrics calls fn for every metric, sorted by name, with a copy of the metric that can be modified.
+// Unlike ListMetrics, the metrics are copied one by one, so the whole list is never copied at once,
+// and the lock is not held while fn is running. It stops at the first error returned by fn.
+func (d *db) IterateMetrics(fn func(name string, metric *v1.Metric) error) error {
+	if snapshot := d.snapshot.Load(); snapshot != nil {
+		for _, name := range slices.Sorted(maps.Keys(*snapshot)) {
+			metric := deep.MustCopy((*snapshot)[name])
+			d.ownership.setOwner(name, metric)
+			if err := fn(name, metric); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	d.metricsMutex.RLock()
+	names := slices.Sorted(maps.Keys(d.metrics))
+	d.metricsMutex.RUnlock()
+	for _, name := range names {
+		d.metricsMutex.RLock()
+		metric, exists := d.metrics[name]
+		if exists {
+			metric = deep.MustCopy(metric)
+		}
+		d.metricsMutex.RUnlock()
+		if !exists {
+			continue
+		}
+		d.ownership.setOwner(name, metric)
+		if err := fn(name, metric); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (d *db) ListPartialMetrics() (map[string]*v1.PartialMetric, error) {
-	d.partialMetricsUsageMutex.Lock()
-	defer d.partialMetricsUsageMutex.Unlock()
+	d.partialMetricsUsageMutex.RLock()
+	defer d.partialMetricsUsageMutex.RUnlock()
 	return deep.Copy(d.partialMetrics)
 }
 
+// metricsBatch is a list of metric names waiting to be written in the database.
+type metricsBatch struct {
+	metrics []string
+	// source is the URL of the Prometheus the metrics have been collected from. It is empty when unknown.
+	source string
+}
+
 func (d *db) EnqueueMetricList(metrics []string) {
-	d.metricsQueue <- metrics
+	d.metricListsEnqueued.Add(1)
+	d.metricsQueue <- &metricsBatch{metrics: metrics}
+}
+
+func (d *db) EnqueueMetricListFrom(source string, metrics []string) {
+	d.metricListsEnqueued.Add(1)
+	d.metricsQueue <- &metricsBatch{metrics: metrics, source: source}
+}
+
+func (d *db) WaitForMetricLists(ctx context.Context) error {
+	target := d.metricListsEnqueued.Load()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for d.metricListsApplied.Load() < target {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
 }
 
 func (d *db) ListPendingUsage() map[string]*v1.MetricUsage {
-	d.metricsMutex.Lock()
-	defer d.metricsMutex.Unlock()
-	return d.usage
+	d.metricsMutex.RLock()
+	defer d.metricsMutex.RUnlock()
+	return deep.MustCopy(d.usage)
 }
 
 func (d *db) EnqueueUsage(usages map[string]*v1.MetricUsage) {
@@ -141,57 +401,248 @@ func (d *db) EnqueuePartialMetricsUsage(usages map[string]*v1.MetricUsage) {
 	d.partialMetricsUsageQueue <- usages
 }
 
+// labelsBatch is a set of labels per metric waiting to be written in the database.
+type labelsBatch struct {
+	labels map[string][]string
+	// replace is true when the labels are the complete set of labels of each metric, replacing the previous ones.
+	replace bool
+}
+
 func (d *db) EnqueueLabels(labels map[string][]string) {
-	d.labelsQueue <- labels
+	d.labelsQueue <- &labelsBatch{labels: labels}
+}
+
+func (d *db) EnqueueLabelsReplacement(labels map[string][]string) {
+	d.labelsQueue <- &labelsBatch{labels: labels, replace: true}
+}
+
+func (d *db) EnqueueUsedLabels(usedLabels *v1.UsedLabels) {
+	d.usedLabelsQueue <- usedLabels
+}
+
+// RecomputePartialMetrics rebuilds the regexp and the list of matching metrics of every partial metric against the current list of metrics,
+// including the metrics only known through their pending usage.
+// It returns the number of partial metrics having a regexp and the total number of matches found.
+func (d *db) RecomputePartialMetrics() (int, int) {
+	// The metric names are collected first, so metricsMutex is not held while the partial metrics are updated.
+	// A metric received in between will be matched by the queue watcher anyway.
+	d.metricsMutex.RLock()
+	metricNames := make([]string, 0, len(d.metrics)+len(d.usage))
+	metricSources := make(map[string]v1.Set[string])
+	for metricName, metric := range d.metrics {
+		metricNames = append(metricNames, metricName)
+		if len(metric.Sources) > 0 {
+			metricSources[metricName] = maps.Clone(metric.Sources)
+		}
+	}
+	for metricName := range d.usage {
+		metricNames = append(metricNames, metricName)
+	}
+	d.metricsMutex.RUnlock()
+
+	d.partialMetricsUsageMutex.Lock()
+	defer d.partialMetricsUsageMutex.Unlock()
+	nbPartialMetrics := 0
+	nbMatches := 0
+	for partialMetricName, partialMetric := range d.partialMetrics {
+		re, err := v1.PartialMetricRegexp(partialMetricName)
+		if err != nil {
+			logrus.WithError(err).Errorf("unable to compile the partial metric name %q into a regexp", partialMetricName)
+		}
+		partialMetric.MatchingRegexp = re
+		partialMetric.MatchingMetrics = nil
+		partialMetric.TooBroad = false
+		partialMetric.Sources = partialMetric.Usage.Sources()
+		if re == nil {
+			continue
+		}
+		nbPartialMetrics++
+		matchingMetrics := v1.NewSet[string]()
+		for _, metricName := range metricNames {
+			if isMatching(re, metricName) && partialMetric.IsMatchingSources(metricSources[metricName]) {
+				matchingMetrics.Add(metricName)
+				if d.isTooBroad(matchingMetrics) {
+					break
+				}
+			}
+		}
+		d.setMatchingMetrics(partialMetric, matchingMetrics)
+		nbMatches += len(partialMetric.MatchingMetrics)
+	}
+	d.generation.Add(1)
+	return nbPartialMetrics, nbMatches
+}
+
+// Reset removes every metric, partial metric, pending usage and broken query, and drops the data waiting in the queues.
+// When the database is stored in a file, the file is emptied as well.
+// The data being written by a queue watcher at the same time can still be stored once the reset is over.
+func (d *db) Reset() error {
+	d.lockAll()
+	d.metricListsApplied.Add(uint64(drainQueue(d.metricsQueue)))
+	drainQueue(d.usageQueue)
+	drainQueue(d.partialMetricsUsageQueue)
+	drainQueue(d.labelsQueue)
+	drainQueue(d.usedLabelsQueue)
+	drainQueue(d.metadataQueue)
+	drainQueue(d.reconcileQueue)
+	d.metrics = make(map[string]*v1.Metric)
+	d.partialMetrics = make(map[string]*v1.PartialMetric)
+	d.usage = make(map[string]*v1.MetricUsage)
+	d.globalUsedLabels = v1.NewSet[string]()
+	d.unlockAll()
+	d.brokenQueriesMutex.Lock()
+	d.brokenQueries = make(map[string][]v1.DashboardBrokenQueries)
+	d.brokenQueriesMutex.Unlock()
+	if d.readFromSnapshot {
+		d.snapshot.Store(&map[string]*v1.Metric{})
+	}
+	d.generation.Add(1)
+	pubsub.Publish(pubsub.Event{Kind: pubsub.DatabaseResetKind})
+	if d.inMemory {
+		return nil
+	}
+	return d.writeMetricsInJSONFile()
+}
+
+func (d *db) Generation() uint64 {
+	return d.generation.Load()
+}
+
+// drainQueue removes, without blocking, every element waiting in the queue.
+// It returns the number of elements removed.
+func drainQueue[T any](queue chan T) int {
+	n := 0
+	for {
+		select {
+		case <-queue:
+			n++
+		default:
+			return n
+		}
+	}
+}
+
+type metadataBatch struct {
+	metadata map[string]v1.MetricMetadata
+	// families is true when the metadata is indexed by metric family, like returned by the Prometheus metadata API.
+	// Otherwise, it is indexed by metric name, like in the files imported.
+	families bool
+}
+
+func (d *db) EnqueueMetadata(metadata map[string]v1.MetricMetadata) {
+	d.metadataQueue <- &metadataBatch{metadata: metadata, families: true}
+}
+
+// seriesOfFamily returns the names of the metrics exposed by the metric family. metricsMutex must be held.
+// A counter family is exposed with the suffix _total by the OpenMetrics format, so the metric with the suffix is used when it is the one known.
+func (d *db) seriesOfFamily(family string, metadata v1.MetricMetadata) []string {
+	if metadata.Type == "counter" && !strings.HasSuffix(family, "_total") {
+		if _, ok := d.metrics[family]; !ok {
+			if _, ok := d.metrics[family+"_total"]; ok {
+				return []string{family + "_total"}
+			}
+		}
+	}
+	return metadata.SeriesNames(family)
+}
+
+// newMetric returns an empty metric, classified according to its name.
+func (d *db) newMetric(metricName string) *v1.Metric {
+	metric := &v1.Metric{
+		Labels:     make(v1.Set[string]),
+		IsInternal: d.classifier.isInternal(metricName),
+	}
+	d.markSeen(metric, time.Now())
+	return metric
+}
+
+// addMetric adds a new metric to the database, with the usage waiting for it in the pending buffer.
+// metricsMutex must be held by the caller.
+func (d *db) addMetric(metricName string) *v1.Metric {
+	metric := d.newMetric(metricName)
+	if usage, usageExists := d.usage[metricName]; usageExists {
+		// TODO at some point we need to erase the usage map because it will cause a memory leak
+		metric.Usage = usage
+		delete(d.usage, metricName)
+	}
+	d.metrics[metricName] = metric
+	return metric
+}
+
+// markSeen records that the metric has been reported at the given time. It is only tracked when the retention is enabled.
+func (d *db) markSeen(metric *v1.Metric, t time.Time) {
+	if d.unusedMaxAge > 0 {
+		metric.LastSeen = &t
+	}
 }
 
 func (d *db) watchMetricsQueue() {
-	for metricsName := range d.metricsQueue {
+	for batch := range d.metricsQueue {
+		var newMetrics []string
+		// newSources are the metrics already known, collected from a new source. They can match more partial metrics.
+		var newSources []string
 		d.metricsMutex.Lock()
-		for _, metricName := range metricsName {
-			if _, ok := d.metrics[metricName]; !ok {
-				// As this queue only serves the purpose of storing missing metrics, we are only looking for the one not already present in the database.
-				d.metrics[metricName] = &v1.Metric{
-					Labels: make(v1.Set[string]),
-				}
-				d.matchValidMetric(metricName)
-				// Since it's a new metric, potentially we already have a usage stored in the buffer.
-				if usage, usageExists := d.usage[metricName]; usageExists {
-					// TODO at some point we need to erase the usage map because it will cause a memory leak
-					d.metrics[metricName].Usage = usage
-					delete(d.usage, metricName)
+		now := time.Now()
+		for _, metricName := range batch.metrics {
+			if metric, ok := d.metrics[metricName]; ok {
+				// The metric is already known, it only needs to be marked as seen for the retention.
+				d.markSeen(metric, now)
+				if addSource(metric, batch.source) {
+					newSources = append(newSources, metricName)
 				}
+				continue
 			}
+			// As this queue only serves the purpose of storing missing metrics, we are only looking for the one not already present in the database.
+			// Since it's a new metric, potentially we already have a usage stored in the buffer.
+			addSource(d.addMetric(metricName), batch.source)
+			newMetrics = append(newMetrics, metricName)
 		}
 		d.metricsMutex.Unlock()
+		// The partial metrics are matched once metricsMutex is released, to respect the lock order.
+		d.matchValidMetrics(append(newMetrics, newSources...))
+		d.generation.Add(1)
+		d.metricListsApplied.Add(1)
+		pubsub.PublishMetrics(pubsub.MetricsAddedKind, newMetrics)
 	}
 }
 
+// addSource records that the metric has been collected from the source. It returns true if the source is new for the metric.
+func addSource(metric *v1.Metric, source string) bool {
+	if len(source) == 0 || metric.Sources.Contains(source) {
+		return false
+	}
+	if metric.Sources == nil {
+		metric.Sources = v1.NewSet[string]()
+	}
+	metric.Sources.Add(source)
+	return true
+}
+
 func (d *db) watchPartialMetricsUsageQueue() {
 	for data := range d.partialMetricsUsageQueue {
 		d.partialMetricsUsageMutex.Lock()
 		for metricName, usage := range data {
-			if _, ok := d.partialMetrics[metricName]; !ok {
-				re, matchingMetrics := d.matchPartialMetric(metricName)
-				d.partialMetrics[metricName] = &v1.PartialMetric{
-					Usage:           usage,
-					MatchingMetrics: matchingMetrics,
-					MatchingRegexp:  re,
-				}
-			} else {
-				d.partialMetrics[metricName].Usage = v1.MergeUsage(d.partialMetrics[metricName].Usage, usage)
+			var previous *v1.MetricUsage
+			if partialMetric, ok := d.partialMetrics[metricName]; ok {
+				previous = partialMetric.Usage
 			}
+			d.setPartialMetricUsage(metricName, v1.MergeUsage(previous, usage))
 		}
 		d.partialMetricsUsageMutex.Unlock()
+		d.generation.Add(1)
 	}
 }
 
 func (d *db) watchUsageQueue() {
 	for data := range d.usageQueue {
+		var newPendingMetrics []string
 		d.metricsMutex.Lock()
 		for metricName, usage := range data {
 			if _, ok := d.metrics[metricName]; !ok {
 				logrus.Debugf("metric_name %q is used but it's not found by the metric collector", metricName)
+				if _, pending := d.usage[metricName]; !pending {
+					newPendingMetrics = append(newPendingMetrics, metricName)
+				}
 				// Since the metric_name is not known yet, we need to buffer it.
 				// In a later stage, if the metric is received/known,
 				// we will then use this buffer to populate the usage of the metric.
@@ -201,27 +652,128 @@ func (d *db) watchUsageQueue() {
 			}
 		}
 		d.metricsMutex.Unlock()
+		// The metrics only known through their usage are a concrete metric as well, so they are matched by the partial metrics
+		// without waiting for the metric collector.
+		d.matchValidMetrics(newPendingMetrics)
+		d.generation.Add(1)
 	}
 }
 
 func (d *db) watchLabelsQueue() {
-	for data := range d.labelsQueue {
+	for batch := range d.labelsQueue {
+		var newMetrics []string
 		d.metricsMutex.Lock()
-		for metricName, labels := range data {
+		now := time.Now()
+		for metricName, labels := range batch.labels {
 			if _, ok := d.metrics[metricName]; !ok {
 				// In this case, we should add the metric, because it means the metrics has been found from another source.
-				d.metrics[metricName] = &v1.Metric{
-					Labels: v1.NewSet(labels...),
-				}
+				d.addMetric(metricName).Labels.Add(labels...)
+				newMetrics = append(newMetrics, metricName)
 			} else {
-				if d.metrics[metricName].Labels == nil {
+				d.markSeen(d.metrics[metricName], now)
+				if batch.replace || d.metrics[metricName].Labels == nil {
 					d.metrics[metricName].Labels = v1.NewSet(labels...)
+					d.pruneGlobalUsedLabels(d.metrics[metricName])
 				} else {
 					d.metrics[metricName].Labels.Add(labels...)
 				}
 			}
+			d.applyGlobalUsedLabels(d.metrics[metricName], labels)
+		}
+		d.metricsMutex.Unlock()
+		d.matchValidMetrics(newMetrics)
+		d.generation.Add(1)
+		pubsub.PublishMetrics(pubsub.MetricsAddedKind, newMetrics)
+	}
+}
+
+func (d *db) watchUsedLabelsQueue() {
+	for data := range d.usedLabelsQueue {
+		var newMetrics []string
+		d.metricsMutex.Lock()
+		for metricName, labels := range data.ByMetric {
+			if _, ok := d.metrics[metricName]; !ok {
+				// Like for the labels, the metric has been found from another source, so we should add it.
+				d.addMetric(metricName)
+				newMetrics = append(newMetrics, metricName)
+			}
+			if d.metrics[metricName].UsedLabels == nil {
+				d.metrics[metricName].UsedLabels = v1.NewSet[string]()
+			}
+			d.metrics[metricName].UsedLabels.Add(labels...)
+		}
+		var newGlobalLabels []string
+		for _, label := range data.Global {
+			if !d.globalUsedLabels.Contains(label) {
+				d.globalUsedLabels.Add(label)
+				newGlobalLabels = append(newGlobalLabels, label)
+			}
+		}
+		// The metrics collected afterward get the global labels when their labels are stored,
+		// so the metrics are only scanned for the labels never seen before.
+		if len(newGlobalLabels) > 0 {
+			for _, metric := range d.metrics {
+				d.applyGlobalUsedLabels(metric, newGlobalLabels)
+			}
+		}
+		d.metricsMutex.Unlock()
+		d.matchValidMetrics(newMetrics)
+		d.generation.Add(1)
+		pubsub.PublishMetrics(pubsub.MetricsAddedKind, newMetrics)
+	}
+}
+
+// applyGlobalUsedLabels marks as used the given labels of the metric that are used globally. metricsMutex must be held by the caller.
+func (d *db) applyGlobalUsedLabels(metric *v1.Metric, labels []string) {
+	for _, label := range labels {
+		if !d.globalUsedLabels.Contains(label) || !metric.Labels.Contains(label) {
+			continue
+		}
+		if metric.UsedLabels == nil {
+			metric.UsedLabels = v1.NewSet[string]()
+		}
+		metric.UsedLabels.Add(label)
+	}
+}
+
+// pruneGlobalUsedLabels removes from the used labels of the metric the global ones it doesn't have anymore,
+// once its labels have been replaced. metricsMutex must be held by the caller.
+func (d *db) pruneGlobalUsedLabels(metric *v1.Metric) {
+	for label := range metric.UsedLabels {
+		if d.globalUsedLabels.Contains(label) && !metric.Labels.Contains(label) {
+			metric.UsedLabels.Remove(label)
+		}
+	}
+	if len(metric.UsedLabels) == 0 {
+		metric.UsedLabels = nil
+	}
+}
+
+func (d *db) watchMetadataQueue() {
+	for batch := range d.metadataQueue {
+		var newMetrics []string
+		d.metricsMutex.Lock()
+		now := time.Now()
+		for name, metadata := range batch.metadata {
+			metricNames := []string{name}
+			if batch.families {
+				metricNames = d.seriesOfFamily(name, metadata)
+			}
+			for _, metricName := range metricNames {
+				if _, ok := d.metrics[metricName]; !ok {
+					// Like for the labels, the metric has been found from another source, so we should add it.
+					d.addMetric(metricName)
+					newMetrics = append(newMetrics, metricName)
+				}
+				d.markSeen(d.metrics[metricName], now)
+				d.metrics[metricName].Type = metadata.Type
+				d.metrics[metricName].Help = metadata.Help
+			}
 		}
 		d.metricsMutex.Unlock()
+		d.matchValidMetrics(newMetrics)
+		d.generation.Add(1)
+		pubsub.PublishMetrics(pubsub.MetricsAddedKind, newMetrics)
 	}
 }
 
@@ -229,58 +781,158 @@ func (d *db) flush(period time.Duration) {
 	ticker := time.NewTicker(period)
 	defer ticker.Stop()
 	for range ticker.C {
-		if err := d.writeMetricsInJSONFile(); err != nil {
-			logrus.WithError(err).Error("unable to flush the data in the file")
+		if !d.inMemory {
+			if d.unusedMaxAge > 0 {
+				if pruned := d.pruneUnusedMetrics(time.Now().Add(-d.unusedMaxAge)); pruned > 0 {
+					logrus.Infof("%d unused metrics not seen for %s removed from the database", pruned, d.unusedMaxAge)
+				}
+			}
+			if err := d.writeMetricsInJSONFile(); err != nil {
+				logrus.WithError(err).Error("unable to flush the data in the file")
+			}
+		}
+		if d.readFromSnapshot {
+			if err := d.refreshSnapshot(); err != nil {
+				logrus.WithError(err).Error("unable to refresh the snapshot of the metrics")
+			}
 		}
 	}
 }
 
 func (d *db) writeMetricsInJSONFile() error {
-	d.metricsMutex.Lock()
-	defer d.metricsMutex.Unlock()
-	data, err := json.Marshal(d.metrics)
+	d.metricsMutex.RLock()
+	defer d.metricsMutex.RUnlock()
+	data, err := encodeFile(d.metrics)
 	if err != nil {
 		return err
 	}
 	return os.WriteFile(d.path, data, 0644)
 }
 
+// refreshSnapshot replaces the snapshot by a copy of the current metrics.
+// The lock is only held while serializing the metrics, the snapshot is built once it is released.
+func (d *db) refreshSnapshot() error {
+	d.metricsMutex.RLock()
+	data, err := json.Marshal(d.metrics)
+	d.metricsMutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	snapshot := make(map[string]*v1.Metric)
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	d.snapshot.Store(&snapshot)
+	d.generation.Add(1)
+	return nil
+}
+
 func (d *db) readMetricsInJSONFile() error {
 	data, err := os.ReadFile(d.path)
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(data, &d.metrics)
+	metrics, err := decodeFile(data)
+	if err != nil {
+		return err
+	}
+	d.metrics = metrics
+	return nil
+}
+
+// setPartialMetricUsage sets the usage of the partial metric, creating it if needed.
+// The metrics it is matching are searched again when its usage is not querying the same sources anymore.
+// partialMetricsUsageMutex must be held by the caller.
+func (d *db) setPartialMetricUsage(partialMetric string, usage *v1.MetricUsage) {
+	if current, ok := d.partialMetrics[partialMetric]; ok && maps.Equal(current.Sources, usage.Sources()) {
+		current.Usage = usage
+		return
+	}
+	result := d.matchPartialMetric(partialMetric, usage.Sources())
+	result.Usage = usage
+	d.partialMetrics[partialMetric] = result
 }
 
-func (d *db) matchPartialMetric(partialMetric string) (*common.Regexp, v1.Set[string]) {
-	re, err := generateRegexp(partialMetric)
+// matchPartialMetric returns a new partial metric with its regexp and the metrics it is matching among the ones collected from the given sources.
+// The partial metric matches the metrics of every source when sources is empty.
+func (d *db) matchPartialMetric(partialMetric string, sources v1.Set[string]) *v1.PartialMetric {
+	result := &v1.PartialMetric{Sources: sources}
+	re, err := v1.PartialMetricRegexp(partialMetric)
 	if err != nil {
 		logrus.WithError(err).Errorf("unable to compile the partial metric name %q into a regexp", partialMetric)
-		return nil, nil
+		return result
 	}
 	if re == nil {
-		return nil, nil
+		return result
 	}
-	result := v1.NewSet[string]()
-	d.metricsMutex.Lock()
-	defer d.metricsMutex.Unlock()
-	for m := range d.metrics {
+	result.MatchingRegexp = re
+	matchingMetrics := v1.NewSet[string]()
+	d.metricsMutex.RLock()
+	defer d.metricsMutex.RUnlock()
+	for m, metric := range d.metrics {
+		if re.MatchString(m) && result.IsMatchingSources(metric.Sources) {
+			matchingMetrics.Add(m)
+		}
+	}
+	// The metrics used but not collected yet are matched as well, they are concrete metrics waiting in the pending buffer.
+	for m := range d.usage {
 		if re.MatchString(m) {
-			result.Add(m)
+			matchingMetrics.Add(m)
 		}
 	}
-	return re, result
+	d.setMatchingMetrics(result, matchingMetrics)
+	return result
+}
+
+// isTooBroad returns true if the partial metric matching the given metrics must be flagged as too broad.
+func (d *db) isTooBroad(matchingMetrics v1.Set[string]) bool {
+	return d.maxMatchingMetrics > 0 && len(matchingMetrics) > d.maxMatchingMetrics
+}
+
+// setMatchingMetrics sets the metrics matching the partial metric, unless there are too many of them.
+// In this case, the partial metric is flagged as too broad and the list is dropped, to bound the size of the database.
+func (d *db) setMatchingMetrics(partialMetric *v1.PartialMetric, matchingMetrics v1.Set[string]) {
+	if d.isTooBroad(matchingMetrics) {
+		partialMetric.TooBroad = true
+		partialMetric.MatchingMetrics = nil
+		return
+	}
+	partialMetric.MatchingMetrics = matchingMetrics
 }
 
-func (d *db) matchValidMetric(validMetric string) {
+func (d *db) matchValidMetrics(validMetrics []string) {
+	if len(validMetrics) == 0 {
+		return
+	}
 	d.partialMetricsUsageMutex.Lock()
 	defer d.partialMetricsUsageMutex.Unlock()
+	sources := d.metricSources(validMetrics)
+	for _, validMetric := range validMetrics {
+		d.matchValidMetric(validMetric, sources[validMetric])
+	}
+}
+
+// metricSources returns a copy of the sources of the given metrics. The metrics only known through their pending usage have no source.
+func (d *db) metricSources(metricNames []string) map[string]v1.Set[string] {
+	d.metricsMutex.RLock()
+	defer d.metricsMutex.RUnlock()
+	result := make(map[string]v1.Set[string])
+	for _, metricName := range metricNames {
+		if metric, ok := d.metrics[metricName]; ok && len(metric.Sources) > 0 {
+			result[metricName] = maps.Clone(metric.Sources)
+		}
+	}
+	return result
+}
+
+// matchValidMetric adds the metric collected from the given sources to the partial metrics it is matching.
+// partialMetricsUsageMutex must be held by the caller.
+func (d *db) matchValidMetric(validMetric string, sources v1.Set[string]) {
 	for metricName, partialMetric := range d.partialMetrics {
 		re := partialMetric.MatchingRegexp
 		if re == nil {
 			var err error
-			re, err = generateRegexp(metricName)
+			re, err = v1.PartialMetricRegexp(metricName)
 			if err != nil {
 				logrus.WithError(err).Errorf("unable to compile the partial metric name %q into a regexp", metricName)
 				continue
@@ -290,48 +942,20 @@ func (d *db) matchValidMetric(validMetric string) {
 				continue
 			}
 		}
+		if partialMetric.TooBroad || !partialMetric.IsMatchingSources(sources) {
+			continue
+		}
 		if isMatching(re, validMetric) {
 			matchingMetrics := partialMetric.MatchingMetrics
 			if matchingMetrics == nil {
 				matchingMetrics = v1.NewSet[string]()
-				partialMetric.MatchingMetrics = matchingMetrics
 			}
 			matchingMetrics.Add(validMetric)
+			d.setMatchingMetrics(partialMetric, matchingMetrics)
 		}
 	}
 }
 
-// GenerateRegexp is taking a partial metric name,
-// will replace every variable by a pattern and then returning a regepx if the final string is not just equal to .*.
-func generateRegexp(partialMetricName string) (*common.Regexp, error) {
-	// The first step is to replace every variable by a single special char.
-	// We are using a special single char because it will be easier to find if these chars are continuous
-	// or if there are other characters in between.
-	s := replaceVariableRegexp.ReplaceAllString(partialMetricName, "#")
-	s = strings.ReplaceAll(s, ".+", "#")
-	s = strings.ReplaceAll(s, ".*", "#")
-	if s == "#" || len(s) == 0 {
-		// This means the metric name is just a variable and as such can match all metric.
-		// So it's basically impossible to know what this partial metric name is covering/matching.
-		return nil, nil
-	}
-	// The next step is to contact every continuous special char '#' to a single one.
-	compileString := fmt.Sprintf("%c", s[0])
-	expr := []rune(s)
-	for i := 1; i < len(expr); i++ {
-		if expr[i-1] == '#' && expr[i-1] == expr[i] {
-			continue
-		}
-		compileString += string(expr[i])
-	}
-	if compileString == "#" {
-		return nil, nil
-	}
-	compileString = strings.ReplaceAll(compileString, "#", ".+")
-	re, err := common.NewRegexp(fmt.Sprintf("^%s$", compileString))
-	return &re, err
-}
-
 func isMatching(re *common.Regexp, metric string) bool {
 	if !re.MatchString(metric) {
 		return false
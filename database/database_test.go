@@ -14,10 +14,23 @@
 package database
 
 import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/perses/metrics-usage/config"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
 	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func newRegexp(re string) *common.Regexp {
@@ -25,53 +38,420 @@ func newRegexp(re string) *common.Regexp {
 	return &r
 }
 
-func TestGenerateRegexp(t *testing.T) {
-	tests := []struct {
-		title         string
-		partialMetric string
-		result        *common.Regexp
-	}{
-		{
-			title:         "metric equal to a variable",
-			partialMetric: "${metric}",
-			result:        nil,
+func TestIsMatching(t *testing.T) {
+	re, _ := v1.PartialMetricRegexp("foo|")
+	assert.False(t, isMatching(re, "bar"))
+	assert.True(t, isMatching(re, "foo"))
+
+	re, _ = v1.PartialMetricRegexp("foo|bar")
+	assert.True(t, isMatching(re, "bar"))
+	assert.False(t, isMatching(re, "foo_bar"))
+
+	re, _ = v1.PartialMetricRegexp("cpu.usage")
+	assert.True(t, isMatching(re, "cpu.usage"))
+	assert.False(t, isMatching(re, "cpu_usage"))
+}
+
+func TestRecomputePartialMetrics(t *testing.T) {
+	d := &db{
+		metrics: map[string]*v1.Metric{
+			"foo_a": {},
+			"foo_b": {},
+			"bar":   {},
 		},
-		{
-			title:         "metric with variable a suffix",
-			partialMetric: "otelcol_exporter_enqueue_failed_log_records${suffix}",
-			result:        newRegexp(`^otelcol_exporter_enqueue_failed_log_records.+$`),
+		// foo_c is only known through its usage.
+		usage: map[string]*v1.MetricUsage{
+			"foo_c": {},
 		},
-		{
-			title:         "metric with multiple variable 1",
-			partialMetric: "${foo}${bar}${john}${doe}",
-			result:        nil,
+		partialMetrics: map[string]*v1.PartialMetric{
+			"foo_.+": {
+				MatchingMetrics: v1.NewSet("bar"),
+			},
+			"${metric}": {},
 		},
-		{
-			title:         "metric with multiple variable 2",
-			partialMetric: "prefix_${foo}${bar}:collection_${collection}_suffix:${john}${doe}",
-			result:        newRegexp(`^prefix_.+:collection_.+_suffix:.+$`),
+	}
+	nbPartialMetrics, nbMatches := d.RecomputePartialMetrics()
+	assert.Equal(t, 1, nbPartialMetrics)
+	assert.Equal(t, 3, nbMatches)
+	assert.Equal(t, v1.NewSet("foo_a", "foo_b", "foo_c"), d.partialMetrics["foo_.+"].MatchingMetrics)
+	assert.Equal(t, newRegexp(`^foo_.+$`), d.partialMetrics["foo_.+"].MatchingRegexp)
+	assert.Nil(t, d.partialMetrics["${metric}"].MatchingMetrics)
+}
+
+func TestMaxMatchingMetrics(t *testing.T) {
+	d := &db{
+		metrics: map[string]*v1.Metric{
+			"foo_a": {},
+			"foo_b": {},
 		},
-		{
-			title:         "metric no variable",
-			partialMetric: "otelcol_receiver_.+",
-			result:        newRegexp(`^otelcol_receiver_.+$`),
+		partialMetrics:     map[string]*v1.PartialMetric{},
+		maxMatchingMetrics: 2,
+	}
+	d.partialMetrics["foo_.+"] = d.matchPartialMetric("foo_.+", nil)
+	d.partialMetrics["ba.+"] = d.matchPartialMetric("ba.+", nil)
+	assert.Equal(t, v1.NewSet("foo_a", "foo_b"), d.partialMetrics["foo_.+"].MatchingMetrics)
+	assert.False(t, d.partialMetrics["foo_.+"].TooBroad)
+
+	// The partial metric becomes too broad once a third metric is matching it.
+	d.metrics["foo_c"] = &v1.Metric{}
+	d.matchValidMetric("foo_c", nil)
+	assert.True(t, d.partialMetrics["foo_.+"].TooBroad)
+	assert.Nil(t, d.partialMetrics["foo_.+"].MatchingMetrics)
+	d.matchValidMetric("foo_d", nil)
+	assert.Nil(t, d.partialMetrics["foo_.+"].MatchingMetrics)
+
+	// The flag is computed again from scratch.
+	d.maxMatchingMetrics = 3
+	nbPartialMetrics, nbMatches := d.RecomputePartialMetrics()
+	assert.Equal(t, 2, nbPartialMetrics)
+	assert.Equal(t, 3, nbMatches)
+	assert.False(t, d.partialMetrics["foo_.+"].TooBroad)
+
+	d.maxMatchingMetrics = 2
+	delete(d.metrics, "foo_c")
+	for _, name := range []string{"bar", "baz", "bax"} {
+		d.metrics[name] = &v1.Metric{}
+	}
+	d.RecomputePartialMetrics()
+	assert.True(t, d.partialMetrics["ba.+"].TooBroad)
+	assert.Equal(t, v1.NewSet("foo_a", "foo_b"), d.partialMetrics["foo_.+"].MatchingMetrics)
+}
+
+func TestPartialMetricSources(t *testing.T) {
+	d := &db{
+		metrics: map[string]*v1.Metric{
+			"foo_a": {Sources: v1.NewSet("http://prometheus-a")},
+			"foo_b": {Sources: v1.NewSet("http://prometheus-b")},
+			"foo_c": {},
 		},
+		partialMetrics: map[string]*v1.PartialMetric{},
 	}
+	ruleUsage := &v1.MetricUsage{AlertRules: v1.NewSet(v1.RuleUsage{PromLink: "http://prometheus-a", Name: "alert"})}
+	d.setPartialMetricUsage("foo_.+", ruleUsage)
+	assert.Equal(t, v1.NewSet("http://prometheus-a"), d.partialMetrics["foo_.+"].Sources)
+	// The metrics without source are matched, as they can come from any Prometheus.
+	assert.Equal(t, v1.NewSet("foo_a", "foo_c"), d.partialMetrics["foo_.+"].MatchingMetrics)
+
+	d.metrics["foo_d"] = &v1.Metric{Sources: v1.NewSet("http://prometheus-b")}
+	d.matchValidMetric("foo_d", d.metrics["foo_d"].Sources)
+	assert.False(t, d.partialMetrics["foo_.+"].MatchingMetrics.Contains("foo_d"))
+
+	// Once the partial metric is used by a dashboard, its source is unknown and it is matching every metric.
+	dashboardUsage := &v1.MetricUsage{Dashboards: v1.NewSet(v1.DashboardUsage{ID: "dashboard"})}
+	d.setPartialMetricUsage("foo_.+", v1.MergeUsage(ruleUsage, dashboardUsage))
+	assert.Nil(t, d.partialMetrics["foo_.+"].Sources)
+	assert.Equal(t, v1.NewSet("foo_a", "foo_b", "foo_c", "foo_d"), d.partialMetrics["foo_.+"].MatchingMetrics)
+
+	d.setPartialMetricUsage("foo_.+", ruleUsage)
+	d.RecomputePartialMetrics()
+	assert.Equal(t, v1.NewSet("foo_a", "foo_c"), d.partialMetrics["foo_.+"].MatchingMetrics)
+}
+
+func TestPartialMetricsMatchPendingUsage(t *testing.T) {
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory}, config.Classification{})
+	usage := &v1.MetricUsage{Dashboards: v1.NewSet(v1.DashboardUsage{ID: "dashboard"})}
+	// The metric is only known through its usage, the metric collector didn't see it yet.
+	d.EnqueueUsage(map[string]*v1.MetricUsage{"foo_pending": usage})
+	assert.Eventually(t, func() bool {
+		return len(d.ListPendingUsage()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A partial metric received after it is matching it.
+	d.EnqueuePartialMetricsUsage(map[string]*v1.MetricUsage{"foo_.+": usage})
+	assert.Eventually(t, func() bool {
+		partialMetrics, err := d.ListPartialMetrics()
+		return err == nil && partialMetrics["foo_.+"] != nil && partialMetrics["foo_.+"].MatchingMetrics.Contains("foo_pending")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A partial metric received before is matching it too.
+	d.EnqueueUsage(map[string]*v1.MetricUsage{"foo_other": usage})
+	assert.Eventually(t, func() bool {
+		partialMetrics, err := d.ListPartialMetrics()
+		return err == nil && partialMetrics["foo_.+"].MatchingMetrics.Contains("foo_other")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The pending usage is kept when the metric is found by another source than the metric collector.
+	d.EnqueueLabels(map[string][]string{"foo_pending": {"job"}})
+	assert.Eventually(t, func() bool {
+		metric := d.GetMetric("foo_pending")
+		return metric != nil && metric.Usage != nil && len(d.ListPendingUsage()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestListMetricsFromSnapshot(t *testing.T) {
+	d := &db{
+		metrics: map[string]*v1.Metric{
+			"foo": {Labels: v1.NewSet("job")},
+		},
+		readFromSnapshot: true,
+	}
+	assert.NoError(t, d.refreshSnapshot())
+	d.metrics["bar"] = &v1.Metric{}
+
+	metrics, err := d.ListMetrics()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*v1.Metric{"foo": {Labels: v1.NewSet("job")}}, metrics)
 
-	for _, test := range tests {
-		t.Run(test.title, func(t *testing.T) {
-			re, err := generateRegexp(test.partialMetric)
-			assert.NoError(t, err)
-			assert.Equal(t, test.result, re)
+	// The result can be modified without altering the snapshot.
+	metrics["foo"].Labels.Add("instance")
+	metrics, err = d.ListMetrics()
+	assert.NoError(t, err)
+	assert.Equal(t, v1.NewSet("job"), metrics["foo"].Labels)
+
+	assert.NoError(t, d.refreshSnapshot())
+	metrics, err = d.ListMetrics()
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 2)
+}
+
+func TestConcurrentMetricsAndPartialMetrics(t *testing.T) {
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory}, config.Classification{})
+	nbMetrics := 100
+	for i := 0; i < nbMetrics; i++ {
+		// Metrics and partial metrics are received at the same time to check the queue watchers don't block each other.
+		d.EnqueueMetricList([]string{fmt.Sprintf("foo_%d", i)})
+		d.EnqueuePartialMetricsUsage(map[string]*v1.MetricUsage{
+			fmt.Sprintf("foo_%d.+", i): {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "dashboard"})},
 		})
 	}
+	assert.Eventually(t, func() bool {
+		partialMetrics, err := d.ListPartialMetrics()
+		if err != nil || len(partialMetrics) != nbMetrics {
+			return false
+		}
+		metrics, err := d.ListMetrics()
+		return err == nil && len(metrics) == nbMetrics && len(d.ListPendingUsage()) == 0
+	}, 5*time.Second, 10*time.Millisecond)
 }
 
-func TestIsMatching(t *testing.T) {
-	re, _ := generateRegexp("foo|")
-	assert.False(t, isMatching(re, "bar"))
-	assert.True(t, isMatching(re, "foo"))
+// TestConcurrentLockOrder calls, at the same time, every function taking both locks or one lock after the other.
+// It fails instead of hanging if the lock order is not respected. It should be run with -race.
+func TestConcurrentLockOrder(t *testing.T) {
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory}, config.Classification{}).(*db)
+	nbIterations := 200
+	var wg sync.WaitGroup
+	run := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < nbIterations; i++ {
+				fn(i)
+			}
+		}()
+	}
+	run(func(i int) {
+		d.EnqueueMetricList([]string{fmt.Sprintf("foo_%d", i), fmt.Sprintf("bar_%d", i)})
+	})
+	run(func(i int) {
+		// Many partial metrics are sent at once, so the watcher holds partialMetricsUsageMutex while matching them against the metrics.
+		usage := make(map[string]*v1.MetricUsage)
+		for j := 0; j < 20; j++ {
+			usage[fmt.Sprintf("foo_%d_%d.+", i, j)] = &v1.MetricUsage{Dashboards: v1.NewSet(v1.DashboardUsage{ID: "dashboard", URL: "http://grafana/d/dashboard"})}
+		}
+		d.EnqueuePartialMetricsUsage(usage)
+	})
+	run(func(i int) {
+		d.EnqueueUsage(map[string]*v1.MetricUsage{
+			fmt.Sprintf("bar_%d", i): {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "dashboard", URL: "http://grafana/d/dashboard"})},
+		})
+	})
+	run(func(i int) {
+		d.EnqueueReconciliation(&Reconciliation{
+			Source: "http://grafana",
+			Usage: map[string]*v1.MetricUsage{
+				fmt.Sprintf("bar_%d", i): {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "other", URL: "http://grafana/d/other"})},
+			},
+			PartialMetricsUsage: map[string]*v1.MetricUsage{
+				fmt.Sprintf("bar_%d.+", i): {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "other", URL: "http://grafana/d/other"})},
+			},
+		})
+	})
+	run(func(i int) {
+		d.RecomputePartialMetrics()
+		d.GetMetricUsage(fmt.Sprintf("foo_%d", i))
+		_, _ = d.ListPartialMetrics()
+	})
+	run(func(_ int) {
+		assert.NoError(t, d.Reset())
+	})
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		// Once the queues are empty, the watchers are waiting for new data, so they are not holding any lock.
+		d.lockAll()
+		d.unlockAll()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock: the database is still locked after 10 seconds")
+	}
+}
 
-	re, _ = generateRegexp("foo|bar")
-	assert.True(t, isMatching(re, "bar"))
+func TestUsedLabels(t *testing.T) {
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory}, config.Classification{})
+	d.EnqueueLabels(map[string][]string{
+		"up":         {"job", "instance"},
+		"node_load1": {"instance"},
+	})
+	assert.Eventually(t, func() bool {
+		metrics, _ := d.ListMetrics()
+		return len(metrics) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	d.EnqueueUsedLabels(&v1.UsedLabels{
+		ByMetric: map[string][]string{"node_load1": {"instance"}},
+		Global:   []string{"job"},
+	})
+	assert.Eventually(t, func() bool {
+		metrics, _ := d.ListMetrics()
+		return metrics["up"].UsedLabels.Contains("job") &&
+			!metrics["up"].UsedLabels.Contains("instance") &&
+			metrics["node_load1"].UsedLabels.Contains("instance") &&
+			!metrics["node_load1"].UsedLabels.Contains("job")
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestGlobalUsedLabels(t *testing.T) {
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory}, config.Classification{}).(*db)
+	d.EnqueueUsedLabels(&v1.UsedLabels{Global: []string{"job"}})
+	// The labels of the metric are collected after the dashboards, the global used labels are still applied.
+	d.EnqueueLabels(map[string][]string{"up": {"job", "instance"}})
+	assert.Eventually(t, func() bool {
+		metric := d.GetMetric("up")
+		return metric != nil && metric.UsedLabels.Contains("job")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Once the metric doesn't have the label anymore, it is not used anymore.
+	d.EnqueueLabelsReplacement(map[string][]string{"up": {"instance"}})
+	assert.Eventually(t, func() bool {
+		metric := d.GetMetric("up")
+		return !metric.Labels.Contains("job") && metric.UsedLabels == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, d.Reset())
+	d.metricsMutex.RLock()
+	assert.Empty(t, d.globalUsedLabels)
+	d.metricsMutex.RUnlock()
+}
+
+func TestLabelsReplacement(t *testing.T) {
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory}, config.Classification{})
+	d.EnqueueLabels(map[string][]string{
+		"up":         {"job", "instance", "dropped"},
+		"node_load1": {"instance"},
+	})
+	d.EnqueueLabels(map[string][]string{"up": {"cluster"}})
+	assert.Eventually(t, func() bool {
+		metric := d.GetMetric("up")
+		return metric != nil && len(metric.Labels) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	d.EnqueueLabelsReplacement(map[string][]string{"up": {"job", "instance"}})
+	assert.Eventually(t, func() bool {
+		metric := d.GetMetric("up")
+		return len(metric.Labels) == 2 && metric.Labels.Contains("job") && metric.Labels.Contains("instance")
+	}, 5*time.Second, 10*time.Millisecond)
+	// The metrics that are not in the batch keep their labels.
+	assert.Equal(t, v1.NewSet("instance"), d.GetMetric("node_load1").Labels)
+}
+
+func TestReset(t *testing.T) {
+	inMemory := false
+	path := filepath.Join(t.TempDir(), "database.json")
+	d := New(config.Database{InMemory: &inMemory, Path: path, FlushPeriod: model.Duration(time.Hour)}, config.Classification{})
+	d.EnqueueMetricList([]string{"up", "node_load1"})
+	d.EnqueuePartialMetricsUsage(map[string]*v1.MetricUsage{"node_.+": {}})
+	d.EnqueueUsage(map[string]*v1.MetricUsage{"http_requests_total": {}})
+	assert.Eventually(t, func() bool {
+		metrics, _ := d.ListMetrics()
+		partialMetrics, _ := d.ListPartialMetrics()
+		return len(metrics) == 2 && len(partialMetrics) == 1 && len(d.ListPendingUsage()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, d.Reset())
+	metrics, _ := d.ListMetrics()
+	partialMetrics, _ := d.ListPartialMetrics()
+	assert.Empty(t, metrics)
+	assert.Empty(t, partialMetrics)
+	assert.Empty(t, d.ListPendingUsage())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"version":1,"metrics":{}}`, string(data))
+}
+
+func TestMetadataOfFamilies(t *testing.T) {
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory}, config.Classification{})
+	d.EnqueueMetricList([]string{"http_requests_total"})
+	assert.Eventually(t, func() bool {
+		return d.GetMetric("http_requests_total") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	d.EnqueueMetadata(map[string]v1.MetricMetadata{
+		"http_request_duration_seconds": {Type: "histogram", Help: "Duration of the HTTP requests."},
+		"rpc_duration_seconds":          {Type: "summary", Help: "Duration of the RPCs."},
+		"http_requests":                 {Type: "counter", Help: "Number of HTTP requests."},
+	})
+	assert.Eventually(t, func() bool {
+		metric := d.GetMetric("rpc_duration_seconds_sum")
+		return metric != nil && metric.Type == "summary"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	metrics, err := d.ListMetrics()
+	require.NoError(t, err)
+	// The family of the histogram is not a series, so it must not be reported as an unused metric.
+	assert.ElementsMatch(t, []string{
+		"http_request_duration_seconds_bucket",
+		"http_request_duration_seconds_count",
+		"http_request_duration_seconds_sum",
+		"rpc_duration_seconds",
+		"rpc_duration_seconds_count",
+		"rpc_duration_seconds_sum",
+		"http_requests_total",
+	}, slices.Collect(maps.Keys(metrics)))
+	assert.Equal(t, "histogram", metrics["http_request_duration_seconds_bucket"].Type)
+	assert.Equal(t, "Duration of the HTTP requests.", metrics["http_request_duration_seconds_count"].Help)
+	// The counter family is matched with its series having the suffix _total.
+	assert.Equal(t, "counter", metrics["http_requests_total"].Type)
+}
+
+func TestGeneration(t *testing.T) {
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory}, config.Classification{})
+	assert.Equal(t, uint64(0), d.Generation())
+	d.EnqueueMetricList([]string{"up"})
+	assert.Eventually(t, func() bool {
+		return d.Generation() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	d.EnqueueLabels(map[string][]string{"up": {"job"}})
+	assert.Eventually(t, func() bool {
+		return d.Generation() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, d.Reset())
+	assert.Equal(t, uint64(3), d.Generation())
+}
+
+func TestStats(t *testing.T) {
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory}, config.Classification{})
+	d.EnqueueUsage(map[string]*v1.MetricUsage{"http_requests_total": {}, "up": {}})
+	assert.Eventually(t, func() bool {
+		return len(d.ListPendingUsage()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	expected := `
+# HELP database_pending_usage_entries The number of metrics having a usage, waiting for the metric to be collected.
+# TYPE database_pending_usage_entries gauge
+database_pending_usage_entries 2
+# HELP database_usage_queue_depth The number of batches waiting in the queue usage to be written in the database.
+# TYPE database_usage_queue_depth gauge
+database_usage_queue_depth 0
+`
+	assert.NoError(t, testutil.CollectAndCompare(stats, strings.NewReader(expected), "database_pending_usage_entries", "database_usage_queue_depth"))
 }
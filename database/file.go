@@ -0,0 +1,96 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// currentFileVersion is the version of the format of the database file.
+// When the way the metrics are stored changes, it must be increased and a migration must be added to fileMigrations.
+const currentFileVersion = 1
+
+// legacyFileVersion is the version of the files written before the versioning, containing directly the map of the metrics.
+const legacyFileVersion = 0
+
+// fileMigration upgrades the metrics, as stored in the file, from one version to the next one.
+type fileMigration func(metrics json.RawMessage) (json.RawMessage, error)
+
+// fileMigrations contains, at the index N, the migration from the version N to the version N+1.
+var fileMigrations = []fileMigration{
+	legacyFileVersion: func(metrics json.RawMessage) (json.RawMessage, error) {
+		// The version 1 only introduced the envelope, the metrics are stored the same way.
+		return metrics, nil
+	},
+}
+
+type fileEnvelope struct {
+	Version int                   `json:"version"`
+	Metrics map[string]*v1.Metric `json:"metrics"`
+}
+
+type rawFileEnvelope struct {
+	Version int             `json:"version"`
+	Metrics json.RawMessage `json:"metrics"`
+}
+
+func encodeFile(metrics map[string]*v1.Metric) ([]byte, error) {
+	return json.Marshal(fileEnvelope{Version: currentFileVersion, Metrics: metrics})
+}
+
+// decodeFile returns the metrics stored in the file, after migrating them to the current version.
+func decodeFile(data []byte) (map[string]*v1.Metric, error) {
+	version, rawMetrics, err := readEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if version > currentFileVersion {
+		return nil, fmt.Errorf("the database file has the version %d, only the versions up to %d are supported", version, currentFileVersion)
+	}
+	for v := version; v < currentFileVersion; v++ {
+		rawMetrics, err = fileMigrations[v](rawMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("unable to migrate the database file from the version %d: %w", v, err)
+		}
+	}
+	if version < currentFileVersion {
+		logrus.Infof("database file migrated from the version %d to the version %d", version, currentFileVersion)
+	}
+	metrics := make(map[string]*v1.Metric)
+	if len(rawMetrics) == 0 {
+		return metrics, nil
+	}
+	return metrics, json.Unmarshal(rawMetrics, &metrics)
+}
+
+// readEnvelope returns the version of the file and the metrics it contains, without decoding them.
+// A file without envelope is a legacy file, made only of the metrics.
+// As "version" and "metrics" are valid metric names, the envelope is only recognized when the version is a number.
+func readEnvelope(data []byte) (int, json.RawMessage, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return 0, nil, err
+	}
+	if version, hasVersion := fields["version"]; hasVersion && string(version) != "null" {
+		envelope := &rawFileEnvelope{}
+		if err := json.Unmarshal(data, envelope); err == nil {
+			return envelope.Version, envelope.Metrics, nil
+		}
+	}
+	return legacyFileVersion, data, nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeFile(t *testing.T) {
+	testSuite := []struct {
+		title       string
+		data        string
+		expected    map[string]*v1.Metric
+		expectedErr string
+	}{
+		{
+			title: "current version",
+			data:  `{"version":1,"metrics":{"up":{"labels":["job"]}}}`,
+			expected: map[string]*v1.Metric{
+				"up": {Labels: v1.NewSet("job")},
+			},
+		},
+		{
+			title: "legacy file without envelope",
+			data:  `{"up":{"labels":["job"]},"node_load1":{}}`,
+			expected: map[string]*v1.Metric{
+				"up":         {Labels: v1.NewSet("job")},
+				"node_load1": {},
+			},
+		},
+		{
+			title: "legacy file containing metrics named version and metrics",
+			data:  `{"version":{"labels":["instance"]},"metrics":{}}`,
+			expected: map[string]*v1.Metric{
+				"version": {Labels: v1.NewSet("instance")},
+				"metrics": {},
+			},
+		},
+		{
+			title:    "empty file",
+			data:     `{"version":1}`,
+			expected: map[string]*v1.Metric{},
+		},
+		{
+			title:       "newer version",
+			data:        `{"version":2,"metrics":{}}`,
+			expectedErr: "the database file has the version 2, only the versions up to 1 are supported",
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			metrics, err := decodeFile([]byte(test.data))
+			if len(test.expectedErr) > 0 {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, metrics)
+		})
+	}
+}
+
+func TestEncodeFile(t *testing.T) {
+	metrics := map[string]*v1.Metric{"up": {Labels: v1.NewSet("job")}}
+	data, err := encodeFile(metrics)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"version":1,"metrics":{"up":{"labels":["job"]}}}`, string(data))
+	decoded, err := decodeFile(data)
+	require.NoError(t, err)
+	assert.Equal(t, metrics, decoded)
+}
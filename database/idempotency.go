@@ -0,0 +1,72 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"time"
+)
+
+// idempotencyKeyTTL is how long an idempotency key is remembered. It only needs to cover the retries of a client.
+const idempotencyKeyTTL = 10 * time.Minute
+
+// idempotencyKey is the state of a request having an idempotency key.
+type idempotencyKey struct {
+	claimedAt time.Time
+	// processed is false while the request is being processed.
+	processed bool
+}
+
+func (d *db) ClaimIdempotencyKey(key string) (bool, bool) {
+	d.idempotencyKeysMutex.Lock()
+	defer d.idempotencyKeysMutex.Unlock()
+	// A key expired but not pruned yet is claimed again.
+	if k, exists := d.idempotencyKeys[key]; exists && time.Since(k.claimedAt) <= idempotencyKeyTTL {
+		return false, k.processed
+	}
+	d.idempotencyKeys[key] = &idempotencyKey{claimedAt: time.Now()}
+	return true, false
+}
+
+func (d *db) CompleteIdempotencyKey(key string) {
+	d.idempotencyKeysMutex.Lock()
+	defer d.idempotencyKeysMutex.Unlock()
+	if k, exists := d.idempotencyKeys[key]; exists {
+		k.processed = true
+	}
+}
+
+func (d *db) ReleaseIdempotencyKey(key string) {
+	d.idempotencyKeysMutex.Lock()
+	defer d.idempotencyKeysMutex.Unlock()
+	delete(d.idempotencyKeys, key)
+}
+
+// pruneIdempotencyKeys removes periodically the expired keys, so the cache never holds more than the keys received during the TTL.
+func (d *db) pruneIdempotencyKeys() {
+	ticker := time.NewTicker(idempotencyKeyTTL / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		d.removeExpiredIdempotencyKeys(time.Now())
+	}
+}
+
+func (d *db) removeExpiredIdempotencyKeys(now time.Time) {
+	d.idempotencyKeysMutex.Lock()
+	defer d.idempotencyKeysMutex.Unlock()
+	for key, k := range d.idempotencyKeys {
+		if now.Sub(k.claimedAt) > idempotencyKeyTTL {
+			delete(d.idempotencyKeys, key)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	d := &db{idempotencyKeys: map[string]*idempotencyKey{"expired": {claimedAt: time.Now().Add(-2 * idempotencyKeyTTL), processed: true}}}
+	claimed, _ := d.ClaimIdempotencyKey("a")
+	assert.True(t, claimed)
+	claimed, processed := d.ClaimIdempotencyKey("a")
+	assert.False(t, claimed)
+	assert.False(t, processed)
+	d.CompleteIdempotencyKey("a")
+	claimed, processed = d.ClaimIdempotencyKey("a")
+	assert.False(t, claimed)
+	assert.True(t, processed)
+	d.ReleaseIdempotencyKey("a")
+	claimed, _ = d.ClaimIdempotencyKey("a")
+	assert.True(t, claimed)
+	claimed, _ = d.ClaimIdempotencyKey("expired")
+	assert.True(t, claimed)
+}
+
+func TestRemoveExpiredIdempotencyKeys(t *testing.T) {
+	now := time.Now()
+	d := &db{idempotencyKeys: map[string]*idempotencyKey{
+		"expired": {claimedAt: now.Add(-2 * idempotencyKeyTTL)},
+		"recent":  {claimedAt: now.Add(-idempotencyKeyTTL / 2)},
+	}}
+	d.removeExpiredIdempotencyKeys(now)
+	assert.NotContains(t, d.idempotencyKeys, "expired")
+	assert.Contains(t, d.idempotencyKeys, "recent")
+}
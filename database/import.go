@@ -0,0 +1,101 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// importFiles merges the metrics of every file into the database, through the queues like any collector would do.
+// A file that cannot be read is skipped, as the database is still usable without it.
+func (d *db) importFiles(paths []string) {
+	for _, path := range paths {
+		metrics, err := readImportFile(path)
+		if err != nil {
+			logrus.WithError(err).Errorf("unable to import the metrics from the file %q", path)
+			continue
+		}
+		d.importMetrics(metrics)
+		logrus.Infof("%d metrics imported from the file %q", len(metrics), path)
+	}
+}
+
+// readImportFile reads a file containing the metrics in the format returned by the endpoint /api/v1/metrics.
+func readImportFile(path string) (map[string]*v1.Metric, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	metrics := make(map[string]*v1.Metric)
+	if err = json.Unmarshal(data, &metrics); err != nil {
+		return nil, err
+	}
+	usage := make(map[string]*v1.MetricUsage)
+	for metricName, metric := range metrics {
+		if metric == nil {
+			return nil, fmt.Errorf("the metric %q is null", metricName)
+		}
+		if metric.Usage != nil {
+			usage[metricName] = metric.Usage
+		}
+	}
+	if errs := v1.ValidateUsage(usage); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid usage: %v", errs)
+	}
+	return metrics, nil
+}
+
+func (d *db) importMetrics(metrics map[string]*v1.Metric) {
+	metricNames := make([]string, 0, len(metrics))
+	usage := make(map[string]*v1.MetricUsage)
+	labels := make(map[string][]string)
+	usedLabels := &v1.UsedLabels{ByMetric: make(map[string][]string)}
+	metadata := make(map[string]v1.MetricMetadata)
+	for metricName, metric := range metrics {
+		metricNames = append(metricNames, metricName)
+		if metric.Usage != nil {
+			usage[metricName] = metric.Usage
+		}
+		if len(metric.Labels) > 0 {
+			labels[metricName] = metric.Labels.TransformAsSlice()
+		}
+		if len(metric.UsedLabels) > 0 {
+			usedLabels.ByMetric[metricName] = metric.UsedLabels.TransformAsSlice()
+		}
+		// The metadata is replacing the existing one, so it is only sent when the file is providing it.
+		if len(metric.Type) > 0 || len(metric.Help) > 0 {
+			metadata[metricName] = v1.MetricMetadata{Type: metric.Type, Help: metric.Help}
+		}
+	}
+	// The usage is merged with the existing one by the queue, the same way it is done for the usage sent by the collectors.
+	d.EnqueueMetricList(metricNames)
+	if len(usage) > 0 {
+		d.EnqueueUsage(usage)
+	}
+	if len(labels) > 0 {
+		d.EnqueueLabels(labels)
+	}
+	if len(usedLabels.ByMetric) > 0 {
+		d.EnqueueUsedLabels(usedLabels)
+	}
+	if len(metadata) > 0 {
+		// The metadata of the files is given per metric, not per family.
+		d.metadataQueue <- &metadataBatch{metadata: metadata}
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/perses/metrics-usage/config"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportFiles(t *testing.T) {
+	dir := t.TempDir()
+	importPath := filepath.Join(dir, "metrics.json")
+	require.NoError(t, os.WriteFile(importPath, []byte(`{
+  "up": {
+    "labels": ["instance", "job"],
+    "type": "gauge",
+    "usage": {"dashboards": [{"uid": "node", "title": "Node", "url": "https://grafana.example.com/d/node"}]}
+  },
+  "node_load1": {}
+}`), 0600))
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory, ImportPaths: []string{importPath, filepath.Join(dir, "missing.json")}}, config.Classification{})
+	d.EnqueueUsage(map[string]*v1.MetricUsage{
+		"up": {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "k8s", Name: "Kubernetes", URL: "https://grafana.example.com/d/k8s"})},
+	})
+	require.Eventually(t, func() bool {
+		metric := d.GetMetric("up")
+		return metric != nil && metric.Type == "gauge" && len(metric.Labels) == 2 && metric.Usage != nil && len(metric.Usage.Dashboards) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotNil(t, d.GetMetric("node_load1"))
+}
+
+func TestReadImportFile(t *testing.T) {
+	testSuite := []struct {
+		title       string
+		data        string
+		expected    map[string]*v1.Metric
+		expectedErr bool
+	}{
+		{
+			title: "metrics",
+			data:  `{"up":{"labels":["job"]},"node_load1":{}}`,
+			expected: map[string]*v1.Metric{
+				"up":         {Labels: v1.NewSet("job")},
+				"node_load1": {},
+			},
+		},
+		{
+			title:       "not a list of metrics",
+			data:        `["up"]`,
+			expectedErr: true,
+		},
+		{
+			title:       "null metric",
+			data:        `{"up":null}`,
+			expectedErr: true,
+		},
+		{
+			title:       "invalid usage",
+			data:        `{"up":{"usage":{"dashboards":[{"title":"Node"}]}}}`,
+			expectedErr: true,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			importPath := filepath.Join(t.TempDir(), "metrics.json")
+			require.NoError(t, os.WriteFile(importPath, []byte(test.data), 0600))
+			result, err := readImportFile(importPath)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/perses/metrics-usage/config"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// ownershipReloadPeriod is how often the ownership file is checked for changes.
+const ownershipReloadPeriod = time.Minute
+
+// ownership is giving the owner of the metrics based on the longest prefix matching their name.
+// The mapping is reloaded when the file is modified, so the owners don't require a restart to be updated.
+type ownership struct {
+	path    string
+	modTime time.Time
+	owners  atomic.Pointer[map[string]string]
+}
+
+// newOwnership returns nil when no ownership file is configured.
+func newOwnership(path string) *ownership {
+	if len(path) == 0 {
+		return nil
+	}
+	o := &ownership{path: path}
+	if err := o.reload(); err != nil {
+		logrus.WithError(err).Error("unable to load the ownership file")
+	}
+	return o
+}
+
+// reload reads the file again if it has been modified since the last time it has been read.
+// In case of error, the previous mapping is kept.
+func (o *ownership) reload() error {
+	info, err := os.Stat(o.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(o.modTime) {
+		return nil
+	}
+	owners, err := config.LoadOwnership(o.path)
+	if err != nil {
+		return err
+	}
+	o.modTime = info.ModTime()
+	o.owners.Store(&owners)
+	return nil
+}
+
+func (o *ownership) watch() {
+	ticker := time.NewTicker(ownershipReloadPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := o.reload(); err != nil {
+			logrus.WithError(err).Error("unable to reload the ownership file")
+		}
+	}
+}
+
+func (o *ownership) ownerOf(metricName string) string {
+	owners := o.owners.Load()
+	if owners == nil {
+		return ""
+	}
+	owner := ""
+	longestPrefix := -1
+	for prefix, team := range *owners {
+		if len(prefix) > longestPrefix && strings.HasPrefix(metricName, prefix) {
+			owner = team
+			longestPrefix = len(prefix)
+		}
+	}
+	return owner
+}
+
+// setOwner sets the owner of a metric read from the database. It must only be called on a copy of the stored metric.
+func (o *ownership) setOwner(metricName string, metric *v1.Metric) {
+	if o != nil {
+		metric.Owner = o.ownerOf(metricName)
+	}
+}
+
+// setOwners sets the owner of every metric read from the database.
+func (o *ownership) setOwners(metrics map[string]*v1.Metric) {
+	if o == nil {
+		return
+	}
+	for name, metric := range metrics {
+		metric.Owner = o.ownerOf(name)
+	}
+}
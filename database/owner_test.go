@@ -0,0 +1,68 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/perses/metrics-usage/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOwnershipOwnerOf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "owners.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("kube_: platform\nkube_pod_: workloads\n"), 0600))
+	o := newOwnership(path)
+	assert.Equal(t, "workloads", o.ownerOf("kube_pod_info"))
+	assert.Equal(t, "platform", o.ownerOf("kube_node_info"))
+	assert.Equal(t, "", o.ownerOf("http_requests_total"))
+
+	// The file is reloaded only when it is modified.
+	require.NoError(t, os.WriteFile(path, []byte("http_: web\n"), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Hour)))
+	require.NoError(t, o.reload())
+	assert.Equal(t, "web", o.ownerOf("http_requests_total"))
+	assert.Equal(t, "", o.ownerOf("kube_pod_info"))
+
+	// A broken file is keeping the previous mapping.
+	require.NoError(t, os.WriteFile(path, []byte("not: [a, mapping"), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Hour)))
+	assert.Error(t, o.reload())
+	assert.Equal(t, "web", o.ownerOf("http_requests_total"))
+}
+
+func TestOwnerSetWhenReading(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "owners.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("foo_: team-foo\n"), 0600))
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory}, config.Classification{OwnershipFile: path}).(*db)
+	d.EnqueueMetricList([]string{"foo_total", "bar_total"})
+	assert.Eventually(t, func() bool {
+		return d.GetMetric("bar_total") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "team-foo", d.GetMetric("foo_total").Owner)
+	metrics, err := d.ListMetrics()
+	require.NoError(t, err)
+	assert.Equal(t, "team-foo", metrics["foo_total"].Owner)
+	assert.Equal(t, "", metrics["bar_total"].Owner)
+	// The owner is never stored.
+	d.metricsMutex.RLock()
+	defer d.metricsMutex.RUnlock()
+	assert.Equal(t, "", d.metrics["foo_total"].Owner)
+}
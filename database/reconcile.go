@@ -0,0 +1,95 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"time"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// Reconciliation is the whole usage collected from a source during a single run.
+// The usage collected previously from the same source and not confirmed by the reconciliation is removed.
+type Reconciliation struct {
+	// Source is the URL of the Prometheus, of the Grafana or of the Perses the usage has been collected from.
+	// See v1.UsageItem.IsFrom for more details.
+	Source              string
+	Usage               map[string]*v1.MetricUsage
+	PartialMetricsUsage map[string]*v1.MetricUsage
+	// Window is how long a usage is kept once it is not confirmed anymore. With 0, it is removed immediately.
+	Window time.Duration
+}
+
+func (d *db) EnqueueReconciliation(r *Reconciliation) {
+	d.reconcileQueue <- r
+}
+
+func (d *db) watchReconcileQueue() {
+	for r := range d.reconcileQueue {
+		now := time.Now()
+		d.reconcile(r, now, now.Add(-r.Window))
+	}
+}
+
+// reconcile stores the usage confirmed at the given time, then removes the usage of the same source not confirmed since the deadline.
+func (d *db) reconcile(r *Reconciliation, now time.Time, deadline time.Time) {
+	defer d.generation.Add(1)
+	for _, usage := range r.Usage {
+		usage.Confirm(now)
+	}
+	for _, usage := range r.PartialMetricsUsage {
+		usage.Confirm(now)
+	}
+	// The partial metrics are handled first, as matchPartialMetric is taking metricsMutex while partialMetricsUsageMutex is held.
+	d.partialMetricsUsageMutex.Lock()
+	for metricName, usage := range r.PartialMetricsUsage {
+		var previous *v1.MetricUsage
+		if partialMetric, ok := d.partialMetrics[metricName]; ok {
+			previous = partialMetric.Usage
+		}
+		d.setPartialMetricUsage(metricName, v1.MergeUsage(previous, usage))
+	}
+	for metricName, partialMetric := range d.partialMetrics {
+		usage := partialMetric.Usage.Prune(r.Source, deadline)
+		if usage == nil {
+			// A partial metric only exists because of its usage.
+			delete(d.partialMetrics, metricName)
+			continue
+		}
+		if usage == partialMetric.Usage {
+			// Nothing has been pruned, the sources and so the matching metrics are the same.
+			continue
+		}
+		d.setPartialMetricUsage(metricName, usage)
+	}
+	d.partialMetricsUsageMutex.Unlock()
+
+	d.metricsMutex.Lock()
+	defer d.metricsMutex.Unlock()
+	for metricName, usage := range r.Usage {
+		if metric, ok := d.metrics[metricName]; ok {
+			metric.Usage = v1.MergeUsage(metric.Usage, usage)
+		} else {
+			d.usage[metricName] = v1.MergeUsage(d.usage[metricName], usage)
+		}
+	}
+	for _, metric := range d.metrics {
+		metric.Usage = metric.Usage.Prune(r.Source, deadline)
+	}
+	for metricName, usage := range d.usage {
+		if d.usage[metricName] = usage.Prune(r.Source, deadline); d.usage[metricName] == nil {
+			delete(d.usage, metricName)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/perses/metrics-usage/config"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcile(t *testing.T) {
+	inMemory := true
+	d := New(config.Database{InMemory: &inMemory}, config.Classification{}).(*db)
+	dashboardA := v1.DashboardUsage{ID: "a", Name: "A", URL: "http://grafana/d/a"}
+	dashboardB := v1.DashboardUsage{ID: "b", Name: "B", URL: "http://grafana/d/b"}
+	otherGrafana := v1.DashboardUsage{ID: "c", Name: "C", URL: "http://grafana-2/d/c"}
+	rule := v1.RuleUsage{PromLink: "http://prometheus", GroupName: "node", Name: "NodeDown", Expression: "up == 0"}
+	d.metricsMutex.Lock()
+	d.metrics["up"] = &v1.Metric{Usage: &v1.MetricUsage{
+		Dashboards: v1.NewSet(dashboardA, dashboardB, otherGrafana),
+		AlertRules: v1.NewSet(rule),
+	}}
+	d.metrics["node_load1"] = &v1.Metric{Usage: &v1.MetricUsage{Dashboards: v1.NewSet(dashboardB)}}
+	d.usage["http_requests_total"] = &v1.MetricUsage{Dashboards: v1.NewSet(dashboardB)}
+	d.metricsMutex.Unlock()
+	d.partialMetricsUsageMutex.Lock()
+	d.partialMetrics["node_.+"] = &v1.PartialMetric{Usage: &v1.MetricUsage{Dashboards: v1.NewSet(dashboardB)}}
+	d.partialMetricsUsageMutex.Unlock()
+
+	t0 := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	reconcile := func(now time.Time) {
+		d.reconcile(&Reconciliation{
+			Source: "http://grafana",
+			Usage:  map[string]*v1.MetricUsage{"up": {Dashboards: v1.NewSet(dashboardA)}},
+		}, now, now.Add(-time.Hour))
+	}
+	// The usage collected before the reconciliation was enabled has never been confirmed, so it is removed at once.
+	d.reconcile(&Reconciliation{
+		Source: "http://grafana",
+		Usage:  map[string]*v1.MetricUsage{"up": {Dashboards: v1.NewSet(dashboardA, dashboardB)}},
+	}, t0, t0.Add(-time.Hour))
+	metric := d.GetMetric("node_load1")
+	require.NotNil(t, metric)
+	assert.Nil(t, metric.Usage)
+	assert.Empty(t, d.ListPendingUsage())
+	partialMetrics, err := d.ListPartialMetrics()
+	require.NoError(t, err)
+	assert.Empty(t, partialMetrics)
+
+	// The dashboard B has been deleted, but the window keeps its usage for now.
+	reconcile(t0.Add(30 * time.Minute))
+	metric = d.GetMetric("up")
+	require.NotNil(t, metric)
+	assert.Equal(t, v1.NewSet(dashboardA, dashboardB, otherGrafana), metric.Usage.Dashboards)
+	assert.Equal(t, t0, metric.Usage.LastConfirmed["dashboard:http://grafana/d/b"])
+
+	// Once the window is over, only the usage of the other sources and the one confirmed again are kept.
+	t1 := t0.Add(2 * time.Hour)
+	reconcile(t1)
+	metric = d.GetMetric("up")
+	require.NotNil(t, metric)
+	assert.Equal(t, v1.NewSet(dashboardA, otherGrafana), metric.Usage.Dashboards)
+	assert.Equal(t, v1.NewSet(rule), metric.Usage.AlertRules)
+	assert.Equal(t, map[string]time.Time{"dashboard:http://grafana/d/a": t1}, metric.Usage.LastConfirmed)
+}
@@ -0,0 +1,51 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"time"
+
+	"github.com/perses/metrics-usage/utils/pubsub"
+)
+
+// pruneUnusedMetrics removes the metrics without any usage that have not been seen since the deadline.
+// They are also removed from the metrics matched by the partial metrics. It returns the number of metrics removed.
+// The readers are getting a copy of the metrics, so the ones being read are not affected.
+func (d *db) pruneUnusedMetrics(deadline time.Time) int {
+	var pruned []string
+	defer func() {
+		// The event is published once the locks are released.
+		if len(pruned) > 0 {
+			d.generation.Add(1)
+		}
+		pubsub.PublishMetrics(pubsub.MetricsRemovedKind, pruned)
+	}()
+	d.lockAll()
+	defer d.unlockAll()
+	for metricName, metric := range d.metrics {
+		if metric.Usage.IsEmpty() && metric.LastSeen != nil && metric.LastSeen.Before(deadline) {
+			delete(d.metrics, metricName)
+			pruned = append(pruned, metricName)
+		}
+	}
+	if len(pruned) == 0 {
+		return 0
+	}
+	for _, partialMetric := range d.partialMetrics {
+		for _, metricName := range pruned {
+			partialMetric.MatchingMetrics.Remove(metricName)
+		}
+	}
+	return len(pruned)
+}
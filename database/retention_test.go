@@ -0,0 +1,53 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneUnusedMetrics(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	d := &db{
+		metrics: map[string]*v1.Metric{
+			"old_unused": {LastSeen: &old},
+			"old_used":   {LastSeen: &old, Usage: &v1.MetricUsage{Dashboards: v1.NewSet(v1.DashboardUsage{ID: "a"})}},
+			"recent":     {LastSeen: &now},
+			"never_seen": {},
+		},
+		partialMetrics: map[string]*v1.PartialMetric{
+			"old_.+": {MatchingMetrics: v1.NewSet("old_unused", "old_used")},
+		},
+	}
+	assert.Equal(t, 1, d.pruneUnusedMetrics(now.Add(-24*time.Hour)))
+	assert.NotContains(t, d.metrics, "old_unused")
+	assert.Contains(t, d.metrics, "old_used")
+	assert.Contains(t, d.metrics, "recent")
+	assert.Contains(t, d.metrics, "never_seen")
+	assert.Equal(t, v1.NewSet("old_used"), d.partialMetrics["old_.+"].MatchingMetrics)
+	assert.Equal(t, 0, d.pruneUnusedMetrics(now.Add(-24*time.Hour)))
+}
+
+func TestMarkSeen(t *testing.T) {
+	metric := &v1.Metric{}
+	(&db{}).markSeen(metric, time.Now())
+	assert.Nil(t, metric.LastSeen)
+	(&db{unusedMaxAge: time.Hour}).markSeen(metric, time.Now())
+	assert.NotNil(t, metric.LastSeen)
+}
@@ -0,0 +1,194 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/brunoga/deep"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// snapshotNameRegexp restricts the name of the saved snapshots, as it is used as a file name.
+var snapshotNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// snapshotFileExtension is the extension of the files storing the saved snapshots.
+const snapshotFileExtension = ".json"
+
+// savedSnapshot is a copy of the metrics saved under a name, with the time it has been saved, used to evict the oldest ones.
+type savedSnapshot struct {
+	metrics map[string]*v1.Metric
+	savedAt time.Time
+}
+
+// ValidateSnapshotName returns an error if the name cannot be used to save a snapshot.
+func ValidateSnapshotName(name string) error {
+	if !snapshotNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %q, it must start with a letter or a digit and only contain letters, digits, '_', '.' and '-'", name)
+	}
+	return nil
+}
+
+// SaveSnapshot stores a copy of the current metrics under the given name, replacing the snapshot having the same name.
+// When the database is stored in a file, the snapshot is written in the directory next to it, so it survives a restart.
+// Once there are more snapshots than the maximum, the oldest ones are removed.
+func (d *db) SaveSnapshot(name string) error {
+	if err := ValidateSnapshotName(name); err != nil {
+		return err
+	}
+	d.metricsMutex.RLock()
+	data, err := encodeFile(d.metrics)
+	d.metricsMutex.RUnlock()
+	if err != nil {
+		return err
+	}
+	metrics, err := decodeFile(data)
+	if err != nil {
+		return err
+	}
+	if !d.inMemory {
+		if err := os.MkdirAll(d.snapshotDir(), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(d.snapshotDir(), name+snapshotFileExtension), data, 0644); err != nil {
+			return err
+		}
+	}
+	d.savedSnapshotsMutex.Lock()
+	d.savedSnapshots[name] = &savedSnapshot{metrics: metrics, savedAt: time.Now()}
+	d.evictSnapshots()
+	d.savedSnapshotsMutex.Unlock()
+	d.generation.Add(1)
+	return nil
+}
+
+// DeleteSnapshot removes the snapshot saved under the given name, and its file. It returns false if there is no such snapshot.
+func (d *db) DeleteSnapshot(name string) (bool, error) {
+	d.savedSnapshotsMutex.Lock()
+	defer d.savedSnapshotsMutex.Unlock()
+	if _, ok := d.savedSnapshots[name]; !ok {
+		return false, nil
+	}
+	if err := d.removeSnapshotFile(name); err != nil {
+		return true, err
+	}
+	delete(d.savedSnapshots, name)
+	d.generation.Add(1)
+	return true, nil
+}
+
+// evictSnapshots removes the oldest snapshots beyond the maximum. savedSnapshotsMutex must be held by the caller.
+func (d *db) evictSnapshots() {
+	if d.maxSnapshots <= 0 || len(d.savedSnapshots) <= d.maxSnapshots {
+		return
+	}
+	names := make([]string, 0, len(d.savedSnapshots))
+	for name := range d.savedSnapshots {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		return d.savedSnapshots[a].savedAt.Compare(d.savedSnapshots[b].savedAt)
+	})
+	for _, name := range names[:len(names)-d.maxSnapshots] {
+		if err := d.removeSnapshotFile(name); err != nil {
+			// The snapshot is still removed from memory, the file will be evicted again at the next restart.
+			logrus.WithError(err).Warningf("unable to remove the file of the evicted snapshot %q", name)
+		}
+		delete(d.savedSnapshots, name)
+		logrus.Infof("the snapshot %q has been evicted, as the maximum of %d snapshots is reached", name, d.maxSnapshots)
+	}
+}
+
+// removeSnapshotFile removes the file of the snapshot, when the database is stored in a file.
+func (d *db) removeSnapshotFile(name string) error {
+	if d.inMemory {
+		return nil
+	}
+	if err := os.Remove(filepath.Join(d.snapshotDir(), name+snapshotFileExtension)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// GetSnapshot returns a copy of the metrics saved under the given name, or nil if there is no such snapshot.
+func (d *db) GetSnapshot(name string) map[string]*v1.Metric {
+	d.savedSnapshotsMutex.RLock()
+	defer d.savedSnapshotsMutex.RUnlock()
+	snapshot, ok := d.savedSnapshots[name]
+	if !ok {
+		return nil
+	}
+	return deep.MustCopy(snapshot.metrics)
+}
+
+// ListSnapshots returns the sorted names of the saved snapshots.
+func (d *db) ListSnapshots() []string {
+	d.savedSnapshotsMutex.RLock()
+	defer d.savedSnapshotsMutex.RUnlock()
+	names := make([]string, 0, len(d.savedSnapshots))
+	for name := range d.savedSnapshots {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// snapshotDir is the directory where the saved snapshots are written, next to the database file.
+func (d *db) snapshotDir() string {
+	return d.path + ".snapshots"
+}
+
+// readSnapshotFiles loads the snapshots saved by a previous run. A missing directory means no snapshot has been saved yet.
+// The time a snapshot has been saved is the modification time of its file.
+func (d *db) readSnapshotFiles() error {
+	entries, err := os.ReadDir(d.snapshotDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		name, isSnapshot := strings.CutSuffix(entry.Name(), snapshotFileExtension)
+		if entry.IsDir() || !isSnapshot || ValidateSnapshotName(name) != nil {
+			continue
+		}
+		info, infoErr := entry.Info()
+		if infoErr != nil {
+			logrus.WithError(infoErr).Warningf("unable to read the snapshot %q", name)
+			continue
+		}
+		data, readErr := os.ReadFile(filepath.Join(d.snapshotDir(), entry.Name()))
+		if readErr != nil {
+			logrus.WithError(readErr).Warningf("unable to read the snapshot %q", name)
+			continue
+		}
+		metrics, decodeErr := decodeFile(data)
+		if decodeErr != nil {
+			logrus.WithError(decodeErr).Warningf("unable to decode the snapshot %q", name)
+			continue
+		}
+		d.savedSnapshots[name] = &savedSnapshot{metrics: metrics, savedAt: info.ModTime()}
+	}
+	// The maximum may have been lowered since the snapshots were saved.
+	d.evictSnapshots()
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/perses/metrics-usage/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveSnapshot(t *testing.T) {
+	inMemory := false
+	cfg := config.Database{InMemory: &inMemory, Path: filepath.Join(t.TempDir(), "database.json"), FlushPeriod: model.Duration(time.Hour)}
+	d := New(cfg, config.Classification{})
+	d.EnqueueMetricList([]string{"up"})
+	assert.Eventually(t, func() bool {
+		return d.GetMetric("up") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Error(t, d.SaveSnapshot("../escape"))
+	require.NoError(t, d.SaveSnapshot("before"))
+	d.EnqueueMetricList([]string{"node_load1"})
+	assert.Eventually(t, func() bool {
+		return d.GetMetric("node_load1") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, d.Reset())
+
+	assert.Nil(t, d.GetSnapshot("unknown"))
+	assert.Equal(t, []string{"before"}, d.ListSnapshots())
+	assert.Contains(t, d.GetSnapshot("before"), "up")
+	assert.NotContains(t, d.GetSnapshot("before"), "node_load1")
+
+	// The snapshots are loaded again by a new instance using the same file.
+	reloaded := New(cfg, config.Classification{})
+	assert.Equal(t, []string{"before"}, reloaded.ListSnapshots())
+	assert.Contains(t, reloaded.GetSnapshot("before"), "up")
+}
+
+func TestSnapshotEviction(t *testing.T) {
+	inMemory := false
+	cfg := config.Database{InMemory: &inMemory, Path: filepath.Join(t.TempDir(), "database.json"), FlushPeriod: model.Duration(time.Hour), MaxSnapshots: 2}
+	d := New(cfg, config.Classification{}).(*db)
+	for _, name := range []string{"first", "second", "third"} {
+		require.NoError(t, d.SaveSnapshot(name))
+		// The snapshots must be ordered by the time they have been saved.
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"second", "third"}, d.ListSnapshots())
+	assert.NoFileExists(t, filepath.Join(d.snapshotDir(), "first"+snapshotFileExtension))
+
+	// Replacing a snapshot doesn't evict another one.
+	require.NoError(t, d.SaveSnapshot("second"))
+	assert.Equal(t, []string{"second", "third"}, d.ListSnapshots())
+
+	found, err := d.DeleteSnapshot("third")
+	require.NoError(t, err)
+	assert.True(t, found)
+	found, err = d.DeleteSnapshot("third")
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, []string{"second"}, d.ListSnapshots())
+	assert.NoFileExists(t, filepath.Join(d.snapshotDir(), "third"+snapshotFileExtension))
+
+	// The snapshot deleted is not loaded again by a new instance using the same file.
+	reloaded := New(cfg, config.Classification{})
+	assert.Equal(t, []string{"second"}, reloaded.ListSnapshots())
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package database
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	pendingUsageEntriesDesc = prometheus.NewDesc(
+		"database_pending_usage_entries",
+		"The number of metrics having a usage, waiting for the metric to be collected.",
+		nil, nil,
+	)
+	queueDepthDescs = map[string]*prometheus.Desc{
+		"usage":                 newQueueDepthDesc("usage"),
+		"partial_metrics_usage": newQueueDepthDesc("partial_metrics_usage"),
+		"labels":                newQueueDepthDesc("labels"),
+		"used_labels":           newQueueDepthDesc("used_labels"),
+		"metadata":              newQueueDepthDesc("metadata"),
+		"metrics":               newQueueDepthDesc("metrics"),
+		"reconcile":             newQueueDepthDesc("reconcile"),
+	}
+	// stats is registered once in the default registry, and is describing the last database created.
+	stats = &statsCollector{}
+)
+
+func init() {
+	prometheus.MustRegister(stats)
+}
+
+func newQueueDepthDesc(queue string) *prometheus.Desc {
+	return prometheus.NewDesc(
+		"database_"+queue+"_queue_depth",
+		"The number of batches waiting in the queue "+queue+" to be written in the database.",
+		nil, nil,
+	)
+}
+
+// statsCollector is sampling the size of the internal buffers of the database at every scrape.
+// It is used to detect when the pending usage is growing or when the writers are not keeping up with the collectors.
+type statsCollector struct {
+	db atomic.Pointer[db]
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pendingUsageEntriesDesc
+	for _, desc := range queueDepthDescs {
+		ch <- desc
+	}
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	d := c.db.Load()
+	if d == nil {
+		return
+	}
+	d.metricsMutex.RLock()
+	pendingUsage := len(d.usage)
+	d.metricsMutex.RUnlock()
+	ch <- prometheus.MustNewConstMetric(pendingUsageEntriesDesc, prometheus.GaugeValue, float64(pendingUsage))
+	for queue, depth := range map[string]int{
+		"usage":                 len(d.usageQueue),
+		"partial_metrics_usage": len(d.partialMetricsUsageQueue),
+		"labels":                len(d.labelsQueue),
+		"used_labels":           len(d.usedLabelsQueue),
+		"metadata":              len(d.metadataQueue),
+		"metrics":               len(d.metricsQueue),
+		"reconcile":             len(d.reconcileQueue),
+	} {
+		ch <- prometheus.MustNewConstMetric(queueDepthDescs[queue], prometheus.GaugeValue, float64(depth))
+	}
+}
@@ -15,32 +15,115 @@ package main
 
 import (
 	"flag"
+	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/perses/common/app"
+	"github.com/perses/common/async"
+	"github.com/perses/metrics-usage/auth"
+	"github.com/perses/metrics-usage/check"
 	"github.com/perses/metrics-usage/config"
 	"github.com/perses/metrics-usage/database"
+	"github.com/perses/metrics-usage/notifier"
+	grafanaAnalyzer "github.com/perses/metrics-usage/pkg/analyze/grafana"
+	persesAnalyzer "github.com/perses/metrics-usage/pkg/analyze/perses"
+	"github.com/perses/metrics-usage/pkg/analyze/prometheus"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/perses/metrics-usage/source/admin"
+	"github.com/perses/metrics-usage/source/configuration"
+	"github.com/perses/metrics-usage/source/debug"
+	"github.com/perses/metrics-usage/source/events"
 	"github.com/perses/metrics-usage/source/grafana"
 	"github.com/perses/metrics-usage/source/labels"
 	"github.com/perses/metrics-usage/source/metric"
 	"github.com/perses/metrics-usage/source/perses"
 	"github.com/perses/metrics-usage/source/rules"
+	"github.com/perses/metrics-usage/source/snapshot"
+	"github.com/perses/metrics-usage/source/suggestion"
+	"github.com/perses/metrics-usage/utils/inflight"
+	"github.com/perses/metrics-usage/utils/pathprefix"
+	"github.com/perses/metrics-usage/utils/pubsub"
+	"github.com/perses/metrics-usage/utils/respcache"
+	"github.com/perses/metrics-usage/utils/schedule"
 	"github.com/sirupsen/logrus"
 )
 
+// configFiles is the list of configuration files given by repeating the flag --config.
+type configFiles []string
+
+func (c *configFiles) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *configFiles) Set(value string) error {
+	*c = append(*c, value)
+	return nil
+}
+
 func main() {
-	configFile := flag.String("config", "", "Path to the YAML configuration file for the API. Configuration settings can be overridden when using environment variables.")
+	var files configFiles
+	flag.Var(&files, "config", "Path to the YAML configuration file for the API. It can be repeated, the files are then merged in order, the later ones overriding the earlier ones. Configuration settings can be overridden when using environment variables.")
 	pprof := flag.Bool("pprof", false, "Enable pprof")
+	checkMode := flag.Bool("check", false, "Check the metric hygiene instead of starting the server, and exit with a non-zero code when a threshold is violated. The arguments are the dashboard files to check.")
+	checkURL := flag.String("check-url", "", "URL of a running instance of metrics-usage used by --check. By default, the database of the configuration is read.")
+	maxUnused := flag.Int("max-unused", -1, "Maximum number of unused metrics accepted by --check. Disabled when negative.")
+	failOnMissing := flag.Bool("fail-on-missing", false, "Make --check fail when a dashboard uses a metric that doesn't exist.")
+	compareEngines := flag.Bool("compare-engines", false, "Compare the metrics extracted by the PromQL and the MetricsQL analyzers from the queries collected, instead of starting the server, and exit with a non-zero code when they differ. The queries are read like the metrics of --check, --check-url included.")
 	flag.Parse()
 
 	// load the config from file or/and from environment
-	conf, err := config.Resolve(*configFile)
+	conf, err := config.Resolve(files...)
 	if err != nil {
-		logrus.WithError(err).Fatalf("error reading configuration from file %q or from environment", *configFile)
+		logrus.WithError(err).Fatalf("error reading configuration from the files %q or from environment", files)
 	}
 
-	db := database.New(conf.Database)
+	if !conf.Analyzer.DisableCache {
+		prometheus.EnableCache(conf.Analyzer.CacheSize)
+	}
+	if conf.Analyzer.NormalizePartialMetrics {
+		prometheus.EnablePartialMetricsNormalization()
+	}
+	if conf.Analyzer.ExpandRegexpMatchers {
+		prometheus.EnableRegexpExpansion()
+	}
+	if conf.Analyzer.UTF8MetricNames {
+		prometheus.EnableUTF8MetricNames()
+	}
+	for _, plugin := range conf.Analyzer.PersesPlugins {
+		persesAnalyzer.RegisterPlugin(plugin.Kind, persesAnalyzer.FieldExtractor(plugin.ExpressionField))
+	}
+	for _, panel := range conf.Analyzer.GrafanaPanels {
+		grafanaAnalyzer.RegisterPanelType(panel.Type, grafanaAnalyzer.PathExtractor(panel.ExpressionPaths...))
+	}
+	if slices.ContainsFunc(conf.GrafanaCollectors, func(c *config.GrafanaCollector) bool { return c.Enable && (c.DeepScan || c.ScanTextPanels) }) {
+		grafanaAnalyzer.EnablePanelJSONRetention()
+	}
+	if *checkMode {
+		os.Exit(runCheck(conf, *checkURL, check.Options{MaxUnused: *maxUnused, FailOnMissing: *failOnMissing, Dashboards: flag.Args()}))
+	}
+	if *compareEngines {
+		os.Exit(runCompareEngines(conf, *checkURL))
+	}
+	db := database.New(conf.Database, conf.Classification)
 	runner := app.NewRunner().WithDefaultHTTPServer("metrics_usage")
+	jitter := time.Duration(conf.CollectorJitter)
+
+	// afterCollection are the tasks executed at the end of every run of the collectors defining the list of metrics.
+	var afterCollection []async.SimpleTask
+	if conf.Notifier.Enable {
+		unusedMetricsNotifier, notifierErr := notifier.New(db, conf.Notifier, conf.Database)
+		if notifierErr != nil {
+			logrus.WithError(notifierErr).Fatal("unable to create the notifier")
+		}
+		if conf.MetricCollector.Enable || slices.ContainsFunc(conf.MetricFileCollectors, func(c *config.MetricFileCollector) bool { return c.Enable }) {
+			afterCollection = append(afterCollection, unusedMetricsNotifier)
+		} else {
+			// The metrics are pushed by remote collectors, so the notifier is running on its own.
+			runner.WithTimerTasks(time.Duration(conf.Notifier.Period), schedule.Delay(unusedMetricsNotifier, 0, jitter))
+		}
+	}
 
 	if conf.MetricCollector.Enable {
 		metricCollectorConfig := conf.MetricCollector
@@ -48,7 +131,17 @@ func main() {
 		if collectorErr != nil {
 			logrus.WithError(collectorErr).Fatal("unable to create the metric collector")
 		}
-		runner.WithTimerTasks(time.Duration(metricCollectorConfig.Period), metricCollector)
+		runner.WithTimerTasks(time.Duration(metricCollectorConfig.Period), schedule.Delay(schedule.After(metricCollector, afterCollection...), time.Duration(metricCollectorConfig.StartOffset), jitter))
+	}
+
+	for i, metricFileCollectorConfig := range conf.MetricFileCollectors {
+		if metricFileCollectorConfig.Enable {
+			metricFileCollector, collectorErr := metric.NewFileCollector(db, metricFileCollectorConfig)
+			if collectorErr != nil {
+				logrus.WithError(collectorErr).Fatalf("unable to create the metric file collector number %d", i)
+			}
+			runner.WithTimerTasks(time.Duration(metricFileCollectorConfig.Period), schedule.Delay(schedule.After(metricFileCollector, afterCollection...), time.Duration(metricFileCollectorConfig.StartOffset), jitter))
+		}
 	}
 
 	for i, rulesCollectorConfig := range conf.RulesCollectors {
@@ -57,7 +150,7 @@ func main() {
 			if collectorErr != nil {
 				logrus.WithError(collectorErr).Fatalf("unable to create the rules collector number %d", i)
 			}
-			runner.WithTimerTasks(time.Duration(rulesCollectorConfig.Period), rulesCollector)
+			runner.WithTimerTasks(time.Duration(rulesCollectorConfig.Period), schedule.Delay(rulesCollector, time.Duration(rulesCollectorConfig.StartOffset), jitter))
 		}
 	}
 
@@ -67,7 +160,7 @@ func main() {
 			if collectorErr != nil {
 				logrus.WithError(collectorErr).Fatalf("unable to create the labels collector number %d", i)
 			}
-			runner.WithTimerTasks(time.Duration(labelsCollectorConfig.Period), labelsCollector)
+			runner.WithTimerTasks(time.Duration(labelsCollectorConfig.Period), schedule.Delay(labelsCollector, time.Duration(labelsCollectorConfig.StartOffset), jitter))
 		}
 	}
 
@@ -77,22 +170,100 @@ func main() {
 		if collectorErr != nil {
 			logrus.WithError(collectorErr).Fatal("unable to create the perses collector")
 		}
-		runner.WithTimerTasks(time.Duration(persesCollectorConfig.Period), persesCollector)
+		runner.WithTimerTasks(time.Duration(persesCollectorConfig.Period), schedule.Delay(persesCollector, time.Duration(persesCollectorConfig.StartOffset), jitter))
 	}
 
-	if conf.GrafanaCollector.Enable {
-		grafanaCollectorConfig := conf.GrafanaCollector
-		grafanaCollector, collectorErr := grafana.NewCollector(db, grafanaCollectorConfig)
+	if conf.PersesFileCollector.Enable {
+		persesFileCollectorConfig := conf.PersesFileCollector
+		persesFileCollector, collectorErr := perses.NewFileCollector(db, persesFileCollectorConfig)
 		if collectorErr != nil {
-			logrus.WithError(collectorErr).Fatal("unable to create the grafana collector")
+			logrus.WithError(collectorErr).Fatal("unable to create the perses file collector")
+		}
+		runner.WithTimerTasks(time.Duration(persesFileCollectorConfig.Period), schedule.Delay(persesFileCollector, time.Duration(persesFileCollectorConfig.StartOffset), jitter))
+	}
+
+	for i, grafanaCollectorConfig := range conf.GrafanaCollectors {
+		if grafanaCollectorConfig.Enable {
+			grafanaCollector, collectorErr := grafana.NewCollector(db, grafanaCollectorConfig)
+			if collectorErr != nil {
+				logrus.WithError(collectorErr).Fatalf("unable to create the grafana collector number %d", i)
+			}
+			runner.WithTimerTasks(time.Duration(grafanaCollectorConfig.Period), schedule.Delay(grafanaCollector, time.Duration(grafanaCollectorConfig.StartOffset), jitter))
 		}
-		runner.WithTimerTasks(time.Duration(grafanaCollectorConfig.Period), grafanaCollector)
 	}
 
-	runner.HTTPServerBuilder().
+	httpServerBuilder := runner.HTTPServerBuilder().
 		ActivatePprof(*pprof).
 		APIRegistration(metric.NewAPI(db)).
 		APIRegistration(rules.NewAPI(db)).
-		APIRegistration(labels.NewAPI(db))
+		APIRegistration(labels.NewAPI(db)).
+		APIRegistration(snapshot.NewAPI(db)).
+		APIRegistration(suggestion.NewAPI(db))
+	if len(conf.Server.PathPrefix) > 0 {
+		httpServerBuilder.PreMiddleware(pathprefix.Middleware(conf.Server.PathPrefix))
+	}
+	if conf.Server.Auth != nil {
+		httpServerBuilder.Middleware(auth.Middleware(*conf.Server.Auth))
+	}
+	if conf.Server.Push != nil {
+		inflight.Enable(conf.Server.Push.MaxInFlight, time.Duration(conf.Server.Push.RetryAfter))
+	}
+	if conf.Server.ResponseCache != nil {
+		respcache.Enable(time.Duration(conf.Server.ResponseCache.TTL))
+	}
+	if conf.Server.Events != nil {
+		pubsub.Enable(conf.Server.Events.MaxSubscribers)
+		httpServerBuilder.APIRegistration(events.NewAPI())
+	}
+	if *pprof {
+		// the debug endpoints are exposing the whole database, so like pprof, they are not available by default.
+		httpServerBuilder.APIRegistration(debug.NewAPI(db))
+	}
+	if *pprof || conf.Server.Auth != nil {
+		// the admin endpoints are wiping the database, so they are only available when the API is protected or in debug mode.
+		httpServerBuilder.APIRegistration(admin.NewAPI(db))
+		// the configuration is describing the whole setup (the URLs of the sources, the users), so it is protected the same way.
+		httpServerBuilder.APIRegistration(configuration.NewAPI(conf))
+	}
 	runner.Start()
 }
+
+// runCheck checks the metrics of the running instance, or of the database, and returns the exit code.
+func runCheck(conf config.Config, instanceURL string, opts check.Options) int {
+	result, err := check.Run(listMetricsToCheck(conf, instanceURL), opts)
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to run the check")
+	}
+	result.Print(os.Stdout)
+	if len(result.Failures) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runCompareEngines compares the PromQL and the MetricsQL analyzers on the queries collected by the running instance, or stored in the database,
+// and returns the exit code.
+func runCompareEngines(conf config.Config, instanceURL string) int {
+	queries := check.CollectQueries(listMetricsToCheck(conf, instanceURL))
+	differences := check.CompareEngines(queries)
+	check.PrintEngineDifferences(os.Stdout, len(queries), differences)
+	if len(differences) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// listMetricsToCheck returns the metrics of the running instance when its URL is given, the ones of the database otherwise.
+func listMetricsToCheck(conf config.Config, instanceURL string) map[string]*modelAPIV1.Metric {
+	var metrics map[string]*modelAPIV1.Metric
+	var err error
+	if len(instanceURL) > 0 {
+		metrics, err = check.FetchMetrics(instanceURL)
+	} else {
+		metrics, err = database.New(conf.Database, conf.Classification).ListMetrics()
+	}
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to list the metrics to check")
+	}
+	return metrics
+}
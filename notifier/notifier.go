@@ -0,0 +1,196 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/perses/common/async"
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/metrics-usage/database"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/sirupsen/logrus"
+)
+
+// message is the payload sent to the webhook. It is compatible with the Slack incoming webhooks.
+type message struct {
+	Text string `json:"text"`
+}
+
+// New returns the notifier. When the database is stored in a file, the unused metrics of the last check are stored next to it,
+// so the first check after a restart is compared with the last one done before.
+func New(db database.Database, cfg config.Notifier, dbConfig config.Database) (async.SimpleTask, error) {
+	httpClient, err := config.NewHTTPClient(cfg.Webhook, "notifier")
+	if err != nil {
+		return nil, err
+	}
+	n := &notifier{
+		db:         db,
+		httpClient: httpClient,
+		webhookURL: cfg.Webhook.URL.String(),
+		delta:      cfg.Delta,
+		topN:       cfg.TopN,
+		logger:     logrus.StandardLogger().WithField("task", "notifier"),
+	}
+	if dbConfig.InMemory != nil && !*dbConfig.InMemory {
+		n.baselinePath = dbConfig.Path + ".notifier"
+		if readErr := n.readBaseline(); readErr != nil {
+			n.logger.WithError(readErr).Warning("failed to read the unused metrics of the previous check")
+		}
+	}
+	return n, nil
+}
+
+type notifier struct {
+	async.SimpleTask
+	db         database.Database
+	httpClient *http.Client
+	webhookURL string
+	delta      uint
+	topN       uint
+	// baselinePath is the file storing the unused metrics of the previous check. It is empty when the database is in memory.
+	baselinePath string
+	// mutex serializes the checks, as the notifier is triggered by every collector defining the list of metrics.
+	mutex sync.Mutex
+	// unusedMetrics is the list of unused metrics found during the previous check.
+	// It is nil until the first check is done.
+	unusedMetrics modelAPIV1.Set[string]
+	logger        *logrus.Entry
+}
+
+func (n *notifier) Execute(ctx context.Context, _ context.CancelFunc) error {
+	// The notifier runs right after the collector, whose list of metrics is written asynchronously.
+	// The check waits for it, and reads the live data, as the snapshot is only refreshed when the database is flushed.
+	if err := n.db.WaitForMetricLists(ctx); err != nil {
+		n.logger.WithError(err).Error("the metrics collected haven't been written in time")
+		return nil
+	}
+	metrics, err := n.db.ListLiveMetrics()
+	if err != nil {
+		n.logger.WithError(err).Error("failed to list the metrics")
+		return nil
+	}
+	unusedMetrics := modelAPIV1.NewSet[string]()
+	for metricName, metric := range metrics {
+		if metric.Usage == nil {
+			unusedMetrics.Add(metricName)
+		}
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	previousUnusedMetrics := n.unusedMetrics
+	n.unusedMetrics = unusedMetrics
+	if writeErr := n.writeBaseline(); writeErr != nil {
+		n.logger.WithError(writeErr).Error("failed to write the unused metrics")
+	}
+	text, notify := diff(previousUnusedMetrics, unusedMetrics, n.delta, n.topN)
+	if !notify {
+		return nil
+	}
+	if sendErr := n.send(ctx, text); sendErr != nil {
+		n.logger.WithError(sendErr).Error("failed to send the notification")
+	}
+	return nil
+}
+
+func (n *notifier) String() string {
+	return "unused metrics notifier"
+}
+
+// diff compares the unused metrics with the ones of the previous check, and returns the text of the notification.
+// A notification is only sent when the number of unused metrics has increased by more than delta.
+// At most topN newly unused metrics are listed in the text.
+func diff(previousUnusedMetrics modelAPIV1.Set[string], unusedMetrics modelAPIV1.Set[string], delta uint, topN uint) (string, bool) {
+	if previousUnusedMetrics == nil {
+		// First check, there is nothing to compare with yet.
+		return "", false
+	}
+	if len(unusedMetrics) <= len(previousUnusedMetrics) || uint(len(unusedMetrics)-len(previousUnusedMetrics)) <= delta {
+		return "", false
+	}
+	var newlyUnusedMetrics []string
+	for metricName := range unusedMetrics {
+		if !previousUnusedMetrics.Contains(metricName) {
+			newlyUnusedMetrics = append(newlyUnusedMetrics, metricName)
+		}
+	}
+	slices.Sort(newlyUnusedMetrics)
+	if uint(len(newlyUnusedMetrics)) > topN {
+		newlyUnusedMetrics = newlyUnusedMetrics[:topN]
+	}
+	text := fmt.Sprintf("%d metrics are unused (+%d since the last check).", len(unusedMetrics), len(unusedMetrics)-len(previousUnusedMetrics))
+	if len(newlyUnusedMetrics) > 0 {
+		text = fmt.Sprintf("%s Newly unused metrics:\n• %s", text, strings.Join(newlyUnusedMetrics, "\n• "))
+	}
+	return text, true
+}
+
+// readBaseline loads the unused metrics of the last check. A missing file means no check has been done yet.
+func (n *notifier) readBaseline() error {
+	data, err := os.ReadFile(n.baselinePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var unusedMetrics []string
+	if err = json.Unmarshal(data, &unusedMetrics); err != nil {
+		return err
+	}
+	n.unusedMetrics = modelAPIV1.NewSet(unusedMetrics...)
+	return nil
+}
+
+func (n *notifier) writeBaseline() error {
+	if len(n.baselinePath) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(slices.Sorted(maps.Keys(n.unusedMetrics)))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(n.baselinePath, data, 0644)
+}
+
+func (n *notifier) send(ctx context.Context, text string) error {
+	data, err := json.Marshal(message{Text: text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhookURL, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusPartialContent {
+		return fmt.Errorf("when sending the notification, unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/metrics-usage/database"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	testSuite := []struct {
+		title    string
+		previous modelAPIV1.Set[string]
+		current  modelAPIV1.Set[string]
+		delta    uint
+		topN     uint
+		text     string
+		notify   bool
+	}{
+		{
+			title:   "first check",
+			current: modelAPIV1.NewSet("a", "b"),
+			topN:    10,
+		},
+		{
+			title:    "no increase",
+			previous: modelAPIV1.NewSet("a", "b"),
+			current:  modelAPIV1.NewSet("b", "c"),
+			topN:     10,
+		},
+		{
+			title:    "increase not greater than the delta",
+			previous: modelAPIV1.NewSet("a"),
+			current:  modelAPIV1.NewSet("a", "b", "c"),
+			delta:    2,
+			topN:     10,
+		},
+		{
+			title:    "increase",
+			previous: modelAPIV1.NewSet("a"),
+			current:  modelAPIV1.NewSet("a", "c", "b"),
+			topN:     10,
+			text:     "3 metrics are unused (+2 since the last check). Newly unused metrics:\n• b\n• c",
+			notify:   true,
+		},
+		{
+			title:    "more newly unused metrics than topN",
+			previous: modelAPIV1.NewSet("a"),
+			current:  modelAPIV1.NewSet("b", "c", "d"),
+			topN:     2,
+			text:     "3 metrics are unused (+2 since the last check). Newly unused metrics:\n• b\n• c",
+			notify:   true,
+		},
+		{
+			title:    "no name listed",
+			previous: modelAPIV1.NewSet[string](),
+			current:  modelAPIV1.NewSet("a"),
+			topN:     0,
+			text:     "1 metrics are unused (+1 since the last check).",
+			notify:   true,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			text, notify := diff(test.previous, test.current, test.delta, test.topN)
+			assert.Equal(t, test.notify, notify)
+			assert.Equal(t, test.text, text)
+		})
+	}
+}
+
+func TestNotifyAfterRestart(t *testing.T) {
+	var texts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		texts = append(texts, msg.Text)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	webhookURL, err := common.ParseURL(server.URL)
+	require.NoError(t, err)
+	inMemory := false
+	dbConfig := config.Database{InMemory: &inMemory, Path: filepath.Join(t.TempDir(), "database.json")}
+	notifierConfig := config.Notifier{Enable: true, TopN: 10, Webhook: config.HTTPClient{URL: webhookURL}}
+	require.NoError(t, notifierConfig.Verify())
+
+	inMemoryDB := true
+	db := database.New(config.Database{InMemory: &inMemoryDB}, config.Classification{})
+	db.EnqueueMetricList([]string{"up"})
+	require.Eventually(t, func() bool { return db.GetMetric("up") != nil }, 5*time.Second, 10*time.Millisecond)
+	n, err := New(db, notifierConfig, dbConfig)
+	require.NoError(t, err)
+	require.NoError(t, n.Execute(context.Background(), nil))
+	assert.Empty(t, texts)
+	data, err := os.ReadFile(dbConfig.Path + ".notifier")
+	require.NoError(t, err)
+	assert.JSONEq(t, `["up"]`, string(data))
+
+	// After a restart, the first check is compared with the one done before.
+	db.EnqueueMetricList([]string{"go_goroutines"})
+	require.Eventually(t, func() bool { return db.GetMetric("go_goroutines") != nil }, 5*time.Second, 10*time.Millisecond)
+	n, err = New(db, notifierConfig, dbConfig)
+	require.NoError(t, err)
+	require.NoError(t, n.Execute(context.Background(), nil))
+	assert.Equal(t, []string{"2 metrics are unused (+1 since the last check). Newly unused metrics:\n• go_goroutines"}, texts)
+}
+
+func TestNotifyWithTheMetricsJustCollected(t *testing.T) {
+	var texts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg message
+		if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		texts = append(texts, msg.Text)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	webhookURL, err := common.ParseURL(server.URL)
+	require.NoError(t, err)
+	notifierConfig := config.Notifier{Enable: true, TopN: 10, Webhook: config.HTTPClient{URL: webhookURL}}
+	require.NoError(t, notifierConfig.Verify())
+
+	// The snapshot is only refreshed when the database is flushed, so it doesn't contain the metrics collected since.
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory, ReadFromSnapshot: true, FlushPeriod: model.Duration(time.Hour)}, config.Classification{})
+	n, err := New(db, notifierConfig, config.Database{InMemory: &inMemory})
+	require.NoError(t, err)
+	db.EnqueueMetricList([]string{"up"})
+	require.NoError(t, n.Execute(context.Background(), nil))
+	assert.Empty(t, texts)
+
+	// The check runs right after the collection, without waiting for the list of metrics to be written.
+	db.EnqueueMetricList([]string{"go_goroutines"})
+	require.NoError(t, n.Execute(context.Background(), nil))
+	assert.Equal(t, []string{"2 metrics are unused (+1 since the last check). Newly unused metrics:\n• go_goroutines"}, texts)
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"fmt"
+
+	"github.com/perses/metrics-usage/config"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// expressionDatasourceUID is the datasource of the Grafana expressions (like math, reduce or threshold) used in the alert rules.
+// They are only referencing the result of the other queries of the rule.
+const expressionDatasourceUID = "__expr__"
+
+// SimplifiedAlertRule is the part of a Grafana-managed alert rule (unified alerting) required to extract the metrics it uses.
+type SimplifiedAlertRule struct {
+	UID       string       `json:"uid"`
+	Title     string       `json:"title"`
+	FolderUID string       `json:"folderUID"`
+	RuleGroup string       `json:"ruleGroup"`
+	Data      []AlertQuery `json:"data"`
+}
+
+type AlertQuery struct {
+	RefID         string `json:"refId,omitempty"`
+	DatasourceUID string `json:"datasourceUid,omitempty"`
+	// Model is the query sent to the datasource. For a Prometheus datasource, it is the same as the target of a panel.
+	Model Target `json:"model"`
+}
+
+// AnalyzeAlertRule returns the metrics and the partial metrics used by the queries of the alert rule.
+// The queries using a datasource ignored by the filter are skipped. The filter can be nil.
+func AnalyzeAlertRule(rule *SimplifiedAlertRule, filter *config.DatasourceFilter) (modelAPIV1.Set[string], modelAPIV1.Set[string], []*modelAPIV1.LogError) {
+	metrics, partialMetrics, errs := AnalyzeAlertRuleAndExplain(rule, filter)
+	return metrics, partialMetrics.Metrics(), errs
+}
+
+// AnalyzeAlertRuleAndExplain is like AnalyzeAlertRule, but it also returns the reason why each partial metric has been classified as partial.
+func AnalyzeAlertRuleAndExplain(rule *SimplifiedAlertRule, filter *config.DatasourceFilter) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, []*modelAPIV1.LogError) {
+	var errs []*modelAPIV1.LogError
+	result := modelAPIV1.Set[string]{}
+	partialMetricsResult := modelAPIV1.PartialMetrics{}
+	// An alert rule doesn't have any variable, only the global ones like $__interval can be used.
+	expander := newVariableExpander(nil)
+	dsFilter := newDatasourceFilter(filter, nil)
+	for _, q := range rule.Data {
+		if q.DatasourceUID == expressionDatasourceUID || len(q.Model.Expr) == 0 {
+			continue
+		}
+		ds := q.Model.Datasource
+		if ds == nil || len(ds.UID) == 0 {
+			ds = &Datasource{UID: q.DatasourceUID}
+			if q.Model.Datasource != nil {
+				ds.Type = q.Model.Datasource.Type
+			}
+		}
+		if dsFilter.ignore(ds) {
+			continue
+		}
+		metrics, partialMetrics, err := analyzeExpression(q.Model.Expr, expander, modelAPIV1.Set[string]{})
+		if err != nil {
+			errs = append(errs, &modelAPIV1.LogError{
+				Error:   err,
+				Message: fmt.Sprintf("failed to extract metric names from PromQL expression in the query %q for the alert rule %s/%s", q.RefID, rule.Title, rule.UID),
+			})
+		}
+		result.Merge(metrics)
+		partialMetricsResult.Merge(partialMetrics)
+	}
+	return result, partialMetricsResult, errs
+}
@@ -0,0 +1,65 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/perses/metrics-usage/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeAlertRule(t *testing.T) {
+	data, err := os.ReadFile("tests/alert1.json")
+	require.NoError(t, err)
+	rule := &SimplifiedAlertRule{}
+	require.NoError(t, json.Unmarshal(data, rule))
+
+	tests := []struct {
+		name             string
+		datasourceFilter *config.DatasourceFilter
+		resultMetrics    []string
+		partialMetrics   []string
+		nbErrs           int
+	}{
+		{
+			name:           "no filter",
+			resultMetrics:  []string{"http_requests_total"},
+			partialMetrics: []string{"api_.+_errors_total"},
+			// the LogQL query cannot be analyzed.
+			nbErrs: 1,
+		},
+		{
+			name:             "datasource ignored by its type",
+			datasourceFilter: &config.DatasourceFilter{IgnoreTypes: []string{"loki"}},
+			resultMetrics:    []string{"http_requests_total"},
+			partialMetrics:   []string{"api_.+_errors_total"},
+		},
+		{
+			name:             "datasource ignored by its UID, even when the model doesn't reference it",
+			datasourceFilter: &config.DatasourceFilter{IgnoreUIDs: []string{"prometheus-main", "loki"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metrics, partialMetrics, errs := AnalyzeAlertRule(rule, test.datasourceFilter)
+			assert.Len(t, errs, test.nbErrs)
+			assert.ElementsMatch(t, test.resultMetrics, metrics.TransformAsSlice())
+			assert.ElementsMatch(t, test.partialMetrics, partialMetrics.TransformAsSlice())
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"slices"
+
+	"github.com/perses/metrics-usage/config"
+)
+
+// datasourceFilter is deciding if the queries using a datasource must be ignored.
+// The datasource variables are resolved with their default value before applying the filter.
+type datasourceFilter struct {
+	filter    *config.DatasourceFilter
+	variables map[string]Datasource
+}
+
+func newDatasourceFilter(filter *config.DatasourceFilter, variables []templateVar) *datasourceFilter {
+	return &datasourceFilter{
+		filter:    filter,
+		variables: extractDatasourceVariables(variables),
+	}
+}
+
+// extractDatasourceVariables returns, for each datasource variable, the datasource used by default.
+// The query of a datasource variable is the type of the datasources it can select.
+func extractDatasourceVariables(variables []templateVar) map[string]Datasource {
+	result := make(map[string]Datasource)
+	for _, v := range variables {
+		if v.Type != "datasource" {
+			continue
+		}
+		uid, ok := v.defaultValue()
+		if !ok {
+			continue
+		}
+		dsType, _ := v.Query.(string)
+		result[v.Name] = Datasource{Type: dsType, UID: uid}
+	}
+	return result
+}
+
+// resolve replaces the datasource variable used by the datasource with its default value.
+func (f *datasourceFilter) resolve(ds *Datasource) *Datasource {
+	name, isVariable := ds.variable()
+	if !isVariable {
+		return ds
+	}
+	v, ok := f.variables[name]
+	if !ok {
+		return ds
+	}
+	result := &Datasource{Type: ds.Type, UID: v.UID}
+	if len(result.Type) == 0 || datasourceVariableRegexp.MatchString(result.Type) {
+		result.Type = v.Type
+	}
+	return result
+}
+
+// ignore returns true if the queries using the given datasource must be ignored.
+func (f *datasourceFilter) ignore(ds *Datasource) bool {
+	if f.filter == nil || ds == nil {
+		return false
+	}
+	resolved := f.resolve(ds)
+	return (len(resolved.UID) > 0 && slices.Contains(f.filter.IgnoreUIDs, resolved.UID)) ||
+		(len(resolved.Type) > 0 && slices.Contains(f.filter.IgnoreTypes, resolved.Type))
+}
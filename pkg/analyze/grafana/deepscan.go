@@ -0,0 +1,137 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/metrics-usage/pkg/analyze/parser"
+	"github.com/perses/metrics-usage/pkg/analyze/prometheus"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// promQLLikeRegexp is matching the strings looking like a PromQL expression: an identifier followed by a selector,
+// a range or a function call, like up{job="api"}, node_load1[5m] or rate(..., or a selector on the metric name.
+// The plain words, like the values of the panel options, are not matching.
+var promQLLikeRegexp = regexp.MustCompile(`(?:^|[^\w$.])[a-zA-Z_:][\w:]*\s*[{\[(]|\{\s*__name__`)
+
+// DeepScan looks for PromQL expressions in the given JSON paths of every panel, like the options of the panel plugins storing a query.
+// A path is a list of keys separated by dots, like fieldConfig.defaults.custom. Every string under the path looking like PromQL is analyzed.
+// When such a string cannot be parsed, the metric names found by the fallback parser are considered partial,
+// as the string is likely not a PromQL expression. The panels using a datasource ignored by the filter are skipped.
+func DeepScan(dashboard *SimplifiedDashboard, paths []string, filter *config.DatasourceFilter) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics) {
+	expander := newVariableExpander(dashboard.Templating.List)
+	allVariableNames := collectAllVariableName(dashboard.Templating.List)
+	dsFilter := newDatasourceFilter(filter, dashboard.Templating.List)
+	splitPaths := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		splitPaths = append(splitPaths, strings.Split(path, "."))
+	}
+	result := modelAPIV1.Set[string]{}
+	partialMetricsResult := modelAPIV1.PartialMetrics{}
+	panels := dashboard.Panels
+	for _, r := range dashboard.Rows {
+		panels = append(panels, r.Panels...)
+	}
+	for _, expr := range collectDeepScanExpressions(panels, splitPaths, dsFilter) {
+		metrics, partialMetrics := analyzeDeepScanExpression(expr, expander, allVariableNames)
+		result.Merge(metrics)
+		partialMetricsResult.Merge(partialMetrics)
+	}
+	return result, partialMetricsResult
+}
+
+// collectDeepScanExpressions returns the strings looking like PromQL found under the paths of the panels and of their sub-panels.
+func collectDeepScanExpressions(panels []Panel, paths [][]string, dsFilter *datasourceFilter) []string {
+	var result []string
+	for _, p := range panels {
+		result = append(result, collectDeepScanExpressions(p.Panels, paths, dsFilter)...)
+		if len(p.raw) == 0 || dsFilter.ignore(p.Datasource) {
+			continue
+		}
+		var decoded interface{}
+		if err := json.Unmarshal(p.raw, &decoded); err != nil {
+			continue
+		}
+		for _, path := range paths {
+			for _, value := range lookupPath(decoded, path) {
+				result = append(result, collectPromQLLikeStrings(value)...)
+			}
+		}
+	}
+	return result
+}
+
+// lookupPath returns the values found under the path. When a list is met, the rest of the path is looked up in each element.
+func lookupPath(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+	switch v := value.(type) {
+	case []interface{}:
+		var result []interface{}
+		for _, item := range v {
+			result = append(result, lookupPath(item, path)...)
+		}
+		return result
+	case map[string]interface{}:
+		if child, ok := v[path[0]]; ok {
+			return lookupPath(child, path[1:])
+		}
+	}
+	return nil
+}
+
+func collectPromQLLikeStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if promQLLikeRegexp.MatchString(v) {
+			return []string{v}
+		}
+	case []interface{}:
+		var result []string
+		for _, item := range v {
+			result = append(result, collectPromQLLikeStrings(item)...)
+		}
+		return result
+	case map[string]interface{}:
+		var result []string
+		for _, item := range v {
+			result = append(result, collectPromQLLikeStrings(item)...)
+		}
+		return result
+	}
+	return nil
+}
+
+// analyzeDeepScanExpression is like analyzeExpression, except that every metric found by the fallback parser is considered partial.
+func analyzeDeepScanExpression(expr string, expander *variableExpander, allVariableNames modelAPIV1.Set[string]) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics) {
+	result := modelAPIV1.Set[string]{}
+	partialMetricsResult := modelAPIV1.PartialMetrics{}
+	for _, exprWithVariableReplaced := range expander.expand(expr) {
+		metrics, partialMetrics, err := prometheus.AnalyzeDashboardExpression(exprWithVariableReplaced)
+		if err == nil {
+			result.Merge(metrics)
+			partialMetricsResult.AddSet(partialMetrics, modelAPIV1.RegexReason)
+			continue
+		}
+		for m := range parser.ExtractMetricNameWithVariable(exprWithVariableReplaced) {
+			partialMetricsResult.Add(prometheus.NormalizePartialMetric(formatVariableInMetricName(m, allVariableNames)), parser.FallbackReason(m))
+		}
+	}
+	return result, partialMetricsResult
+}
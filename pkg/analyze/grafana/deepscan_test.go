@@ -0,0 +1,100 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/perses/metrics-usage/config"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const deepScanDashboard = `{
+  "uid": "deep",
+  "title": "Deep scan",
+  "templating": {
+    "list": [{"name": "job", "type": "custom", "current": {"value": "api"}, "options": [{"value": "api"}]}]
+  },
+  "panels": [
+    {
+      "type": "custom-promql-panel",
+      "title": "Plugin storing its query in the options",
+      "options": {
+        "legend": {"displayMode": "list", "placement": "bottom"},
+        "query": "sum by (job) (rate(http_requests_total{job=\"$job\"}[5m]))",
+        "thresholdsQuery": ["max(http_requests_limit)"]
+      },
+      "fieldConfig": {
+        "defaults": {"custom": {"drawStyle": "line", "baseline": "avg(node_load1)"}},
+        "overrides": [{"matcher": {"id": "byName", "options": "rate(errors)"}, "properties": [{"id": "custom.query", "value": "rate(errors_total{code=\"5xx\"}[5m]"}]}]
+      }
+    },
+    {
+      "type": "row",
+      "title": "Collapsed row",
+      "panels": [{"type": "custom-promql-panel", "options": {"query": "up{job=\"node\"}"}}]
+    },
+    {
+      "type": "loki-panel",
+      "datasource": {"type": "loki", "uid": "logs"},
+      "options": {"query": "count_over_time({app=\"api\"}[5m])"}
+    }
+  ]
+}`
+
+func TestDeepScan(t *testing.T) {
+	EnablePanelJSONRetention()
+	dashboard := &SimplifiedDashboard{}
+	require.NoError(t, json.Unmarshal([]byte(deepScanDashboard), dashboard))
+	testSuite := []struct {
+		title           string
+		paths           []string
+		filter          *config.DatasourceFilter
+		expectedMetrics []string
+		expectedPartial modelAPIV1.PartialMetrics
+	}{
+		{
+			title:           "options only",
+			paths:           []string{"options"},
+			filter:          &config.DatasourceFilter{IgnoreTypes: []string{"loki"}},
+			expectedMetrics: []string{"http_requests_limit", "http_requests_total", "up"},
+			expectedPartial: modelAPIV1.PartialMetrics{},
+		},
+		{
+			title:           "field config",
+			paths:           []string{"fieldConfig.defaults.custom", "fieldConfig.overrides.properties"},
+			filter:          &config.DatasourceFilter{IgnoreTypes: []string{"loki"}},
+			expectedMetrics: []string{"node_load1"},
+			expectedPartial: modelAPIV1.PartialMetrics{"errors_total": modelAPIV1.ParseFallbackReason},
+		},
+		{
+			title:           "unknown path",
+			paths:           []string{"options.unknown"},
+			expectedPartial: modelAPIV1.PartialMetrics{},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			metrics, partialMetrics := DeepScan(dashboard, test.paths, test.filter)
+			metricsAsSlice := metrics.TransformAsSlice()
+			slices.Sort(metricsAsSlice)
+			assert.Equal(t, test.expectedMetrics, metricsAsSlice)
+			assert.Equal(t, test.expectedPartial, partialMetrics)
+		})
+	}
+}
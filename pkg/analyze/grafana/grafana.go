@@ -18,11 +18,16 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/perses/metrics-usage/config"
 	"github.com/perses/metrics-usage/pkg/analyze/parser"
 	"github.com/perses/metrics-usage/pkg/analyze/prometheus"
 	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
 )
 
+// adhocFilterMatcher replaces the ad-hoc filter variables used in the queries.
+// The filters are arbitrary label matchers chosen at runtime, so a matcher that doesn't filter anything keeps the query parseable.
+const adhocFilterMatcher = `__adhoc_filters__=""`
+
 type variableTuple struct {
 	name  string
 	value string
@@ -31,11 +36,18 @@ type variableTuple struct {
 var (
 	labelValuesRegexp            = regexp.MustCompile(`(?s)label_values\((.+),.+\)`)
 	labelValuesNoQueryRegexp     = regexp.MustCompile(`(?s)label_values\((.+)\)`)
+	labelValuesLabelRegexp       = regexp.MustCompile(`(?s)label_values\((.+),\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*\)`)
+	labelNameRegexp              = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
 	queryResultRegexp            = regexp.MustCompile(`(?s)query_result\((.+)\)`)
 	metricsRegexp                = regexp.MustCompile(`(?s)metrics\((.+)\)`)
 	variableRangeQueryRangeRegex = regexp.MustCompile(`\[\$?\w+?]`)
 	variableSubqueryRangeRegex   = regexp.MustCompile(`\[\$?\w+:\$?\w+?]`)
-	globalVariableList           = []variableTuple{
+	// variableOffsetRegex matches an offset modifier using a variable, possibly negative, like offset -$shift.
+	variableOffsetRegex = regexp.MustCompile(`(?i)\boffset(\s+-?\s*)\$\{?\w+(?::\w+)*}?`)
+	// variableAtModifierRegex matches an @ modifier using a variable, like @ $__from or @ ${__to:date:iso}.
+	// The variable must be replaced by a Unix timestamp, as not every global variable is formatted as such.
+	variableAtModifierRegex = regexp.MustCompile(`@\s*\$\{?\w+(?::\w+)*}?`)
+	globalVariableList      = []variableTuple{
 		// Don't change the order.
 		// The order matters because, when replacing the variable with its value in the expression, if, for example,
 		// __interval is replaced before __interval_ms, then you might have partially replaced the variable.
@@ -46,10 +58,19 @@ var (
 			name:  "__interval_ms",
 			value: "1200000",
 		},
+		{
+			// $__searchFilter is replaced by the text typed to search the values of a query variable, as a regexp.
+			name:  "__searchFilter",
+			value: ".*",
+		},
 		{
 			name:  "__interval",
 			value: "20m",
 		},
+		{
+			name:  "__auto",
+			value: "20m",
+		},
 		{
 			name:  "interval",
 			value: "20m",
@@ -126,10 +147,40 @@ var (
 			name:  "__to",
 			value: "1594671549254",
 		},
+		{
+			name:  "__timeFilter",
+			value: "1594671549254",
+		},
+		{
+			name:  "__timezone",
+			value: "utc",
+		},
+		// Some global variables are objects and their properties can be accessed with the syntax ${__user.login}.
+		// These properties must be replaced before the variable itself.
+		{
+			name:  "__user.login",
+			value: "foo",
+		},
+		{
+			name:  "__user.email",
+			value: "foo@perses.dev",
+		},
+		{
+			name:  "__user.id",
+			value: "1",
+		},
 		{
 			name:  "__user",
 			value: "foo",
 		},
+		{
+			name:  "__org.name",
+			value: "perses",
+		},
+		{
+			name:  "__org.id",
+			value: "1",
+		},
 		{
 			name:  "__org",
 			value: "perses",
@@ -138,6 +189,10 @@ var (
 			name:  "__name",
 			value: "john",
 		},
+		{
+			name:  "__dashboard.uid",
+			value: "the_infamous_one",
+		},
 		{
 			name:  "__dashboard",
 			value: "the_infamous_one",
@@ -146,64 +201,108 @@ var (
 	variableReplacer = strings.NewReplacer(generateGrafanaTupleVariableSyntaxReplacer(globalVariableList)...)
 )
 
-func Analyze(dashboard *SimplifiedDashboard) (modelAPIV1.Set[string], modelAPIV1.Set[string], []*modelAPIV1.LogError) {
-	staticVariables := strings.NewReplacer(generateGrafanaVariableSyntaxReplacer(extractStaticVariables(dashboard.Templating.List))...)
+// Analyze returns the metrics and the partial metrics used by the dashboard.
+// The queries using a datasource ignored by the filter are skipped. The filter can be nil.
+func Analyze(dashboard *SimplifiedDashboard, filter *config.DatasourceFilter) (modelAPIV1.Set[string], modelAPIV1.Set[string], []*modelAPIV1.LogError) {
+	metrics, partialMetrics, errs := AnalyzeAndExplain(dashboard, filter)
+	return metrics, partialMetrics.Metrics(), errs
+}
+
+// AnalyzeAndExplain is like Analyze, but it also returns the reason why each partial metric has been classified as partial.
+func AnalyzeAndExplain(dashboard *SimplifiedDashboard, filter *config.DatasourceFilter) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, []*modelAPIV1.LogError) {
+	return analyze(dashboard, filter, nil)
+}
+
+// AnalyzeWithExpressions is like AnalyzeAndExplain, but it also returns the queries using each metric and partial metric.
+func AnalyzeWithExpressions(dashboard *SimplifiedDashboard, filter *config.DatasourceFilter) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, modelAPIV1.MetricExpressions, []*modelAPIV1.LogError) {
+	expressions := modelAPIV1.MetricExpressions{}
+	metrics, partialMetrics, errs := analyze(dashboard, filter, expressions)
+	return metrics, partialMetrics, expressions, errs
+}
+
+// analyze extracts the metrics from the panels and the variables of the dashboard.
+// The queries using each metric are recorded in expressions, unless it is nil.
+func analyze(dashboard *SimplifiedDashboard, filter *config.DatasourceFilter, expressions modelAPIV1.MetricExpressions) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, []*modelAPIV1.LogError) {
+	expander := newVariableExpander(dashboard.Templating.List)
 	allVariableNames := collectAllVariableName(dashboard.Templating.List)
-	m1, inv1, err1 := extractMetricsFromPanels(dashboard.Panels, staticVariables, allVariableNames, dashboard)
+	dsFilter := newDatasourceFilter(filter, dashboard.Templating.List)
+	m1, inv1, err1 := extractMetricsFromPanels(dashboard.Panels, expander, allVariableNames, dsFilter, dashboard, expressions)
 	for _, r := range dashboard.Rows {
-		m2, inv2, err2 := extractMetricsFromPanels(r.Panels, staticVariables, allVariableNames, dashboard)
+		m2, inv2, err2 := extractMetricsFromPanels(r.Panels, expander, allVariableNames, dsFilter, dashboard, expressions)
 		m1.Merge(m2)
 		inv1.Merge(inv2)
 		err1 = append(err1, err2...)
 	}
-	m3, inv3, err3 := extractMetricsFromVariables(dashboard.Templating.List, staticVariables, allVariableNames, dashboard)
+	m3, inv3, err3 := extractMetricsFromVariables(dashboard.Templating.List, expander, allVariableNames, dsFilter, dashboard, expressions)
 	m1.Merge(m3)
 	inv1.Merge(inv3)
 	return m1, inv1, append(err1, err3...)
 }
 
-func extractMetricsFromPanels(panels []Panel, staticVariables *strings.Replacer, allVariableNames modelAPIV1.Set[string], dashboard *SimplifiedDashboard) (modelAPIV1.Set[string], modelAPIV1.Set[string], []*modelAPIV1.LogError) {
+func extractMetricsFromPanels(panels []Panel, expander *variableExpander, allVariableNames modelAPIV1.Set[string], dsFilter *datasourceFilter, dashboard *SimplifiedDashboard, expressions modelAPIV1.MetricExpressions) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, []*modelAPIV1.LogError) {
 	var errs []*modelAPIV1.LogError
 	result := modelAPIV1.Set[string]{}
-	partialMetricsResult := modelAPIV1.Set[string]{}
+	partialMetricsResult := modelAPIV1.PartialMetrics{}
 	for _, p := range panels {
 		for _, t := range extractTarget(p) {
-			if len(t.Expr) == 0 {
+			if len(t.Expr) == 0 || dsFilter.ignore(t.Datasource) {
 				continue
 			}
-			exprWithVariableReplaced := replaceVariables(t.Expr, staticVariables)
-			metrics, partialMetrics, err := prometheus.AnalyzePromQLExpression(exprWithVariableReplaced)
+			metrics, partialMetrics, err := analyzeExpression(t.Expr, expander, allVariableNames)
 			if err != nil {
-				otherMetrics := parser.ExtractMetricNameWithVariable(exprWithVariableReplaced)
-				if len(otherMetrics) > 0 {
-					for m := range otherMetrics {
-						if prometheus.IsValidMetricName(m) {
-							result.Add(m)
-						} else {
-							partialMetricsResult.Add(formatVariableInMetricName(m, allVariableNames))
-						}
-					}
-				} else {
-					errs = append(errs, &modelAPIV1.LogError{
-						Error:   err,
-						Message: fmt.Sprintf("failed to extract metric names from PromQL expression in the panel %q for the dashboard %s/%s", p.Title, dashboard.Title, dashboard.UID),
-					})
-				}
-			} else {
-				result.Merge(metrics)
-				partialMetricsResult.Merge(partialMetrics)
+				errs = append(errs, &modelAPIV1.LogError{
+					Error:      err,
+					Message:    fmt.Sprintf("failed to extract metric names from PromQL expression in the panel %q for the dashboard %s/%s", p.Title, dashboard.Title, dashboard.UID),
+					Expression: t.Expr,
+				})
 			}
+			expressions.Add(t.Expr, metrics)
+			expressions.Add(t.Expr, partialMetrics.Metrics())
+			result.Merge(metrics)
+			partialMetricsResult.Merge(partialMetrics)
+		}
+		extraExpressions, extractErrs := extractPanelExpressions(p)
+		for _, extractErr := range extractErrs {
+			errs = append(errs, &modelAPIV1.LogError{
+				Error:   extractErr,
+				Message: fmt.Sprintf("failed to read the options of a panel for the dashboard %s/%s", dashboard.Title, dashboard.UID),
+			})
+		}
+		for _, extra := range extraExpressions {
+			if dsFilter.ignore(extra.panel.Datasource) {
+				continue
+			}
+			metrics, partialMetrics, err := analyzeExpression(extra.expr, expander, allVariableNames)
+			if err != nil {
+				errs = append(errs, &modelAPIV1.LogError{
+					Error:      err,
+					Message:    fmt.Sprintf("failed to extract metric names from PromQL expression in the options of the panel %q for the dashboard %s/%s", extra.panel.Title, dashboard.Title, dashboard.UID),
+					Expression: extra.expr,
+				})
+			}
+			expressions.Add(extra.expr, metrics)
+			expressions.Add(extra.expr, partialMetrics.Metrics())
+			result.Merge(metrics)
+			partialMetricsResult.Merge(partialMetrics)
+		}
+		for _, refID := range unresolvedReferences(p) {
+			// The expression is not a PromQL expression, and the missing query may just have been removed from the panel.
+			// So we just log it as a warning.
+			errs = append(errs, &modelAPIV1.LogError{
+				Warning: fmt.Errorf("no query found with the refId %q", refID),
+				Message: fmt.Sprintf("failed to resolve a query referenced by an expression in the panel %q for the dashboard %s/%s", p.Title, dashboard.Title, dashboard.UID),
+			})
 		}
 	}
 	return result, partialMetricsResult, errs
 }
 
-func extractMetricsFromVariables(variables []templateVar, staticVariables *strings.Replacer, allVariableNames modelAPIV1.Set[string], dashboard *SimplifiedDashboard) (modelAPIV1.Set[string], modelAPIV1.Set[string], []*modelAPIV1.LogError) {
+func extractMetricsFromVariables(variables []templateVar, expander *variableExpander, allVariableNames modelAPIV1.Set[string], dsFilter *datasourceFilter, dashboard *SimplifiedDashboard, expressions modelAPIV1.MetricExpressions) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, []*modelAPIV1.LogError) {
 	var errs []*modelAPIV1.LogError
 	result := modelAPIV1.Set[string]{}
-	partialMetricsResult := modelAPIV1.Set[string]{}
+	partialMetricsResult := modelAPIV1.PartialMetrics{}
 	for _, v := range variables {
-		if v.Type != "query" {
+		if v.Type != "query" || dsFilter.ignore(v.Datasource) {
 			continue
 		}
 		query, err := v.extractQueryFromVariableTemplating()
@@ -234,34 +333,103 @@ func extractMetricsFromVariables(variables []templateVar, staticVariables *strin
 			// metrics(.*partial_metric_name)
 		} else if metricsRegexp.MatchString(query) {
 			// for this particular use case, the query is a partial metric names so there is no need to use the PromQL parser.
-			query = metricsRegexp.FindStringSubmatch(query)[1]
-			partialMetricsResult.Add(formatVariableInMetricName(query, allVariableNames))
+			partialMetric := prometheus.NormalizePartialMetric(formatVariableInMetricName(metricsRegexp.FindStringSubmatch(query)[1], allVariableNames))
+			partialMetricsResult.Add(partialMetric, modelAPIV1.RegexReason)
+			expressions.Add(query, modelAPIV1.NewSet(partialMetric))
 			continue
 		}
-		exprWithVariableReplaced := replaceVariables(query, staticVariables)
-		metrics, partialMetrics, err := prometheus.AnalyzePromQLExpression(exprWithVariableReplaced)
+		metrics, partialMetrics, err := analyzeExpression(query, expander, allVariableNames)
 		if err != nil {
-			otherMetrics := parser.ExtractMetricNameWithVariable(exprWithVariableReplaced)
-			if len(otherMetrics) > 0 {
-				for m := range otherMetrics {
-					if prometheus.IsValidMetricName(m) {
-						result.Add(m)
-					} else {
-						partialMetricsResult.Add(formatVariableInMetricName(m, allVariableNames))
-					}
-				}
+			errs = append(errs, &modelAPIV1.LogError{
+				Error:      err,
+				Message:    fmt.Sprintf("failed to extract metric names from PromQL expression in variable %q for the dashboard %s/%s", v.Name, dashboard.Title, dashboard.UID),
+				Expression: query,
+			})
+		}
+		expressions.Add(query, metrics)
+		expressions.Add(query, partialMetrics.Metrics())
+		result.Merge(metrics)
+		partialMetricsResult.Merge(partialMetrics)
+	}
+	return result, partialMetricsResult, errs
+}
+
+// analyzeExpression extracts the metrics from every variant of the expression generated by the expander.
+// When a variant cannot be parsed, the metric names are extracted with a more permissive parser.
+// An error is returned only if no metric can be extracted from any variant.
+func analyzeExpression(expr string, expander *variableExpander, allVariableNames modelAPIV1.Set[string]) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, error) {
+	result := modelAPIV1.Set[string]{}
+	partialMetricsResult := modelAPIV1.PartialMetrics{}
+	var firstErr error
+	for _, exprWithVariableReplaced := range expander.expand(expr) {
+		metrics, partialMetrics, err := prometheus.AnalyzeDashboardExpression(exprWithVariableReplaced)
+		if err == nil {
+			result.Merge(metrics)
+			partialMetricsResult.AddSet(partialMetrics, modelAPIV1.RegexReason)
+			continue
+		}
+		otherMetrics := parser.ExtractMetricNameWithVariable(exprWithVariableReplaced)
+		if len(otherMetrics) == 0 && firstErr == nil {
+			firstErr = err
+		}
+		for m := range otherMetrics {
+			if prometheus.IsValidMetricName(m) {
+				result.Add(m)
 			} else {
-				errs = append(errs, &modelAPIV1.LogError{
-					Error:   err,
-					Message: fmt.Sprintf("failed to extract metric names from PromQL expression in variable %q for the dashboard %s/%s", v.Name, dashboard.Title, dashboard.UID),
-				})
+				partialMetricsResult.Add(prometheus.NormalizePartialMetric(formatVariableInMetricName(m, allVariableNames)), parser.FallbackReason(m))
 			}
-		} else {
-			result.Merge(metrics)
-			partialMetricsResult.Merge(partialMetrics)
 		}
 	}
-	return result, partialMetricsResult, errs
+	if len(result) == 0 && len(partialMetricsResult) == 0 {
+		return result, partialMetricsResult, firstErr
+	}
+	return result, partialMetricsResult, nil
+}
+
+// ExtractUsedLabels returns the labels used by the variables label_values of the dashboard.
+// With label_values(query, label), the label is used by the metrics of the query.
+// With label_values(label), the label is used without being tied to a metric.
+// The errors are not returned, as they are already reported by Analyze.
+func ExtractUsedLabels(dashboard *SimplifiedDashboard) *modelAPIV1.UsedLabels {
+	staticVariables := newStaticVariables(extractStaticVariables(dashboard.Templating.List))
+	byMetric := make(map[string]modelAPIV1.Set[string])
+	global := modelAPIV1.Set[string]{}
+	for _, v := range dashboard.Templating.List {
+		if v.Type != "query" {
+			continue
+		}
+		query, err := v.extractQueryFromVariableTemplating()
+		if err != nil {
+			continue
+		}
+		if sm := labelValuesLabelRegexp.FindStringSubmatch(query); len(sm) > 0 {
+			metrics, _, parserErr := prometheus.AnalyzePromQLExpression(replaceVariables(sm[1], staticVariables))
+			if parserErr != nil {
+				continue
+			}
+			for metric := range metrics {
+				if _, ok := byMetric[metric]; !ok {
+					byMetric[metric] = modelAPIV1.Set[string]{}
+				}
+				byMetric[metric].Add(sm[2])
+			}
+		} else if sm := labelValuesNoQueryRegexp.FindStringSubmatch(query); len(sm) > 0 {
+			label := strings.TrimSpace(sm[1])
+			if labelNameRegexp.MatchString(label) {
+				global.Add(label)
+			}
+		}
+	}
+	result := &modelAPIV1.UsedLabels{
+		ByMetric: make(map[string][]string, len(byMetric)),
+	}
+	if len(global) > 0 {
+		result.Global = global.TransformAsSlice()
+	}
+	for metric, labels := range byMetric {
+		result.ByMetric[metric] = labels.TransformAsSlice()
+	}
+	return result
 }
 
 func extractStaticVariables(variables []templateVar) map[string]string {
@@ -271,11 +439,15 @@ func extractStaticVariables(variables []templateVar) map[string]string {
 			// We don't want to look at the runtime query. We are using them to extract metrics instead.
 			continue
 		}
-		if len(v.Options) > 0 {
-			result[v.Name] = v.Options[0].Value
+		if v.Type == "adhoc" {
+			result[v.Name] = adhocFilterMatcher
+			continue
+		}
+		if value, ok := v.defaultValue(); ok {
+			result[v.Name] = value
 			if v.Type == "custom" {
 				// It seems the variable format <variable:value> ca be used for the "custom" variables.
-				result[fmt.Sprintf("%s:value", v.Name)] = v.Options[0].Value
+				result[fmt.Sprintf("%s:value", v.Name)] = value
 			}
 		}
 	}
@@ -290,11 +462,14 @@ func collectAllVariableName(variables []templateVar) modelAPIV1.Set[string] {
 	return result
 }
 
-func replaceVariables(expr string, staticVariables *strings.Replacer) string {
-	newExpr := staticVariables.Replace(expr)
+func replaceVariables(expr string, staticVariables *parser.VariableReplacer) string {
+	newExpr := variableAtModifierRegex.ReplaceAllLiteralString(expr, `@ 1594671549`)
+	newExpr = staticVariables.Replace(newExpr)
 	newExpr = variableReplacer.Replace(newExpr)
 	newExpr = variableRangeQueryRangeRegex.ReplaceAllLiteralString(newExpr, `[5m]`)
 	newExpr = variableSubqueryRangeRegex.ReplaceAllLiteralString(newExpr, `[5m:1m]`)
+	// The variables left in an offset are the ones without a known value, the sign of the offset is kept.
+	newExpr = variableOffsetRegex.ReplaceAllString(newExpr, `offset${1}5m`)
 	return newExpr
 }
 
@@ -307,14 +482,6 @@ func formatVariableInMetricName(metric string, variables modelAPIV1.Set[string])
 	return metric
 }
 
-func generateGrafanaVariableSyntaxReplacer(variables map[string]string) []string {
-	var result []string
-	for variable, value := range variables {
-		result = append(result, fmt.Sprintf("$%s", variable), value, fmt.Sprintf("${%s}", variable), value)
-	}
-	return result
-}
-
 func generateGrafanaTupleVariableSyntaxReplacer(variables []variableTuple) []string {
 	var result []string
 	for _, v := range variables {
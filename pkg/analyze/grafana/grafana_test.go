@@ -15,10 +15,12 @@ package grafana
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"slices"
 	"testing"
 
+	"github.com/perses/metrics-usage/config"
 	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
 	"github.com/stretchr/testify/assert"
 )
@@ -34,11 +36,12 @@ func unmarshalDashboard(path string) (*SimplifiedDashboard, error) {
 
 func TestAnalyze(t *testing.T) {
 	tests := []struct {
-		name           string
-		dashboardFile  string
-		resultMetrics  []string
-		invalidMetrics []string
-		resultErrs     []*modelAPIV1.LogError
+		name             string
+		dashboardFile    string
+		datasourceFilter *config.DatasourceFilter
+		resultMetrics    []string
+		invalidMetrics   []string
+		resultErrs       []*modelAPIV1.LogError
 	}{
 		{
 			name:          "from/to variables",
@@ -55,6 +58,49 @@ func TestAnalyze(t *testing.T) {
 			dashboardFile: "tests/d3.json",
 			resultMetrics: []string{"probe_success"},
 		},
+		{
+			name:          "global variables",
+			dashboardFile: "tests/d5.json",
+			resultMetrics: []string{
+				"grafana_org_dashboards",
+				"grafana_user_sessions",
+				"http_request_duration_seconds_count",
+				"http_requests_total",
+				"process_cpu_seconds_total",
+			},
+		},
+		{
+			name:          "grafana expressions",
+			dashboardFile: "tests/d6.json",
+			resultMetrics: []string{"http_requests_total", "node_load1"},
+			resultErrs: []*modelAPIV1.LogError{
+				{
+					Warning: fmt.Errorf("no query found with the refId %q", "Z"),
+					Message: "failed to resolve a query referenced by an expression in the panel \"Saturation\" for the dashboard Grafana expressions/expressions",
+				},
+			},
+		},
+		{
+			name:          "offset and @ modifiers using variables",
+			dashboardFile: "tests/d12.json",
+			resultMetrics: []string{
+				"http_requests_total",
+				"node_load1",
+				"node_network_receive_bytes_total",
+				"process_start_time_seconds",
+			},
+		},
+		{
+			name:          "collapsed rows",
+			dashboardFile: "tests/d7.json",
+			resultMetrics: []string{
+				"node_cpu_seconds_total",
+				"node_filesystem_avail_bytes",
+				"node_load1",
+				"node_memory_MemAvailable_bytes",
+				"node_memory_MemTotal_bytes",
+			},
+		},
 		{
 			name:          "variable in metrics",
 			dashboardFile: "tests/d4.json",
@@ -114,6 +160,33 @@ func TestAnalyze(t *testing.T) {
 				"otelcol_receiver_refused_spans${suffix}",
 			},
 		},
+		{
+			name:          "custom variable with multiple options",
+			dashboardFile: "tests/d10.json",
+			resultMetrics: []string{"http_errors_total", "http_requests_total"},
+		},
+		{
+			name:          "datasource variables without filter",
+			dashboardFile: "tests/d9.json",
+			resultMetrics: []string{"http_requests_total", "node_load1", "up"},
+		},
+		{
+			name:             "ignore a datasource type resolved from a variable",
+			dashboardFile:    "tests/d9.json",
+			datasourceFilter: &config.DatasourceFilter{IgnoreTypes: []string{"loki"}},
+			resultMetrics:    []string{"node_load1", "up"},
+		},
+		{
+			name:             "ignore a datasource UID resolved from a variable",
+			dashboardFile:    "tests/d9.json",
+			datasourceFilter: &config.DatasourceFilter{IgnoreUIDs: []string{"prom-staging"}},
+			resultMetrics:    []string{"http_requests_total", "up"},
+		},
+		{
+			name:          "ad-hoc filters and search filter",
+			dashboardFile: "tests/d11.json",
+			resultMetrics: []string{"http_requests_total", "node_boot_time_seconds", "node_load1", "up"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -121,7 +194,7 @@ func TestAnalyze(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			metrics, partialMetrics, errs := Analyze(dashboard)
+			metrics, partialMetrics, errs := Analyze(dashboard, tt.datasourceFilter)
 			metricsAsSlice := metrics.TransformAsSlice()
 			invalidMetricsAsSlice := partialMetrics.TransformAsSlice()
 			slices.Sort(metricsAsSlice)
@@ -132,3 +205,72 @@ func TestAnalyze(t *testing.T) {
 		})
 	}
 }
+
+func TestAnalyzeAndExplain(t *testing.T) {
+	dashboard, err := unmarshalDashboard("tests/d4.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, partialMetrics, _ := AnalyzeAndExplain(dashboard, nil)
+	assert.Equal(t, modelAPIV1.RegexReason, partialMetrics["otelcol_receiver_.+"])
+	assert.Equal(t, modelAPIV1.VariableReason, partialMetrics["otelcol_process_uptime${suffix}"])
+	for metric, reason := range partialMetrics {
+		assert.NotEmpty(t, reason, "missing reason for the partial metric %q", metric)
+	}
+}
+
+func TestAnalyzeWithExpressions(t *testing.T) {
+	dashboard, err := unmarshalDashboard("tests/d3.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, _, expressions, _ := AnalyzeWithExpressions(dashboard, nil)
+	assert.ElementsMatch(t, []string{"probe_success"}, metrics.TransformAsSlice())
+	assert.Len(t, expressions["probe_success"], 3)
+	for expr := range expressions["probe_success"] {
+		assert.Contains(t, expr, "probe_success")
+	}
+}
+
+func TestExtractUsedLabels(t *testing.T) {
+	tests := []struct {
+		name          string
+		dashboardFile string
+		result        *modelAPIV1.UsedLabels
+	}{
+		{
+			name:          "label_values with a query",
+			dashboardFile: "tests/d1.json",
+			result: &modelAPIV1.UsedLabels{
+				ByMetric: map[string][]string{
+					"run": {"rb_collection", "rb_env", "rb_region"},
+				},
+			},
+		},
+		{
+			name:          "label_values with and without a query",
+			dashboardFile: "tests/d8.json",
+			result: &modelAPIV1.UsedLabels{
+				ByMetric: map[string][]string{
+					"node_uname_info": {"nodename"},
+					"up":              {"instance"},
+				},
+				Global: []string{"job"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dashboard, err := unmarshalDashboard(tt.dashboardFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := ExtractUsedLabels(dashboard)
+			for _, labels := range result.ByMetric {
+				slices.Sort(labels)
+			}
+			slices.Sort(result.Global)
+			assert.Equal(t, tt.result, result)
+		})
+	}
+}
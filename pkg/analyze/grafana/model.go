@@ -13,17 +13,112 @@
 
 package grafana
 
-import "fmt"
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// allValue is the value of the option "All" of a variable.
+const allValue = "$__all"
+
+var (
+	expressionRefIDRegexp    = regexp.MustCompile(`\$\{?(\w+)}?`)
+	datasourceVariableRegexp = regexp.MustCompile(`^(?:\$\{?(\w+)}?|\[\[(\w+)]])$`)
+)
+
+// Datasource is the reference to the datasource used by a panel, a target or a variable.
+// The UID can be a variable, like ${datasource}, when the dashboard is using a datasource variable.
+type Datasource struct {
+	Type string `json:"type,omitempty"`
+	UID  string `json:"uid,omitempty"`
+}
+
+func (d *Datasource) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		// Legacy dashboards are referencing the datasource by its name (or by a variable) instead of an object.
+		d.UID = name
+		return nil
+	}
+	type plain Datasource
+	return json.Unmarshal(data, (*plain)(d))
+}
+
+// variable returns the name of the variable used as UID, if any.
+func (d *Datasource) variable() (string, bool) {
+	sm := datasourceVariableRegexp.FindStringSubmatch(d.UID)
+	if len(sm) == 0 {
+		return "", false
+	}
+	return sm[1] + sm[2], true
+}
 
 type Target struct {
-	Expr string `json:"expr,omitempty"`
+	RefID      string      `json:"refId,omitempty"`
+	Expr       string      `json:"expr,omitempty"`
+	Datasource *Datasource `json:"datasource,omitempty"`
+	// Type and Expression are set when the target is a Grafana expression (like math or reduce), using the datasource __expr__.
+	// Such an expression is referencing the result of other targets of the panel by their refId.
+	// Other datasources, like CloudWatch, can have a field expression as well, with a different meaning.
+	Type       string `json:"type,omitempty"`
+	Expression string `json:"expression,omitempty"`
+}
+
+// isExpression returns true when the target is a Grafana expression, evaluated by Grafana itself.
+func (t Target) isExpression() bool {
+	return t.Datasource != nil && (t.Datasource.Type == expressionDatasourceUID || t.Datasource.UID == expressionDatasourceUID)
+}
+
+// references returns the refId of the targets used by the Grafana expression.
+// A math expression is using the syntax $A or ${A}, while the other expressions are just containing the refId.
+func (t Target) references() []string {
+	if !t.isExpression() || len(t.Expression) == 0 {
+		return nil
+	}
+	if t.Type != "math" {
+		return []string{strings.TrimPrefix(strings.TrimSpace(t.Expression), "$")}
+	}
+	var result []string
+	for _, sm := range expressionRefIDRegexp.FindAllStringSubmatch(t.Expression, -1) {
+		result = append(result, sm[1])
+	}
+	return result
 }
 
 type Panel struct {
-	Type    string   `json:"type"`
-	Title   string   `json:"title"`
-	Panels  []Panel  `json:"panels"`
-	Targets []Target `json:"targets"`
+	Type       string      `json:"type"`
+	Title      string      `json:"title"`
+	Datasource *Datasource `json:"datasource,omitempty"`
+	Panels     []Panel     `json:"panels"`
+	Targets    []Target    `json:"targets"`
+	// raw is the whole JSON of the panel, used by the deep scan to look at the fields not decoded above.
+	// It is only kept when it is needed, see EnablePanelJSONRetention and RegisterPanelType.
+	raw json.RawMessage
+}
+
+// retainPanelJSON is true when the whole JSON of every panel must be kept once decoded.
+var retainPanelJSON bool
+
+// EnablePanelJSONRetention makes the decoded panels keep their whole JSON, as required by DeepScan and ScanTextPanels.
+// Otherwise, it is only kept for the panel types registered with RegisterPanelType.
+// It must be called before decoding any dashboard.
+func EnablePanelJSONRetention() {
+	retainPanelJSON = true
+}
+
+func (p *Panel) UnmarshalJSON(data []byte) error {
+	type plain Panel
+	if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+	if retainPanelJSON || isPanelTypeRegistered(p.Type) {
+		p.raw = slices.Clone(data)
+	}
+	return nil
 }
 
 type row struct {
@@ -35,10 +130,38 @@ type option struct {
 }
 
 type templateVar struct {
-	Name    string      `json:"name"`
-	Type    string      `json:"type"`
-	Query   interface{} `json:"query"`
-	Options []option    `json:"options"`
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	Query      interface{} `json:"query"`
+	Datasource *Datasource `json:"datasource,omitempty"`
+	Options    []option    `json:"options"`
+	Current    struct {
+		// Value is a list when the variable allows multiple values.
+		Value interface{} `json:"value"`
+	} `json:"current"`
+}
+
+// defaultValue returns the first option of the variable, or its current value when the options are not saved in the dashboard.
+// The option "All" is skipped when there are other options, as it cannot be replaced by a meaningful value.
+func (v templateVar) defaultValue() (string, bool) {
+	for _, o := range v.Options {
+		if o.Value != allValue {
+			return o.Value, true
+		}
+	}
+	if len(v.Options) > 0 {
+		return v.Options[0].Value, true
+	}
+	switch value := v.Current.Value.(type) {
+	case string:
+		return value, len(value) > 0
+	case []interface{}:
+		if len(value) > 0 {
+			s, ok := value[0].(string)
+			return s, ok
+		}
+	}
+	return "", false
 }
 
 // extractQueryFromVariableTemplating will extract the PromQL expression from query.
@@ -59,19 +182,68 @@ func (v templateVar) extractQueryFromVariableTemplating() (string, error) {
 }
 
 type SimplifiedDashboard struct {
-	UID        string  `json:"uid,omitempty"`
-	Title      string  `json:"title"`
-	Panels     []Panel `json:"panels"`
-	Rows       []row   `json:"rows"`
+	UID     string `json:"uid,omitempty"`
+	Title   string `json:"title"`
+	Version int64  `json:"version,omitempty"`
+	// Updated is the last time the dashboard has been updated. It is not part of the dashboard model, the collector sets it from the metadata returned by Grafana.
+	Updated    time.Time `json:"-"`
+	Panels     []Panel   `json:"panels"`
+	Rows       []row     `json:"rows"`
 	Templating struct {
 		List []templateVar `json:"list"`
 	} `json:"templating"`
 }
 
+// extractTarget returns the targets of the panel and of its sub-panels.
+// It covers the collapsed rows, which are panels of type "row" holding their child panels.
+// A target without datasource is inheriting the one of its panel.
 func extractTarget(panel Panel) []Target {
 	var targets []Target
 	for _, p := range panel.Panels {
 		targets = append(targets, extractTarget(p)...)
 	}
-	return append(targets, panel.Targets...)
+	for _, t := range panel.Targets {
+		if t.Datasource == nil {
+			t.Datasource = panel.Datasource
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// unresolvedReferences returns, for the panel and its sub-panels, the refId referenced by a Grafana expression
+// that doesn't match any target of the panel. The chain of expressions is followed until reaching a PromQL query.
+func unresolvedReferences(panel Panel) []string {
+	var result []string
+	for _, p := range panel.Panels {
+		result = append(result, unresolvedReferences(p)...)
+	}
+	targets := make(map[string]Target, len(panel.Targets))
+	for _, t := range panel.Targets {
+		if len(t.RefID) > 0 {
+			targets[t.RefID] = t
+		}
+	}
+	visited := make(map[string]bool)
+	var resolve func(refID string)
+	resolve = func(refID string) {
+		if visited[refID] {
+			return
+		}
+		visited[refID] = true
+		t, ok := targets[refID]
+		if !ok {
+			result = append(result, refID)
+			return
+		}
+		for _, ref := range t.references() {
+			resolve(ref)
+		}
+	}
+	for _, t := range panel.Targets {
+		for _, ref := range t.references() {
+			resolve(ref)
+		}
+	}
+	return result
 }
@@ -0,0 +1,132 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// PanelExtractor returns the PromQL expressions stored by a panel outside its targets, like in the options of its plugin.
+// It receives the whole JSON of the panel.
+type PanelExtractor func(panel json.RawMessage) ([]string, error)
+
+var (
+	// panelExtractors is the registry of the extractors of the extra expressions, indexed by the panel type.
+	// It is empty by default: the panels not registered are only analyzed through their targets.
+	// It is protected by panelExtractorsMutex, as the collectors and the API can analyze dashboards concurrently.
+	panelExtractors      = map[string]PanelExtractor{}
+	panelExtractorsMutex sync.RWMutex
+)
+
+// RegisterPanelType makes the analyzer look for extra expressions in the panels of the given type, like a table storing a query in its transformations.
+// It must be called before decoding any dashboard, and it replaces the extractor of a type already registered.
+func RegisterPanelType(panelType string, extractor PanelExtractor) {
+	panelExtractorsMutex.Lock()
+	defer panelExtractorsMutex.Unlock()
+	panelExtractors[panelType] = extractor
+}
+
+func isPanelTypeRegistered(panelType string) bool {
+	_, ok := getPanelExtractor(panelType)
+	return ok
+}
+
+func getPanelExtractor(panelType string) (PanelExtractor, bool) {
+	panelExtractorsMutex.RLock()
+	defer panelExtractorsMutex.RUnlock()
+	extractor, ok := panelExtractors[panelType]
+	return extractor, ok
+}
+
+// PathExtractor returns a PanelExtractor reading the expressions under the given JSON paths of the panel, like options.reduceOptions.expr.
+// A path is a list of keys separated by dots. When a list is met, the rest of the path is looked up in each element.
+// The path can lead to a string or to a list of strings.
+func PathExtractor(paths ...string) PanelExtractor {
+	splitPaths := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		splitPaths = append(splitPaths, strings.Split(path, "."))
+	}
+	return func(panel json.RawMessage) ([]string, error) {
+		var decoded interface{}
+		if err := json.Unmarshal(panel, &decoded); err != nil {
+			return nil, err
+		}
+		var result []string
+		for i, path := range splitPaths {
+			for _, value := range lookupPath(decoded, path) {
+				exprs, err := stringValues(value)
+				if err != nil {
+					return nil, fmt.Errorf("the value under the path %q of the panel %w", paths[i], err)
+				}
+				result = append(result, exprs...)
+			}
+		}
+		return result, nil
+	}
+}
+
+func stringValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if len(v) == 0 {
+			return nil, nil
+		}
+		return []string{v}, nil
+	case []interface{}:
+		var result []string
+		for _, item := range v {
+			exprs, err := stringValues(item)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, exprs...)
+		}
+		return result, nil
+	}
+	return nil, fmt.Errorf("is not a string but a %T", value)
+}
+
+// panelExpression is an extra expression of a panel, with the panel it has been found in.
+type panelExpression struct {
+	panel Panel
+	expr  string
+}
+
+// extractPanelExpressions returns the extra expressions of the panel and of its sub-panels, found by the extractor registered for their type.
+func extractPanelExpressions(panel Panel) ([]panelExpression, []error) {
+	var result []panelExpression
+	var errs []error
+	for _, p := range panel.Panels {
+		exprs, subErrs := extractPanelExpressions(p)
+		result = append(result, exprs...)
+		errs = append(errs, subErrs...)
+	}
+	extractor, ok := getPanelExtractor(panel.Type)
+	if !ok || len(panel.raw) == 0 {
+		return result, errs
+	}
+	exprs, err := extractor(panel.raw)
+	if err != nil {
+		return result, append(errs, fmt.Errorf("unable to extract the expressions of the panel %q of type %q: %w", panel.Title, panel.Type, err))
+	}
+	for _, expr := range exprs {
+		result = append(result, panelExpression{panel: panel, expr: expr})
+	}
+	return result, errs
+}
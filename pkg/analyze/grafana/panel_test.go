@@ -0,0 +1,98 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const statPanelDashboard = `{
+  "uid": "stat",
+  "title": "Stats",
+  "panels": [
+    {
+      "type": "stat",
+      "title": "Load",
+      "targets": [{"refId": "A", "expr": "node_load1"}],
+      "options": {"reduceOptions": {"expr": "max(node_load5)"}}
+    },
+    {
+      "type": "row",
+      "title": "Tables",
+      "panels": [
+        {
+          "type": "table",
+          "title": "Errors",
+          "transformations": [{"id": "query", "options": {"queries": ["sum(http_errors_total)", "sum(http_requests_total)"]}}, {"id": "organize"}]
+        }
+      ]
+    },
+    {
+      "type": "gauge",
+      "title": "Broken",
+      "options": {"reduceOptions": {"expr": 42}}
+    }
+  ]
+}`
+
+func TestRegisterPanelType(t *testing.T) {
+	dashboard := &SimplifiedDashboard{}
+	require.NoError(t, json.Unmarshal([]byte(statPanelDashboard), dashboard))
+
+	// By default, only the targets are analyzed.
+	metrics, _, errs := Analyze(dashboard, nil)
+	assert.Empty(t, errs)
+	assert.ElementsMatch(t, []string{"node_load1"}, metrics.TransformAsSlice())
+
+	RegisterPanelType("stat", PathExtractor("options.reduceOptions.expr"))
+	RegisterPanelType("gauge", PathExtractor("options.reduceOptions.expr"))
+	RegisterPanelType("table", PathExtractor("transformations.options.queries"))
+	t.Cleanup(func() {
+		panelExtractorsMutex.Lock()
+		defer panelExtractorsMutex.Unlock()
+		panelExtractors = map[string]PanelExtractor{}
+	})
+	// The JSON of the panels is kept when decoding the dashboard, once their type is registered.
+	dashboard = &SimplifiedDashboard{}
+	require.NoError(t, json.Unmarshal([]byte(statPanelDashboard), dashboard))
+	metrics, _, expressions, errs := AnalyzeWithExpressions(dashboard, nil)
+	assert.ElementsMatch(t, []string{"node_load1", "node_load5", "http_errors_total", "http_requests_total"}, metrics.TransformAsSlice())
+	assert.ElementsMatch(t, []string{"max(node_load5)"}, expressions["node_load5"].TransformAsSlice())
+	require.Len(t, errs, 1)
+	assert.EqualError(t, errs[0].Error, `unable to extract the expressions of the panel "Broken" of type "gauge": the value under the path "options.reduceOptions.expr" of the panel is not a string but a float64`)
+}
+
+func TestPanelJSONRetention(t *testing.T) {
+	previous := retainPanelJSON
+	retainPanelJSON = false
+	t.Cleanup(func() {
+		retainPanelJSON = previous
+		panelExtractorsMutex.Lock()
+		defer panelExtractorsMutex.Unlock()
+		panelExtractors = map[string]PanelExtractor{}
+	})
+	RegisterPanelType("stat", PathExtractor("options.reduceOptions.expr"))
+	panels := []Panel{}
+	require.NoError(t, json.Unmarshal([]byte(`[{"type": "stat", "title": "Load"}, {"type": "timeseries", "title": "Requests"}]`), &panels))
+	assert.NotEmpty(t, panels[0].raw)
+	assert.Empty(t, panels[1].raw)
+
+	EnablePanelJSONRetention()
+	require.NoError(t, json.Unmarshal([]byte(`[{"type": "timeseries", "title": "Requests"}]`), &panels))
+	assert.NotEmpty(t, panels[0].raw)
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"encoding/json"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// textPanelType is the type of the panels displaying a markdown or an HTML content.
+const textPanelType = "text"
+
+var (
+	// linkQueryRegexp is matching the query string of the links, like ?left=...&orgId=1 in /explore?left=...&orgId=1.
+	// It stops at the characters ending a link in markdown or in HTML.
+	linkQueryRegexp = regexp.MustCompile(`\?[^\s"'<>()]+`)
+	// jsonExprRegexp is matching the field expr of the queries encoded in JSON, like in the Grafana Explore links.
+	jsonExprRegexp = regexp.MustCompile(`"expr"\s*:\s*("(?:[^"\\]|\\.)*")`)
+)
+
+// ScanTextPanels looks for PromQL expressions in the links of the text panels, like the links to Grafana Explore
+// or to the Prometheus UI documenting a metric. The expressions are taken from the query parameters expr (or g0.expr, ...),
+// and from the field expr of the queries encoded in JSON in the other query parameters.
+// As the links are written by hand, the metrics found by the fallback parser are considered partial.
+func ScanTextPanels(dashboard *SimplifiedDashboard) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics) {
+	expander := newVariableExpander(dashboard.Templating.List)
+	allVariableNames := collectAllVariableName(dashboard.Templating.List)
+	result := modelAPIV1.Set[string]{}
+	partialMetricsResult := modelAPIV1.PartialMetrics{}
+	panels := dashboard.Panels
+	for _, r := range dashboard.Rows {
+		panels = append(panels, r.Panels...)
+	}
+	for _, expr := range collectTextPanelExpressions(panels) {
+		metrics, partialMetrics := analyzeDeepScanExpression(expr, expander, allVariableNames)
+		result.Merge(metrics)
+		partialMetricsResult.Merge(partialMetrics)
+	}
+	return result, partialMetricsResult
+}
+
+// collectTextPanelExpressions returns the expressions found in the links of the text panels and of their sub-panels.
+func collectTextPanelExpressions(panels []Panel) []string {
+	var result []string
+	for _, p := range panels {
+		result = append(result, collectTextPanelExpressions(p.Panels)...)
+		if p.Type != textPanelType || len(p.raw) == 0 {
+			continue
+		}
+		var decoded struct {
+			// Content is where the legacy text panels store their content.
+			Content string `json:"content"`
+			Options struct {
+				Content string `json:"content"`
+			} `json:"options"`
+		}
+		if err := json.Unmarshal(p.raw, &decoded); err != nil {
+			continue
+		}
+		result = append(result, extractLinkExpressions(decoded.Options.Content)...)
+		result = append(result, extractLinkExpressions(decoded.Content)...)
+	}
+	return result
+}
+
+// extractLinkExpressions returns the expressions found in the query strings of the links contained in the markdown or HTML content.
+func extractLinkExpressions(content string) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	var result []string
+	// In HTML, the & separating the query parameters are usually escaped.
+	for _, query := range linkQueryRegexp.FindAllString(html.UnescapeString(content), -1) {
+		// The parameters that cannot be decoded are skipped, the other ones are still returned.
+		values, _ := url.ParseQuery(query[1:])
+		for key, list := range values {
+			for _, value := range list {
+				if key == "expr" || strings.HasSuffix(key, ".expr") {
+					if len(value) > 0 {
+						result = append(result, value)
+					}
+					continue
+				}
+				for _, match := range jsonExprRegexp.FindAllStringSubmatch(value, -1) {
+					var expr string
+					if err := json.Unmarshal([]byte(match[1]), &expr); err == nil {
+						result = append(result, expr)
+					}
+				}
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const textPanelDashboard = `{
+  "uid": "doc",
+  "title": "Documentation",
+  "templating": {
+    "list": [{"name": "job", "type": "custom", "current": {"value": "api"}, "options": [{"value": "api"}]}]
+  },
+  "panels": [
+    {
+      "type": "text",
+      "title": "Markdown",
+      "options": {
+        "mode": "markdown",
+        "content": "See [the errors](/explore?orgId=1&left=%7B%22queries%22%3A%5B%7B%22refId%22%3A%22A%22%2C%22expr%22%3A%22rate%28http_errors_total%7Bjob%3D%5C%22%24job%5C%22%7D%5B5m%5D%29%22%7D%5D%7D) and [the load](http://prometheus:9090/graph?g0.expr=node_load1&g0.tab=0)."
+      }
+    },
+    {
+      "type": "text",
+      "title": "HTML",
+      "options": {
+        "mode": "html",
+        "content": "<a href=\"http://prometheus:9090/graph?g0.range_input=1h&amp;g0.expr=sum%20by%20%28job%29%20%28up%29\">up</a>"
+      }
+    },
+    {
+      "type": "row",
+      "title": "Collapsed row",
+      "panels": [{"type": "text", "options": {"content": "[partial](/explore?expr=%7B__name__%3D~%22node_.%2A%22%7D) [broken](/explore?expr=sum%28process_cpu_seconds_total%7Bjob%3D%22api%22%7D)"}}]
+    },
+    {
+      "type": "timeseries",
+      "title": "Not a text panel",
+      "options": {"content": "[ignored](/explore?expr=ignored_metric)"}
+    }
+  ]
+}`
+
+func TestScanTextPanels(t *testing.T) {
+	EnablePanelJSONRetention()
+	dashboard := &SimplifiedDashboard{}
+	require.NoError(t, json.Unmarshal([]byte(textPanelDashboard), dashboard))
+	metrics, partialMetrics := ScanTextPanels(dashboard)
+	assert.ElementsMatch(t, []string{"http_errors_total", "node_load1", "up"}, metrics.TransformAsSlice())
+	assert.ElementsMatch(t, []string{"node_.*", "process_cpu_seconds_total"}, partialMetrics.Metrics().TransformAsSlice())
+}
+
+func TestExtractLinkExpressions(t *testing.T) {
+	assert.Nil(t, extractLinkExpressions(""))
+	assert.Nil(t, extractLinkExpressions("No link here, only rate(foo[5m])"))
+	assert.Equal(t, []string{"up"}, extractLinkExpressions("[up](/explore?expr=up&expr=)"))
+	assert.Equal(t, []string{"sum(up)"}, extractLinkExpressions("[up](/explore?panes=%7B%22a%22%3A%7B%22queries%22%3A%5B%7B%22expr%22%3A%22sum%28up%29%22%7D%5D%7D%7D)"))
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/perses/metrics-usage/pkg/analyze/parser"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// maxExpressionVariants is the maximum number of variants of an expression analyzed, to bound the cost of the dashboards having variables with a lot of options.
+const maxExpressionVariants = 50
+
+// newStaticVariables returns the replacer of the static variables of an expression by their value.
+func newStaticVariables(values map[string]string) *parser.VariableReplacer {
+	return parser.NewVariableReplacer(values, func(name string) []string {
+		return []string{fmt.Sprintf("$%s", name), fmt.Sprintf("${%s}", name)}
+	})
+}
+
+// variableExpander generates the variants of an expression to analyze.
+// The first variant uses the default value of every static variable.
+// Then, as the metrics used can depend on the value, there is one more variant for each other option of the custom variables used by the expression.
+type variableExpander struct {
+	values   map[string]string
+	defaults *parser.VariableReplacer
+	options  map[string][]string
+}
+
+func newVariableExpander(variables []templateVar) *variableExpander {
+	values := extractStaticVariables(variables)
+	return &variableExpander{
+		values:   values,
+		defaults: newStaticVariables(values),
+		options:  extractOtherOptions(variables, values),
+	}
+}
+
+// extractOtherOptions returns, for each custom variable, the values of its options other than the default one.
+func extractOtherOptions(variables []templateVar, defaultValues map[string]string) map[string][]string {
+	result := make(map[string][]string)
+	for _, v := range variables {
+		if v.Type != "custom" || len(v.Options) < 2 {
+			continue
+		}
+		seen := modelAPIV1.NewSet(defaultValues[v.Name], allValue)
+		for _, o := range v.Options {
+			if seen.Contains(o.Value) {
+				continue
+			}
+			seen.Add(o.Value)
+			result[v.Name] = append(result[v.Name], o.Value)
+		}
+	}
+	return result
+}
+
+func (e *variableExpander) expand(expr string) []string {
+	result := []string{replaceVariables(expr, e.defaults)}
+	for _, name := range slices.Sorted(maps.Keys(e.options)) {
+		if !strings.Contains(expr, fmt.Sprintf("$%s", name)) && !strings.Contains(expr, fmt.Sprintf("${%s", name)) {
+			continue
+		}
+		for _, value := range e.options[name] {
+			if len(result) >= maxExpressionVariants {
+				return result
+			}
+			values := maps.Clone(e.values)
+			values[name] = value
+			values[fmt.Sprintf("%s:value", name)] = value
+			variant := replaceVariables(expr, newStaticVariables(values))
+			if !slices.Contains(result, variant) {
+				result = append(result, variant)
+			}
+		}
+	}
+	return result
+}
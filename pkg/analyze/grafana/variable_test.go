@@ -0,0 +1,54 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grafana
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticVariablesReplace(t *testing.T) {
+	staticVariables := newStaticVariables(map[string]string{
+		"host":   `.*\.example\.com`,
+		"filter": `job="node"`,
+		"quote":  `say "hello"`,
+	})
+	tests := []struct {
+		name   string
+		expr   string
+		result string
+	}{
+		{
+			name:   "value escaped in a string",
+			expr:   `up{host=~"$host"}`,
+			result: `up{host=~".*\\.example\\.com"}`,
+		},
+		{
+			name:   "value not escaped outside a string",
+			expr:   `up{$filter}`,
+			result: `up{job="node"}`,
+		},
+		{
+			name:   "escaped quote in the string",
+			expr:   `up{a="\"${quote}", b="$quote"}`,
+			result: `up{a="\"say \"hello\"", b="say \"hello\""}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.result, staticVariables.Replace(tt.expr))
+		})
+	}
+}
@@ -13,7 +13,29 @@
 
 package parser
 
-import modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+import (
+	"strings"
+
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// FallbackReason returns why a metric name extracted by ExtractMetricNameWithVariable is partial.
+// It is either because the name contains a variable, or just because the expression couldn't be parsed by the PromQL parser.
+func FallbackReason(metric string) modelAPIV1.PartialMetricReason {
+	if strings.Contains(metric, "$") {
+		return modelAPIV1.VariableReason
+	}
+	return modelAPIV1.ParseFallbackReason
+}
+
+// utf8MetricNames is true when the dotted metric names and the metric names quoted in the braces, like {"http.server.duration"}, are recognized.
+var utf8MetricNames bool
+
+// EnableUTF8MetricNames makes the parser recognize the metric names allowed by Prometheus 3.0, like the OpenTelemetry dotted names.
+// It must be called before any expression is parsed.
+func EnableUTF8MetricNames() {
+	utf8MetricNames = true
+}
 
 func ExtractMetricNameWithVariable(expr string) modelAPIV1.Set[string] {
 	p := &parser{
@@ -25,6 +47,8 @@ func ExtractMetricNameWithVariable(expr string) modelAPIV1.Set[string] {
 type parser struct {
 	metrics       modelAPIV1.Set[string]
 	currentMetric string
+	// afterBrace is true when the last character read, apart from the whitespaces, is an opening brace.
+	afterBrace bool
 }
 
 func (p *parser) parse(expr string) modelAPIV1.Set[string] {
@@ -35,6 +59,18 @@ func (p *parser) parse(expr string) modelAPIV1.Set[string] {
 			// Whitespace
 			continue
 		}
+		afterBrace := p.afterBrace
+		p.afterBrace = char == '{'
+		if utf8MetricNames && char == '"' && afterBrace {
+			// In UTF-8 mode, the first string in the braces can be the metric name, like {"http.server.duration", job="api"}.
+			var name string
+			name, i = readQuotedString(query, i)
+			if !isFollowedByMatcher(query, i+1) {
+				p.metrics.Add(name)
+			}
+			p.currentMetric = ""
+			continue
+		}
 		if isValidMetricChar(char) {
 			p.currentMetric += string(char)
 			continue
@@ -80,7 +116,36 @@ func isWhitespace(ch rune) bool {
 	return ch == ' ' || ch == '\t' || ch == '\n'
 }
 
+// readQuotedString returns the content of the string starting with the quote at the given index, and the index of the closing quote.
+// The variables are kept as they are, like in the metric names outside quotes.
+func readQuotedString(query []rune, start int) (string, int) {
+	var result strings.Builder
+	i := start + 1
+	for ; i < len(query) && query[i] != '"'; i++ {
+		if query[i] == '\\' && i+1 < len(query) {
+			i++
+		}
+		result.WriteRune(query[i])
+	}
+	return result.String(), i
+}
+
+// isFollowedByMatcher returns true if the first character from the given index, apart from the whitespaces, starts a label matcher operator.
+// It means the string read before is a quoted label name, like in {"service.name"="api"}, and not a metric name.
+func isFollowedByMatcher(query []rune, from int) bool {
+	for i := from; i < len(query); i++ {
+		if isWhitespace(query[i]) {
+			continue
+		}
+		return query[i] == '=' || query[i] == '!'
+	}
+	return false
+}
+
 func isValidMetricChar(ch rune) bool {
+	if utf8MetricNames && ch == '.' {
+		return true
+	}
 	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '_' || ch == ':'
 }
 
@@ -56,3 +56,39 @@ func TestExtractMetricNameWithVariable(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractMetricNameWithVariableUTF8(t *testing.T) {
+	EnableUTF8MetricNames()
+	t.Cleanup(func() {
+		utf8MetricNames = false
+	})
+	tests := []struct {
+		title  string
+		expr   string
+		result []string
+	}{
+		{
+			title:  "quoted metric with a variable",
+			expr:   "sum(rate({\"http.server.request.duration${suffix}\", \"service.name\"=~\"$service\"}[$__rate_interval]))",
+			result: []string{"http.server.request.duration${suffix}"},
+		},
+		{
+			title:  "quoted label only",
+			expr:   "sum by (${grouping}) ({ \"service.name\" = \"$service\"})",
+			result: nil,
+		},
+		{
+			title:  "dotted metric with a variable",
+			expr:   "sum(${metric:value}(otelcol.processor.${name}.batch_size{processor=~\"$processor\"}[$__rate_interval]))",
+			result: []string{"otelcol.processor.${name}.batch_size"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			result := ExtractMetricNameWithVariable(test.expr)
+			r := result.TransformAsSlice()
+			slices.Sort(r)
+			assert.Equal(t, test.result, r)
+		})
+	}
+}
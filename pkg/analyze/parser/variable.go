@@ -0,0 +1,75 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import "strings"
+
+// quotedValueEscaper escapes a value to be used inside a PromQL double-quoted string.
+var quotedValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// VariableReplacer replaces the variables of an expression by their value.
+// Inside a double-quoted string, like a label matcher, the value is escaped so the expression remains valid PromQL.
+type VariableReplacer struct {
+	plain  *strings.Replacer
+	quoted *strings.Replacer
+}
+
+// NewVariableReplacer returns a VariableReplacer for the given values, indexed by the variable name.
+// references returns the different syntaxes used to reference a variable in an expression, like $name or ${name}.
+func NewVariableReplacer(values map[string]string, references func(name string) []string) *VariableReplacer {
+	var plain, quoted []string
+	for name, value := range values {
+		escapedValue := quotedValueEscaper.Replace(value)
+		for _, reference := range references(name) {
+			plain = append(plain, reference, value)
+			quoted = append(quoted, reference, escapedValue)
+		}
+	}
+	return &VariableReplacer{
+		plain:  strings.NewReplacer(plain...),
+		quoted: strings.NewReplacer(quoted...),
+	}
+}
+
+func (r *VariableReplacer) Replace(expr string) string {
+	var sb strings.Builder
+	inString := false
+	start := 0
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '\\':
+			if inString {
+				// Skip the escaped character, it cannot close the string.
+				i++
+			}
+		case '"':
+			if inString {
+				sb.WriteString(r.quoted.Replace(expr[start:i]))
+			} else {
+				sb.WriteString(r.plain.Replace(expr[start:i]))
+			}
+			sb.WriteByte('"')
+			inString = !inString
+			start = i + 1
+		}
+	}
+	if start < len(expr) {
+		if inString {
+			sb.WriteString(r.quoted.Replace(expr[start:]))
+		} else {
+			sb.WriteString(r.plain.Replace(expr[start:]))
+		}
+	}
+	return sb.String()
+}
@@ -14,17 +14,13 @@
 package perses
 
 import (
-	"encoding/json"
 	"fmt"
 	"strings"
 
 	"github.com/perses/metrics-usage/pkg/analyze/parser"
 	"github.com/perses/metrics-usage/pkg/analyze/prometheus"
 	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
-	"github.com/perses/perses/go-sdk/prometheus/query"
-	"github.com/perses/perses/go-sdk/prometheus/variable/promql"
 	v1 "github.com/perses/perses/pkg/model/api/v1"
-	"github.com/perses/perses/pkg/model/api/v1/common"
 	"github.com/perses/perses/pkg/model/api/v1/dashboard"
 	"github.com/perses/perses/pkg/model/api/v1/variable"
 )
@@ -41,54 +37,62 @@ var variableReplacer = strings.NewReplacer(
 )
 
 func Analyze(dashboard *v1.Dashboard) (modelAPIV1.Set[string], modelAPIV1.Set[string], []*modelAPIV1.LogError) {
-	m1, inv1, err1 := extractMetricUsageFromVariables(dashboard.Spec.Variables, dashboard)
-	m2, inv2, err2 := extractMetricUsageFromPanels(dashboard.Spec.Panels, dashboard)
+	metrics, partialMetrics, errs := AnalyzeAndExplain(dashboard)
+	return metrics, partialMetrics.Metrics(), errs
+}
+
+// AnalyzeAndExplain is like Analyze, but it also returns the reason why each partial metric has been classified as partial.
+func AnalyzeAndExplain(dashboard *v1.Dashboard) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, []*modelAPIV1.LogError) {
+	return analyze(dashboard, nil)
+}
+
+// AnalyzeWithExpressions is like AnalyzeAndExplain, but it also returns the queries using each metric and partial metric.
+func AnalyzeWithExpressions(dashboard *v1.Dashboard) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, modelAPIV1.MetricExpressions, []*modelAPIV1.LogError) {
+	expressions := modelAPIV1.MetricExpressions{}
+	metrics, partialMetrics, errs := analyze(dashboard, expressions)
+	return metrics, partialMetrics, expressions, errs
+}
+
+// analyze extracts the metrics from the variables and the panels of the dashboard.
+// The queries using each metric are recorded in expressions, unless it is nil.
+func analyze(dashboard *v1.Dashboard, expressions modelAPIV1.MetricExpressions) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, []*modelAPIV1.LogError) {
+	staticVariables := newStaticVariables(extractStaticVariables(dashboard.Spec.Variables))
+	m1, inv1, err1 := extractMetricUsageFromVariables(dashboard.Spec.Variables, dashboard, staticVariables, expressions)
+	m2, inv2, err2 := extractMetricUsageFromPanels(dashboard.Spec.Panels, dashboard, staticVariables, expressions)
 	m1.Merge(m2)
 	inv1.Merge(inv2)
 	return m1, inv1, append(err1, err2...)
 }
 
-func extractMetricUsageFromPanels(panels map[string]*v1.Panel, currentDashboard *v1.Dashboard) (modelAPIV1.Set[string], modelAPIV1.Set[string], []*modelAPIV1.LogError) {
+func extractMetricUsageFromPanels(panels map[string]*v1.Panel, currentDashboard *v1.Dashboard, staticVariables *parser.VariableReplacer, expressions modelAPIV1.MetricExpressions) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, []*modelAPIV1.LogError) {
 	var errs []*modelAPIV1.LogError
 	result := modelAPIV1.Set[string]{}
-	partialMetricsResult := modelAPIV1.Set[string]{}
+	partialMetricsResult := modelAPIV1.PartialMetrics{}
 	for panelName, panel := range panels {
 		for i, q := range panel.Spec.Queries {
-			if q.Spec.Plugin.Kind != query.PluginKind {
-				continue
-			}
-			spec, err := convertPluginSpecToTimeSeriesQuery(q.Spec.Plugin)
+			expr, isAnalyzed, err := extractExpression(q.Spec.Plugin)
 			if err != nil {
 				errs = append(errs, &modelAPIV1.LogError{
 					Error:   err,
-					Message: "Failed to convert plugin spec to TimeSeriesQuery",
+					Message: fmt.Sprintf("Failed to extract the expression of the query %d of kind %q in the panel %q for the dashboard '%s/%s'", i, q.Spec.Plugin.Kind, panelName, currentDashboard.Metadata.Project, currentDashboard.Metadata.Name),
 				})
 				continue
 			}
-			if len(spec.Query) == 0 {
-				// No PromQL expression for the query
+			if !isAnalyzed || len(expr) == 0 {
+				// The plugin doesn't contain any PromQL expression.
 				continue
 			}
-			exprWithVariableReplaced := replaceVariables(spec.Query)
-			metrics, partialMetrics, err := prometheus.AnalyzePromQLExpression(exprWithVariableReplaced)
+			metrics, partialMetrics, err := analyzeExpression(expr, staticVariables)
 			if err != nil {
-				otherMetrics := parser.ExtractMetricNameWithVariable(exprWithVariableReplaced)
-				if len(otherMetrics) > 0 {
-					for m := range otherMetrics {
-						if prometheus.IsValidMetricName(m) {
-							result.Add(m)
-						} else {
-							partialMetricsResult.Add(m)
-						}
-					}
-				} else {
-					errs = append(errs, &modelAPIV1.LogError{
-						Error:   err,
-						Message: fmt.Sprintf("Failed to extract metric names from query %d in the panel %q for the dashboard '%s/%s'", i, panelName, currentDashboard.Metadata.Project, currentDashboard.Metadata.Name),
-					})
-					continue
-				}
+				errs = append(errs, &modelAPIV1.LogError{
+					Error:      err,
+					Message:    fmt.Sprintf("Failed to extract metric names from query %d in the panel %q for the dashboard '%s/%s'", i, panelName, currentDashboard.Metadata.Project, currentDashboard.Metadata.Name),
+					Expression: expr,
+				})
+				continue
 			}
+			expressions.Add(expr, metrics)
+			expressions.Add(expr, partialMetrics.Metrics())
 			result.Merge(metrics)
 			partialMetricsResult.Merge(partialMetrics)
 		}
@@ -96,10 +100,10 @@ func extractMetricUsageFromPanels(panels map[string]*v1.Panel, currentDashboard
 	return result, partialMetricsResult, errs
 }
 
-func extractMetricUsageFromVariables(variables []dashboard.Variable, currentDashboard *v1.Dashboard) (modelAPIV1.Set[string], modelAPIV1.Set[string], []*modelAPIV1.LogError) {
+func extractMetricUsageFromVariables(variables []dashboard.Variable, currentDashboard *v1.Dashboard, staticVariables *parser.VariableReplacer, expressions modelAPIV1.MetricExpressions) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, []*modelAPIV1.LogError) {
 	var errs []*modelAPIV1.LogError
 	result := modelAPIV1.Set[string]{}
-	partialMetricsResult := modelAPIV1.Set[string]{}
+	partialMetricsResult := modelAPIV1.PartialMetrics{}
 	for _, v := range variables {
 		if v.Kind != variable.KindList {
 			continue
@@ -111,64 +115,62 @@ func extractMetricUsageFromVariables(variables []dashboard.Variable, currentDash
 			})
 			continue
 		}
-		if variableList.Plugin.Kind != promql.PluginKind {
-			// Skipping this variable as it shouldn't contain any PromQL expression.
-			continue
-		}
-		spec, err := convertPluginSpecToPromQLVariable(variableList.Plugin)
+		expr, isAnalyzed, err := extractExpression(variableList.Plugin)
 		if err != nil {
 			errs = append(errs, &modelAPIV1.LogError{
 				Error:   err,
-				Message: "Failed to convert plugin spec to PromQL variable",
+				Message: fmt.Sprintf("Failed to extract the expression of the variable %q of kind %q for the dashboard '%s/%s'", variableList.Name, variableList.Plugin.Kind, currentDashboard.Metadata.Project, currentDashboard.Metadata.Name),
 			})
 			continue
 		}
-		exprWithVariableReplaced := replaceVariables(spec.Expr)
-		metrics, partialMetrics, err := prometheus.AnalyzePromQLExpression(exprWithVariableReplaced)
+		if !isAnalyzed || len(expr) == 0 {
+			// Skipping this variable as it shouldn't contain any PromQL expression.
+			continue
+		}
+		metrics, partialMetrics, err := analyzeExpression(expr, staticVariables)
 		if err != nil {
-			otherMetrics := parser.ExtractMetricNameWithVariable(exprWithVariableReplaced)
-			if len(otherMetrics) > 0 {
-				for m := range otherMetrics {
-					if prometheus.IsValidMetricName(m) {
-						result.Add(m)
-					} else {
-						partialMetricsResult.Add(m)
-					}
-				}
-			} else {
-				errs = append(errs, &modelAPIV1.LogError{
-					Error:   err,
-					Message: fmt.Sprintf("Failed to extract metric names from variable for the dashboard '%s/%s'", currentDashboard.Metadata.Project, currentDashboard.Metadata.Name),
-				})
-				continue
-			}
+			errs = append(errs, &modelAPIV1.LogError{
+				Error:      err,
+				Message:    fmt.Sprintf("Failed to extract metric names from variable %q for the dashboard '%s/%s'", variableList.Name, currentDashboard.Metadata.Project, currentDashboard.Metadata.Name),
+				Expression: expr,
+			})
+			continue
 		}
+		expressions.Add(expr, metrics)
+		expressions.Add(expr, partialMetrics.Metrics())
 		result.Merge(metrics)
 		partialMetricsResult.Merge(partialMetrics)
 	}
 	return result, partialMetricsResult, errs
 }
 
-func replaceVariables(expr string) string {
-	return variableReplacer.Replace(expr)
-}
-
-func convertPluginSpecToPromQLVariable(plugin common.Plugin) (promql.PluginSpec, error) {
-	data, err := json.Marshal(plugin.Spec)
-	if err != nil {
-		return promql.PluginSpec{}, err
+// analyzeExpression extracts the metrics from the expression, once the static and the global variables are replaced.
+// When the expression cannot be parsed, the metric names are extracted with a more permissive parser.
+// An error is returned only if no metric can be extracted.
+func analyzeExpression(expr string, staticVariables *parser.VariableReplacer) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, error) {
+	exprWithVariableReplaced := replaceVariables(expr, staticVariables)
+	result := modelAPIV1.Set[string]{}
+	partialMetricsResult := modelAPIV1.PartialMetrics{}
+	metrics, partialMetrics, err := prometheus.AnalyzeDashboardExpression(exprWithVariableReplaced)
+	if err == nil {
+		result.Merge(metrics)
+		partialMetricsResult.AddSet(partialMetrics, modelAPIV1.RegexReason)
+		return result, partialMetricsResult, nil
 	}
-	var result promql.PluginSpec
-	err = json.Unmarshal(data, &result)
-	return result, err
+	otherMetrics := parser.ExtractMetricNameWithVariable(exprWithVariableReplaced)
+	if len(otherMetrics) == 0 {
+		return nil, nil, err
+	}
+	for m := range otherMetrics {
+		if prometheus.IsValidMetricName(m) {
+			result.Add(m)
+		} else {
+			partialMetricsResult.Add(prometheus.NormalizePartialMetric(m), parser.FallbackReason(m))
+		}
+	}
+	return result, partialMetricsResult, nil
 }
 
-func convertPluginSpecToTimeSeriesQuery(plugin common.Plugin) (query.PluginSpec, error) {
-	data, err := json.Marshal(plugin.Spec)
-	if err != nil {
-		return query.PluginSpec{}, err
-	}
-	var result query.PluginSpec
-	err = json.Unmarshal(data, &result)
-	return result, err
+func replaceVariables(expr string, staticVariables *parser.VariableReplacer) string {
+	return variableReplacer.Replace(staticVariables.Replace(expr))
 }
@@ -0,0 +1,181 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perses
+
+import (
+	"sync"
+	"testing"
+
+	v1 "github.com/perses/perses/pkg/model/api/v1"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/perses/perses/pkg/model/api/v1/dashboard"
+	"github.com/perses/perses/pkg/model/api/v1/variable"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDashboard(plugins ...common.Plugin) *v1.Dashboard {
+	queries := make([]v1.Query, 0, len(plugins))
+	for _, plugin := range plugins {
+		queries = append(queries, v1.Query{Kind: "TimeSeriesQuery", Spec: v1.QuerySpec{Plugin: plugin}})
+	}
+	return &v1.Dashboard{
+		Spec: v1.DashboardSpec{
+			Panels: map[string]*v1.Panel{
+				"panel": {Spec: v1.PanelSpec{Queries: queries}},
+			},
+		},
+	}
+}
+
+func TestAnalyzePlugins(t *testing.T) {
+	dashboard := newDashboard(
+		common.Plugin{Kind: "PrometheusTimeSeriesQuery", Spec: map[string]interface{}{"query": "rate(http_requests_total[5m])"}},
+		common.Plugin{Kind: "VictoriaMetricsTimeSeriesQuery", Spec: map[string]interface{}{"expr": "label_match(up, \"job\", \"node\")"}},
+		common.Plugin{Kind: "LokiTimeSeriesQuery", Spec: map[string]interface{}{"query": "{job=\"foo\"} |= \"error\""}},
+	)
+
+	metrics, _, errs := Analyze(dashboard)
+	assert.Empty(t, errs)
+	assert.ElementsMatch(t, []string{"http_requests_total"}, metrics.TransformAsSlice())
+
+	RegisterPlugin("VictoriaMetricsTimeSeriesQuery", FieldExtractor("expr"))
+	defer func() {
+		pluginsMutex.Lock()
+		defer pluginsMutex.Unlock()
+		delete(plugins, "VictoriaMetricsTimeSeriesQuery")
+	}()
+	metrics, _, errs = Analyze(dashboard)
+	assert.Empty(t, errs)
+	assert.ElementsMatch(t, []string{"http_requests_total", "up"}, metrics.TransformAsSlice())
+}
+
+func TestAnalyzeWithExpressions(t *testing.T) {
+	dashboard := newDashboard(
+		common.Plugin{Kind: "PrometheusTimeSeriesQuery", Spec: map[string]interface{}{"query": "rate(http_requests_total[5m])"}},
+		common.Plugin{Kind: "PrometheusTimeSeriesQuery", Spec: map[string]interface{}{"query": "sum(http_requests_total) / sum(up)"}},
+		common.Plugin{Kind: "PrometheusTimeSeriesQuery", Spec: map[string]interface{}{"query": "{__name__=~\"node_.+\"}"}},
+	)
+
+	metrics, partialMetrics, expressions, errs := AnalyzeWithExpressions(dashboard)
+	assert.Empty(t, errs)
+	assert.ElementsMatch(t, []string{"http_requests_total", "up"}, metrics.TransformAsSlice())
+	assert.Len(t, partialMetrics, 1)
+	assert.ElementsMatch(t, []string{"rate(http_requests_total[5m])", "sum(http_requests_total) / sum(up)"}, expressions["http_requests_total"].TransformAsSlice())
+	assert.ElementsMatch(t, []string{"sum(http_requests_total) / sum(up)"}, expressions["up"].TransformAsSlice())
+	for partialMetric := range partialMetrics {
+		assert.ElementsMatch(t, []string{"{__name__=~\"node_.+\"}"}, expressions[partialMetric].TransformAsSlice())
+	}
+}
+
+func TestFieldExtractor(t *testing.T) {
+	testSuite := []struct {
+		title       string
+		spec        interface{}
+		expected    string
+		expectedErr bool
+	}{
+		{
+			title:    "field set",
+			spec:     map[string]interface{}{"query": "up", "minStep": "15s"},
+			expected: "up",
+		},
+		{
+			title: "field missing",
+			spec:  map[string]interface{}{"expr": "up"},
+		},
+		{
+			title:       "field not a string",
+			spec:        map[string]interface{}{"query": 42},
+			expectedErr: true,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			expr, err := FieldExtractor("query")(test.spec)
+			if test.expectedErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, expr)
+		})
+	}
+}
+
+func TestAnalyzeStaticVariables(t *testing.T) {
+	persesDashboard := newDashboard(
+		common.Plugin{Kind: "PrometheusTimeSeriesQuery", Spec: map[string]interface{}{"query": "rate(${prefix}_cpu_seconds_total{region=\"$region\"}[5m])"}},
+		common.Plugin{Kind: "PrometheusTimeSeriesQuery", Spec: map[string]interface{}{"query": "sum(${metric:raw})"}},
+		common.Plugin{Kind: "PrometheusTimeSeriesQuery", Spec: map[string]interface{}{"query": "sum(${job}_up{mode=\"idle\"})"}},
+	)
+	persesDashboard.Spec.Variables = []dashboard.Variable{
+		{
+			Kind: variable.KindText,
+			Spec: &dashboard.TextVariableSpec{Name: "prefix", TextSpec: variable.TextSpec{Value: "node"}},
+		},
+		{
+			Kind: variable.KindList,
+			Spec: &dashboard.ListVariableSpec{Name: "region", ListSpec: variable.ListSpec{
+				Plugin: common.Plugin{Kind: "StaticListVariable", Spec: map[string]interface{}{"values": []interface{}{"eu", "us"}}},
+			}},
+		},
+		{
+			Kind: variable.KindList,
+			Spec: &dashboard.ListVariableSpec{Name: "metric", ListSpec: variable.ListSpec{
+				DefaultValue: &variable.DefaultValue{SliceValues: []string{"$__all", "http_requests_total"}},
+				Plugin:       common.Plugin{Kind: "StaticListVariable", Spec: map[string]interface{}{"values": []interface{}{map[string]interface{}{"label": "Up", "value": "up"}}}},
+			}},
+		},
+		{
+			// The variables depending on a query are not replaced.
+			Kind: variable.KindList,
+			Spec: &dashboard.ListVariableSpec{Name: "job", ListSpec: variable.ListSpec{
+				Plugin: common.Plugin{Kind: "PrometheusLabelValuesVariable", Spec: map[string]interface{}{"labelName": "job"}},
+			}},
+		},
+	}
+
+	metrics, partialMetrics, errs := Analyze(persesDashboard)
+	assert.Empty(t, errs)
+	assert.ElementsMatch(t, []string{"node_cpu_seconds_total", "http_requests_total"}, metrics.TransformAsSlice())
+	assert.ElementsMatch(t, []string{"${job}_up"}, partialMetrics.TransformAsSlice())
+}
+
+func TestRegisterPluginConcurrently(t *testing.T) {
+	dashboard := newDashboard(
+		common.Plugin{Kind: "ThanosTimeSeriesQuery", Spec: map[string]interface{}{"expr": "up"}},
+	)
+	defer func() {
+		pluginsMutex.Lock()
+		defer pluginsMutex.Unlock()
+		delete(plugins, "ThanosTimeSeriesQuery")
+	}()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterPlugin("ThanosTimeSeriesQuery", FieldExtractor("expr"))
+		}()
+		go func() {
+			defer wg.Done()
+			_, _, errs := Analyze(dashboard)
+			assert.Empty(t, errs)
+		}()
+	}
+	wg.Wait()
+	metrics, _, errs := Analyze(dashboard)
+	assert.Empty(t, errs)
+	assert.ElementsMatch(t, []string{"up"}, metrics.TransformAsSlice())
+}
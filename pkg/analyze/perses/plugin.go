@@ -0,0 +1,84 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perses
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/perses/perses/go-sdk/prometheus/query"
+	"github.com/perses/perses/go-sdk/prometheus/variable/promql"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+)
+
+// ExpressionExtractor returns the PromQL expression contained in the spec of a plugin.
+// An empty expression means the plugin doesn't have anything to analyze.
+type ExpressionExtractor func(spec interface{}) (string, error)
+
+var (
+	// plugins is the registry of the query and variable plugins analyzed, indexed by their kind.
+	// The plugins not registered are ignored, as they are not supposed to contain a PromQL expression.
+	// It is protected by pluginsMutex, as the collectors and the API can analyze dashboards concurrently.
+	plugins = map[string]ExpressionExtractor{
+		query.PluginKind:  FieldExtractor("query"),
+		promql.PluginKind: FieldExtractor("expr"),
+	}
+	pluginsMutex sync.RWMutex
+)
+
+// RegisterPlugin makes the analyzer look for the metrics in the queries and the variables using the given plugin kind.
+// It is used to support the plugins compatible with PromQL, like the ones targeting VictoriaMetrics or Thanos.
+// It must be called before analyzing any dashboard, and it replaces the extractor of a kind already registered.
+func RegisterPlugin(kind string, extractor ExpressionExtractor) {
+	pluginsMutex.Lock()
+	defer pluginsMutex.Unlock()
+	plugins[kind] = extractor
+}
+
+// FieldExtractor returns an ExpressionExtractor reading the expression from the given field at the root of the plugin spec.
+func FieldExtractor(field string) ExpressionExtractor {
+	return func(spec interface{}) (string, error) {
+		data, err := json.Marshal(spec)
+		if err != nil {
+			return "", err
+		}
+		var fields map[string]interface{}
+		if unmarshalErr := json.Unmarshal(data, &fields); unmarshalErr != nil {
+			return "", unmarshalErr
+		}
+		value, ok := fields[field]
+		if !ok || value == nil {
+			return "", nil
+		}
+		expr, isString := value.(string)
+		if !isString {
+			return "", fmt.Errorf("the field %q of the plugin spec is not a string but a %T", field, value)
+		}
+		return expr, nil
+	}
+}
+
+// extractExpression returns the expression of the given plugin.
+// The boolean is false when the plugin is not registered, and so must be ignored.
+func extractExpression(plugin common.Plugin) (string, bool, error) {
+	pluginsMutex.RLock()
+	extractor, ok := plugins[plugin.Kind]
+	pluginsMutex.RUnlock()
+	if !ok {
+		return "", false, nil
+	}
+	expr, err := extractor(plugin.Spec)
+	return expr, true, err
+}
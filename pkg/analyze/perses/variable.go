@@ -0,0 +1,121 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perses
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/perses/metrics-usage/pkg/analyze/parser"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/perses/perses/pkg/model/api/v1/dashboard"
+	"github.com/perses/perses/pkg/model/api/v1/variable"
+)
+
+const (
+	// staticListPluginKind is the kind of the plugin of the list variables whose values are written in the dashboard.
+	staticListPluginKind = "StaticListVariable"
+	// allValue is the value of the option "All" of a list variable.
+	allValue = "$__all"
+)
+
+// variableFormats are the formats that can be used to interpolate a variable, like ${name:regex}.
+var variableFormats = []string{
+	"csv", "distributed", "doublequote", "glob", "json", "lucene", "percentencode",
+	"pipe", "queryparam", "raw", "regex", "singlequote", "sqlstring", "text",
+}
+
+// newStaticVariables returns the replacer of the static variables of an expression by their value.
+func newStaticVariables(values map[string]string) *parser.VariableReplacer {
+	return parser.NewVariableReplacer(values, func(name string) []string {
+		references := []string{fmt.Sprintf("$%s", name), fmt.Sprintf("${%s}", name)}
+		for _, format := range variableFormats {
+			references = append(references, fmt.Sprintf("${%s:%s}", name, format))
+		}
+		return references
+	})
+}
+
+// extractStaticVariables returns the value of the variables that don't depend on a query: the text variables and the static list variables.
+// The value of a list variable is its default value, or its first value when there is no default.
+func extractStaticVariables(variables []dashboard.Variable) map[string]string {
+	result := make(map[string]string)
+	for _, v := range variables {
+		switch spec := v.Spec.(type) {
+		case *dashboard.TextVariableSpec:
+			if len(spec.Value) > 0 {
+				result[spec.Name] = spec.Value
+			}
+		case *dashboard.ListVariableSpec:
+			if spec.Plugin.Kind != staticListPluginKind {
+				// We don't want to look at the runtime query. We are using them to extract metrics instead.
+				continue
+			}
+			if value, ok := listDefaultValue(spec.DefaultValue, spec.Plugin); ok {
+				result[spec.Name] = value
+			}
+		}
+	}
+	return result
+}
+
+func listDefaultValue(defaultValue *variable.DefaultValue, plugin common.Plugin) (string, bool) {
+	if defaultValue != nil {
+		if len(defaultValue.SingleValue) > 0 && defaultValue.SingleValue != allValue {
+			return defaultValue.SingleValue, true
+		}
+		for _, value := range defaultValue.SliceValues {
+			if value != allValue {
+				return value, true
+			}
+		}
+	}
+	values, err := staticListValues(plugin.Spec)
+	if err != nil || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// staticListValues returns the values of a static list variable.
+// A value is either a string or an object with a label and a value.
+func staticListValues(spec interface{}) ([]string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	var staticList struct {
+		Values []json.RawMessage `json:"values"`
+	}
+	if unmarshalErr := json.Unmarshal(data, &staticList); unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+	var result []string
+	for _, raw := range staticList.Values {
+		var value string
+		if json.Unmarshal(raw, &value) != nil {
+			var option struct {
+				Value string `json:"value"`
+			}
+			if json.Unmarshal(raw, &option) != nil {
+				continue
+			}
+			value = option.Value
+		}
+		if len(value) > 0 {
+			result = append(result, value)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"container/list"
+	"maps"
+	"sync"
+
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// exprCache is memoizing the result of AnalyzePromQLExpression, and metricsQLExprCache the one of AnalyzeMetricsQLExpression,
+// as the same expression can be valid in MetricsQL and not in PromQL. They are nil when the cache is disabled.
+var (
+	exprCache          *lruCache
+	metricsQLExprCache *lruCache
+)
+
+// EnableCache activates the cache of the PromQL and MetricsQL expressions analysis. It keeps at most size results for each of them.
+// It must be called before any analysis is done.
+func EnableCache(size int) {
+	if size <= 0 {
+		exprCache = nil
+		metricsQLExprCache = nil
+		return
+	}
+	exprCache = newLRUCache(size)
+	metricsQLExprCache = newLRUCache(size)
+}
+
+type analysisResult struct {
+	metrics        modelAPIV1.Set[string]
+	partialMetrics modelAPIV1.Set[string]
+	err            error
+}
+
+// clone returns a copy of the result, so the sets stored in the cache cannot be modified by the caller.
+func (r analysisResult) clone() analysisResult {
+	return analysisResult{
+		metrics:        maps.Clone(r.metrics),
+		partialMetrics: maps.Clone(r.partialMetrics),
+		err:            r.err,
+	}
+}
+
+type cacheEntry struct {
+	query  string
+	result analysisResult
+}
+
+// lruCache is a cache bounded in size that evicts the least recently used entry when it is full.
+type lruCache struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		entries: make(map[string]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+func (c *lruCache) get(query string) (analysisResult, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.entries[query]
+	if !ok {
+		return analysisResult{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cacheEntry).result.clone(), true
+}
+
+func (c *lruCache) add(query string, result analysisResult) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.entries[query]; ok {
+		element.Value.(*cacheEntry).result = result.clone()
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[query] = c.order.PushFront(&cacheEntry{query: query, result: result.clone()})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).query)
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"testing"
+
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	c.add("up", analysisResult{metrics: modelAPIV1.NewSet("up")})
+	c.add("foo", analysisResult{metrics: modelAPIV1.NewSet("foo")})
+	// Using "up" makes "foo" the least recently used entry.
+	_, ok := c.get("up")
+	assert.True(t, ok)
+	c.add("bar", analysisResult{metrics: modelAPIV1.NewSet("bar")})
+
+	_, ok = c.get("foo")
+	assert.False(t, ok)
+	result, ok := c.get("up")
+	assert.True(t, ok)
+	assert.Equal(t, modelAPIV1.NewSet("up"), result.metrics)
+
+	// The result returned must not be shared with the cache.
+	result.metrics.Add("down")
+	result, _ = c.get("up")
+	assert.Equal(t, modelAPIV1.NewSet("up"), result.metrics)
+}
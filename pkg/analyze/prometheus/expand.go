@@ -0,0 +1,110 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"regexp/syntax"
+	"slices"
+)
+
+// maxExpandedMetrics is the maximum number of metric names a regexp can be expanded into.
+// Beyond that, the regexp is kept as a partial metric.
+const maxExpandedMetrics = 100
+
+// expandRegexpMatchers is true when the regexp matchers on the metric name must be expanded into the metric names they match.
+var expandRegexpMatchers bool
+
+// EnableRegexpExpansion makes the analyzers replace a regexp matcher on the metric name, like {__name__=~"foo_(a|b)"},
+// by the metric names it matches (foo_a and foo_b) when they can be enumerated. It must be called before any analysis is done.
+func EnableRegexpExpansion() {
+	expandRegexpMatchers = true
+}
+
+// ExpandRegexp returns the sorted list of every string matched by the regexp, when this list is finite and not too long.
+// It is the case of the alternations of literals, like foo_(a|b|c) or foo_[ab]_total.
+// It returns nil if the regexp is matching an infinite (or too large) set of strings, like foo_.+, or if it is not valid.
+// Like in PromQL, the regexp is considered as fully anchored.
+func ExpandRegexp(expr string) []string {
+	re, err := syntax.Parse(expr, syntax.Perl|syntax.DotNL)
+	if err != nil {
+		return nil
+	}
+	result, ok := enumerateRegexp(re.Simplify())
+	if !ok {
+		return nil
+	}
+	slices.Sort(result)
+	return slices.Compact(result)
+}
+
+// enumerateRegexp returns every string matched by the regexp. The second value is false if they cannot be enumerated.
+func enumerateRegexp(re *syntax.Regexp) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine:
+		return []string{""}, true
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil, false
+		}
+		return []string{string(re.Rune)}, true
+	case syntax.OpCharClass:
+		var result []string
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			if int(re.Rune[i+1]-re.Rune[i])+len(result) >= maxExpandedMetrics {
+				return nil, false
+			}
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				result = append(result, string(r))
+			}
+		}
+		return result, true
+	case syntax.OpCapture:
+		return enumerateRegexp(re.Sub[0])
+	case syntax.OpQuest:
+		sub, ok := enumerateRegexp(re.Sub[0])
+		if !ok {
+			return nil, false
+		}
+		return append([]string{""}, sub...), true
+	case syntax.OpAlternate:
+		var result []string
+		for _, s := range re.Sub {
+			sub, ok := enumerateRegexp(s)
+			if !ok || len(result)+len(sub) > maxExpandedMetrics {
+				return nil, false
+			}
+			result = append(result, sub...)
+		}
+		return result, true
+	case syntax.OpConcat:
+		result := []string{""}
+		for _, s := range re.Sub {
+			sub, ok := enumerateRegexp(s)
+			if !ok || len(result)*len(sub) > maxExpandedMetrics {
+				return nil, false
+			}
+			product := make([]string, 0, len(result)*len(sub))
+			for _, prefix := range result {
+				for _, suffix := range sub {
+					product = append(product, prefix+suffix)
+				}
+			}
+			result = product
+		}
+		return result, true
+	default:
+		// The other operators, like .* or x+, are matching an infinite set of strings.
+		return nil, false
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandRegexp(t *testing.T) {
+	testSuite := []struct {
+		title  string
+		expr   string
+		result []string
+	}{
+		{
+			title:  "alternation in a group",
+			expr:   "foo_(a|b|c)",
+			result: []string{"foo_a", "foo_b", "foo_c"},
+		},
+		{
+			title:  "alternation of metric names",
+			expr:   "up|node_load1",
+			result: []string{"node_load1", "up"},
+		},
+		{
+			title:  "character class and optional suffix",
+			expr:   "^foo_[ab](_total)?$",
+			result: []string{"foo_a", "foo_a_total", "foo_b", "foo_b_total"},
+		},
+		{
+			title:  "bounded repetition",
+			expr:   "fo{1,2}",
+			result: []string{"fo", "foo"},
+		},
+		{
+			title: "wildcard",
+			expr:  "foo_.+",
+		},
+		{
+			title: "unbounded repetition",
+			expr:  "foo_(a|b)*",
+		},
+		{
+			title: "case insensitive",
+			expr:  "(?i)foo",
+		},
+		{
+			title: "too many names",
+			expr:  "foo_[a-z][a-z]",
+		},
+		{
+			title: "invalid regexp",
+			expr:  "foo_(a",
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.result, ExpandRegexp(test.expr))
+		})
+	}
+}
+
+func TestAnalyzeWithRegexpExpansion(t *testing.T) {
+	EnableRegexpExpansion()
+	t.Cleanup(func() {
+		expandRegexpMatchers = false
+	})
+	metrics, partialMetrics, err := analyzePromQLExpression(`sum({__name__=~"foo_(a|b)"}) + sum({__name__=~"bar_.+"}) + sum({__name__=~"baz|", job="api"})`)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"foo_a", "foo_b"}, metrics.TransformAsSlice())
+	assert.ElementsMatch(t, []string{"bar_.+", "baz|"}, partialMetrics.TransformAsSlice())
+}
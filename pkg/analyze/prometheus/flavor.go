@@ -0,0 +1,58 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"regexp"
+
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// Flavor is the Prometheus compatible backend serving the rules.
+type Flavor string
+
+const (
+	PrometheusFlavor      Flavor = "prometheus"
+	ThanosFlavor          Flavor = "thanos"
+	CortexFlavor          Flavor = "cortex"
+	VictoriaMetricsFlavor Flavor = "victoriametrics"
+)
+
+// metricNameValidators are the metric name validations of the flavors not accepting the same names as Prometheus.
+var metricNameValidators = map[Flavor]*regexp.Regexp{
+	// VictoriaMetrics is also accepting the dots, like in the metrics ingested with the Graphite protocol.
+	VictoriaMetricsFlavor: regexp.MustCompile(`^[a-zA-Z_:.][a-zA-Z0-9_:.]*$`),
+}
+
+// IsValidMetricNameForFlavor is validating the metric name with the rules of the given backend.
+// An empty or unknown flavor is considered as Prometheus.
+func IsValidMetricNameForFlavor(flavor Flavor, name string) bool {
+	if validator, ok := metricNameValidators[flavor]; ok {
+		return validator.MatchString(name)
+	}
+	return IsValidMetricName(name)
+}
+
+// reclassifyPartialMetrics moves to the metric names the partial metrics that are actually valid metric names for the given backend.
+func reclassifyPartialMetrics(flavor Flavor, metricNames modelAPIV1.Set[string], partialMetrics modelAPIV1.Set[string]) {
+	if _, ok := metricNameValidators[flavor]; !ok {
+		return
+	}
+	for name := range partialMetrics {
+		if IsValidMetricNameForFlavor(flavor, name) {
+			metricNames.Add(name)
+			partialMetrics.Remove(name)
+		}
+	}
+}
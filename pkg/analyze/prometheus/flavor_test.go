@@ -0,0 +1,73 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"testing"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeWithFlavor(t *testing.T) {
+	groups := []v1.RuleGroup{
+		{
+			Name: "graphite",
+			Rules: v1.Rules{
+				v1.RecordingRule{
+					Name:  "carbon:requests:rate5m",
+					Query: `rate({__name__="carbon.agents.requests"}[5m]) + on() group_left() sum({__name__=~"node_.+"})`,
+				},
+			},
+		},
+	}
+	testSuite := []struct {
+		title          string
+		flavor         Flavor
+		metrics        []string
+		partialMetrics []string
+	}{
+		{
+			title:          "prometheus",
+			flavor:         PrometheusFlavor,
+			partialMetrics: []string{"carbon.agents.requests", "node_.+"},
+		},
+		{
+			title:          "thanos",
+			flavor:         ThanosFlavor,
+			partialMetrics: []string{"carbon.agents.requests", "node_.+"},
+		},
+		{
+			title:          "victoriametrics",
+			flavor:         VictoriaMetricsFlavor,
+			metrics:        []string{"carbon.agents.requests"},
+			partialMetrics: []string{"node_.+"},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			metricUsage, partialMetricUsage, errs := AnalyzeWithFlavor(groups, "http://localhost:9090", test.flavor)
+			assert.Empty(t, errs)
+			var metrics, partialMetrics []string
+			for name := range metricUsage {
+				metrics = append(metrics, name)
+			}
+			for name := range partialMetricUsage {
+				partialMetrics = append(partialMetrics, name)
+			}
+			assert.ElementsMatch(t, test.metrics, metrics)
+			assert.ElementsMatch(t, test.partialMetrics, partialMetrics)
+		})
+	}
+}
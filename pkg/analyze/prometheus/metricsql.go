@@ -0,0 +1,92 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"maps"
+	"sync"
+
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// metricsQLLabelFunctions are the MetricsQL functions manipulating the labels of a series.
+// They are not known by the PromQL parser, so the expressions using them (like the vmalert rules) couldn't be analyzed at all.
+// Their first argument is the query, and the others are string literals (label names, regexps, values),
+// so they are parsed as StringLiteral and never mistaken for metric names.
+var metricsQLLabelFunctions = []*parser.Function{
+	{Name: "alias", ArgTypes: []parser.ValueType{parser.ValueTypeVector, parser.ValueTypeString}, ReturnType: parser.ValueTypeVector},
+	{Name: "label_copy", ArgTypes: []parser.ValueType{parser.ValueTypeVector, parser.ValueTypeString}, Variadic: -1, ReturnType: parser.ValueTypeVector},
+	{Name: "label_del", ArgTypes: []parser.ValueType{parser.ValueTypeVector, parser.ValueTypeString}, Variadic: -1, ReturnType: parser.ValueTypeVector},
+	{Name: "label_keep", ArgTypes: []parser.ValueType{parser.ValueTypeVector, parser.ValueTypeString}, Variadic: -1, ReturnType: parser.ValueTypeVector},
+	{Name: "label_lowercase", ArgTypes: []parser.ValueType{parser.ValueTypeVector, parser.ValueTypeString}, Variadic: -1, ReturnType: parser.ValueTypeVector},
+	{Name: "label_match", ArgTypes: []parser.ValueType{parser.ValueTypeVector, parser.ValueTypeString, parser.ValueTypeString}, ReturnType: parser.ValueTypeVector},
+	{Name: "label_mismatch", ArgTypes: []parser.ValueType{parser.ValueTypeVector, parser.ValueTypeString, parser.ValueTypeString}, ReturnType: parser.ValueTypeVector},
+	{Name: "label_move", ArgTypes: []parser.ValueType{parser.ValueTypeVector, parser.ValueTypeString}, Variadic: -1, ReturnType: parser.ValueTypeVector},
+	{Name: "label_set", ArgTypes: []parser.ValueType{parser.ValueTypeVector, parser.ValueTypeString}, Variadic: -1, ReturnType: parser.ValueTypeVector},
+	{Name: "label_transform", ArgTypes: []parser.ValueType{parser.ValueTypeVector, parser.ValueTypeString, parser.ValueTypeString, parser.ValueTypeString}, ReturnType: parser.ValueTypeVector},
+	{Name: "label_uppercase", ArgTypes: []parser.ValueType{parser.ValueTypeVector, parser.ValueTypeString}, Variadic: -1, ReturnType: parser.ValueTypeVector},
+}
+
+// metricsQLFunctions are the functions known by the parser used for the MetricsQL expressions: the PromQL ones and the label functions of MetricsQL.
+// The functions of the PromQL parser are not modified, so the MetricsQL functions are still rejected in the PromQL expressions.
+var metricsQLFunctions = sync.OnceValue(func() map[string]*parser.Function {
+	functions := maps.Clone(parser.Functions)
+	// A function already known by the PromQL parser is never overridden.
+	for _, f := range metricsQLLabelFunctions {
+		if _, exist := functions[f.Name]; !exist {
+			functions[f.Name] = f
+		}
+	}
+	return functions
+})
+
+// AnalyzeMetricsQLExpression is like AnalyzePromQLExpression, for an expression evaluated by VictoriaMetrics.
+// Only the label functions of MetricsQL are supported on top of PromQL.
+func AnalyzeMetricsQLExpression(query string) (modelAPIV1.Set[string], modelAPIV1.Set[string], error) {
+	if metricsQLExprCache == nil {
+		return analyzeMetricsQLExpression(query)
+	}
+	if result, ok := metricsQLExprCache.get(query); ok {
+		return result.metrics, result.partialMetrics, result.err
+	}
+	metricNames, partialMetricNames, err := analyzeMetricsQLExpression(query)
+	metricsQLExprCache.add(query, analysisResult{metrics: metricNames, partialMetrics: partialMetricNames, err: err})
+	return metricNames, partialMetricNames, err
+}
+
+// AnalyzeDashboardExpression analyzes the expression of a dashboard, whose datasource can be a Prometheus or a VictoriaMetrics.
+// The expression is analyzed as PromQL, and as MetricsQL only when it is not valid PromQL.
+// When it is valid in none of them, the error of the PromQL parser is returned.
+func AnalyzeDashboardExpression(query string) (modelAPIV1.Set[string], modelAPIV1.Set[string], error) {
+	metricNames, partialMetricNames, err := AnalyzePromQLExpression(query)
+	if err == nil {
+		return metricNames, partialMetricNames, nil
+	}
+	if metricNames, partialMetricNames, metricsQLErr := AnalyzeMetricsQLExpression(query); metricsQLErr == nil {
+		return metricNames, partialMetricNames, nil
+	}
+	return nil, nil, err
+}
+
+func analyzeMetricsQLExpression(query string) (modelAPIV1.Set[string], modelAPIV1.Set[string], error) {
+	p := parser.NewParser(query, parser.WithFunctions(metricsQLFunctions()))
+	defer p.Close()
+	expr, err := p.ParseExpr()
+	if err != nil {
+		return nil, nil, err
+	}
+	metricNames, partialMetricNames := extractMetricNames(expr)
+	return metricNames, partialMetricNames, nil
+}
@@ -0,0 +1,36 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// normalizePartialMetrics is true when the partial metrics must be named after the regexp matching them.
+var normalizePartialMetrics bool
+
+// EnablePartialMetricsNormalization makes the analyzers name the partial metrics after the anchored regexp used to match them,
+// so the same partial metric doesn't appear under two names. It must be called before any analysis is done.
+func EnablePartialMetricsNormalization() {
+	normalizePartialMetrics = true
+}
+
+// NormalizePartialMetric returns the name of the partial metric to use. It is unchanged when the normalization is disabled.
+// See modelAPIV1.NormalizePartialMetricName for more details.
+func NormalizePartialMetric(name string) string {
+	if !normalizePartialMetrics {
+		return name
+	}
+	return modelAPIV1.NormalizePartialMetricName(name)
+}
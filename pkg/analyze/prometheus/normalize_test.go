@@ -0,0 +1,68 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizePartialMetrics(t *testing.T) {
+	EnablePartialMetricsNormalization()
+	t.Cleanup(func() {
+		normalizePartialMetrics = false
+	})
+	testSuite := []struct {
+		title          string
+		expr           string
+		partialMetrics []string
+	}{
+		{
+			title:          "regexp matcher",
+			expr:           `{__name__=~"node_cpu_.*"}`,
+			partialMetrics: []string{"^node_cpu_.+$"},
+		},
+		{
+			title:          "anchored regexp matcher",
+			expr:           `{__name__=~"^node_cpu_.+$"}`,
+			partialMetrics: []string{"^node_cpu_.+$"},
+		},
+		{
+			title:          "regexp matcher with an alternation",
+			expr:           `{__name__=~"foo_.+|bar_total"}`,
+			partialMetrics: []string{"^(?:foo_.+|bar_total)$"},
+		},
+		{
+			title:          "regexp matching every metric",
+			expr:           `{__name__=~".+"}`,
+			partialMetrics: []string{".+"},
+		},
+		{
+			title:          "equality matcher is not a regexp",
+			expr:           `{__name__="cpu.usage"}`,
+			partialMetrics: []string{"cpu.usage"},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			_, partialMetrics, err := analyzePromQLExpression(test.expr)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, test.partialMetrics, partialMetrics.TransformAsSlice())
+		})
+	}
+	// The same partial metric coming from a dashboard variable has the same name.
+	assert.Equal(t, "^node_cpu_.+$", NormalizePartialMetric("node_cpu_${suffix}"))
+}
@@ -25,7 +25,13 @@ import (
 
 var validMetricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
 
+// Analyze is extracting the metrics used by the rules served by Prometheus.
 func Analyze(ruleGroups []v1.RuleGroup, source string) (map[string]*modelAPIV1.MetricUsage, map[string]*modelAPIV1.MetricUsage, []*modelAPIV1.LogError) {
+	return AnalyzeWithFlavor(ruleGroups, source, PrometheusFlavor)
+}
+
+// AnalyzeWithFlavor is extracting the metrics used by the rules. The flavor is the backend serving the rules, it defines which metric names are valid.
+func AnalyzeWithFlavor(ruleGroups []v1.RuleGroup, source string, flavor Flavor) (map[string]*modelAPIV1.MetricUsage, map[string]*modelAPIV1.MetricUsage, []*modelAPIV1.LogError) {
 	var errs []*modelAPIV1.LogError
 	metricUsage := make(map[string]*modelAPIV1.MetricUsage)
 	partialMetricUsage := make(map[string]*modelAPIV1.MetricUsage)
@@ -33,7 +39,7 @@ func Analyze(ruleGroups []v1.RuleGroup, source string) (map[string]*modelAPIV1.M
 		for _, rule := range ruleGroup.Rules {
 			switch v := rule.(type) {
 			case v1.RecordingRule:
-				metricNames, partialMetrics, parserErr := AnalyzePromQLExpression(v.Query)
+				metricNames, partialMetrics, parserErr := analyzeRuleExpression(v.Query, flavor)
 				if parserErr != nil {
 					errs = append(errs, &modelAPIV1.LogError{
 						Message: fmt.Sprintf("Failed to extract metric name for the ruleGroup %q and the recordingRule %q", ruleGroup.Name, v.Name),
@@ -41,6 +47,7 @@ func Analyze(ruleGroups []v1.RuleGroup, source string) (map[string]*modelAPIV1.M
 					})
 					continue
 				}
+				reclassifyPartialMetrics(flavor, metricNames, partialMetrics)
 				populateUsage(metricUsage,
 					metricNames,
 					modelAPIV1.RuleUsage{
@@ -62,7 +69,7 @@ func Analyze(ruleGroups []v1.RuleGroup, source string) (map[string]*modelAPIV1.M
 					false,
 				)
 			case v1.AlertingRule:
-				metricNames, partialMetrics, parserErr := AnalyzePromQLExpression(v.Query)
+				metricNames, partialMetrics, parserErr := analyzeRuleExpression(v.Query, flavor)
 				if parserErr != nil {
 					errs = append(errs, &modelAPIV1.LogError{
 						Message: fmt.Sprintf("Failed to extract metric name for the ruleGroup %q and the alertingRule %q", ruleGroup.Name, v.Name),
@@ -70,6 +77,17 @@ func Analyze(ruleGroups []v1.RuleGroup, source string) (map[string]*modelAPIV1.M
 					})
 					continue
 				}
+				// The templates of the labels and annotations can also run queries, like {{ query "up" }}.
+				templateMetrics, templatePartialMetrics, templateErrs := analyzeAlertTemplates(v.Labels, v.Annotations)
+				for _, templateErr := range templateErrs {
+					errs = append(errs, &modelAPIV1.LogError{
+						Message: fmt.Sprintf("Failed to extract metric name from the templates for the ruleGroup %q and the alertingRule %q", ruleGroup.Name, v.Name),
+						Warning: templateErr,
+					})
+				}
+				metricNames.Merge(templateMetrics)
+				partialMetrics.Merge(templatePartialMetrics)
+				reclassifyPartialMetrics(flavor, metricNames, partialMetrics)
 				populateUsage(metricUsage,
 					metricNames,
 					modelAPIV1.RuleUsage{
@@ -100,13 +118,39 @@ func Analyze(ruleGroups []v1.RuleGroup, source string) (map[string]*modelAPIV1.M
 	return metricUsage, partialMetricUsage, errs
 }
 
+// analyzeRuleExpression analyzes the expression of a rule with the query language of the backend serving it.
+func analyzeRuleExpression(query string, flavor Flavor) (modelAPIV1.Set[string], modelAPIV1.Set[string], error) {
+	if flavor == VictoriaMetricsFlavor {
+		return AnalyzeMetricsQLExpression(query)
+	}
+	return AnalyzePromQLExpression(query)
+}
+
 // AnalyzePromQLExpression is returning a list of valid metric names extracted from the PromQL expression.
 // It also returned a list of partial metric names that likely look like a regexp.
 func AnalyzePromQLExpression(query string) (modelAPIV1.Set[string], modelAPIV1.Set[string], error) {
+	if exprCache == nil {
+		return analyzePromQLExpression(query)
+	}
+	if result, ok := exprCache.get(query); ok {
+		return result.metrics, result.partialMetrics, result.err
+	}
+	metricNames, partialMetricNames, err := analyzePromQLExpression(query)
+	exprCache.add(query, analysisResult{metrics: metricNames, partialMetrics: partialMetricNames, err: err})
+	return metricNames, partialMetricNames, err
+}
+
+func analyzePromQLExpression(query string) (modelAPIV1.Set[string], modelAPIV1.Set[string], error) {
 	expr, err := parser.ParseExpr(query)
 	if err != nil {
 		return nil, nil, err
 	}
+	metricNames, partialMetricNames := extractMetricNames(expr)
+	return metricNames, partialMetricNames, nil
+}
+
+// extractMetricNames returns the valid metric names, and the partial metric names that likely look like a regexp, used by the expression.
+func extractMetricNames(expr parser.Expr) (modelAPIV1.Set[string], modelAPIV1.Set[string]) {
 	metricNames := modelAPIV1.Set[string]{}
 	partialMetricNames := modelAPIV1.Set[string]{}
 	parser.Inspect(expr, func(node parser.Node, _ []parser.Node) error {
@@ -123,6 +167,10 @@ func AnalyzePromQLExpression(query string) (modelAPIV1.Set[string], modelAPIV1.S
 				if m.Name == labels.MetricName {
 					if IsValidMetricName(m.Value) {
 						metricNames.Add(m.Value)
+					} else if expanded := expandMetricNameRegexp(m); len(expanded) > 0 {
+						metricNames.Add(expanded...)
+					} else if m.Type == labels.MatchRegexp {
+						partialMetricNames.Add(NormalizePartialMetric(m.Value))
 					} else {
 						partialMetricNames.Add(m.Value)
 					}
@@ -133,10 +181,28 @@ func AnalyzePromQLExpression(query string) (modelAPIV1.Set[string], modelAPIV1.S
 		}
 		return nil
 	})
-	return metricNames, partialMetricNames, nil
+	return metricNames, partialMetricNames
+}
+
+// expandMetricNameRegexp returns the metric names matched by the regexp matcher, when the expansion is enabled.
+// It returns nil if the matcher is not a regexp, if it cannot be expanded, or if one of the strings matched is not a valid metric name.
+func expandMetricNameRegexp(matcher *labels.Matcher) []string {
+	if !expandRegexpMatchers || matcher.Type != labels.MatchRegexp {
+		return nil
+	}
+	names := ExpandRegexp(matcher.Value)
+	for _, name := range names {
+		if !IsValidMetricName(name) {
+			return nil
+		}
+	}
+	return names
 }
 
 func IsValidMetricName(name string) bool {
+	if utf8MetricNames {
+		return validUTF8MetricName.MatchString(name)
+	}
 	return validMetricName.MatchString(name)
 }
 
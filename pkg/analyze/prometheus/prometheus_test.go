@@ -11,3 +11,49 @@ func TestAnalyzePromQLExpression(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"service_status"}, result.TransformAsSlice())
 }
+
+func TestAnalyzeMetricsQLExpression(t *testing.T) {
+	testSuite := []struct {
+		title          string
+		expr           string
+		metrics        []string
+		partialMetrics []string
+	}{
+		{
+			title:   "label_match",
+			expr:    `label_match(rate(http_requests_total{job="api"}[5m]), "instance", "node_exporter_.+")`,
+			metrics: []string{"http_requests_total"},
+		},
+		{
+			title:   "label_mismatch with a nested label_match",
+			expr:    `label_mismatch(label_match(up, "job", "kube_state_metrics"), "instance", "foo_bar")`,
+			metrics: []string{"up"},
+		},
+		{
+			title:   "alias",
+			expr:    `alias(sum(node_cpu_seconds_total) / sum(machine_cpu_cores), "cpu_usage_ratio")`,
+			metrics: []string{"machine_cpu_cores", "node_cpu_seconds_total"},
+		},
+		{
+			title:   "label_transform",
+			expr:    `label_transform(kube_pod_info, "pod", "(.+)_total", "$1")`,
+			metrics: []string{"kube_pod_info"},
+		},
+		{
+			title:          "label_set with a regexp on the metric name",
+			expr:           `label_set({__name__=~"container_.+"}, "team", "infra", "env", "prod")`,
+			partialMetrics: []string{"container_.+"},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			metrics, partialMetrics, err := analyzeMetricsQLExpression(test.expr)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, test.metrics, metrics.TransformAsSlice())
+			assert.ElementsMatch(t, test.partialMetrics, partialMetrics.TransformAsSlice())
+			// The MetricsQL functions are not known by the PromQL parser.
+			_, _, err = analyzePromQLExpression(test.expr)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+	"slices"
+	"text/template/parse"
+
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	templateQueryFunction = "query"
+	// templateDefinitions are the variables defined by Prometheus before executing the template of an alerting rule.
+	templateDefinitions = "{{$labels := .Labels}}{{$externalLabels := .ExternalLabels}}{{$externalURL := .ExternalURL}}{{$value := .Value}}"
+)
+
+// analyzeAlertTemplates returns the metrics used by the PromQL expressions given to the function "query"
+// in the templates of the labels and the annotations of an alerting rule. For example: {{ query "up" }}.
+func analyzeAlertTemplates(labels model.LabelSet, annotations model.LabelSet) (modelAPIV1.Set[string], modelAPIV1.Set[string], []error) {
+	var errs []error
+	metricNames := modelAPIV1.Set[string]{}
+	partialMetricNames := modelAPIV1.Set[string]{}
+	for _, text := range sortedTemplates(labels, annotations) {
+		queries, err := extractQueriesFromTemplate(text)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, query := range queries {
+			metrics, partialMetrics, parserErr := AnalyzePromQLExpression(query)
+			if parserErr != nil {
+				errs = append(errs, fmt.Errorf("unable to parse the query %q used in the template: %w", query, parserErr))
+				continue
+			}
+			metricNames.Merge(metrics)
+			partialMetricNames.Merge(partialMetrics)
+		}
+	}
+	return metricNames, partialMetricNames, errs
+}
+
+func sortedTemplates(labelSets ...model.LabelSet) []string {
+	var result []string
+	for _, labelSet := range labelSets {
+		for _, value := range labelSet {
+			result = append(result, string(value))
+		}
+	}
+	slices.Sort(result)
+	return result
+}
+
+// extractQueriesFromTemplate parses the Go template and returns the string literals given to the function "query".
+// The functions used in the template are not checked, as they are defined by Prometheus and not by this package.
+func extractQueriesFromTemplate(text string) ([]string, error) {
+	tree := parse.New("template")
+	tree.Mode = parse.SkipFuncCheck
+	if _, err := tree.Parse(templateDefinitions+text, "", "", make(map[string]*parse.Tree)); err != nil {
+		return nil, err
+	}
+	var queries []string
+	walkTemplateNode(tree.Root, &queries)
+	return queries, nil
+}
+
+func walkTemplateNode(node parse.Node, queries *[]string) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkTemplateNode(child, queries)
+		}
+	case *parse.ActionNode:
+		walkTemplateNode(n.Pipe, queries)
+	case *parse.IfNode:
+		walkBranchNode(&n.BranchNode, queries)
+	case *parse.RangeNode:
+		walkBranchNode(&n.BranchNode, queries)
+	case *parse.WithNode:
+		walkBranchNode(&n.BranchNode, queries)
+	case *parse.TemplateNode:
+		walkTemplateNode(n.Pipe, queries)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkTemplateNode(cmd, queries)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) > 1 {
+			if identifier, ok := n.Args[0].(*parse.IdentifierNode); ok && identifier.Ident == templateQueryFunction {
+				if query, isString := n.Args[1].(*parse.StringNode); isString {
+					*queries = append(*queries, query.Text)
+				}
+			}
+		}
+		for _, arg := range n.Args {
+			walkTemplateNode(arg, queries)
+		}
+	}
+}
+
+func walkBranchNode(n *parse.BranchNode, queries *[]string) {
+	walkTemplateNode(n.Pipe, queries)
+	walkTemplateNode(n.List, queries)
+	walkTemplateNode(n.ElseList, queries)
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"testing"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractQueriesFromTemplate(t *testing.T) {
+	testSuite := []struct {
+		title  string
+		text   string
+		result []string
+	}{
+		{
+			title: "no query",
+			text:  "the value is {{ $value }}",
+		},
+		{
+			title:  "simple query",
+			text:   `{{ query "up" | first | value }}`,
+			result: []string{"up"},
+		},
+		{
+			title:  "query in a range and in a with",
+			text:   "{{ range query `node_load1{job=\"node\"}` }}{{ .Labels.instance }}{{ end }}{{ with query \"node_load5\" }}{{ . | first | value }}{{ else }}none{{ end }}",
+			result: []string{"node_load1{job=\"node\"}", "node_load5"},
+		},
+		{
+			title:  "query in a nested pipeline",
+			text:   `{{ printf "%.2f" (query "sum(rate(http_requests_total[5m]))" | first | value) }}`,
+			result: []string{"sum(rate(http_requests_total[5m]))"},
+		},
+		{
+			title: "dynamic query is ignored",
+			text:  `{{ query (printf "up{instance=%q}" $labels.instance) }}`,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			result, err := extractQueriesFromTemplate(test.text)
+			require.NoError(t, err)
+			assert.Equal(t, test.result, result)
+		})
+	}
+}
+
+func TestAnalyzeAlertTemplates(t *testing.T) {
+	groups := []v1.RuleGroup{
+		{
+			Name: "node",
+			Rules: v1.Rules{
+				v1.AlertingRule{
+					Name:  "NodeDown",
+					Query: "up == 0",
+					Labels: model.LabelSet{
+						"severity": "critical",
+					},
+					Annotations: model.LabelSet{
+						"description": `{{ $labels.instance }} is down, load was {{ query "node_load1" | first | value }}`,
+						"broken":      "{{ if }}",
+					},
+				},
+			},
+		},
+	}
+	metricUsage, _, errs := Analyze(groups, "http://localhost:9090")
+	assert.Contains(t, metricUsage, "up")
+	assert.Contains(t, metricUsage, "node_load1")
+	require.Len(t, errs, 1)
+	assert.NotNil(t, errs[0].Warning)
+	assert.Nil(t, errs[0].Error)
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"regexp"
+
+	"github.com/perses/metrics-usage/pkg/analyze/parser"
+)
+
+// utf8MetricNames is true when the metric names allowed by Prometheus 3.0, like the OpenTelemetry dotted names, are accepted.
+var utf8MetricNames bool
+
+// validUTF8MetricName is the validation of the metric names in UTF-8 mode.
+// Any UTF-8 string is a valid name for Prometheus, but the names containing a variable or a regexp must stay partial metrics,
+// so only the dots are accepted on top of the legacy characters.
+var validUTF8MetricName = regexp.MustCompile(`^[a-zA-Z_:.][a-zA-Z0-9_:.]*$`)
+
+// EnableUTF8MetricNames makes the analyzers accept the dotted metric names, like {"http.server.duration"}.
+// The fallback parser is switched to the same mode. It must be called before any analysis is done.
+func EnableUTF8MetricNames() {
+	utf8MetricNames = true
+	parser.EnableUTF8MetricNames()
+}
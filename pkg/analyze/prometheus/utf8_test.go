@@ -0,0 +1,38 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeWithUTF8MetricNames(t *testing.T) {
+	expr := `sum(rate({"http.server.request.duration", "service.name"="api"}[5m])) / sum(up) + sum({__name__=~"http.server.+"})`
+	metrics, partialMetrics, err := analyzePromQLExpression(expr)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"up"}, metrics.TransformAsSlice())
+	assert.ElementsMatch(t, []string{"http.server.request.duration", "http.server.+"}, partialMetrics.TransformAsSlice())
+
+	EnableUTF8MetricNames()
+	t.Cleanup(func() {
+		utf8MetricNames = false
+	})
+	metrics, partialMetrics, err = analyzePromQLExpression(expr)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"http.server.request.duration", "up"}, metrics.TransformAsSlice())
+	assert.ElementsMatch(t, []string{"http.server.+"}, partialMetrics.TransformAsSlice())
+}
@@ -19,6 +19,8 @@ type LogError struct {
 	Message string
 	Warning error
 	Error   error
+	// Expression is the query that couldn't be analyzed, when the error is about a query.
+	Expression string
 }
 
 func (l *LogError) Log(logger *logrus.Entry) {
@@ -28,3 +30,34 @@ func (l *LogError) Log(logger *logrus.Entry) {
 		logger.WithError(l.Warning).Warning(l.Message)
 	}
 }
+
+// BrokenQuery is a query of a dashboard that couldn't be analyzed, so the metrics it is using are unknown.
+type BrokenQuery struct {
+	Message    string `json:"message"`
+	Error      string `json:"error"`
+	Expression string `json:"expression,omitempty"`
+}
+
+// DashboardBrokenQueries is the list of the broken queries of a dashboard, found by the last run of a collector.
+type DashboardBrokenQueries struct {
+	// Source is the URL of the Grafana or of the Perses the dashboard has been collected from.
+	Source    string         `json:"source"`
+	Dashboard DashboardUsage `json:"dashboard"`
+	Queries   []BrokenQuery  `json:"queries"`
+}
+
+// NewBrokenQueries returns the queries that couldn't be analyzed among the errors. The warnings are ignored.
+func NewBrokenQueries(errs []*LogError) []BrokenQuery {
+	var result []BrokenQuery
+	for _, err := range errs {
+		if err.Error == nil || len(err.Expression) == 0 {
+			continue
+		}
+		result = append(result, BrokenQuery{
+			Message:    err.Message,
+			Error:      err.Error.Error(),
+			Expression: err.Expression,
+		})
+	}
+	return result
+}
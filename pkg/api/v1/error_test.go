@@ -0,0 +1,30 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBrokenQueries(t *testing.T) {
+	errs := []*LogError{
+		{Message: "failed to extract metric names", Error: errors.New("parse error"), Expression: "up{"},
+		{Message: "variable not found", Warning: errors.New("unknown variable"), Expression: "up{job=\"$job\"}"},
+		{Message: "failed to unmarshal the panel", Error: errors.New("invalid JSON")},
+	}
+	assert.Equal(t, []BrokenQuery{{Message: "failed to extract metric names", Error: "parse error", Expression: "up{"}}, NewBrokenQueries(errs))
+}
@@ -15,6 +15,7 @@ package v1
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/perses/perses/pkg/model/api/v1/common"
 )
@@ -109,18 +110,117 @@ type RuleUsage struct {
 	GroupName  string `json:"group_name"`
 	Name       string `json:"name"`
 	Expression string `json:"expression"`
+	// Tags are the ones configured on the collector that has collected the rule.
+	Tags Tags `json:"tags,omitempty"`
+}
+
+// DedupeRules returns a new set where the rules sharing the same group name, name, expression and tags are collapsed into a single one.
+// It happens when the same rule is evaluated by multiple Prometheus (like shards or replicas), and so only the PromLink differs.
+// To keep the result stable, the PromLink kept is the smallest one in lexical order.
+func DedupeRules(rules Set[RuleUsage]) Set[RuleUsage] {
+	if rules == nil {
+		return nil
+	}
+	dedup := make(map[RuleUsage]string)
+	for rule := range rules {
+		key := RuleUsage{
+			GroupName:  rule.GroupName,
+			Name:       rule.Name,
+			Expression: rule.Expression,
+			Tags:       rule.Tags,
+		}
+		if promLink, ok := dedup[key]; !ok || rule.PromLink < promLink {
+			dedup[key] = rule.PromLink
+		}
+	}
+	result := make(Set[RuleUsage], len(dedup))
+	for rule, promLink := range dedup {
+		rule.PromLink = promLink
+		result.Add(rule)
+	}
+	return result
 }
 
 type DashboardUsage struct {
 	ID   string `json:"uid"`
 	Name string `json:"title"`
 	URL  string `json:"url"`
+	// Expression is the query of the dashboard using the metric. It is only set by the collectors recording the expressions.
+	Expression string `json:"expression,omitempty"`
+	// Version is the version of the dashboard analyzed, when the source is versioning the dashboards.
+	Version int64 `json:"version,omitempty"`
+	// Updated is the last time the dashboard analyzed has been updated, in RFC 3339 format. It is empty when unknown.
+	Updated string `json:"updated,omitempty"`
+	// Tags are the ones configured on the collector that has collected the dashboard.
+	Tags Tags `json:"tags,omitempty"`
+}
+
+// mergeDashboards merges the usage of the dashboards like MergeSet.
+// The usage coming from an older version of a dashboard than the one of the new usage is dropped,
+// so a dashboard edited is not reported once per version analyzed.
+func mergeDashboards(old, new Set[DashboardUsage]) Set[DashboardUsage] {
+	if new == nil || old == nil {
+		return MergeSet(old, new)
+	}
+	versions := make(map[string]int64)
+	for dashboard := range new {
+		versions[dashboard.URL] = max(versions[dashboard.URL], dashboard.Version)
+	}
+	s := Set[DashboardUsage]{}
+	for dashboard := range old {
+		if dashboard.Version < versions[dashboard.URL] {
+			continue
+		}
+		s.Add(dashboard)
+	}
+	s.Merge(new)
+	return s
+}
+
+// MetricExpressions associates each metric with the expressions using it.
+// A nil MetricExpressions records nothing, so it can be used when the expressions are not needed.
+type MetricExpressions map[string]Set[string]
+
+// Add records the expression as using every given metric.
+func (e MetricExpressions) Add(expr string, metrics Set[string]) {
+	if e == nil {
+		return
+	}
+	for metric := range metrics {
+		if _, ok := e[metric]; !ok {
+			e[metric] = NewSet[string]()
+		}
+		e[metric].Add(expr)
+	}
+}
+
+// Merge records every expression of other.
+func (e MetricExpressions) Merge(other MetricExpressions) {
+	for metric, expressions := range other {
+		for expr := range expressions {
+			e.Add(expr, NewSet(metric))
+		}
+	}
+}
+
+// GrafanaAlertUsage is a Grafana-managed alert rule (unified alerting) using the metric.
+type GrafanaAlertUsage struct {
+	ID        string `json:"uid"`
+	Name      string `json:"title"`
+	GroupName string `json:"group_name"`
+	URL       string `json:"url"`
+	// Tags are the ones configured on the collector that has collected the alert.
+	Tags Tags `json:"tags,omitempty"`
 }
 
 type MetricUsage struct {
-	Dashboards     Set[DashboardUsage] `json:"dashboards,omitempty"`
-	RecordingRules Set[RuleUsage]      `json:"recordingRules,omitempty"`
-	AlertRules     Set[RuleUsage]      `json:"alertRules,omitempty"`
+	Dashboards     Set[DashboardUsage]    `json:"dashboards,omitempty"`
+	RecordingRules Set[RuleUsage]         `json:"recordingRules,omitempty"`
+	AlertRules     Set[RuleUsage]         `json:"alertRules,omitempty"`
+	GrafanaAlerts  Set[GrafanaAlertUsage] `json:"grafanaAlerts,omitempty"`
+	// LastConfirmed is the last time each usage has been collected, indexed by the key of the usage (see UsageItem.Key).
+	// It is only set by the collectors reconciling their usage.
+	LastConfirmed map[string]time.Time `json:"lastConfirmed,omitempty"`
 }
 
 func MergeUsage(old, new *MetricUsage) *MetricUsage {
@@ -131,19 +231,273 @@ func MergeUsage(old, new *MetricUsage) *MetricUsage {
 		return old
 	}
 	return &MetricUsage{
-		Dashboards:     MergeSet(old.Dashboards, new.Dashboards),
+		Dashboards:     mergeDashboards(old.Dashboards, new.Dashboards),
 		AlertRules:     MergeSet(old.AlertRules, new.AlertRules),
 		RecordingRules: MergeSet(old.RecordingRules, new.RecordingRules),
+		GrafanaAlerts:  MergeSet(old.GrafanaAlerts, new.GrafanaAlerts),
+		LastConfirmed:  mergeLastConfirmed(old.LastConfirmed, new.LastConfirmed),
+	}
+}
+
+// MergeUsageInto merges every usage of src into dst. Both are indexed by metric name.
+func MergeUsageInto(dst, src map[string]*MetricUsage) {
+	for metricName, usage := range src {
+		dst[metricName] = MergeUsage(dst[metricName], usage)
+	}
+}
+
+// mergeLastConfirmed returns a new map where the most recent time is kept for each key.
+func mergeLastConfirmed(old, new map[string]time.Time) map[string]time.Time {
+	if new == nil {
+		return old
+	}
+	if old == nil {
+		return new
+	}
+	result := make(map[string]time.Time, len(old))
+	for k, v := range old {
+		result[k] = v
+	}
+	for k, v := range new {
+		if v.After(result[k]) {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// IsCoveredBy returns true if every dashboard, rule and Grafana alert of the usage is also part of the other usage.
+func (u *MetricUsage) IsCoveredBy(other *MetricUsage) bool {
+	if u == nil {
+		return true
+	}
+	if other == nil {
+		return false
+	}
+	return isSubset(u.Dashboards, other.Dashboards) &&
+		isSubset(u.RecordingRules, other.RecordingRules) &&
+		isSubset(u.AlertRules, other.AlertRules) &&
+		isSubset(u.GrafanaAlerts, other.GrafanaAlerts)
+}
+
+func isSubset[T comparable](s, other Set[T]) bool {
+	for v := range s {
+		if !other.Contains(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Sources returns the URLs of the Prometheus queried by the usage. It is only known for the rules, which are coming from the Prometheus evaluating them.
+// It returns nil when the usage has a dashboard or a Grafana alert, as the Prometheus behind their datasource is unknown.
+func (u *MetricUsage) Sources() Set[string] {
+	if u == nil || len(u.Dashboards) > 0 || len(u.GrafanaAlerts) > 0 {
+		return nil
 	}
+	var result Set[string]
+	for _, rules := range []Set[RuleUsage]{u.RecordingRules, u.AlertRules} {
+		for rule := range rules {
+			if len(rule.PromLink) == 0 {
+				return nil
+			}
+			if result == nil {
+				result = NewSet[string]()
+			}
+			result.Add(rule.PromLink)
+		}
+	}
+	return result
+}
+
+// DedupeRules returns a copy of the usage where the recording rules and the alert rules are deduplicated.
+// See the function DedupeRules for more details.
+func (u *MetricUsage) DedupeRules() *MetricUsage {
+	if u == nil {
+		return nil
+	}
+	return &MetricUsage{
+		Dashboards:     u.Dashboards,
+		RecordingRules: DedupeRules(u.RecordingRules),
+		AlertRules:     DedupeRules(u.AlertRules),
+		GrafanaAlerts:  u.GrafanaAlerts,
+		LastConfirmed:  u.LastConfirmed,
+	}
+}
+
+// UsageCount is the number of usages of a metric per kind. It is a summary of MetricUsage, cheaper to return and to sort on.
+type UsageCount struct {
+	Dashboards     int `json:"dashboards,omitempty"`
+	RecordingRules int `json:"recordingRules,omitempty"`
+	AlertRules     int `json:"alertRules,omitempty"`
+	GrafanaAlerts  int `json:"grafanaAlerts,omitempty"`
+}
+
+// Total returns the number of usages of every kind.
+func (c *UsageCount) Total() int {
+	if c == nil {
+		return 0
+	}
+	return c.Dashboards + c.RecordingRules + c.AlertRules + c.GrafanaAlerts
+}
+
+// Count returns the number of usages per kind, or nil if there is no usage.
+// A dashboard is counted once, even when it is reported once per expression or once per version.
+func (u *MetricUsage) Count() *UsageCount {
+	if u == nil {
+		return nil
+	}
+	dashboards := NewSet[DashboardUsage]()
+	for dashboard := range u.Dashboards {
+		dashboard.Expression = ""
+		dashboard.Version = 0
+		dashboard.Updated = ""
+		dashboards.Add(dashboard)
+	}
+	result := &UsageCount{
+		Dashboards:     len(dashboards),
+		RecordingRules: len(u.RecordingRules),
+		AlertRules:     len(u.AlertRules),
+		GrafanaAlerts:  len(u.GrafanaAlerts),
+	}
+	if result.Total() == 0 {
+		return nil
+	}
+	return result
+}
+
+type UsageKind string
+
+const (
+	DashboardUsageKind     UsageKind = "dashboard"
+	RecordingRuleUsageKind UsageKind = "recordingRule"
+	AlertRuleUsageKind     UsageKind = "alertRule"
+	GrafanaAlertUsageKind  UsageKind = "grafanaAlert"
+)
+
+// UsageItem is a flattened view of a single usage of a metric.
+type UsageItem struct {
+	Kind      UsageKind       `json:"kind"`
+	Dashboard *DashboardUsage `json:"dashboard,omitempty"`
+	Rule      *RuleUsage      `json:"rule,omitempty"`
+	// GrafanaAlert is set when the kind is grafanaAlert.
+	GrafanaAlert *GrafanaAlertUsage `json:"grafana_alert,omitempty"`
+	// PartialMetric is set when the usage is coming from a partial metric matching the metric.
+	PartialMetric string `json:"partial_metric,omitempty"`
+	// LastConfirmed is the last time the usage has been collected. It is only known for the collectors reconciling their usage.
+	LastConfirmed *time.Time `json:"last_confirmed,omitempty"`
+}
+
+// Flatten returns every usage contained in MetricUsage as a single list.
+// partialMetric is set on every item returned, it can be empty.
+func (u *MetricUsage) Flatten(partialMetric string) []UsageItem {
+	if u == nil {
+		return nil
+	}
+	var result []UsageItem
+	for dashboard := range u.Dashboards {
+		result = append(result, UsageItem{Kind: DashboardUsageKind, Dashboard: &dashboard, PartialMetric: partialMetric})
+	}
+	for rule := range u.RecordingRules {
+		result = append(result, UsageItem{Kind: RecordingRuleUsageKind, Rule: &rule, PartialMetric: partialMetric})
+	}
+	for rule := range u.AlertRules {
+		result = append(result, UsageItem{Kind: AlertRuleUsageKind, Rule: &rule, PartialMetric: partialMetric})
+	}
+	for alert := range u.GrafanaAlerts {
+		result = append(result, UsageItem{Kind: GrafanaAlertUsageKind, GrafanaAlert: &alert, PartialMetric: partialMetric})
+	}
+	for i := range result {
+		if lastConfirmed, ok := u.LastConfirmed[result[i].Key()]; ok {
+			result[i].LastConfirmed = &lastConfirmed
+		}
+	}
+	return result
+}
+
+// MetricMetadata is the metadata exposed by Prometheus for a metric.
+type MetricMetadata struct {
+	Type string `json:"type,omitempty"`
+	Help string `json:"help,omitempty"`
+}
+
+// SeriesNames returns the names of the series exposed by the metric family having this metadata.
+// The metadata is given per family, so the classic histograms and the summaries are expanded with their suffixes.
+func (m MetricMetadata) SeriesNames(family string) []string {
+	switch m.Type {
+	case "histogram", "gaugehistogram":
+		return []string{family + "_bucket", family + "_count", family + "_sum"}
+	case "summary":
+		return []string{family, family + "_count", family + "_sum"}
+	}
+	return []string{family}
+}
+
+// UsedLabels is the list of labels used by the dashboards, like in the Grafana variables label_values(metric, label).
+type UsedLabels struct {
+	// ByMetric is the list of labels used per metric.
+	ByMetric map[string][]string `json:"byMetric,omitempty"`
+	// Global is the list of labels used without a metric, like in label_values(label).
+	// They are considered used by every metric having them.
+	Global []string `json:"global,omitempty"`
 }
 
 type Metric struct {
-	Labels Set[string]  `json:"labels,omitempty"`
-	Usage  *MetricUsage `json:"usage,omitempty"`
+	Labels Set[string] `json:"labels,omitempty"`
+	// UsedLabels is the list of labels of the metric used by the dashboards.
+	UsedLabels Set[string] `json:"usedLabels,omitempty"`
+	Type       string      `json:"type,omitempty"`
+	Help       string      `json:"help,omitempty"`
+	// Sources is the list of the URLs of the Prometheus the metric has been collected from by the metric collectors.
+	// It is empty when the metric has only been found by other collectors, like the ones reading files.
+	Sources Set[string] `json:"sources,omitempty"`
+	// IsInternal is true when the metric is matching the classification rules of the internal metrics.
+	IsInternal bool `json:"is_internal,omitempty"`
+	// Owner is the team owning the metric, based on the ownership file of the classification.
+	// It is not stored, it is set when the metric is read.
+	Owner string       `json:"owner,omitempty"`
+	Usage *MetricUsage `json:"usage,omitempty"`
+	// UsageCount is the summary of Usage. It is not stored, the API computes it on the metrics it returns.
+	UsageCount *UsageCount `json:"usageCount,omitempty"`
+	// LastSeen is the last time the metric has been reported by a collector listing the metrics, their labels or their metadata.
+	// It is only recorded when the retention of the database is enabled.
+	LastSeen *time.Time `json:"lastSeen,omitempty"`
+}
+
+// NamedMetric is a metric with its name, used when the metrics are streamed one by one.
+type NamedMetric struct {
+	Name string `json:"name"`
+	*Metric
 }
 
 type PartialMetric struct {
 	Usage           *MetricUsage   `json:"usage,omitempty"`
 	MatchingMetrics Set[string]    `json:"matchingMetrics,omitempty"`
 	MatchingRegexp  *common.Regexp `json:"matchingRegexp,omitempty"`
+	// TooBroad is true when the partial metric is matching more metrics than the maximum configured.
+	// In this case, MatchingMetrics is empty.
+	TooBroad bool `json:"tooBroad,omitempty"`
+	// Sources is the list of the URLs of the Prometheus queried by the usage of the partial metric (see MetricUsage.Sources).
+	// When it is set, the partial metric only matches the metrics collected from one of them, or from an unknown source.
+	Sources Set[string] `json:"sources,omitempty"`
+}
+
+// IsMatchingSources returns true if the partial metric can match a metric collected from the given sources.
+// It is always the case when the sources of the partial metric or the ones of the metric are unknown.
+func (p *PartialMetric) IsMatchingSources(sources Set[string]) bool {
+	if len(p.Sources) == 0 || len(sources) == 0 {
+		return true
+	}
+	for source := range sources {
+		if p.Sources.Contains(source) {
+			return true
+		}
+	}
+	return false
+}
+
+// NamedPartialMetric is a partial metric with its name, used when the partial metrics are returned as a list.
+type NamedPartialMetric struct {
+	Name string `json:"name"`
+	*PartialMetric
 }
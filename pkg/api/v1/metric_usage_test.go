@@ -0,0 +1,212 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricExpressions(t *testing.T) {
+	var disabled MetricExpressions
+	disabled.Add("up", NewSet("up"))
+	assert.Nil(t, disabled)
+
+	expressions := MetricExpressions{}
+	expressions.Add("sum(up)", NewSet("up"))
+	expressions.Merge(MetricExpressions{"up": NewSet("rate(up[5m])"), "node_load1": NewSet("node_load1 > 1")})
+	assert.ElementsMatch(t, []string{"sum(up)", "rate(up[5m])"}, expressions["up"].TransformAsSlice())
+	assert.ElementsMatch(t, []string{"node_load1 > 1"}, expressions["node_load1"].TransformAsSlice())
+}
+
+func TestDashboardUsageItemKey(t *testing.T) {
+	dashboard := &DashboardUsage{ID: "a", URL: "http://perses/a"}
+	withoutExpression := UsageItem{Kind: DashboardUsageKind, Dashboard: dashboard}
+	withExpression := UsageItem{Kind: DashboardUsageKind, Dashboard: &DashboardUsage{ID: "a", URL: "http://perses/a", Expression: "sum(up)"}}
+	assert.NotEqual(t, withoutExpression.Key(), withExpression.Key())
+}
+
+func TestMetricUsageCount(t *testing.T) {
+	var unused *MetricUsage
+	assert.Nil(t, unused.Count())
+	assert.Nil(t, (&MetricUsage{}).Count())
+	usage := &MetricUsage{
+		Dashboards:    NewSet(DashboardUsage{ID: "a", Expression: "up"}, DashboardUsage{ID: "a", Expression: "sum(up)"}, DashboardUsage{ID: "b"}),
+		AlertRules:    NewSet(RuleUsage{Name: "down"}),
+		GrafanaAlerts: NewSet(GrafanaAlertUsage{ID: "c"}),
+	}
+	count := usage.Count()
+	assert.Equal(t, &UsageCount{Dashboards: 2, AlertRules: 1, GrafanaAlerts: 1}, count)
+	assert.Equal(t, 4, count.Total())
+}
+
+func TestMergeUsageDashboardVersion(t *testing.T) {
+	old := &MetricUsage{Dashboards: NewSet(
+		DashboardUsage{ID: "a", URL: "http://grafana/d/a", Expression: "up", Version: 41},
+		DashboardUsage{ID: "a", URL: "http://grafana/d/a", Expression: "sum(up)", Version: 41},
+		DashboardUsage{ID: "b", URL: "http://grafana/d/b", Version: 3},
+	)}
+	new := &MetricUsage{Dashboards: NewSet(
+		DashboardUsage{ID: "a", URL: "http://grafana/d/a", Expression: "up", Version: 42, Updated: "2024-03-01T10:00:00Z"},
+	)}
+	merged := MergeUsage(old, new)
+	assert.ElementsMatch(t, []DashboardUsage{
+		{ID: "a", URL: "http://grafana/d/a", Expression: "up", Version: 42, Updated: "2024-03-01T10:00:00Z"},
+		{ID: "b", URL: "http://grafana/d/b", Version: 3},
+	}, merged.Dashboards.TransformAsSlice())
+	assert.Equal(t, 2, merged.Count().Dashboards)
+}
+
+func TestMetricUsageSources(t *testing.T) {
+	rules := &MetricUsage{
+		RecordingRules: NewSet(RuleUsage{PromLink: "http://prometheus-a", Name: "record"}),
+		AlertRules:     NewSet(RuleUsage{PromLink: "http://prometheus-b", Name: "alert"}),
+	}
+	assert.Equal(t, NewSet("http://prometheus-a", "http://prometheus-b"), rules.Sources())
+	rules.Dashboards = NewSet(DashboardUsage{ID: "a"})
+	assert.Nil(t, rules.Sources())
+
+	partialMetric := &PartialMetric{Sources: NewSet("http://prometheus-a")}
+	assert.True(t, partialMetric.IsMatchingSources(nil))
+	assert.True(t, partialMetric.IsMatchingSources(NewSet("http://prometheus-a", "http://prometheus-b")))
+	assert.False(t, partialMetric.IsMatchingSources(NewSet("http://prometheus-b")))
+}
+
+func TestDedupeRules(t *testing.T) {
+	testSuites := []struct {
+		title  string
+		rules  Set[RuleUsage]
+		result Set[RuleUsage]
+	}{
+		{
+			title: "no rule",
+		},
+		{
+			title:  "single rule",
+			rules:  NewSet(RuleUsage{PromLink: "http://prometheus-a", GroupName: "node", Name: "NodeDown", Expression: "up == 0"}),
+			result: NewSet(RuleUsage{PromLink: "http://prometheus-a", GroupName: "node", Name: "NodeDown", Expression: "up == 0"}),
+		},
+		{
+			title: "same rule on several Prometheus keeps the smallest link",
+			rules: NewSet(
+				RuleUsage{PromLink: "http://prometheus-b", GroupName: "node", Name: "NodeDown", Expression: "up == 0"},
+				RuleUsage{PromLink: "http://prometheus-a", GroupName: "node", Name: "NodeDown", Expression: "up == 0"},
+				RuleUsage{PromLink: "http://prometheus-c", GroupName: "node", Name: "NodeDown", Expression: "up == 0"},
+			),
+			result: NewSet(RuleUsage{PromLink: "http://prometheus-a", GroupName: "node", Name: "NodeDown", Expression: "up == 0"}),
+		},
+		{
+			title: "different expressions are kept",
+			rules: NewSet(
+				RuleUsage{PromLink: "http://prometheus-a", GroupName: "node", Name: "NodeDown", Expression: "up == 0"},
+				RuleUsage{PromLink: "http://prometheus-b", GroupName: "node", Name: "NodeDown", Expression: `up{job="node"} == 0`},
+			),
+			result: NewSet(
+				RuleUsage{PromLink: "http://prometheus-a", GroupName: "node", Name: "NodeDown", Expression: "up == 0"},
+				RuleUsage{PromLink: "http://prometheus-b", GroupName: "node", Name: "NodeDown", Expression: `up{job="node"} == 0`},
+			),
+		},
+		{
+			title: "different groups are kept",
+			rules: NewSet(
+				RuleUsage{PromLink: "http://prometheus-a", GroupName: "node", Name: "NodeDown", Expression: "up == 0"},
+				RuleUsage{PromLink: "http://prometheus-b", GroupName: "node-backup", Name: "NodeDown", Expression: "up == 0"},
+			),
+			result: NewSet(
+				RuleUsage{PromLink: "http://prometheus-a", GroupName: "node", Name: "NodeDown", Expression: "up == 0"},
+				RuleUsage{PromLink: "http://prometheus-b", GroupName: "node-backup", Name: "NodeDown", Expression: "up == 0"},
+			),
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.result, DedupeRules(test.rules))
+			usage := (&MetricUsage{RecordingRules: test.rules, AlertRules: test.rules}).DedupeRules()
+			assert.Equal(t, test.result, usage.RecordingRules)
+			assert.Equal(t, test.result, usage.AlertRules)
+		})
+	}
+}
+
+func TestMetricUsageFlatten(t *testing.T) {
+	lastConfirmed := time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)
+	testSuites := []struct {
+		title         string
+		usage         *MetricUsage
+		partialMetric string
+		expected      []string
+		// expectedConfirmed is the list of keys expected to have a last confirmation.
+		expectedConfirmed []string
+	}{
+		{
+			title:    "nil usage",
+			expected: nil,
+		},
+		{
+			title:    "empty usage",
+			usage:    &MetricUsage{},
+			expected: nil,
+		},
+		{
+			title: "every kind of usage",
+			usage: &MetricUsage{
+				Dashboards:     NewSet(DashboardUsage{ID: "a", URL: "http://perses/a"}),
+				RecordingRules: NewSet(RuleUsage{PromLink: "http://prometheus", GroupName: "group", Name: "record"}),
+				AlertRules:     NewSet(RuleUsage{PromLink: "http://prometheus", GroupName: "group", Name: "alert"}),
+				GrafanaAlerts:  NewSet(GrafanaAlertUsage{URL: "http://grafana/alert"}),
+			},
+			expected: []string{
+				"dashboard:http://perses/a",
+				"recordingRule:http://prometheus/group/record/",
+				"alertRule:http://prometheus/group/alert/",
+				"grafanaAlert:http://grafana/alert",
+			},
+		},
+		{
+			title:         "usage coming from a partial metric",
+			usage:         &MetricUsage{Dashboards: NewSet(DashboardUsage{ID: "a", URL: "http://perses/a"})},
+			partialMetric: "foo_.+",
+			expected:      []string{"dashboard:http://perses/a"},
+		},
+		{
+			title: "last confirmation only set on the known usages",
+			usage: &MetricUsage{
+				Dashboards: NewSet(DashboardUsage{ID: "a", URL: "http://perses/a"}, DashboardUsage{ID: "b", URL: "http://perses/b"}),
+				LastConfirmed: map[string]time.Time{
+					"dashboard:http://perses/a": lastConfirmed,
+				},
+			},
+			expected:          []string{"dashboard:http://perses/a", "dashboard:http://perses/b"},
+			expectedConfirmed: []string{"dashboard:http://perses/a"},
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			var keys []string
+			var confirmed []string
+			for _, item := range test.usage.Flatten(test.partialMetric) {
+				keys = append(keys, item.Key())
+				assert.Equal(t, test.partialMetric, item.PartialMetric)
+				if item.LastConfirmed != nil {
+					assert.Equal(t, lastConfirmed, *item.LastConfirmed)
+					confirmed = append(confirmed, item.Key())
+				}
+			}
+			assert.ElementsMatch(t, test.expected, keys)
+			assert.ElementsMatch(t, test.expectedConfirmed, confirmed)
+		})
+	}
+}
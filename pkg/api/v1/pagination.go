@@ -0,0 +1,82 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+const DefaultPageSize = 100
+
+type PaginatedList[T any] struct {
+	// Total is the number of items available across all pages.
+	Total int `json:"total"`
+	// Page is the number of the current page, starting at 1.
+	Page  int `json:"page"`
+	Size  int `json:"size"`
+	Items []T `json:"items"`
+}
+
+// Paginate returns the requested page of the given items.
+// A page lower than 1 is considered as the first page, and a size lower than 1 is replaced by DefaultPageSize.
+func Paginate[T any](items []T, page int, size int) PaginatedList[T] {
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 {
+		size = DefaultPageSize
+	}
+	result := PaginatedList[T]{
+		Total: len(items),
+		Page:  page,
+		Size:  size,
+		Items: []T{},
+	}
+	start := (page - 1) * size
+	if start >= len(items) {
+		return result
+	}
+	result.Items = items[start:min(start+size, len(items))]
+	return result
+}
+
+type OffsetPaginatedList[T any] struct {
+	// Total is the number of items available before applying the offset and the limit.
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit,omitempty"`
+	Items  []T `json:"items"`
+}
+
+// PaginateWithOffset skips the first offset items and returns at most limit items.
+// A limit lower than 1 means there is no limit.
+func PaginateWithOffset[T any](items []T, offset int, limit int) OffsetPaginatedList[T] {
+	if offset < 0 {
+		offset = 0
+	}
+	if limit < 0 {
+		limit = 0
+	}
+	result := OffsetPaginatedList[T]{
+		Total:  len(items),
+		Offset: offset,
+		Limit:  limit,
+		Items:  []T{},
+	}
+	if offset >= len(items) {
+		return result
+	}
+	end := len(items)
+	if limit > 0 {
+		end = min(offset+limit, len(items))
+	}
+	result.Items = items[offset:end]
+	return result
+}
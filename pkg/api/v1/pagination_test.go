@@ -0,0 +1,95 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	tests := []struct {
+		title  string
+		page   int
+		size   int
+		result PaginatedList[int]
+	}{
+		{
+			title:  "default values",
+			result: PaginatedList[int]{Total: 5, Page: 1, Size: DefaultPageSize, Items: []int{1, 2, 3, 4, 5}},
+		},
+		{
+			title:  "first page",
+			page:   1,
+			size:   2,
+			result: PaginatedList[int]{Total: 5, Page: 1, Size: 2, Items: []int{1, 2}},
+		},
+		{
+			title:  "last page incomplete",
+			page:   3,
+			size:   2,
+			result: PaginatedList[int]{Total: 5, Page: 3, Size: 2, Items: []int{5}},
+		},
+		{
+			title:  "page out of range",
+			page:   4,
+			size:   2,
+			result: PaginatedList[int]{Total: 5, Page: 4, Size: 2, Items: []int{}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.result, Paginate(items, test.page, test.size))
+		})
+	}
+}
+
+func TestPaginateWithOffset(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+	tests := []struct {
+		title  string
+		offset int
+		limit  int
+		result OffsetPaginatedList[int]
+	}{
+		{
+			title:  "no limit",
+			result: OffsetPaginatedList[int]{Total: 5, Items: []int{1, 2, 3, 4, 5}},
+		},
+		{
+			title:  "offset and limit",
+			offset: 1,
+			limit:  2,
+			result: OffsetPaginatedList[int]{Total: 5, Offset: 1, Limit: 2, Items: []int{2, 3}},
+		},
+		{
+			title:  "limit bigger than the remaining items",
+			offset: 4,
+			limit:  2,
+			result: OffsetPaginatedList[int]{Total: 5, Offset: 4, Limit: 2, Items: []int{5}},
+		},
+		{
+			title:  "offset out of range",
+			offset: 5,
+			result: OffsetPaginatedList[int]{Total: 5, Offset: 5, Items: []int{}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.result, PaginateWithOffset(items, test.offset, test.limit))
+		})
+	}
+}
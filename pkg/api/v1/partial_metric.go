@@ -0,0 +1,166 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/sirupsen/logrus"
+)
+
+var replaceVariableRegexp = regexp.MustCompile(`\$\{[a-zA-Z0-9_:]+}`)
+
+// PartialMetricReason explains why a metric name has been classified as partial.
+type PartialMetricReason string
+
+const (
+	// RegexReason is used when the metric name is a regexp, like with the matcher {__name__=~"node_.+"}.
+	RegexReason PartialMetricReason = "regex"
+	// VariableReason is used when the metric name contains a variable that couldn't be replaced by a static value.
+	VariableReason PartialMetricReason = "variable"
+	// ParseFallbackReason is used when the expression couldn't be parsed and the metric name has been extracted by the fallback parser.
+	ParseFallbackReason PartialMetricReason = "parse_fallback"
+)
+
+// PartialMetrics associates each partial metric with the reason it has been classified as partial.
+type PartialMetrics map[string]PartialMetricReason
+
+// Add records the partial metric with the given reason. The first reason recorded for a metric is kept.
+func (p PartialMetrics) Add(metric string, reason PartialMetricReason) {
+	if _, ok := p[metric]; !ok {
+		p[metric] = reason
+	}
+}
+
+// AddSet records every metric of the set with the given reason.
+func (p PartialMetrics) AddSet(metrics Set[string], reason PartialMetricReason) {
+	for metric := range metrics {
+		p.Add(metric, reason)
+	}
+}
+
+func (p PartialMetrics) Merge(other PartialMetrics) {
+	for metric, reason := range other {
+		p.Add(metric, reason)
+	}
+}
+
+// Metrics returns the partial metrics without their reason.
+func (p PartialMetrics) Metrics() Set[string] {
+	result := make(Set[string], len(p))
+	for metric := range p {
+		result.Add(metric)
+	}
+	return result
+}
+
+// Log is logging, at debug level, the reason of each partial metric.
+func (p PartialMetrics) Log(logger *logrus.Entry) {
+	for metric, reason := range p {
+		logger.Debugf("the metric %q is partial, reason: %s", metric, reason)
+	}
+}
+
+// NormalizePartialMetricName returns the anchored regexp generated from the partial metric name (see PartialMetricRegexp).
+// Like that, the same partial metric has the same name, whether it comes from a variable like foo_${suffix} or from a regexp like foo_.*.
+// The name is returned unchanged when it is not possible to generate a regexp from it.
+func NormalizePartialMetricName(partialMetricName string) string {
+	re, err := PartialMetricRegexp(partialMetricName)
+	if err != nil || re == nil {
+		return partialMetricName
+	}
+	return re.String()
+}
+
+// PartialMetricRegexp is taking a partial metric name,
+// will replace every variable by a pattern and then returning a regepx if the final string is not just equal to .*.
+func PartialMetricRegexp(partialMetricName string) (*common.Regexp, error) {
+	// Anchors are added at the end, so we remove the ones that could already be there to avoid having them twice.
+	s := strings.TrimSuffix(strings.TrimPrefix(partialMetricName, "^"), "$")
+	// The first step is to replace every variable by a single special char.
+	// We are using a special single char because it will be easier to find if these chars are continuous
+	// or if there are other characters in between.
+	s = replaceVariableRegexp.ReplaceAllString(s, "#")
+	s = strings.ReplaceAll(s, ".+", "#")
+	s = strings.ReplaceAll(s, ".*", "#")
+	if s == "#" || len(s) == 0 {
+		// This means the metric name is just a variable and as such can match all metric.
+		// So it's basically impossible to know what this partial metric name is covering/matching.
+		return nil, nil
+	}
+	// The next step is to contact every continuous special char '#' to a single one.
+	// At the same time, the remaining dots are escaped as they are literal ones, otherwise they would match any char.
+	// A dot followed by a quantifier, like in foo_.?_total or node_.{2}_bytes, is kept as it is coming from a real regexp.
+	compileString := ""
+	expr := []rune(s)
+	for i := 0; i < len(expr); i++ {
+		if i > 0 && expr[i-1] == '#' && expr[i-1] == expr[i] {
+			continue
+		}
+		if expr[i] == '.' && (i == 0 || expr[i-1] != '\\') && !isFollowedByQuantifier(expr, i) {
+			compileString += `\.`
+			continue
+		}
+		compileString += string(expr[i])
+	}
+	if compileString == "#" {
+		return nil, nil
+	}
+	compileString = strings.ReplaceAll(compileString, "#", ".+")
+	if hasTopLevelAlternation(compileString) {
+		// Without a group, the anchors would only apply to the first and the last alternatives.
+		// An alternation already grouped is left as it is, so normalizing a name twice gives the same result.
+		compileString = fmt.Sprintf("(?:%s)", compileString)
+	}
+	re, err := common.NewRegexp(fmt.Sprintf("^%s$", compileString))
+	return &re, err
+}
+
+// isFollowedByQuantifier returns true if the char at the given position is followed by the quantifier ? or {n,m}.
+func isFollowedByQuantifier(expr []rune, i int) bool {
+	return i+1 < len(expr) && (expr[i+1] == '?' || expr[i+1] == '{')
+}
+
+// hasTopLevelAlternation returns true if the regexp contains a '|' that is neither escaped nor inside a group or a character class.
+func hasTopLevelAlternation(expr string) bool {
+	depth := 0
+	inClass := false
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '\\':
+			// The escaped char is skipped.
+			i++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '(':
+			if !inClass {
+				depth++
+			}
+		case ')':
+			if !inClass {
+				depth--
+			}
+		case '|':
+			if !inClass && depth == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
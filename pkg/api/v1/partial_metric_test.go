@@ -0,0 +1,149 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRegexp(re string) *common.Regexp {
+	r := common.MustNewRegexp(re)
+	return &r
+}
+
+func TestPartialMetricRegexp(t *testing.T) {
+	tests := []struct {
+		title         string
+		partialMetric string
+		result        *common.Regexp
+	}{
+		{
+			title:         "metric equal to a variable",
+			partialMetric: "${metric}",
+			result:        nil,
+		},
+		{
+			title:         "metric with variable a suffix",
+			partialMetric: "otelcol_exporter_enqueue_failed_log_records${suffix}",
+			result:        newRegexp(`^otelcol_exporter_enqueue_failed_log_records.+$`),
+		},
+		{
+			title:         "metric with multiple variable 1",
+			partialMetric: "${foo}${bar}${john}${doe}",
+			result:        nil,
+		},
+		{
+			title:         "metric with multiple variable 2",
+			partialMetric: "prefix_${foo}${bar}:collection_${collection}_suffix:${john}${doe}",
+			result:        newRegexp(`^prefix_.+:collection_.+_suffix:.+$`),
+		},
+		{
+			title:         "metric no variable",
+			partialMetric: "otelcol_receiver_.+",
+			result:        newRegexp(`^otelcol_receiver_.+$`),
+		},
+		{
+			title:         "metric with a literal dot",
+			partialMetric: "cpu.usage_${suffix}",
+			result:        newRegexp(`^cpu\.usage_.+$`),
+		},
+		{
+			title:         "metric with an optional char",
+			partialMetric: "foo_.?_total",
+			result:        newRegexp(`^foo_.?_total$`),
+		},
+		{
+			title:         "metric with a repeated char",
+			partialMetric: "node_.{2}_bytes",
+			result:        newRegexp(`^node_.{2}_bytes$`),
+		},
+		{
+			title:         "metric with a literal dot and a variable",
+			partialMetric: "cpu.${mode}.seconds",
+			result:        newRegexp(`^cpu\..+\.seconds$`),
+		},
+		{
+			title:         "metric with an alternation",
+			partialMetric: "foo_.+|bar_total",
+			result:        newRegexp(`^(?:foo_.+|bar_total)$`),
+		},
+		{
+			title:         "metric with a grouped alternation",
+			partialMetric: "^(?:a|b)_.+$",
+			result:        newRegexp(`^(?:a|b)_.+$`),
+		},
+		{
+			title:         "metric with an escaped pipe",
+			partialMetric: `foo\|bar_.+`,
+			result:        newRegexp(`^foo\|bar_.+$`),
+		},
+		{
+			title:         "metric with anchors",
+			partialMetric: "^node_cpu_.*$",
+			result:        newRegexp(`^node_cpu_.+$`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			re, err := PartialMetricRegexp(test.partialMetric)
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, re)
+		})
+	}
+}
+
+func TestNormalizePartialMetricNameTwice(t *testing.T) {
+	tests := []struct {
+		title         string
+		partialMetric string
+		result        string
+	}{
+		{
+			title:         "variable",
+			partialMetric: "node_${mode}_seconds",
+			result:        "^node_.+_seconds$",
+		},
+		{
+			title:         "literal dot",
+			partialMetric: "cpu.usage_${suffix}",
+			result:        `^cpu\.usage_.+$`,
+		},
+		{
+			title:         "alternation",
+			partialMetric: "foo_.+|bar_total",
+			result:        "^(?:foo_.+|bar_total)$",
+		},
+		{
+			title:         "grouped alternation",
+			partialMetric: "(?:a|b)_.+",
+			result:        "^(?:a|b)_.+$",
+		},
+		{
+			title:         "optional char",
+			partialMetric: "foo_.?_total",
+			result:        "^foo_.?_total$",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			normalized := NormalizePartialMetricName(test.partialMetric)
+			assert.Equal(t, test.result, normalized)
+			assert.Equal(t, normalized, NormalizePartialMetricName(normalized))
+		})
+	}
+}
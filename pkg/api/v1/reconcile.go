@@ -0,0 +1,144 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Key returns the identifier of the usage, used to index its last confirmation.
+// The recording rules and the alert rules are sharing the same structure, so the kind is part of the key.
+// The tags are only part of the key when there are some, so the keys of the usage without tags are unchanged.
+func (i UsageItem) Key() string {
+	key := string(i.Kind)
+	switch {
+	case i.Dashboard != nil && len(i.Dashboard.Expression) > 0:
+		key = fmt.Sprintf("%s:%s/%s", i.Kind, i.Dashboard.URL, i.Dashboard.Expression)
+	case i.Dashboard != nil:
+		key = fmt.Sprintf("%s:%s", i.Kind, i.Dashboard.URL)
+	case i.Rule != nil:
+		key = fmt.Sprintf("%s:%s/%s/%s/%s", i.Kind, i.Rule.PromLink, i.Rule.GroupName, i.Rule.Name, i.Rule.Expression)
+	case i.GrafanaAlert != nil:
+		key = fmt.Sprintf("%s:%s", i.Kind, i.GrafanaAlert.URL)
+	}
+	if tags := i.Tags(); len(tags) > 0 {
+		key = fmt.Sprintf("%s#%s", key, tags)
+	}
+	return key
+}
+
+// Tags returns the tags of the dashboard, of the rule or of the Grafana alert.
+func (i UsageItem) Tags() Tags {
+	switch {
+	case i.Dashboard != nil:
+		return i.Dashboard.Tags
+	case i.Rule != nil:
+		return i.Rule.Tags
+	case i.GrafanaAlert != nil:
+		return i.GrafanaAlert.Tags
+	}
+	return ""
+}
+
+// IsFrom returns true if the usage has been collected from the given source.
+// The source is the URL of the Prometheus for the rules, and the URL of the Grafana or of the Perses for the dashboards and the Grafana alerts.
+func (i UsageItem) IsFrom(source string) bool {
+	switch {
+	case i.Dashboard != nil:
+		return isURLFrom(i.Dashboard.URL, source)
+	case i.Rule != nil:
+		return i.Rule.PromLink == source
+	case i.GrafanaAlert != nil:
+		return isURLFrom(i.GrafanaAlert.URL, source)
+	}
+	return false
+}
+
+func isURLFrom(url string, source string) bool {
+	return url == source || strings.HasPrefix(url, strings.TrimSuffix(source, "/")+"/")
+}
+
+// Confirm sets the last confirmation of every usage to the given time.
+func (u *MetricUsage) Confirm(t time.Time) {
+	if u == nil {
+		return
+	}
+	if u.LastConfirmed == nil {
+		u.LastConfirmed = make(map[string]time.Time)
+	}
+	for _, item := range u.Flatten("") {
+		u.LastConfirmed[item.Key()] = t
+	}
+}
+
+// Prune returns a copy of the usage without the usages collected from the source that have not been confirmed since the deadline.
+// A usage never confirmed is considered as not confirmed. It returns nil if there is no usage left,
+// and the usage itself if nothing has been removed.
+func (u *MetricUsage) Prune(source string, deadline time.Time) *MetricUsage {
+	if u == nil {
+		return nil
+	}
+	items := u.Flatten("")
+	isExpired := func(item UsageItem) bool {
+		return item.IsFrom(source) && (item.LastConfirmed == nil || item.LastConfirmed.Before(deadline))
+	}
+	if !slices.ContainsFunc(items, isExpired) {
+		// Nothing to remove, the usage doesn't need to be copied.
+		return u
+	}
+	return newUsageFromItems(slices.DeleteFunc(items, isExpired))
+}
+
+// newUsageFromItems gathers the items, flattened by MetricUsage.Flatten, into a usage. It returns nil if there is no item.
+func newUsageFromItems(items []UsageItem) *MetricUsage {
+	result := &MetricUsage{}
+	for _, item := range items {
+		if item.LastConfirmed != nil {
+			if result.LastConfirmed == nil {
+				result.LastConfirmed = make(map[string]time.Time)
+			}
+			result.LastConfirmed[item.Key()] = *item.LastConfirmed
+		}
+		switch item.Kind {
+		case DashboardUsageKind:
+			result.Dashboards = addToSet(result.Dashboards, *item.Dashboard)
+		case RecordingRuleUsageKind:
+			result.RecordingRules = addToSet(result.RecordingRules, *item.Rule)
+		case AlertRuleUsageKind:
+			result.AlertRules = addToSet(result.AlertRules, *item.Rule)
+		case GrafanaAlertUsageKind:
+			result.GrafanaAlerts = addToSet(result.GrafanaAlerts, *item.GrafanaAlert)
+		}
+	}
+	if result.IsEmpty() {
+		return nil
+	}
+	return result
+}
+
+// IsEmpty returns true if the usage doesn't contain any dashboard, rule or Grafana alert.
+func (u *MetricUsage) IsEmpty() bool {
+	return u == nil || len(u.Dashboards) == 0 && len(u.RecordingRules) == 0 && len(u.AlertRules) == 0 && len(u.GrafanaAlerts) == 0
+}
+
+func addToSet[T comparable](s Set[T], value T) Set[T] {
+	if s == nil {
+		return NewSet(value)
+	}
+	s.Add(value)
+	return s
+}
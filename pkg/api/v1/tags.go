@@ -0,0 +1,125 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Tags are the free-form key/value pairs configured on the collector that has collected a usage, like the cluster or the environment.
+// They are stored as a JSON object with the keys sorted, so two usages with the same tags are equal and the usage can be part of a Set.
+// In JSON, they are encoded as a regular object.
+type Tags string
+
+// NewTags returns the tags containing the given key/value pairs. It is empty when there is no pair.
+func NewTags(tags map[string]string) Tags {
+	if len(tags) == 0 {
+		return ""
+	}
+	// A map of strings can always be encoded, and its keys are sorted by the encoding.
+	data, _ := json.Marshal(tags)
+	return Tags(data)
+}
+
+// ParseTagMatchers parses the tags given as key=value, like the ones of the query parameter tag.
+func ParseTagMatchers(matchers []string) (map[string]string, error) {
+	if len(matchers) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]string, len(matchers))
+	for _, matcher := range matchers {
+		key, value, ok := strings.Cut(matcher, "=")
+		if !ok || len(key) == 0 {
+			return nil, fmt.Errorf("invalid tag %q, it must be key=value", matcher)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// Map returns the key/value pairs of the tags, or nil if there is none.
+func (t Tags) Map() map[string]string {
+	if len(t) == 0 {
+		return nil
+	}
+	var result map[string]string
+	if err := json.Unmarshal([]byte(t), &result); err != nil {
+		return nil
+	}
+	return result
+}
+
+// Contains returns true if every given key/value pair is part of the tags.
+func (t Tags) Contains(tags map[string]string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	m := t.Map()
+	for key, value := range tags {
+		if v, ok := m[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (t Tags) MarshalJSON() ([]byte, error) {
+	if len(t) == 0 {
+		return []byte("{}"), nil
+	}
+	return []byte(t), nil
+}
+
+func (t *Tags) UnmarshalJSON(data []byte) error {
+	var tags map[string]string
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return err
+	}
+	*t = NewTags(tags)
+	return nil
+}
+
+// WithTags returns a copy of the usage where every dashboard, rule and Grafana alert has the given tags.
+// It returns the usage itself when there is no tag.
+func (u *MetricUsage) WithTags(tags Tags) *MetricUsage {
+	if u == nil || len(tags) == 0 {
+		return u
+	}
+	items := u.Flatten("")
+	for i := range items {
+		switch {
+		case items[i].Dashboard != nil:
+			items[i].Dashboard.Tags = tags
+		case items[i].Rule != nil:
+			items[i].Rule.Tags = tags
+		case items[i].GrafanaAlert != nil:
+			items[i].GrafanaAlert.Tags = tags
+		}
+	}
+	return newUsageFromItems(items)
+}
+
+// FilterTags returns a copy of the usage keeping only the dashboards, rules and Grafana alerts having every given tag.
+// It returns nil if there is no usage left, and the usage itself when there is no tag to match.
+func (u *MetricUsage) FilterTags(tags map[string]string) *MetricUsage {
+	if u == nil || len(tags) == 0 {
+		return u
+	}
+	return newUsageFromItems(slices.DeleteFunc(u.Flatten(""), func(item UsageItem) bool {
+		return !item.Tags().Contains(tags)
+	}))
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagsJSON(t *testing.T) {
+	tags := NewTags(map[string]string{"env": "prod", "cluster": "prod-eu"})
+	assert.Equal(t, Tags(`{"cluster":"prod-eu","env":"prod"}`), tags)
+	assert.Equal(t, Tags(""), NewTags(nil))
+
+	data, err := json.Marshal(DashboardUsage{ID: "dashboard", Tags: tags})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"uid":"dashboard","title":"","url":"","tags":{"cluster":"prod-eu","env":"prod"}}`, string(data))
+	data, err = json.Marshal(DashboardUsage{ID: "dashboard"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"uid":"dashboard","title":"","url":""}`, string(data))
+
+	// The tags are normalized when they are decoded, so the usage is the same whatever the order of the keys.
+	var dashboard DashboardUsage
+	require.NoError(t, json.Unmarshal([]byte(`{"uid":"dashboard","tags":{"env":"prod","cluster":"prod-eu"}}`), &dashboard))
+	assert.Equal(t, DashboardUsage{ID: "dashboard", Tags: tags}, dashboard)
+}
+
+func TestParseTagMatchers(t *testing.T) {
+	matchers, err := ParseTagMatchers([]string{"cluster=prod-eu", "team=a=b", "empty="})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"cluster": "prod-eu", "team": "a=b", "empty": ""}, matchers)
+	_, err = ParseTagMatchers([]string{"cluster"})
+	assert.EqualError(t, err, `invalid tag "cluster", it must be key=value`)
+	_, err = ParseTagMatchers([]string{"=prod"})
+	assert.Error(t, err)
+}
+
+func TestWithTagsAndFilterTags(t *testing.T) {
+	prodEU := NewTags(map[string]string{"cluster": "prod-eu", "env": "prod"})
+	now := time.Now()
+	usage := &MetricUsage{
+		Dashboards: NewSet(DashboardUsage{ID: "dashboard", URL: "https://grafana.eu/d/dashboard"}),
+		AlertRules: NewSet(RuleUsage{PromLink: "https://prometheus.eu", Name: "HighLoad"}),
+	}
+	tagged := usage.WithTags(prodEU)
+	assert.Equal(t, &MetricUsage{
+		Dashboards: NewSet(DashboardUsage{ID: "dashboard", URL: "https://grafana.eu/d/dashboard", Tags: prodEU}),
+		AlertRules: NewSet(RuleUsage{PromLink: "https://prometheus.eu", Name: "HighLoad", Tags: prodEU}),
+	}, tagged)
+	// The usage given is not modified.
+	assert.Equal(t, Tags(""), usage.Flatten("")[0].Tags())
+	assert.Same(t, usage, usage.WithTags(""))
+
+	// The tags are part of the key of the usage, so the same dashboard collected in two clusters is confirmed separately.
+	tagged.Confirm(now)
+	assert.Equal(t, map[string]time.Time{
+		`dashboard:https://grafana.eu/d/dashboard#{"cluster":"prod-eu","env":"prod"}`:   now,
+		`alertRule:https://prometheus.eu//HighLoad/#{"cluster":"prod-eu","env":"prod"}`: now,
+	}, tagged.LastConfirmed)
+
+	merged := MergeUsage(usage, tagged)
+	assert.Len(t, merged.Dashboards, 2)
+	assert.Equal(t, tagged, merged.FilterTags(map[string]string{"cluster": "prod-eu"}))
+	assert.Equal(t, tagged, merged.FilterTags(map[string]string{"cluster": "prod-eu", "env": "prod"}))
+	assert.Nil(t, merged.FilterTags(map[string]string{"cluster": "prod-us"}))
+	assert.Same(t, merged, merged.FilterTags(nil))
+}
+
+func TestDedupeRulesWithTags(t *testing.T) {
+	prodEU := NewTags(map[string]string{"cluster": "prod-eu"})
+	rules := NewSet(
+		RuleUsage{PromLink: "https://prometheus-0.eu", Name: "HighLoad", Tags: prodEU},
+		RuleUsage{PromLink: "https://prometheus-1.eu", Name: "HighLoad", Tags: prodEU},
+		RuleUsage{PromLink: "https://prometheus.us", Name: "HighLoad"},
+	)
+	assert.Equal(t, NewSet(
+		RuleUsage{PromLink: "https://prometheus-0.eu", Name: "HighLoad", Tags: prodEU},
+		RuleUsage{PromLink: "https://prometheus.us", Name: "HighLoad"},
+	), DedupeRules(rules))
+}
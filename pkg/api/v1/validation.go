@@ -0,0 +1,97 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ValidationError describes why a field of a pushed payload is not valid.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func prefixErrors(prefix string, errs []ValidationError) []ValidationError {
+	for i := range errs {
+		errs[i].Field = fmt.Sprintf("%s.%s", prefix, errs[i].Field)
+	}
+	return errs
+}
+
+func (d DashboardUsage) Validate() []ValidationError {
+	var errs []ValidationError
+	if len(d.ID) == 0 {
+		errs = append(errs, ValidationError{Field: "uid", Message: "must not be empty"})
+	}
+	return errs
+}
+
+func (r RuleUsage) Validate() []ValidationError {
+	var errs []ValidationError
+	if len(r.Name) == 0 {
+		errs = append(errs, ValidationError{Field: "name", Message: "must not be empty"})
+	}
+	return errs
+}
+
+func (a GrafanaAlertUsage) Validate() []ValidationError {
+	var errs []ValidationError
+	if len(a.ID) == 0 {
+		errs = append(errs, ValidationError{Field: "uid", Message: "must not be empty"})
+	}
+	return errs
+}
+
+func (u *MetricUsage) Validate() []ValidationError {
+	if u == nil {
+		return nil
+	}
+	var errs []ValidationError
+	for dashboard := range u.Dashboards {
+		errs = append(errs, prefixErrors(fmt.Sprintf("dashboards[%q]", dashboard.URL), dashboard.Validate())...)
+	}
+	for rule := range u.RecordingRules {
+		errs = append(errs, prefixErrors(fmt.Sprintf("recordingRules[%q]", rule.GroupName), rule.Validate())...)
+	}
+	for rule := range u.AlertRules {
+		errs = append(errs, prefixErrors(fmt.Sprintf("alertRules[%q]", rule.GroupName), rule.Validate())...)
+	}
+	for alert := range u.GrafanaAlerts {
+		errs = append(errs, prefixErrors(fmt.Sprintf("grafanaAlerts[%q]", alert.URL), alert.Validate())...)
+	}
+	return errs
+}
+
+// ValidateUsage validates every usage of the map, indexed by the metric name.
+// The errors are sorted by field to have a stable result.
+func ValidateUsage(usages map[string]*MetricUsage) []ValidationError {
+	var errs []ValidationError
+	for metricName, usage := range usages {
+		if len(metricName) == 0 {
+			errs = append(errs, ValidationError{Field: "metric_name", Message: "must not be empty"})
+		}
+		errs = append(errs, prefixErrors(metricName, usage.Validate())...)
+	}
+	slices.SortFunc(errs, func(a, b ValidationError) int {
+		return strings.Compare(a.Field, b.Field)
+	})
+	return errs
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateUsage(t *testing.T) {
+	tests := []struct {
+		title  string
+		usages map[string]*MetricUsage
+		result []ValidationError
+	}{
+		{
+			title: "valid usage",
+			usages: map[string]*MetricUsage{
+				"up": {
+					Dashboards:     NewSet(DashboardUsage{ID: "foo", Name: "Foo", URL: "https://grafana/d/foo"}),
+					RecordingRules: NewSet(RuleUsage{GroupName: "group", Name: "job:up:sum"}),
+				},
+				"node_cpu_seconds_total": nil,
+			},
+		},
+		{
+			title: "invalid usage",
+			usages: map[string]*MetricUsage{
+				"up": {
+					Dashboards:    NewSet(DashboardUsage{Name: "Foo", URL: "https://grafana/d/foo"}),
+					AlertRules:    NewSet(RuleUsage{GroupName: "group"}),
+					GrafanaAlerts: NewSet(GrafanaAlertUsage{Name: "High error rate", URL: "https://grafana/alerting/grafana/foo/view"}),
+				},
+			},
+			result: []ValidationError{
+				{Field: `up.alertRules["group"].name`, Message: "must not be empty"},
+				{Field: `up.dashboards["https://grafana/d/foo"].uid`, Message: "must not be empty"},
+				{Field: `up.grafanaAlerts["https://grafana/alerting/grafana/foo/view"].uid`, Message: "must not be empty"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.result, ValidateUsage(test.usages))
+		})
+	}
+}
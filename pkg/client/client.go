@@ -16,83 +16,166 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/perses/metrics-usage/config"
 	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
 )
 
+// idempotencyKeyHeader is the header used by the server to skip the requests already processed.
+const idempotencyKeyHeader = "Idempotency-Key"
+
 type Client interface {
 	Usage(map[string]*modelAPIV1.MetricUsage) error
 	PartialMetricsUsage(metrics map[string]*modelAPIV1.MetricUsage) error
 	Labels(map[string][]string) error
+	UsedLabels(usedLabels *modelAPIV1.UsedLabels) error
 }
 
-func New(cfg config.HTTPClient) (Client, error) {
-	httpClient, err := config.NewHTTPClient(cfg)
+func New(cfg config.MetricUsageClient) (Client, error) {
+	httpClient, err := config.NewHTTPClient(cfg.HTTPClient, "metric_usage_client")
 	if err != nil {
 		return nil, err
 	}
+	concurrency := int(cfg.BatchConcurrency)
+	if concurrency < 1 {
+		concurrency = 1
+	}
 	return &client{
-		endpoint:   cfg.URL.URL,
-		httpClient: httpClient,
+		endpoint:    cfg.URL.URL,
+		httpClient:  httpClient,
+		batchSize:   int(cfg.BatchSize),
+		concurrency: concurrency,
+		retry:       cfg.Retry,
+		retryWait:   time.Second,
 	}, nil
 }
 
 type client struct {
-	endpoint   *url.URL
-	httpClient *http.Client
+	endpoint    *url.URL
+	httpClient  *http.Client
+	batchSize   int
+	concurrency int
+	retry       uint
+	// retryWait is the time waited before the first retry, when the server doesn't ask for a delay. It increases linearly with each retry.
+	retryWait time.Duration
 }
 
 func (c *client) Usage(metrics map[string]*modelAPIV1.MetricUsage) error {
-	data, err := json.Marshal(metrics)
-	if err != nil {
-		return err
+	return sendInBatches(c, "/api/v1/metrics", "metrics usage", metrics)
+}
+
+func (c *client) PartialMetricsUsage(metrics map[string]*modelAPIV1.MetricUsage) error {
+	return sendInBatches(c, "/api/v1/partial_metrics", "metrics usage", metrics)
+}
+
+func (c *client) Labels(labels map[string][]string) error {
+	return sendInBatches(c, "/api/v1/labels", "label names", labels)
+}
+
+func (c *client) UsedLabels(usedLabels *modelAPIV1.UsedLabels) error {
+	return c.post("/api/v1/used_labels", "used labels", usedLabels)
+}
+
+// sendInBatches splits the data in batches of the configured size and sends them using at most c.concurrency requests in parallel.
+// The server is merging the data received, so it doesn't matter if the data are sent in one or several requests.
+// Every batch is sent even if one fails, and all the errors are returned.
+func sendInBatches[T any](c *client, ep string, kind string, data map[string]T) error {
+	batches := splitInBatches(data, c.batchSize)
+	errs := make([]error, len(batches))
+	semaphore := make(chan struct{}, c.concurrency)
+	var wg sync.WaitGroup
+	for i, batch := range batches {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			errs[i] = c.post(ep, kind, batch)
+		}()
 	}
-	body := bytes.NewBuffer(data)
-	resp, err := c.httpClient.Post(c.url("/api/v1/metrics").String(), "application/json", body)
-	if err != nil {
-		return err
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func splitInBatches[T any](data map[string]T, size int) []map[string]T {
+	if size <= 0 || len(data) <= size {
+		return []map[string]T{data}
 	}
-	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusPartialContent {
-		return fmt.Errorf("when sending metrics usage, unexpected status code: %d", resp.StatusCode)
+	var batches []map[string]T
+	batch := make(map[string]T, size)
+	for k, v := range data {
+		batch[k] = v
+		if len(batch) == size {
+			batches = append(batches, batch)
+			batch = make(map[string]T, size)
+		}
 	}
-	return nil
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+	return batches
 }
 
-func (c *client) PartialMetricsUsage(metrics map[string]*modelAPIV1.MetricUsage) error {
-	data, err := json.Marshal(metrics)
-	if err != nil {
-		return err
-	}
-	body := bytes.NewBuffer(data)
-	resp, err := c.httpClient.Post(c.url("/api/v1/partial_metrics").String(), "application/json", body)
+// post sends the data, and sends them again when the server is not reachable, busy or failing.
+// Every attempt carries the same idempotency key, so the server doesn't process the data twice if an attempt actually succeeded.
+func (c *client) post(ep string, kind string, data any) error {
+	body, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
-	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusPartialContent {
-		return fmt.Errorf("when sending metrics usage, unexpected status code: %d", resp.StatusCode)
+	key := uuid.NewString()
+	waitDuration := c.retryWait
+	for retry := c.retry; ; retry-- {
+		retryAfter, sendErr := c.send(ep, kind, key, body)
+		if sendErr == nil || retryAfter < 0 || retry == 0 {
+			return sendErr
+		}
+		if retryAfter == 0 {
+			retryAfter = waitDuration
+			waitDuration += c.retryWait
+		}
+		time.Sleep(retryAfter)
 	}
-	return nil
 }
 
-func (c *client) Labels(labels map[string][]string) error {
-	data, err := json.Marshal(labels)
+// send does a single attempt to post the body.
+// When it fails, it returns as well the delay asked by the server before retrying (0 when none is asked), or a negative one when the request must not be retried.
+func (c *client) send(ep string, kind string, key string, body []byte) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, c.url(ep).String(), bytes.NewReader(body))
 	if err != nil {
-		return err
+		return -1, err
 	}
-	body := bytes.NewBuffer(data)
-	resp, err := c.httpClient.Post(c.url("/api/v1/labels").String(), "application/json", body)
+	req.Header.Set("Content-Type", "application/json")
+	// The key identifies the request, so the server doesn't process it twice if the request is retried.
+	req.Header.Set(idempotencyKeyHeader, key)
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode <= http.StatusPartialContent {
+		return 0, nil
+	}
+	err = fmt.Errorf("when sending %s, unexpected status code: %d", kind, resp.StatusCode)
+	// The status 409 means that a previous attempt is still being processed, and may still fail.
+	if resp.StatusCode != http.StatusConflict && resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < http.StatusInternalServerError {
+		return -1, err
 	}
-	if resp.StatusCode < http.StatusOK || resp.StatusCode > http.StatusPartialContent {
-		return fmt.Errorf("when sending label names, unexpected status code: %d", resp.StatusCode)
+	if seconds, parseErr := strconv.Atoi(resp.Header.Get("Retry-After")); parseErr == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, err
 	}
-	return nil
+	return 0, err
 }
 
 func (c *client) url(ep string) *url.URL {
@@ -0,0 +1,146 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/perses/metrics-usage/config"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitInBatches(t *testing.T) {
+	data := map[string]int{"a": 1, "b": 2, "c": 3, "d": 4, "e": 5}
+	testSuite := []struct {
+		title        string
+		size         int
+		nbBatches    int
+		maxBatchSize int
+	}{
+		{title: "no batch size", size: 0, nbBatches: 1, maxBatchSize: 5},
+		{title: "batch size bigger than the data", size: 10, nbBatches: 1, maxBatchSize: 5},
+		{title: "batch size dividing the data", size: 1, nbBatches: 5, maxBatchSize: 1},
+		{title: "last batch incomplete", size: 2, nbBatches: 3, maxBatchSize: 2},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			batches := splitInBatches(data, test.size)
+			assert.Len(t, batches, test.nbBatches)
+			merged := make(map[string]int)
+			for _, batch := range batches {
+				assert.LessOrEqual(t, len(batch), test.maxBatchSize)
+				for k, v := range batch {
+					merged[k] = v
+				}
+			}
+			assert.Equal(t, data, merged)
+		})
+	}
+}
+
+func TestUsageInBatches(t *testing.T) {
+	var mutex sync.Mutex
+	received := make(map[string]*modelAPIV1.MetricUsage)
+	nbRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := make(map[string]*modelAPIV1.MetricUsage)
+		if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		nbRequests++
+		for k, v := range data {
+			received[k] = v
+		}
+		if _, ok := data["metric_2"]; ok {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	u, err := common.ParseURL(server.URL)
+	require.NoError(t, err)
+	c, err := New(config.MetricUsageClient{HTTPClient: config.HTTPClient{URL: u}, BatchSize: 2, BatchConcurrency: 2})
+	require.NoError(t, err)
+
+	usage := make(map[string]*modelAPIV1.MetricUsage)
+	for i := 0; i < 5; i++ {
+		usage[fmt.Sprintf("metric_%d", i)] = &modelAPIV1.MetricUsage{}
+	}
+	err = c.Usage(usage)
+	assert.EqualError(t, err, "when sending metrics usage, unexpected status code: 413")
+	assert.Equal(t, 3, nbRequests)
+	assert.Equal(t, usage, received)
+}
+
+func TestClientWithPathPrefix(t *testing.T) {
+	var path, idempotencyKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		idempotencyKey = r.Header.Get(idempotencyKeyHeader)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	u, err := common.ParseURL(server.URL + "/metrics-usage/")
+	require.NoError(t, err)
+	c, err := New(config.MetricUsageClient{HTTPClient: config.HTTPClient{URL: u}})
+	require.NoError(t, err)
+	require.NoError(t, c.Labels(map[string][]string{"up": {"job"}}))
+	assert.Equal(t, "/metrics-usage/api/v1/labels", path)
+	assert.NotEmpty(t, idempotencyKey)
+}
+
+func TestRetryWithSameIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(idempotencyKeyHeader))
+		if len(keys) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	u, err := common.ParseURL(server.URL)
+	require.NoError(t, err)
+	c, err := New(config.MetricUsageClient{HTTPClient: config.HTTPClient{URL: u}, Retry: 3})
+	require.NoError(t, err)
+	c.(*client).retryWait = time.Millisecond
+	require.NoError(t, c.Labels(map[string][]string{"up": {"job"}}))
+	require.Len(t, keys, 3)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+	assert.Equal(t, keys[0], keys[2])
+
+	// A request rejected because of its content is not sent again.
+	keys = nil
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get(idempotencyKeyHeader))
+		w.WriteHeader(http.StatusBadRequest)
+	})
+	assert.EqualError(t, c.Labels(map[string][]string{"up": {"job"}}), "when sending label names, unexpected status code: 400")
+	assert.Len(t, keys, 1)
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package parquet flattens the metrics and their usage into rows following a stable schema, one row per metric,
+// and writes them as a Parquet file, so they can be ingested in a data warehouse.
+package parquet
+
+import (
+	"cmp"
+	"io"
+	"slices"
+
+	"github.com/parquet-go/parquet-go"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// ContentType is the media type of the Parquet files.
+const ContentType = "application/vnd.apache.parquet"
+
+type Dashboard struct {
+	UID        string `parquet:"uid"`
+	Title      string `parquet:"title"`
+	URL        string `parquet:"url"`
+	Expression string `parquet:"expression,optional"`
+	// Tags is the JSON object of the tags of the collector, with the keys sorted.
+	Tags string `parquet:"tags,optional"`
+}
+
+type Rule struct {
+	PromLink   string `parquet:"prom_link"`
+	GroupName  string `parquet:"group_name"`
+	Name       string `parquet:"name"`
+	Expression string `parquet:"expression"`
+	Tags       string `parquet:"tags,optional"`
+}
+
+type GrafanaAlert struct {
+	UID       string `parquet:"uid"`
+	Title     string `parquet:"title"`
+	GroupName string `parquet:"group_name"`
+	URL       string `parquet:"url"`
+	Tags      string `parquet:"tags,optional"`
+}
+
+// Row is a metric flattened with its usage. The columns must only be added, never renamed or removed, to keep the schema stable.
+type Row struct {
+	Name       string `parquet:"name"`
+	Type       string `parquet:"type,optional"`
+	Help       string `parquet:"help,optional"`
+	IsInternal bool   `parquet:"is_internal"`
+	Owner      string `parquet:"owner,optional"`
+	// LastSeen is the number of milliseconds since the epoch. It is null when the retention of the database is disabled.
+	LastSeen   int64    `parquet:"last_seen,optional,timestamp(millisecond)"`
+	Labels     []string `parquet:"labels,list"`
+	UsedLabels []string `parquet:"used_labels,list"`
+	// The counts are the ones returned in the field usageCount of the API, the dashboards are counted once whatever the number of queries.
+	DashboardCount     int64          `parquet:"dashboard_count"`
+	RecordingRuleCount int64          `parquet:"recording_rule_count"`
+	AlertRuleCount     int64          `parquet:"alert_rule_count"`
+	GrafanaAlertCount  int64          `parquet:"grafana_alert_count"`
+	Dashboards         []Dashboard    `parquet:"dashboards,list"`
+	RecordingRules     []Rule         `parquet:"recording_rules,list"`
+	AlertRules         []Rule         `parquet:"alert_rules,list"`
+	GrafanaAlerts      []GrafanaAlert `parquet:"grafana_alerts,list"`
+}
+
+// NewRow flattens the metric. The repeated columns are sorted, so the same metric always gives the same row.
+func NewRow(name string, metric *v1.Metric) Row {
+	row := Row{
+		Name:       name,
+		Type:       metric.Type,
+		Help:       metric.Help,
+		IsInternal: metric.IsInternal,
+		Owner:      metric.Owner,
+		Labels:     sortedStrings(metric.Labels),
+		UsedLabels: sortedStrings(metric.UsedLabels),
+	}
+	if metric.LastSeen != nil {
+		row.LastSeen = metric.LastSeen.UnixMilli()
+	}
+	if metric.Usage == nil {
+		return row
+	}
+	if count := metric.Usage.Count(); count != nil {
+		row.DashboardCount = int64(count.Dashboards)
+		row.RecordingRuleCount = int64(count.RecordingRules)
+		row.AlertRuleCount = int64(count.AlertRules)
+		row.GrafanaAlertCount = int64(count.GrafanaAlerts)
+	}
+	for dashboard := range metric.Usage.Dashboards {
+		row.Dashboards = append(row.Dashboards, Dashboard{UID: dashboard.ID, Title: dashboard.Name, URL: dashboard.URL, Expression: dashboard.Expression, Tags: string(dashboard.Tags)})
+	}
+	slices.SortFunc(row.Dashboards, func(a, b Dashboard) int {
+		return cmp.Or(cmp.Compare(a.UID, b.UID), cmp.Compare(a.URL, b.URL), cmp.Compare(a.Expression, b.Expression), cmp.Compare(a.Tags, b.Tags))
+	})
+	row.RecordingRules = convertRules(metric.Usage.RecordingRules)
+	row.AlertRules = convertRules(metric.Usage.AlertRules)
+	for alert := range metric.Usage.GrafanaAlerts {
+		row.GrafanaAlerts = append(row.GrafanaAlerts, GrafanaAlert{UID: alert.ID, Title: alert.Name, GroupName: alert.GroupName, URL: alert.URL, Tags: string(alert.Tags)})
+	}
+	slices.SortFunc(row.GrafanaAlerts, func(a, b GrafanaAlert) int {
+		return cmp.Or(cmp.Compare(a.URL, b.URL), cmp.Compare(a.UID, b.UID), cmp.Compare(a.Tags, b.Tags))
+	})
+	return row
+}
+
+func convertRules(rules v1.Set[v1.RuleUsage]) []Rule {
+	var result []Rule
+	for rule := range rules {
+		result = append(result, Rule{PromLink: rule.PromLink, GroupName: rule.GroupName, Name: rule.Name, Expression: rule.Expression, Tags: string(rule.Tags)})
+	}
+	slices.SortFunc(result, func(a, b Rule) int {
+		return cmp.Or(cmp.Compare(a.PromLink, b.PromLink), cmp.Compare(a.GroupName, b.GroupName), cmp.Compare(a.Name, b.Name), cmp.Compare(a.Expression, b.Expression), cmp.Compare(a.Tags, b.Tags))
+	})
+	return result
+}
+
+func sortedStrings(set v1.Set[string]) []string {
+	result := set.TransformAsSlice()
+	slices.Sort(result)
+	return result
+}
+
+// Writer writes the metrics in a Parquet file as they are given, so the whole list of metrics is never held in memory.
+// Close must be called to write the footer of the file.
+type Writer struct {
+	writer *parquet.GenericWriter[Row]
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{writer: parquet.NewGenericWriter[Row](w)}
+}
+
+func (w *Writer) Write(name string, metric *v1.Metric) error {
+	_, err := w.writer.Write([]Row{NewRow(name, metric)})
+	return err
+}
+
+func (w *Writer) Close() error {
+	return w.writer.Close()
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parquet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	lastSeen := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	metrics := []v1.NamedMetric{
+		{
+			Name: "http_requests_total",
+			Metric: &v1.Metric{
+				Type:     "counter",
+				Labels:   v1.NewSet("job", "code"),
+				Owner:    "web",
+				LastSeen: &lastSeen,
+				Usage: &v1.MetricUsage{
+					Dashboards: v1.NewSet(
+						v1.DashboardUsage{ID: "b", Name: "B", URL: "https://grafana/b", Expression: "sum(http_requests_total)"},
+						v1.DashboardUsage{ID: "a", Name: "A", URL: "https://grafana/a"},
+						v1.DashboardUsage{ID: "b", Name: "B", URL: "https://grafana/b", Expression: "rate(http_requests_total[5m])"},
+					),
+					AlertRules: v1.NewSet(v1.RuleUsage{PromLink: "https://prometheus", GroupName: "web", Name: "HighErrorRate", Expression: "rate(http_requests_total[5m]) > 1"}),
+				},
+			},
+		},
+		{Name: "up", Metric: &v1.Metric{}},
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, metric := range metrics {
+		require.NoError(t, w.Write(metric.Name, metric.Metric))
+	}
+	require.NoError(t, w.Close())
+
+	rows, err := parquet.Read[Row](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "http_requests_total", rows[0].Name)
+	assert.Equal(t, "counter", rows[0].Type)
+	assert.Equal(t, "web", rows[0].Owner)
+	assert.Equal(t, []string{"code", "job"}, rows[0].Labels)
+	assert.Equal(t, lastSeen.UnixMilli(), rows[0].LastSeen)
+	assert.Equal(t, int64(2), rows[0].DashboardCount)
+	assert.Equal(t, int64(1), rows[0].AlertRuleCount)
+	assert.Equal(t, []Dashboard{
+		{UID: "a", Title: "A", URL: "https://grafana/a"},
+		{UID: "b", Title: "B", URL: "https://grafana/b", Expression: "rate(http_requests_total[5m])"},
+		{UID: "b", Title: "B", URL: "https://grafana/b", Expression: "sum(http_requests_total)"},
+	}, rows[0].Dashboards)
+	assert.Equal(t, []Rule{{PromLink: "https://prometheus", GroupName: "web", Name: "HighErrorRate", Expression: "rate(http_requests_total[5m]) > 1"}}, rows[0].AlertRules)
+	assert.Equal(t, "up", rows[1].Name)
+	assert.Zero(t, rows[1].LastSeen)
+	assert.Empty(t, rows[1].Dashboards)
+}
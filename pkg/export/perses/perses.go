@@ -0,0 +1,141 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package perses converts the metrics and their usage into resources following the envelope of the Perses API (kind, metadata, spec),
+// so they can be ingested by Perses next to the dashboards using them.
+package perses
+
+import (
+	"cmp"
+	"regexp"
+	"slices"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// Kind is the kind of the resources exported.
+const Kind = "MetricMetadata"
+
+// persesDashboardURLRegexp is matching the URL of the dashboards collected from the Perses API, like <perses_url>/api/v1/projects/<project>/dashboards/<name>.
+var persesDashboardURLRegexp = regexp.MustCompile(`/api/v1/projects/([^/]+)/dashboards/([^/]+)$`)
+
+type Metadata struct {
+	Name string `json:"name"`
+}
+
+// MetricMetadata is a metric with its metadata and its usage, exported as a Perses resource.
+type MetricMetadata struct {
+	Kind     string             `json:"kind"`
+	Metadata Metadata           `json:"metadata"`
+	Spec     MetricMetadataSpec `json:"spec"`
+}
+
+type MetricMetadataSpec struct {
+	Type   string   `json:"type,omitempty"`
+	Help   string   `json:"help,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+	Used   bool     `json:"used"`
+	Usage  *Usage   `json:"usage,omitempty"`
+}
+
+// DashboardSelector is referencing a Perses dashboard, the same way Perses does it.
+type DashboardSelector struct {
+	Project   string `json:"project"`
+	Dashboard string `json:"dashboard"`
+}
+
+type Rule struct {
+	// Source is the URL of the Prometheus evaluating the rule, or the URL of the Grafana alert rule.
+	Source string `json:"source"`
+	Group  string `json:"group,omitempty"`
+	Name   string `json:"name"`
+}
+
+type Usage struct {
+	// Dashboards is the list of the Perses dashboards using the metric.
+	Dashboards []DashboardSelector `json:"dashboards,omitempty"`
+	// ExternalDashboards is the list of the URLs of the other dashboards using the metric, like the Grafana ones.
+	ExternalDashboards []string `json:"externalDashboards,omitempty"`
+	RecordingRules     []Rule   `json:"recordingRules,omitempty"`
+	// AlertingRules contains the Prometheus alert rules and the Grafana-managed alert rules.
+	AlertingRules []Rule `json:"alertingRules,omitempty"`
+}
+
+// Convert returns the metrics as Perses resources, sorted by name.
+func Convert(metrics map[string]*v1.Metric) []MetricMetadata {
+	result := make([]MetricMetadata, 0, len(metrics))
+	for name, metric := range metrics {
+		result = append(result, ConvertMetric(name, metric))
+	}
+	slices.SortFunc(result, func(a, b MetricMetadata) int {
+		return cmp.Compare(a.Metadata.Name, b.Metadata.Name)
+	})
+	return result
+}
+
+func ConvertMetric(name string, metric *v1.Metric) MetricMetadata {
+	labels := metric.Labels.TransformAsSlice()
+	slices.Sort(labels)
+	return MetricMetadata{
+		Kind:     Kind,
+		Metadata: Metadata{Name: name},
+		Spec: MetricMetadataSpec{
+			Type:   metric.Type,
+			Help:   metric.Help,
+			Labels: labels,
+			Used:   metric.Usage != nil,
+			Usage:  convertUsage(metric.Usage),
+		},
+	}
+}
+
+func convertUsage(usage *v1.MetricUsage) *Usage {
+	if usage == nil {
+		return nil
+	}
+	result := &Usage{}
+	for dashboard := range usage.Dashboards {
+		if matches := persesDashboardURLRegexp.FindStringSubmatch(dashboard.URL); matches != nil {
+			result.Dashboards = append(result.Dashboards, DashboardSelector{Project: matches[1], Dashboard: matches[2]})
+		} else {
+			result.ExternalDashboards = append(result.ExternalDashboards, dashboard.URL)
+		}
+	}
+	for rule := range usage.RecordingRules {
+		result.RecordingRules = append(result.RecordingRules, Rule{Source: rule.PromLink, Group: rule.GroupName, Name: rule.Name})
+	}
+	for rule := range usage.AlertRules {
+		result.AlertingRules = append(result.AlertingRules, Rule{Source: rule.PromLink, Group: rule.GroupName, Name: rule.Name})
+	}
+	for alert := range usage.GrafanaAlerts {
+		result.AlertingRules = append(result.AlertingRules, Rule{Source: alert.URL, Group: alert.GroupName, Name: alert.Name})
+	}
+	// The sets are not ordered, so the lists are sorted to return a stable result.
+	slices.SortFunc(result.Dashboards, func(a, b DashboardSelector) int {
+		return cmp.Or(cmp.Compare(a.Project, b.Project), cmp.Compare(a.Dashboard, b.Dashboard))
+	})
+	slices.Sort(result.ExternalDashboards)
+	result.ExternalDashboards = slices.Compact(result.ExternalDashboards)
+	result.Dashboards = slices.Compact(result.Dashboards)
+	sortRules(result.RecordingRules)
+	sortRules(result.AlertingRules)
+	result.RecordingRules = slices.Compact(result.RecordingRules)
+	result.AlertingRules = slices.Compact(result.AlertingRules)
+	return result
+}
+
+func sortRules(rules []Rule) {
+	slices.SortFunc(rules, func(a, b Rule) int {
+		return cmp.Or(cmp.Compare(a.Source, b.Source), cmp.Compare(a.Group, b.Group), cmp.Compare(a.Name, b.Name))
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perses
+
+import (
+	"testing"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvert(t *testing.T) {
+	testSuite := []struct {
+		title    string
+		metrics  map[string]*v1.Metric
+		expected []MetricMetadata
+	}{
+		{
+			title:    "no metric",
+			expected: []MetricMetadata{},
+		},
+		{
+			title: "unused metrics sorted by name",
+			metrics: map[string]*v1.Metric{
+				"up":         {Type: "gauge", Help: "The target is up.", Labels: v1.NewSet("job", "instance")},
+				"node_load1": {},
+			},
+			expected: []MetricMetadata{
+				{Kind: Kind, Metadata: Metadata{Name: "node_load1"}},
+				{Kind: Kind, Metadata: Metadata{Name: "up"}, Spec: MetricMetadataSpec{Type: "gauge", Help: "The target is up.", Labels: []string{"instance", "job"}}},
+			},
+		},
+		{
+			title: "used metric",
+			metrics: map[string]*v1.Metric{
+				"up": {
+					Usage: &v1.MetricUsage{
+						Dashboards: v1.NewSet(
+							v1.DashboardUsage{ID: "perses/node", Name: "node", URL: "https://demo.perses.dev/api/v1/projects/perses/dashboards/node"},
+							v1.DashboardUsage{ID: "abc", Name: "Node", URL: "https://grafana.example.com/d/abc"},
+						),
+						RecordingRules: v1.NewSet(v1.RuleUsage{PromLink: "https://prometheus.example.com", GroupName: "node", Name: "job:up:sum", Expression: "sum by (job) (up)"}),
+						AlertRules:     v1.NewSet(v1.RuleUsage{PromLink: "https://prometheus.example.com", GroupName: "node", Name: "TargetDown", Expression: "up == 0"}),
+						GrafanaAlerts:  v1.NewSet(v1.GrafanaAlertUsage{ID: "def", Name: "Down", GroupName: "availability", URL: "https://grafana.example.com/alerting/grafana/def/view"}),
+					},
+				},
+			},
+			expected: []MetricMetadata{
+				{
+					Kind:     Kind,
+					Metadata: Metadata{Name: "up"},
+					Spec: MetricMetadataSpec{
+						Used: true,
+						Usage: &Usage{
+							Dashboards:         []DashboardSelector{{Project: "perses", Dashboard: "node"}},
+							ExternalDashboards: []string{"https://grafana.example.com/d/abc"},
+							RecordingRules:     []Rule{{Source: "https://prometheus.example.com", Group: "node", Name: "job:up:sum"}},
+							AlertingRules: []Rule{
+								{Source: "https://grafana.example.com/alerting/grafana/def/view", Group: "availability", Name: "Down"},
+								{Source: "https://prometheus.example.com", Group: "node", Name: "TargetDown"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.expected, Convert(test.metrics))
+		})
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	persesEcho "github.com/perses/common/echo"
+	"github.com/perses/metrics-usage/database"
+)
+
+// NewAPI returns the endpoints used to administrate the database.
+// They are destructive and so should not be registered by default.
+func NewAPI(db database.Database) persesEcho.Register {
+	return &endpoint{
+		db: db,
+	}
+}
+
+type endpoint struct {
+	db database.Database
+}
+
+func (e *endpoint) RegisterRoute(ech *echo.Echo) {
+	ech.POST("/api/v1/admin/reset", e.Reset)
+}
+
+// Reset removes all the data stored in the database, for example before seeding it again from a fresh collection.
+func (e *endpoint) Reset(ctx echo.Context) error {
+	if err := e.db.Reset(); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "OK"})
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package configuration
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	persesEcho "github.com/perses/common/echo"
+	"github.com/perses/metrics-usage/config"
+)
+
+// NewAPI returns the endpoint exposing the configuration actually used, with the defaults set and the secrets redacted.
+func NewAPI(conf config.Config) persesEcho.Register {
+	return &endpoint{
+		conf: conf,
+	}
+}
+
+type endpoint struct {
+	conf config.Config
+}
+
+func (e *endpoint) RegisterRoute(ech *echo.Echo) {
+	ech.GET("/api/v1/config", e.GetConfig)
+}
+
+// GetConfig returns the resolved configuration, like the engine, the period and the URL of each collector.
+func (e *endpoint) GetConfig(ctx echo.Context) error {
+	result, err := e.conf.Redacted()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	return ctx.JSON(http.StatusOK, result)
+}
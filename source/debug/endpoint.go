@@ -0,0 +1,71 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	persesEcho "github.com/perses/common/echo"
+	"github.com/perses/metrics-usage/database"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// NewAPI returns the endpoints used to debug the application.
+// They are exposing the whole database and so should not be registered by default.
+func NewAPI(db database.Database) persesEcho.Register {
+	return &endpoint{
+		db: db,
+	}
+}
+
+type endpoint struct {
+	db database.Database
+}
+
+func (e *endpoint) RegisterRoute(ech *echo.Echo) {
+	ech.GET("/api/v1/debug/dump", e.Dump)
+}
+
+type dumpRequest struct {
+	// IncludePartialMetrics is used to also dump the partial metrics.
+	// In this case, the metrics are no longer returned at the root of the document but in the field "metrics".
+	IncludePartialMetrics bool `query:"include_partial_metrics"`
+}
+
+type dump struct {
+	Metrics        map[string]*v1.Metric        `json:"metrics"`
+	PartialMetrics map[string]*v1.PartialMetric `json:"partial_metrics"`
+}
+
+// Dump returns a snapshot of the metrics stored in the database, in the same format as the one used to flush the database in a file.
+// The database returns a copy of the data, so the lock is not held while writing the response.
+func (e *endpoint) Dump(ctx echo.Context) error {
+	req := &dumpRequest{}
+	if err := ctx.Bind(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	metrics, err := e.db.ListMetrics()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	if !req.IncludePartialMetrics {
+		return ctx.JSON(http.StatusOK, metrics)
+	}
+	partialMetrics, err := e.db.ListPartialMetrics()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	return ctx.JSON(http.StatusOK, &dump{Metrics: metrics, PartialMetrics: partialMetrics})
+}
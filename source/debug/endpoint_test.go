@@ -0,0 +1,100 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/metrics-usage/database"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDump(t *testing.T) {
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	db.EnqueueMetricList([]string{"foo", "bar"})
+	db.EnqueuePartialMetricsUsage(map[string]*v1.MetricUsage{
+		"foo_.+": {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "a"})},
+	})
+	require.Eventually(t, func() bool {
+		metrics, _ := db.ListMetrics()
+		partialMetrics, _ := db.ListPartialMetrics()
+		return len(metrics) == 2 && len(partialMetrics) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	e := echo.New()
+	NewAPI(db).RegisterRoute(e)
+	testSuite := []struct {
+		title                  string
+		query                  string
+		expectedCode           int
+		expectedMetrics        []string
+		expectedPartialMetrics []string
+	}{
+		{
+			title:           "metrics at the root of the document",
+			expectedCode:    http.StatusOK,
+			expectedMetrics: []string{"foo", "bar"},
+		},
+		{
+			title:                  "metrics and partial metrics",
+			query:                  "include_partial_metrics=true",
+			expectedCode:           http.StatusOK,
+			expectedMetrics:        []string{"foo", "bar"},
+			expectedPartialMetrics: []string{"foo_.+"},
+		},
+		{
+			title:        "invalid query parameter",
+			query:        "include_partial_metrics=maybe",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/debug/dump?"+test.query, nil))
+			require.Equal(t, test.expectedCode, rec.Code)
+			if test.expectedCode != http.StatusOK {
+				return
+			}
+			var metrics map[string]*v1.Metric
+			var partialMetrics map[string]*v1.PartialMetric
+			if len(test.expectedPartialMetrics) > 0 {
+				result := &dump{}
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), result))
+				metrics, partialMetrics = result.Metrics, result.PartialMetrics
+			} else {
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &metrics))
+			}
+			assert.ElementsMatch(t, test.expectedMetrics, keys(metrics))
+			assert.ElementsMatch(t, test.expectedPartialMetrics, keys(partialMetrics))
+		})
+	}
+}
+
+func keys[T any](m map[string]T) []string {
+	var result []string
+	for key := range m {
+		result = append(result, key)
+	}
+	return result
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	persesEcho "github.com/perses/common/echo"
+	"github.com/perses/metrics-usage/utils/pubsub"
+)
+
+const (
+	eventStreamContentType = "text/event-stream"
+	// keepAlivePeriod is how often a comment is sent when there is no event, so the proxies don't close the connection.
+	keepAlivePeriod = 30 * time.Second
+)
+
+func NewAPI() persesEcho.Register {
+	return &endpoint{}
+}
+
+type endpoint struct{}
+
+func (e *endpoint) RegisterRoute(ech *echo.Echo) {
+	ech.GET("/api/v1/events", e.StreamEvents)
+}
+
+// StreamEvents sends the events as Server-Sent Events until the client disconnects.
+func (e *endpoint) StreamEvents(ctx echo.Context) error {
+	events, unsubscribe, err := pubsub.Subscribe()
+	if err != nil {
+		return ctx.JSON(http.StatusServiceUnavailable, echo.Map{"message": err.Error()})
+	}
+	defer unsubscribe()
+	resp := ctx.Response()
+	resp.Header().Set(echo.HeaderContentType, eventStreamContentType)
+	resp.Header().Set("Cache-Control", "no-cache")
+	resp.WriteHeader(http.StatusOK)
+	resp.Flush()
+	ticker := time.NewTicker(keepAlivePeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+			if _, err := fmt.Fprint(resp, ": keep-alive\n\n"); err != nil {
+				return nil
+			}
+		case event := <-events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(resp, "event: %s\ndata: %s\n\n", event.Kind, data); err != nil {
+				return nil
+			}
+		}
+		resp.Flush()
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package events
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/perses/metrics-usage/utils/pubsub"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamEvents(t *testing.T) {
+	e := echo.New()
+	NewAPI().RegisterRoute(e)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/events")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	pubsub.Enable(1)
+	t.Cleanup(func() { pubsub.Enable(-1) })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/v1/events", nil)
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, eventStreamContentType, resp.Header.Get(echo.HeaderContentType))
+
+	pubsub.Publish(pubsub.Event{Kind: pubsub.MetricsAddedKind, Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Metrics: []string{"up"}})
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for i := 0; i < 2; i++ {
+		line, readErr := reader.ReadString('\n')
+		require.NoError(t, readErr)
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []string{
+		"event: metrics_added\n",
+		`data: {"kind":"metrics_added","time":"2024-01-01T00:00:00Z","metrics":["up"]}` + "\n",
+	}, lines)
+}
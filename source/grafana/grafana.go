@@ -16,10 +16,15 @@ package grafana
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/go-openapi/strfmt"
 	grafanaapi "github.com/grafana/grafana-openapi-client-go/client"
+	"github.com/grafana/grafana-openapi-client-go/client/dashboards"
+	"github.com/grafana/grafana-openapi-client-go/client/provisioning"
 	"github.com/grafana/grafana-openapi-client-go/client/search"
 	grafanaModels "github.com/grafana/grafana-openapi-client-go/models"
 	"github.com/perses/common/async"
@@ -29,11 +34,12 @@ import (
 	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
 	"github.com/perses/metrics-usage/pkg/client"
 	"github.com/perses/metrics-usage/usageclient"
+	"github.com/perses/metrics-usage/utils/instrumentation"
 	"github.com/sirupsen/logrus"
 )
 
-func NewCollector(db database.Database, cfg config.GrafanaCollector) (async.SimpleTask, error) {
-	httpClient, err := config.NewHTTPClient(cfg.HTTPClient)
+func NewCollector(db database.Database, cfg *config.GrafanaCollector) (async.SimpleTask, error) {
+	httpClient, err := config.NewHTTPClient(cfg.HTTPClient, "grafana")
 	url := cfg.HTTPClient.URL.URL
 	if err != nil {
 		return nil, err
@@ -52,16 +58,32 @@ func NewCollector(db database.Database, cfg config.GrafanaCollector) (async.Simp
 		Client:   httpClient,
 	}
 	grafanaClient := grafanaapi.NewHTTPClientWithConfig(strfmt.Default, transportConfig)
+	var deepScanPaths []string
+	if cfg.DeepScan {
+		deepScanPaths = cfg.DeepScanPaths
+	}
 	logger := logrus.StandardLogger().WithField("collector", "grafana")
 	return &grafanaCollector{
 		grafanaURL:    url.String(),
+		instance:      url.Redacted(),
 		grafanaClient: grafanaClient,
 		metricUsageClient: &usageclient.Client{
 			DB:                db,
 			MetricUsageClient: metricUsageClient,
 			Logger:            logger,
+			Tags:              modelAPIV1.NewTags(cfg.UsageTags),
 		},
-		logger: logrus.StandardLogger().WithField("collector", "grafana"),
+		tags:              cfg.Tags,
+		folderUIDs:        cfg.FolderUIDs,
+		datasourceFilter:  cfg.DatasourceFilter,
+		collectAlertRules: cfg.CollectAlertRules,
+		deepScanPaths:     deepScanPaths,
+		scanTextPanels:    cfg.ScanTextPanels,
+		runTimeout:        time.Duration(cfg.RunTimeout),
+		recordExpressions: cfg.RecordExpressions,
+		reconcile:         cfg.Reconcile,
+		reconcileWindow:   time.Duration(cfg.ReconcileWindow),
+		logger:            logger,
 	}, nil
 }
 
@@ -69,40 +91,171 @@ type grafanaCollector struct {
 	async.SimpleTask
 	metricUsageClient *usageclient.Client
 	grafanaURL        string
+	// instance is the URL of the Grafana without its password, identifying the collector in its metrics.
+	instance          string
 	grafanaClient     *grafanaapi.GrafanaHTTPAPI
+	tags              []string
+	folderUIDs        []string
+	datasourceFilter  *config.DatasourceFilter
+	collectAlertRules bool
+	// deepScanPaths is the list of the JSON paths of the panels scanned to find other PromQL expressions. It is empty when the deep scan is disabled.
+	deepScanPaths []string
+	// scanTextPanels is true when the links of the text panels are scanned to find other PromQL expressions.
+	scanTextPanels bool
+	runTimeout     time.Duration
+	// recordExpressions is true when the usage of the dashboards carries the queries using each metric.
+	recordExpressions bool
+	reconcile         bool
+	reconcileWindow   time.Duration
 	logger            *logrus.Entry
 }
 
 func (c *grafanaCollector) Execute(ctx context.Context, _ context.CancelFunc) error {
+	run := instrumentation.StartRun(c.String(), c.instance)
+	defer run.End()
+	ctx, cancel := context.WithTimeout(ctx, c.runTimeout)
+	defer cancel()
 	hits, err := c.collectAllDashboardUID(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.logger.WithError(err).Errorf("the run has been interrupted, the dashboards couldn't be listed within the run timeout of %s", c.runTimeout)
+		run.Timeout()
+		return nil
+	}
 	if err != nil {
 		c.logger.WithError(err).Error("failed to collect dashboard UIDs")
+		run.Fail()
 		return nil
 	}
 	c.logger.Infof("collecting %d Grafana dashboards", len(hits))
 
-	for _, h := range hits {
-		dashboard, getErr := c.getDashboard(h.UID)
+	// When reconciling, the usage of every dashboard and alert rule is sent at once at the end of the run.
+	metricUsageCollected := make(map[string]*modelAPIV1.MetricUsage)
+	partialMetricsUsageCollected := make(map[string]*modelAPIV1.MetricUsage)
+	var brokenQueries []modelAPIV1.DashboardBrokenQueries
+	// analyzedURLs are the URLs of the dashboards analyzed, to replace only their broken queries when the run is not complete.
+	var analyzedURLs []string
+	isComplete := true
+	for i, h := range hits {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			c.logger.Errorf("the run has been interrupted after collecting %d dashboards out of %d, it reached the run timeout of %s", i, len(hits), c.runTimeout)
+			run.Timeout()
+			isComplete = false
+			break
+		}
+		dashboard, getErr := c.getDashboard(ctx, h.UID)
 		if getErr != nil {
 			c.logger.WithError(getErr).Errorf("failed to get dashboard %q with UID %q", h.Title, h.UID)
+			run.Fail()
+			isComplete = false
 			continue
 		}
 		c.logger.Debugf("extracting metrics for the dashboard %s with UID %q", h.Title, h.UID)
-		metrics, partialMetrics, errs := grafana.Analyze(dashboard)
+		analyzedURLs = append(analyzedURLs, c.dashboardUsage(dashboard).URL)
+		metrics, partialMetrics, expressions, errs := c.analyze(dashboard)
 		for _, logErr := range errs {
 			logErr.Log(c.logger)
 		}
-		metricUsage := c.generateUsage(metrics, dashboard)
-		partialMetricsUsage := c.generateUsage(partialMetrics, dashboard)
+		if queries := modelAPIV1.NewBrokenQueries(errs); len(queries) > 0 {
+			brokenQueries = append(brokenQueries, modelAPIV1.DashboardBrokenQueries{Dashboard: c.dashboardUsage(dashboard), Queries: queries})
+		}
+		if len(c.deepScanPaths) > 0 {
+			deepScanMetrics, deepScanPartialMetrics := grafana.DeepScan(dashboard, c.deepScanPaths, c.datasourceFilter)
+			metrics.Merge(deepScanMetrics)
+			partialMetrics.Merge(deepScanPartialMetrics)
+		}
+		if c.scanTextPanels {
+			textPanelMetrics, textPanelPartialMetrics := grafana.ScanTextPanels(dashboard)
+			metrics.Merge(textPanelMetrics)
+			partialMetrics.Merge(textPanelPartialMetrics)
+		}
+		partialMetrics.Log(c.logger.WithField("dashboard", h.UID))
+		metricUsage := c.generateUsage(metrics, dashboard, expressions)
+		partialMetricsUsage := c.generateUsage(partialMetrics.Metrics(), dashboard, expressions)
 		c.logger.Infof("%d metrics usage has been collected for the dashboard %q with UID %q", len(metricUsage), h.Title, h.UID)
 		c.logger.Infof("%d metrics containing regexp or variable has been collected for the dashboard %q with UID %q", len(partialMetricsUsage), h.Title, h.UID)
-		c.metricUsageClient.SendUsage(metricUsage, partialMetricsUsage)
+		run.Extracted(len(metricUsage))
+		if c.reconcile {
+			modelAPIV1.MergeUsageInto(metricUsageCollected, metricUsage)
+			modelAPIV1.MergeUsageInto(partialMetricsUsageCollected, partialMetricsUsage)
+		} else {
+			c.metricUsageClient.SendUsage(metricUsage, partialMetricsUsage)
+		}
+		c.metricUsageClient.SendUsedLabels(grafana.ExtractUsedLabels(dashboard))
+	}
+	if isComplete {
+		c.metricUsageClient.SetBrokenQueries(c.grafanaURL, brokenQueries)
+	} else {
+		c.metricUsageClient.MergeBrokenQueries(c.grafanaURL, analyzedURLs, brokenQueries)
+	}
+	if c.collectAlertRules && ctx.Err() == nil {
+		metricUsage, partialMetricsUsage, alertErr := c.collectAlertRulesUsage(ctx, run)
+		if alertErr != nil {
+			c.logger.WithError(alertErr).Error("failed to get the alert rules")
+			run.Fail()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				run.Timeout()
+			}
+			isComplete = false
+		} else if c.reconcile {
+			modelAPIV1.MergeUsageInto(metricUsageCollected, metricUsage)
+			modelAPIV1.MergeUsageInto(partialMetricsUsageCollected, partialMetricsUsage)
+		} else {
+			c.metricUsageClient.SendUsage(metricUsage, partialMetricsUsage)
+		}
+	}
+	if c.reconcile {
+		if isComplete {
+			c.metricUsageClient.ReconcileUsage(c.grafanaURL, metricUsageCollected, partialMetricsUsageCollected, c.reconcileWindow)
+		} else {
+			// The usage of the dashboards that couldn't be collected must not be removed.
+			c.logger.Warning("some dashboards or alert rules couldn't be collected, the usage is merged instead of being reconciled")
+			c.metricUsageClient.SendUsage(metricUsageCollected, partialMetricsUsageCollected)
+		}
 	}
 	return nil
 }
 
-func (c *grafanaCollector) getDashboard(uid string) (*grafana.SimplifiedDashboard, error) {
-	response, err := c.grafanaClient.Dashboards.GetDashboardByUID(uid)
+func (c *grafanaCollector) collectAlertRulesUsage(ctx context.Context, run *instrumentation.Run) (map[string]*modelAPIV1.MetricUsage, map[string]*modelAPIV1.MetricUsage, error) {
+	rules, err := c.getAlertRules(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.logger.Infof("collecting %d Grafana alert rules", len(rules))
+	metricUsage := make(map[string]*modelAPIV1.MetricUsage)
+	partialMetricsUsage := make(map[string]*modelAPIV1.MetricUsage)
+	for _, rule := range rules {
+		if len(c.folderUIDs) > 0 && !slices.Contains(c.folderUIDs, rule.FolderUID) {
+			continue
+		}
+		metrics, partialMetrics, errs := grafana.AnalyzeAlertRuleAndExplain(rule, c.datasourceFilter)
+		for _, logErr := range errs {
+			logErr.Log(c.logger)
+		}
+		partialMetrics.Log(c.logger.WithField("alert_rule", rule.UID))
+		c.populateAlertUsage(metricUsage, metrics, rule)
+		c.populateAlertUsage(partialMetricsUsage, partialMetrics.Metrics(), rule)
+	}
+	c.logger.Infof("%d metrics usage has been collected from the alert rules", len(metricUsage))
+	c.logger.Infof("%d metrics containing regexp or variable has been collected from the alert rules", len(partialMetricsUsage))
+	run.Extracted(len(metricUsage))
+	return metricUsage, partialMetricsUsage, nil
+}
+
+func (c *grafanaCollector) getAlertRules(ctx context.Context) ([]*grafana.SimplifiedAlertRule, error) {
+	response, err := c.grafanaClient.Provisioning.GetAlertRulesWithParams(provisioning.NewGetAlertRulesParamsWithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	rowData, err := json.Marshal(response.Payload)
+	if err != nil {
+		return nil, err
+	}
+	var result []*grafana.SimplifiedAlertRule
+	return result, json.Unmarshal(rowData, &result)
+}
+
+func (c *grafanaCollector) getDashboard(ctx context.Context, uid string) (*grafana.SimplifiedDashboard, error) {
+	response, err := c.grafanaClient.Dashboards.GetDashboardByUIDWithParams(dashboards.NewGetDashboardByUIDParamsWithContext(ctx).WithUID(uid))
 	if err != nil {
 		return nil, err
 	}
@@ -111,7 +264,14 @@ func (c *grafanaCollector) getDashboard(uid string) (*grafana.SimplifiedDashboar
 		return nil, err
 	}
 	result := &grafana.SimplifiedDashboard{}
-	return result, json.Unmarshal(rowData, &result)
+	if err = json.Unmarshal(rowData, &result); err != nil {
+		return nil, err
+	}
+	if meta := response.Payload.Meta; meta != nil {
+		result.Version = meta.Version
+		result.Updated = time.Time(meta.Updated)
+	}
+	return result, nil
 }
 
 func (c *grafanaCollector) collectAllDashboardUID(ctx context.Context) ([]*grafanaModels.Hit, error) {
@@ -123,9 +283,11 @@ func (c *grafanaCollector) collectAllDashboardUID(ctx context.Context) ([]*grafa
 
 	for searchOk {
 		nextPageResult, err := c.grafanaClient.Search.Search(&search.SearchParams{
-			Context: ctx,
-			Type:    &searchType,
-			Page:    &currentPage,
+			Context:    ctx,
+			Type:       &searchType,
+			Page:       &currentPage,
+			Tag:        c.tags,
+			FolderUIDs: c.folderUIDs,
 		})
 		if err != nil {
 			return nil, err
@@ -139,27 +301,64 @@ func (c *grafanaCollector) collectAllDashboardUID(ctx context.Context) ([]*grafa
 	return result, nil
 }
 
-func (c *grafanaCollector) generateUsage(metricNames modelAPIV1.Set[string], currentDashboard *grafana.SimplifiedDashboard) map[string]*modelAPIV1.MetricUsage {
+// analyze extracts the metrics used by the dashboard. The expressions are only returned when they are recorded.
+func (c *grafanaCollector) analyze(dashboard *grafana.SimplifiedDashboard) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, modelAPIV1.MetricExpressions, []*modelAPIV1.LogError) {
+	if c.recordExpressions {
+		return grafana.AnalyzeWithExpressions(dashboard, c.datasourceFilter)
+	}
+	metrics, partialMetrics, errs := grafana.AnalyzeAndExplain(dashboard, c.datasourceFilter)
+	return metrics, partialMetrics, nil, errs
+}
+
+// generateUsage returns the usage of the metrics by the dashboard.
+// When the expressions using a metric are known, there is one usage per expression.
+func (c *grafanaCollector) generateUsage(metricNames modelAPIV1.Set[string], currentDashboard *grafana.SimplifiedDashboard, expressions modelAPIV1.MetricExpressions) map[string]*modelAPIV1.MetricUsage {
 	metricUsage := make(map[string]*modelAPIV1.MetricUsage)
-	dashboardURL := fmt.Sprintf("%s/d/%s", c.grafanaURL, currentDashboard.UID)
+	dashboardUsage := c.dashboardUsage(currentDashboard)
+	for metricName := range metricNames {
+		usage := &modelAPIV1.MetricUsage{Dashboards: modelAPIV1.NewSet[modelAPIV1.DashboardUsage]()}
+		if len(expressions[metricName]) == 0 {
+			usage.Dashboards.Add(dashboardUsage)
+		}
+		for expr := range expressions[metricName] {
+			withExpression := dashboardUsage
+			withExpression.Expression = expr
+			usage.Dashboards.Add(withExpression)
+		}
+		metricUsage[metricName] = usage
+	}
+	return metricUsage
+}
+
+func (c *grafanaCollector) dashboardUsage(dashboard *grafana.SimplifiedDashboard) modelAPIV1.DashboardUsage {
+	usage := modelAPIV1.DashboardUsage{
+		ID:      dashboard.UID,
+		Name:    dashboard.Title,
+		URL:     fmt.Sprintf("%s/d/%s", c.grafanaURL, dashboard.UID),
+		Version: dashboard.Version,
+	}
+	if !dashboard.Updated.IsZero() {
+		usage.Updated = dashboard.Updated.UTC().Format(time.RFC3339)
+	}
+	return usage
+}
+
+func (c *grafanaCollector) populateAlertUsage(metricUsage map[string]*modelAPIV1.MetricUsage, metricNames modelAPIV1.Set[string], rule *grafana.SimplifiedAlertRule) {
+	alert := modelAPIV1.GrafanaAlertUsage{
+		ID:        rule.UID,
+		Name:      rule.Title,
+		GroupName: rule.RuleGroup,
+		URL:       fmt.Sprintf("%s/alerting/grafana/%s/view", c.grafanaURL, rule.UID),
+	}
 	for metricName := range metricNames {
 		if usage, ok := metricUsage[metricName]; ok {
-			usage.Dashboards.Add(modelAPIV1.DashboardUsage{
-				ID:   currentDashboard.UID,
-				Name: currentDashboard.Title,
-				URL:  dashboardURL,
-			})
+			usage.GrafanaAlerts.Add(alert)
 		} else {
 			metricUsage[metricName] = &modelAPIV1.MetricUsage{
-				Dashboards: modelAPIV1.NewSet(modelAPIV1.DashboardUsage{
-					ID:   currentDashboard.UID,
-					Name: currentDashboard.Title,
-					URL:  dashboardURL,
-				}),
+				GrafanaAlerts: modelAPIV1.NewSet(alert),
 			}
 		}
 	}
-	return metricUsage
 }
 
 func (c *grafanaCollector) String() string {
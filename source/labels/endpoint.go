@@ -14,11 +14,18 @@
 package labels
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
 
 	"github.com/labstack/echo/v4"
 	persesEcho "github.com/perses/common/echo"
 	"github.com/perses/metrics-usage/database"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/perses/metrics-usage/utils/idempotency"
+	"github.com/perses/metrics-usage/utils/inflight"
+	"github.com/perses/metrics-usage/utils/jsonbody"
+	"github.com/perses/metrics-usage/utils/search"
 )
 
 func NewAPI(db database.Database) persesEcho.Register {
@@ -33,16 +40,79 @@ type endpoint struct {
 
 func (e *endpoint) RegisterRoute(ech *echo.Echo) {
 	path := "/api/v1/labels"
-	ech.POST(path, e.PushLabels)
+	ech.POST(path, e.PushLabels, inflight.Middleware(), idempotency.Middleware(e.db))
+	ech.GET(path, e.ListLabels)
+	ech.GET(fmt.Sprintf("%s/:metric", path), e.GetLabels)
+	ech.POST("/api/v1/used_labels", e.PushUsedLabels, inflight.Middleware(), idempotency.Middleware(e.db))
 }
 
 func (e *endpoint) PushLabels(ctx echo.Context) error {
 	data := make(map[string][]string)
-	if err := ctx.Bind(&data); err != nil {
-		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	if err := jsonbody.Bind(ctx, &data); err != nil {
+		return ctx.JSON(http.StatusBadRequest, err)
 	}
 	if len(data) > 0 {
 		e.db.EnqueueLabels(data)
 	}
 	return ctx.JSON(http.StatusAccepted, echo.Map{"message": "OK"})
 }
+
+type listLabelsRequest struct {
+	MetricName string `query:"metric_name"`
+	// Mode defines how MetricName is matched. Default to a fuzzy search.
+	Mode search.Mode `query:"mode"`
+}
+
+// ListLabels returns the labels of every metric matching the request. The metrics without any known label are omitted.
+func (e *endpoint) ListLabels(ctx echo.Context) error {
+	req := &listLabelsRequest{}
+	if err := ctx.Bind(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if len(req.Mode) == 0 {
+		req.Mode = search.FuzzyMode
+	}
+	if err := req.Mode.Verify(); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	result := make(map[string][]string)
+	err := e.db.IterateMetrics(func(name string, metric *v1.Metric) error {
+		if len(metric.Labels) == 0 || (len(req.MetricName) > 0 && !req.Mode.Match(req.MetricName, name)) {
+			return nil
+		}
+		result[name] = sortedLabels(metric.Labels)
+		return nil
+	})
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	return ctx.JSON(http.StatusOK, result)
+}
+
+func (e *endpoint) GetLabels(ctx echo.Context) error {
+	metric := e.db.GetMetric(ctx.Param("metric"))
+	if metric == nil {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	return ctx.JSON(http.StatusOK, sortedLabels(metric.Labels))
+}
+
+func sortedLabels(labels v1.Set[string]) []string {
+	result := labels.TransformAsSlice()
+	if result == nil {
+		return []string{}
+	}
+	slices.Sort(result)
+	return result
+}
+
+func (e *endpoint) PushUsedLabels(ctx echo.Context) error {
+	data := &v1.UsedLabels{}
+	if err := jsonbody.Bind(ctx, data); err != nil {
+		return ctx.JSON(http.StatusBadRequest, err)
+	}
+	if len(data.ByMetric) > 0 || len(data.Global) > 0 {
+		e.db.EnqueueUsedLabels(data)
+	}
+	return ctx.JSON(http.StatusAccepted, echo.Map{"message": "OK"})
+}
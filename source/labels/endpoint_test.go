@@ -0,0 +1,133 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package labels
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/metrics-usage/database"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListAndGetLabels(t *testing.T) {
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	db.EnqueueMetricList([]string{"http_requests_total", "http_request_duration_seconds", "up"})
+	db.EnqueueLabels(map[string][]string{
+		"http_requests_total":           {"job", "code", "instance"},
+		"http_request_duration_seconds": {"le", "job"},
+	})
+	require.Eventually(t, func() bool {
+		metric := db.GetMetric("http_request_duration_seconds")
+		return metric != nil && len(metric.Labels) == 2 && db.GetMetric("up") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	e := echo.New()
+	NewAPI(db).RegisterRoute(e)
+	testSuite := []struct {
+		title        string
+		path         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			title:        "list every label",
+			path:         "/api/v1/labels",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"http_request_duration_seconds":["job","le"],"http_requests_total":["code","instance","job"]}`,
+		},
+		{
+			title:        "list labels with a prefix search",
+			path:         "/api/v1/labels?metric_name=http_requests&mode=prefix",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"http_requests_total":["code","instance","job"]}`,
+		},
+		{
+			title:        "list labels with an unknown mode",
+			path:         "/api/v1/labels?metric_name=http&mode=regex",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			title:        "get the labels of a metric",
+			path:         "/api/v1/labels/http_requests_total",
+			expectedCode: http.StatusOK,
+			expectedBody: `["code","instance","job"]`,
+		},
+		{
+			title:        "get the labels of a metric without labels",
+			path:         "/api/v1/labels/up",
+			expectedCode: http.StatusOK,
+			expectedBody: `[]`,
+		},
+		{
+			title:        "get the labels of an unknown metric",
+			path:         "/api/v1/labels/unknown",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.path, nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, test.expectedCode, rec.Code)
+			if len(test.expectedBody) > 0 {
+				assert.JSONEq(t, test.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestPushUsedLabelsInvalidBody(t *testing.T) {
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	e := echo.New()
+	NewAPI(db).RegisterRoute(e)
+	testSuite := []struct {
+		title        string
+		path         string
+		body         string
+		expectedBody string
+	}{
+		{
+			title:        "syntax error",
+			path:         "/api/v1/used_labels",
+			body:         "{\n  \"global\": [\"job\",]\n}",
+			expectedBody: `{"message":"invalid JSON body: invalid character ']' looking for beginning of value","offset":21,"line":2,"column":20}`,
+		},
+		{
+			title:        "unknown field in strict mode",
+			path:         "/api/v1/used_labels?strict=true",
+			body:         `{"globals": ["job"]}`,
+			expectedBody: `{"message":"invalid JSON body: json: unknown field \"globals\"","field":"globals"}`,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.JSONEq(t, test.expectedBody, rec.Body.String())
+		})
+	}
+}
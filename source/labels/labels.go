@@ -15,20 +15,24 @@ package labels
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/perses/common/async"
 	"github.com/perses/metrics-usage/config"
 	"github.com/perses/metrics-usage/database"
 	"github.com/perses/metrics-usage/pkg/client"
+	"github.com/perses/metrics-usage/utils/instrumentation"
 	"github.com/perses/metrics-usage/utils/prometheus"
+	"github.com/perses/perses/pkg/model/api/v1/common"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 	"github.com/sirupsen/logrus"
 )
 
 func NewCollector(db database.Database, cfg *config.LabelsCollector) (async.SimpleTask, error) {
-	promClient, err := prometheus.NewClient(cfg.HTTPClient)
+	promClient, err := prometheus.NewClient(cfg.HTTPClient, "labels")
 	if err != nil {
 		return nil, err
 	}
@@ -41,46 +45,84 @@ func NewCollector(db database.Database, cfg *config.LabelsCollector) (async.Simp
 	}
 	return &labelCollector{
 		promClient:        promClient,
+		instance:          cfg.HTTPClient.URL.Redacted(),
 		db:                db,
 		metricUsageClient: metricUsageClient,
-		period:            cfg.Period,
+		lookback:          cfg.Lookback,
+		runTimeout:        time.Duration(cfg.RunTimeout),
+		retryMetrics:      cfg.RetryToGetMetrics,
+		retryLabels:       cfg.RetryToGetLabels,
+		concurrency:       max(int(cfg.Concurrency), 1),
+		reconcile:         cfg.Reconcile,
+		includeLabels:     cfg.IncludeLabels,
+		excludeLabels:     cfg.ExcludeLabels,
+		metricsRetryWait:  10 * time.Second,
+		labelsRetryWait:   500 * time.Millisecond,
 		logger:            logrus.StandardLogger().WithField("collector", "labels"),
 	}, nil
 }
 
 type labelCollector struct {
 	async.SimpleTask
-	promClient        v1.API
+	promClient v1.API
+	// instance is the URL of the Prometheus without its password, identifying the collector in its metrics.
+	instance          string
 	db                database.Database
 	metricUsageClient client.Client
-	period            model.Duration
-	logger            *logrus.Entry
+	lookback          model.Duration
+	runTimeout        time.Duration
+	retryMetrics      uint
+	retryLabels       uint
+	// concurrency is the number of metrics whose labels are queried in parallel.
+	concurrency int
+	// reconcile is true when the labels collected replace the previous labels of each metric.
+	reconcile bool
+	// includeLabels and excludeLabels are filtering the label names collected. A nil regexp doesn't filter anything.
+	includeLabels *common.Regexp
+	excludeLabels *common.Regexp
+	// metricsRetryWait is the time waited before the first retry to get the list of metrics. It increases linearly with each retry.
+	metricsRetryWait time.Duration
+	// labelsRetryWait is the time waited before the first retry to get the labels of a metric. It doubles with each retry.
+	labelsRetryWait time.Duration
+	logger          *logrus.Entry
 }
 
 func (c *labelCollector) Execute(ctx context.Context, _ context.CancelFunc) error {
+	run := instrumentation.StartRun(c.String(), c.instance)
+	defer run.End()
+	ctx, cancel := context.WithTimeout(ctx, c.runTimeout)
+	defer cancel()
 	now := time.Now()
-	start := now.Add(time.Duration(-c.period))
-	labelValues, _, err := c.promClient.LabelValues(ctx, "__name__", nil, start, now)
+	start := now.Add(time.Duration(-c.lookback))
+	labelValues, err := c.getMetricNames(ctx, start, now)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.logger.Errorf("the run has been interrupted, the list of metrics couldn't be retrieved within the run timeout of %s", c.runTimeout)
+		run.Timeout()
+		return nil
+	}
 	if err != nil {
 		c.logger.WithError(err).Error("failed to query metrics")
+		run.Fail()
 		return nil
 	}
-	result := make(map[string][]string)
-	for _, metricName := range labelValues {
-		c.logger.Debugf("querying Prometheus to get label names for metric %s", metricName)
-		labels, _, queryErr := c.promClient.LabelNames(ctx, []string{string(metricName)}, start, now)
-		if queryErr != nil {
-			c.logger.WithError(queryErr).Errorf("failed to query labels for the metric %q", metricName)
-			continue
-		}
-		result[string(metricName)] = removeLabelName(labels)
+	result, failed := c.getLabels(ctx, labelValues, start, now)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		// The labels already retrieved are kept, the ones of the remaining metrics are collected by the next run.
+		c.logger.Errorf("the run has been interrupted after getting the labels of %d metrics out of %d, it reached the run timeout of %s", len(result)+failed, len(labelValues), c.runTimeout)
+		run.Timeout()
+	} else if failed > 0 {
+		c.logger.Warnf("failed to get the labels for %d metrics out of %d", failed, len(labelValues))
 	}
+	run.FailedMetrics(failed)
+	run.Extracted(len(result))
 	if len(result) > 0 {
 		if c.metricUsageClient != nil {
 			// In this case, that means we have to send the data to a remote server.
 			if sendErr := c.metricUsageClient.Labels(result); sendErr != nil {
 				c.logger.WithError(sendErr).Error("Failed to send labels name")
 			}
+		} else if c.reconcile {
+			c.db.EnqueueLabelsReplacement(result)
 		} else {
 			c.db.EnqueueLabels(result)
 		}
@@ -88,15 +130,99 @@ func (c *labelCollector) Execute(ctx context.Context, _ context.CancelFunc) erro
 	return nil
 }
 
+// getMetricNames returns the name of every metric available in Prometheus.
+// The query is retried a few times, with a wait time increasing with each retry, before actually failing.
+func (c *labelCollector) getMetricNames(ctx context.Context, start time.Time, end time.Time) (model.LabelValues, error) {
+	waitDuration := c.metricsRetryWait
+	var err error
+	var result model.LabelValues
+	for retry := c.retryMetrics; retry > 0; retry-- {
+		result, _, err = c.promClient.LabelValues(ctx, "__name__", nil, start, end)
+		if err == nil {
+			return result, nil
+		}
+		c.logger.WithError(err).Debug("Failed to get the list of metrics, retrying...")
+		if retry > 1 && !prometheus.Wait(ctx, waitDuration) {
+			return nil, ctx.Err()
+		}
+		waitDuration += c.metricsRetryWait
+	}
+	return result, err
+}
+
+// getLabels returns the label names for each given metric, querying the labels of at most c.concurrency metrics in parallel.
+// It also returns the number of metrics for which the labels couldn't be retrieved, even after retrying.
+func (c *labelCollector) getLabels(ctx context.Context, metrics model.LabelValues, start time.Time, end time.Time) (map[string][]string, int) {
+	result := make(map[string][]string)
+	failed := 0
+	var mutex sync.Mutex
+	semaphore := make(chan struct{}, max(c.concurrency, 1))
+	var wg sync.WaitGroup
+	for _, metricName := range metrics {
+		semaphore <- struct{}{}
+		if ctx.Err() != nil {
+			<-semaphore
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			labels, err := c.getLabelsForMetric(ctx, string(metricName), start, end)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				c.logger.WithError(err).Errorf("failed to query labels for the metric %q", metricName)
+				failed++
+				return
+			}
+			result[string(metricName)] = filterLabelNames(labels, c.includeLabels, c.excludeLabels)
+		}()
+	}
+	wg.Wait()
+	return result, failed
+}
+
+func (c *labelCollector) getLabelsForMetric(ctx context.Context, metricName string, start time.Time, end time.Time) ([]string, error) {
+	c.logger.Debugf("querying Prometheus to get label names for metric %s", metricName)
+	waitDuration := c.labelsRetryWait
+	var err error
+	var labels []string
+	for retry := c.retryLabels; retry > 0; retry-- {
+		labels, _, err = c.promClient.LabelNames(ctx, []string{metricName}, start, end)
+		if err == nil {
+			return labels, nil
+		}
+		c.logger.WithError(err).Debugf("Failed to get the labels for the metric %q, retrying...", metricName)
+		if retry > 1 && !prometheus.Wait(ctx, waitDuration) {
+			return nil, ctx.Err()
+		}
+		waitDuration *= 2
+	}
+	return labels, err
+}
+
 func (c *labelCollector) String() string {
 	return "labels collector"
 }
 
-func removeLabelName(labels []string) []string {
-	for i, label := range labels {
+// filterLabelNames removes the label __name__, the label names not matching includeLabels and the ones matching excludeLabels.
+// A nil regexp doesn't filter anything.
+func filterLabelNames(labels []string, includeLabels *common.Regexp, excludeLabels *common.Regexp) []string {
+	result := labels[:0]
+	for _, label := range labels {
 		if label == "__name__" {
-			return append(labels[:i], labels[i+1:]...)
+			continue
+		}
+		if includeLabels != nil && !includeLabels.MatchString(label) {
+			continue
+		}
+		if excludeLabels != nil && excludeLabels.MatchString(label) {
+			continue
 		}
+		result = append(result, label)
 	}
-	return labels
+	return result
 }
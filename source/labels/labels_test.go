@@ -0,0 +1,178 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package labels
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPI is failing the first calls to LabelValues and LabelNames, depending on the number of failures configured.
+type fakeAPI struct {
+	v1.API
+	mutex           sync.Mutex
+	metricsFailures int
+	labelsFailures  map[string]int
+	labels          map[string][]string
+}
+
+func (f *fakeAPI) LabelValues(_ context.Context, _ string, _ []string, _ time.Time, _ time.Time, _ ...v1.Option) (model.LabelValues, v1.Warnings, error) {
+	if f.metricsFailures > 0 {
+		f.metricsFailures--
+		return nil, nil, errors.New("unavailable")
+	}
+	var result model.LabelValues
+	for name := range f.labels {
+		result = append(result, model.LabelValue(name))
+	}
+	return result, nil, nil
+}
+
+func (f *fakeAPI) LabelNames(_ context.Context, matches []string, _ time.Time, _ time.Time, _ ...v1.Option) ([]string, v1.Warnings, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := matches[0]
+	if f.labelsFailures[name] > 0 {
+		f.labelsFailures[name]--
+		return nil, nil, errors.New("unavailable")
+	}
+	return append([]string{"__name__"}, f.labels[name]...), nil, nil
+}
+
+func newTestCollector(api v1.API) *labelCollector {
+	return &labelCollector{
+		promClient:   api,
+		retryMetrics: 3,
+		retryLabels:  3,
+		concurrency:  2,
+		logger:       logrus.StandardLogger().WithField("collector", "labels"),
+	}
+}
+
+func TestGetMetricNames(t *testing.T) {
+	testSuites := []struct {
+		title           string
+		metricsFailures int
+		expectErr       bool
+	}{
+		{
+			title:           "no failure",
+			metricsFailures: 0,
+		},
+		{
+			title:           "transient failures",
+			metricsFailures: 2,
+		},
+		{
+			title:           "too many failures",
+			metricsFailures: 3,
+			expectErr:       true,
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			api := &fakeAPI{metricsFailures: test.metricsFailures, labels: map[string][]string{"up": {"job"}}}
+			result, err := newTestCollector(api).getMetricNames(context.Background(), time.Now(), time.Now())
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, model.LabelValues{"up"}, result)
+		})
+	}
+}
+
+func TestGetLabels(t *testing.T) {
+	api := &fakeAPI{
+		labelsFailures: map[string]int{
+			"up":                        2,
+			"http_requests_total":       3,
+			"process_cpu_seconds_total": 0,
+		},
+		labels: map[string][]string{
+			"up":                        {"instance", "job"},
+			"http_requests_total":       {"code"},
+			"process_cpu_seconds_total": {"instance"},
+		},
+	}
+	metrics := model.LabelValues{"up", "http_requests_total", "process_cpu_seconds_total"}
+	result, failed := newTestCollector(api).getLabels(context.Background(), metrics, time.Now(), time.Now())
+	assert.Equal(t, 1, failed)
+	assert.Equal(t, map[string][]string{
+		"up":                        {"instance", "job"},
+		"process_cpu_seconds_total": {"instance"},
+	}, result)
+}
+
+func TestGetLabelsStopsWhenContextIsDone(t *testing.T) {
+	api := &fakeAPI{
+		labels: map[string][]string{
+			"up": {"instance", "job"},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	result, failed := newTestCollector(api).getLabels(ctx, model.LabelValues{"up"}, time.Now(), time.Now())
+	assert.Equal(t, 0, failed)
+	assert.Empty(t, result)
+}
+
+func TestFilterLabelNames(t *testing.T) {
+	includeLabels := common.MustNewRegexp("^(instance|job|pod|code)$")
+	excludeLabels := common.MustNewRegexp("^(instance|pod)$")
+	testSuite := []struct {
+		title         string
+		includeLabels *common.Regexp
+		excludeLabels *common.Regexp
+		expected      []string
+	}{
+		{
+			title:    "no filter",
+			expected: []string{"instance", "job", "pod", "code", "method"},
+		},
+		{
+			title:         "include labels",
+			includeLabels: &includeLabels,
+			expected:      []string{"instance", "job", "pod", "code"},
+		},
+		{
+			title:         "exclude labels",
+			excludeLabels: &excludeLabels,
+			expected:      []string{"job", "code", "method"},
+		},
+		{
+			title:         "exclude takes precedence over include",
+			includeLabels: &includeLabels,
+			excludeLabels: &excludeLabels,
+			expected:      []string{"job", "code"},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			labels := []string{"__name__", "instance", "job", "pod", "code", "method"}
+			assert.Equal(t, test.expected, filterLabelNames(labels, test.includeLabels, test.excludeLabels))
+		})
+	}
+}
@@ -14,16 +14,31 @@
 package metric
 
 import (
+	"cmp"
+	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/lithammer/fuzzysearch/fuzzy"
 	persesEcho "github.com/perses/common/echo"
 	"github.com/perses/metrics-usage/database"
 	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	parquetExport "github.com/perses/metrics-usage/pkg/export/parquet"
+	persesExport "github.com/perses/metrics-usage/pkg/export/perses"
+	"github.com/perses/metrics-usage/utils/idempotency"
+	"github.com/perses/metrics-usage/utils/inflight"
+	"github.com/perses/metrics-usage/utils/jsonbody"
+	"github.com/perses/metrics-usage/utils/respcache"
+	"github.com/perses/metrics-usage/utils/search"
+	"github.com/sirupsen/logrus"
 )
 
+const ndjsonContentType = "application/x-ndjson"
+
 func NewAPI(db database.Database) persesEcho.Register {
 	return &endpoint{
 		db: db,
@@ -36,63 +51,308 @@ type endpoint struct {
 
 func (e *endpoint) RegisterRoute(ech *echo.Echo) {
 	path := "/api/v1/metrics"
-	ech.POST(path, e.PushMetricsUsage)
+	ech.POST(path, e.PushMetricsUsage, inflight.Middleware(), idempotency.Middleware(e.db))
 	ech.GET(path, e.ListMetrics)
+	ech.GET(fmt.Sprintf("%s/export/perses", path), e.ExportPerses)
+	ech.GET(fmt.Sprintf("%s/export/parquet", path), e.ExportParquet)
 	ech.GET(fmt.Sprintf("%s/:id", path), e.GetMetric)
+	ech.GET(fmt.Sprintf("%s/:id/usage", path), e.GetMetricUsage)
 
-	ech.POST("/api/v1/partial_metrics", e.PushMetricsUsage)
+	ech.POST("/api/v1/partial_metrics", e.PushMetricsUsage, inflight.Middleware(), idempotency.Middleware(e.db))
 	ech.GET("/api/v1/partial_metrics", e.ListPartialMetrics)
+	ech.GET("/api/v1/partial_metrics/stats", e.GetPartialMetricsStats, respcache.Middleware(e.db))
+	ech.POST("/api/v1/partial_metrics/recompute", e.RecomputePartialMetrics)
 	ech.GET("/api/v1/pending_usages", e.ListPendingUsages)
+	ech.GET("/api/v1/broken_queries", e.ListBrokenQueries)
+	ech.GET("/api/v1/graph", e.GetGraph, respcache.Middleware(e.db))
+}
+
+type getMetricRequest struct {
+	// Fields is the list of the JSON paths to return, like usage.dashboards. Default to the whole metric.
+	Fields []string `query:"fields"`
 }
 
 func (e *endpoint) GetMetric(ctx echo.Context) error {
+	req := &getMetricRequest{}
+	if err := ctx.Bind(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
 	name := ctx.Param("id")
 	metric := e.db.GetMetric(name)
 	if metric == nil {
 		return echo.NewHTTPError(http.StatusNotFound)
 	}
-	return ctx.JSON(http.StatusOK, metric)
+	metric.UsageCount = metric.Usage.Count()
+	result, err := parseFields(req.Fields).apply(metric)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	return ctx.JSON(http.StatusOK, result)
+}
+
+type usageRequest struct {
+	Page int `query:"page"`
+	Size int `query:"size"`
+	// ConfirmedAfter and ConfirmedBefore are used to only return the usages last collected in this time range, like the dashboards not collected anymore.
+	// The usages whose last collection is unknown, because their collector is not reconciling them, are excluded by these filters.
+	ConfirmedAfter  *time.Time `query:"confirmed_after"`
+	ConfirmedBefore *time.Time `query:"confirmed_before"`
+}
+
+func (r *usageRequest) match(item v1.UsageItem) bool {
+	if r.ConfirmedAfter == nil && r.ConfirmedBefore == nil {
+		return true
+	}
+	if item.LastConfirmed == nil {
+		return false
+	}
+	if r.ConfirmedAfter != nil && item.LastConfirmed.Before(*r.ConfirmedAfter) {
+		return false
+	}
+	return r.ConfirmedBefore == nil || item.LastConfirmed.Before(*r.ConfirmedBefore)
+}
+
+var usageKindOrder = map[v1.UsageKind]int{
+	v1.DashboardUsageKind:     0,
+	v1.RecordingRuleUsageKind: 1,
+	v1.AlertRuleUsageKind:     2,
+	v1.GrafanaAlertUsageKind:  3,
+}
+
+// GetMetricUsage returns every usage of the metric, including the ones coming from the partial metrics matching it.
+// The usages are sorted by kind, so they are grouped together, and then paginated.
+func (e *endpoint) GetMetricUsage(ctx echo.Context) error {
+	req := &usageRequest{}
+	if err := ctx.Bind(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	usage, partialUsages, exists := e.db.GetMetricUsage(ctx.Param("id"))
+	if !exists {
+		return echo.NewHTTPError(http.StatusNotFound)
+	}
+	items := usage.Flatten("")
+	for partialMetricName, partialUsage := range partialUsages {
+		items = append(items, partialUsage.Flatten(partialMetricName)...)
+	}
+	items = slices.DeleteFunc(items, func(item v1.UsageItem) bool {
+		return !req.match(item)
+	})
+	slices.SortFunc(items, func(a, b v1.UsageItem) int {
+		return cmp.Or(
+			cmp.Compare(usageKindOrder[a.Kind], usageKindOrder[b.Kind]),
+			cmp.Compare(usageItemKey(a), usageItemKey(b)),
+			cmp.Compare(a.PartialMetric, b.PartialMetric),
+		)
+	})
+	return ctx.JSON(http.StatusOK, v1.Paginate(items, req.Page, req.Size))
+}
+
+func usageItemKey(item v1.UsageItem) string {
+	if item.Dashboard != nil {
+		return item.Dashboard.Name + item.Dashboard.URL
+	}
+	if item.Rule != nil {
+		return item.Rule.GroupName + item.Rule.Name + item.Rule.PromLink
+	}
+	if item.GrafanaAlert != nil {
+		return item.GrafanaAlert.GroupName + item.GrafanaAlert.Name + item.GrafanaAlert.URL
+	}
+	return ""
 }
 
 type request struct {
-	MetricName          string `query:"metric_name"`
-	Used                *bool  `query:"used"`
-	MergePartialMetrics bool   `query:"merge_partial_metrics"`
+	MetricName string `query:"metric_name"`
+	// Mode defines how MetricName is matched. Default to a fuzzy search.
+	Mode                search.Mode `query:"mode"`
+	Used                *bool       `query:"used"`
+	MergePartialMetrics bool        `query:"merge_partial_metrics"`
+	// DedupeRules is used to collapse the rules sharing the same group name, name and expression but coming from different Prometheus.
+	DedupeRules bool `query:"dedupe_rules"`
+	// HasLabel is the list of labels the metrics must have.
+	HasLabel []string `query:"has_label"`
+	// MissingLabel is the list of labels the metrics must not have.
+	MissingLabel []string `query:"missing_label"`
+	// Internal is used to return only the metrics flagged as internal (or not) by the classification rules.
+	Internal *bool `query:"internal"`
+	// Owner is used to return only the metrics owned by the given team, according to the ownership file.
+	Owner string `query:"owner"`
+	// Tag is the list of the tags, given as key=value, the usage must have. The usage without them is ignored.
+	Tag []string `query:"tag"`
+	// tags is Tag parsed.
+	tags map[string]string
+	// Transitive is used to consider a metric used only if it is used by a dashboard or an alert rule, directly or through a chain of recording rules.
+	Transitive bool `query:"transitive"`
+	// Fields is the list of the JSON paths to return for each metric, like usage.dashboards. Default to the whole metric.
+	Fields []string `query:"fields"`
+	// Sort is used to return the metrics as a list sorted on the given key, instead of a map indexed by their name.
+	Sort sortKey `query:"sort"`
+	// Order is the order of the sort. Default to asc.
+	Order sortOrder `query:"order"`
 }
 
 func (r *request) filter(validMetricList map[string]*v1.Metric, partialMetricList map[string]*v1.PartialMetric) map[string]*v1.Metric {
 	result := make(map[string]*v1.Metric)
-
-	if r.MergePartialMetrics {
-		for _, metric := range partialMetricList {
-			for metricName := range metric.MatchingMetrics {
-				if m, ok := validMetricList[metricName]; ok {
-					m.Usage = v1.MergeUsage(m.Usage, metric.Usage)
-				}
+	partialUsages := r.partialUsagesByMetric(partialMetricList)
+	var usedMetrics v1.Set[string]
+	if r.Transitive && r.Used != nil {
+		if len(r.tags) > 0 {
+			// The transitive usage must only follow the recording rules having the tags.
+			for _, metric := range validMetricList {
+				metric.Usage = metric.Usage.FilterTags(r.tags)
 			}
 		}
+		usedMetrics = transitivelyUsedMetrics(validMetricList)
+	}
+	for k, v := range validMetricList {
+		if r.apply(k, v, partialUsages, usedMetrics) {
+			result[k] = v
+		}
 	}
+	return result
+}
 
-	if len(r.MetricName) == 0 && r.Used == nil {
-		return validMetricList
+// partialUsagesByMetric returns, for every metric, the usage of the partial metrics matching it.
+// It returns nil if the partial metrics don't need to be merged.
+func (r *request) partialUsagesByMetric(partialMetricList map[string]*v1.PartialMetric) map[string][]*v1.MetricUsage {
+	if !r.MergePartialMetrics {
+		return nil
 	}
-	for k, v := range validMetricList {
-		if len(r.MetricName) == 0 || fuzzy.Match(r.MetricName, k) {
-			if r.Used == nil {
-				result[k] = v
-			} else if *r.Used && validMetricList[k].Usage != nil {
-				result[k] = v
-			} else if !*r.Used && validMetricList[k].Usage == nil {
-				result[k] = v
-			}
+	result := make(map[string][]*v1.MetricUsage)
+	for _, metric := range partialMetricList {
+		for metricName := range metric.MatchingMetrics {
+			result[metricName] = append(result[metricName], metric.Usage)
 		}
 	}
 	return result
 }
 
-func (e *endpoint) ListMetrics(ctx echo.Context) error {
+// apply merges the usage of the partial metrics into the metric, keeps only the usage having the tags of the request,
+// dedupes its rules when required and sets its usage count.
+// Then it returns true if the metric is matching the filters of the request.
+// usedMetrics is the list of the metrics transitively used. It is only required when the filter transitive is used.
+func (r *request) apply(name string, metric *v1.Metric, partialUsages map[string][]*v1.MetricUsage, usedMetrics v1.Set[string]) bool {
+	for _, usage := range partialUsages[name] {
+		metric.Usage = v1.MergeUsage(metric.Usage, usage)
+	}
+	metric.Usage = metric.Usage.FilterTags(r.tags)
+	if r.DedupeRules {
+		metric.Usage = metric.Usage.DedupeRules()
+	}
+	metric.UsageCount = metric.Usage.Count()
+	if len(r.MetricName) > 0 && !r.Mode.Match(r.MetricName, name) {
+		return false
+	}
+	if !r.matchLabels(metric) {
+		return false
+	}
+	if r.Internal != nil && *r.Internal != metric.IsInternal {
+		return false
+	}
+	if len(r.Owner) > 0 && r.Owner != metric.Owner {
+		return false
+	}
+	isUsed := metric.Usage != nil
+	if usedMetrics != nil {
+		isUsed = usedMetrics.Contains(name)
+	}
+	return r.Used == nil || *r.Used == isUsed
+}
+
+func (r *request) matchLabels(metric *v1.Metric) bool {
+	for _, label := range r.HasLabel {
+		if !metric.Labels.Contains(label) {
+			return false
+		}
+	}
+	for _, label := range r.MissingLabel {
+		if metric.Labels.Contains(label) {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyStreamable returns an error if the request cannot be answered while the metrics are read one by one from the database.
+func (r *request) verifyStreamable(contentType string) error {
+	if r.Transitive && r.Used != nil {
+		// The transitive usage is computed from the whole list of metrics.
+		return fmt.Errorf("the filter transitive is not supported with %s", contentType)
+	}
+	if len(r.Sort) > 0 && (r.Sort != nameSort || r.Order != ascOrder) {
+		// The metrics are streamed in the order they are read from the database.
+		return fmt.Errorf("only the sort by name in ascending order is supported with %s", contentType)
+	}
+	return nil
+}
+
+func bindRequest(ctx echo.Context) (*request, error) {
 	req := &request{}
-	err := ctx.Bind(req)
+	if err := ctx.Bind(req); err != nil {
+		return nil, err
+	}
+	if len(req.Mode) == 0 {
+		req.Mode = search.FuzzyMode
+	}
+	if err := req.Mode.Verify(); err != nil {
+		return nil, err
+	}
+	tags, err := v1.ParseTagMatchers(req.Tag)
+	if err != nil {
+		return nil, err
+	}
+	req.tags = tags
+	if len(req.Order) > 0 && len(req.Sort) == 0 {
+		req.Sort = nameSort
+	}
+	if len(req.Sort) == 0 {
+		return req, nil
+	}
+	if len(req.Order) == 0 {
+		req.Order = ascOrder
+	}
+	if err := req.Sort.verify(); err != nil {
+		return nil, err
+	}
+	return req, req.Order.verify()
+}
+
+func (e *endpoint) ListMetrics(ctx echo.Context) error {
+	req, err := bindRequest(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	var partialMetricList map[string]*v1.PartialMetric
+	if req.MergePartialMetrics {
+		partialMetricList, err = e.db.ListPartialMetrics()
+		if err != nil {
+			return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+		}
+	}
+	if strings.Contains(ctx.Request().Header.Get(echo.HeaderAccept), ndjsonContentType) {
+		return e.streamMetrics(ctx, req, partialMetricList)
+	}
+	metricList, err := e.db.ListMetrics()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	filteredMetrics := req.filter(metricList, partialMetricList)
+	var result interface{}
+	if len(req.Sort) > 0 {
+		// The name of the metric is always kept, otherwise the items of the list couldn't be associated with a metric.
+		result, err = parseFields(req.Fields).with("name").apply(sortMetrics(filteredMetrics, req.Sort, req.Order))
+	} else {
+		result, err = parseFields(req.Fields).applyOnEach(filteredMetrics)
+	}
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	return ctx.JSON(http.StatusOK, result)
+}
+
+// ExportPerses returns the metrics matching the request as Perses resources, sorted by name unless another sort is requested.
+// The parameter fields is ignored.
+func (e *endpoint) ExportPerses(ctx echo.Context) error {
+	req, err := bindRequest(ctx)
 	if err != nil {
 		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
 	}
@@ -107,30 +367,226 @@ func (e *endpoint) ListMetrics(ctx echo.Context) error {
 	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
 	}
-	return ctx.JSON(http.StatusOK, req.filter(metricList, partialMetricList))
+	filteredMetrics := req.filter(metricList, partialMetricList)
+	if len(req.Sort) == 0 {
+		return ctx.JSON(http.StatusOK, persesExport.Convert(filteredMetrics))
+	}
+	sortedMetrics := sortMetrics(filteredMetrics, req.Sort, req.Order)
+	result := make([]persesExport.MetricMetadata, 0, len(sortedMetrics))
+	for _, metric := range sortedMetrics {
+		result = append(result, persesExport.ConvertMetric(metric.Name, metric.Metric))
+	}
+	return ctx.JSON(http.StatusOK, result)
+}
+
+// streamMetrics writes the metrics matching the request one per line (JSON Lines), as they are read from the database.
+// Like that, the whole list of metrics is never held in memory.
+func (e *endpoint) streamMetrics(ctx echo.Context, req *request, partialMetricList map[string]*v1.PartialMetric) error {
+	if err := req.verifyStreamable(ndjsonContentType); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	partialUsages := req.partialUsagesByMetric(partialMetricList)
+	// The name of the metric is always kept, otherwise the lines couldn't be associated with a metric.
+	fields := parseFields(req.Fields).with("name")
+	resp := ctx.Response()
+	resp.Header().Set(echo.HeaderContentType, ndjsonContentType)
+	resp.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(resp)
+	return e.db.IterateMetrics(func(name string, metric *v1.Metric) error {
+		if !req.apply(name, metric, partialUsages, nil) {
+			return nil
+		}
+		line, err := fields.apply(v1.NamedMetric{Name: name, Metric: metric})
+		if err != nil {
+			return err
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+		resp.Flush()
+		return nil
+	})
+}
+
+// ExportParquet writes the metrics matching the request as a Parquet file, one row per metric, sorted by name.
+// Like streamMetrics, the metrics are written as they are read from the database. The parameter fields is ignored.
+// As the status is already sent, a failure while writing the file aborts the response, so the client doesn't get a truncated file.
+func (e *endpoint) ExportParquet(ctx echo.Context) error {
+	req, err := bindRequest(ctx)
+	if err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if verifyErr := req.verifyStreamable(parquetExport.ContentType); verifyErr != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": verifyErr.Error()})
+	}
+	var partialMetricList map[string]*v1.PartialMetric
+	if req.MergePartialMetrics {
+		partialMetricList, err = e.db.ListPartialMetrics()
+		if err != nil {
+			return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+		}
+	}
+	partialUsages := req.partialUsagesByMetric(partialMetricList)
+	resp := ctx.Response()
+	resp.Header().Set(echo.HeaderContentType, parquetExport.ContentType)
+	resp.Header().Set(echo.HeaderContentDisposition, `attachment; filename="metrics.parquet"`)
+	resp.WriteHeader(http.StatusOK)
+	writer := parquetExport.NewWriter(resp)
+	err = e.db.IterateMetrics(func(name string, metric *v1.Metric) error {
+		if !req.apply(name, metric, partialUsages, nil) {
+			return nil
+		}
+		return writer.Write(name, metric)
+	})
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		logrus.WithError(err).Error("unable to write the Parquet export, aborting the response")
+		panic(http.ErrAbortHandler)
+	}
+	return nil
 }
 
 func (e *endpoint) PushMetricsUsage(ctx echo.Context) error {
 	data := make(map[string]*v1.MetricUsage)
-	if err := ctx.Bind(&data); err != nil {
-		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	if err := jsonbody.Bind(ctx, &data); err != nil {
+		return ctx.JSON(http.StatusBadRequest, err)
+	}
+	if errs := v1.ValidateUsage(data); len(errs) > 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "invalid usage", "errors": errs})
 	}
 	e.db.EnqueueUsage(data)
 	return ctx.JSON(http.StatusAccepted, echo.Map{"message": "OK"})
 }
 
+type partialMetricsRequest struct {
+	// Offset and Limit are used to return a slice of the partial metrics as a list, sorted by name. See isPaginated.
+	Offset int `query:"offset"`
+	Limit  int `query:"limit"`
+	// IncludeMatching is used to return the list of metrics matching each partial metric. Default to true.
+	IncludeMatching *bool `query:"include_matching"`
+	// MinMatches and MaxMatches are used to filter the partial metrics on the number of metrics they are matching.
+	MinMatches *int `query:"min_matches"`
+	MaxMatches *int `query:"max_matches"`
+	// CollapsePartials is used to hide the partial metrics that are redundant with the metrics they are matching.
+	// See isRedundant for more details.
+	CollapsePartials bool `query:"collapse_partials"`
+}
+
+// filter returns the partial metrics matching the request.
+// metricList is only used to collapse the partial metrics, it can be nil otherwise.
+func (r *partialMetricsRequest) filter(partialMetricList map[string]*v1.PartialMetric, metricList map[string]*v1.Metric) []v1.NamedPartialMetric {
+	var result []v1.NamedPartialMetric
+	for name, partialMetric := range partialMetricList {
+		if r.CollapsePartials && isRedundant(partialMetric, metricList) {
+			continue
+		}
+		nbMatches := len(partialMetric.MatchingMetrics)
+		if partialMetric.TooBroad {
+			// The matching metrics are not kept, but there are more than any reasonable maximum.
+			nbMatches = math.MaxInt
+		}
+		if r.MinMatches != nil && nbMatches < *r.MinMatches {
+			continue
+		}
+		if r.MaxMatches != nil && nbMatches > *r.MaxMatches {
+			continue
+		}
+		if r.IncludeMatching != nil && !*r.IncludeMatching {
+			partialMetric.MatchingMetrics = nil
+		}
+		result = append(result, v1.NamedPartialMetric{Name: name, PartialMetric: partialMetric})
+	}
+	slices.SortFunc(result, func(a, b v1.NamedPartialMetric) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return result
+}
+
 func (e *endpoint) ListPartialMetrics(ctx echo.Context) error {
+	req := &partialMetricsRequest{}
+	if err := ctx.Bind(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	list, err := e.db.ListPartialMetrics()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	var metricList map[string]*v1.Metric
+	if req.CollapsePartials {
+		metricList, err = e.db.ListMetrics()
+		if err != nil {
+			return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+		}
+	}
+	filtered := req.filter(list, metricList)
+	if isPaginated(ctx) {
+		return ctx.JSON(http.StatusOK, v1.PaginateWithOffset(filtered, req.Offset, req.Limit))
+	}
+	result := make(map[string]*v1.PartialMetric, len(filtered))
+	for _, partialMetric := range filtered {
+		result[partialMetric.Name] = partialMetric.PartialMetric
+	}
+	return ctx.JSON(http.StatusOK, result)
+}
+
+// isPaginated returns true when an offset or a limit is requested. Otherwise, the partial metrics are returned as a map indexed by their name, like before the pagination.
+func isPaginated(ctx echo.Context) bool {
+	params := ctx.QueryParams()
+	return params.Has("offset") || params.Has("limit")
+}
+
+func (e *endpoint) GetPartialMetricsStats(ctx echo.Context) error {
+	req := &partialMetricsStatsRequest{}
+	if err := ctx.Bind(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
 	list, err := e.db.ListPartialMetrics()
 	if err != nil {
 		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
 	}
-	return ctx.JSON(http.StatusOK, list)
+	nbMetrics := 0
+	if err = e.db.IterateMetrics(func(_ string, _ *v1.Metric) error {
+		nbMetrics++
+		return nil
+	}); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	return ctx.JSON(http.StatusOK, computePartialMetricsStats(list, nbMetrics, req.IncludeNames))
+}
+
+// isRedundant returns true if the partial metric isn't adding anything to the metrics it is matching.
+// That's the case when every dashboard or rule using the partial metric is also using directly each matching metric.
+// For example, a dashboard using both http_requests_.+ and http_requests_total, the only metric matching the regexp.
+func isRedundant(partialMetric *v1.PartialMetric, metricList map[string]*v1.Metric) bool {
+	if len(partialMetric.MatchingMetrics) == 0 {
+		return false
+	}
+	for name := range partialMetric.MatchingMetrics {
+		metric, ok := metricList[name]
+		if !ok || metric == nil || !partialMetric.Usage.IsCoveredBy(metric.Usage) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *endpoint) RecomputePartialMetrics(ctx echo.Context) error {
+	nbPartialMetrics, nbMatches := e.db.RecomputePartialMetrics()
+	return ctx.JSON(http.StatusOK, echo.Map{
+		"partial_metrics":  nbPartialMetrics,
+		"matching_metrics": nbMatches,
+	})
 }
 
 func (e *endpoint) PushPartialMetricsUsage(ctx echo.Context) error {
 	data := make(map[string]*v1.MetricUsage)
-	if err := ctx.Bind(&data); err != nil {
-		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	if err := jsonbody.Bind(ctx, &data); err != nil {
+		return ctx.JSON(http.StatusBadRequest, err)
+	}
+	if errs := v1.ValidateUsage(data); len(errs) > 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "invalid usage", "errors": errs})
 	}
 	e.db.EnqueuePartialMetricsUsage(data)
 	return ctx.JSON(http.StatusAccepted, echo.Map{"message": "OK"})
@@ -139,3 +595,40 @@ func (e *endpoint) PushPartialMetricsUsage(ctx echo.Context) error {
 func (e *endpoint) ListPendingUsages(ctx echo.Context) error {
 	return ctx.JSON(http.StatusOK, e.db.ListPendingUsage())
 }
+
+func (e *endpoint) ListBrokenQueries(ctx echo.Context) error {
+	result := e.db.ListBrokenQueries()
+	if result == nil {
+		result = []v1.DashboardBrokenQueries{}
+	}
+	return ctx.JSON(http.StatusOK, result)
+}
+
+type graphRequest struct {
+	// Format is either json or dot. Default to json.
+	Format string `query:"format"`
+	// IncludeRules is used to add the rule groups to the graph.
+	IncludeRules bool `query:"include_rules"`
+	// Root is the ID of a node, like metric:up. When set, only the nodes connected to it are returned.
+	Root string `query:"root"`
+}
+
+// GetGraph returns the graph of the dependencies between the metrics and the dashboards (and optionally the rule groups) using them.
+func (e *endpoint) GetGraph(ctx echo.Context) error {
+	req := &graphRequest{}
+	if err := ctx.Bind(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if req.Format != "" && req.Format != "json" && req.Format != "dot" {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": fmt.Sprintf("unknown format %q, it must be json or dot", req.Format)})
+	}
+	metricList, err := e.db.ListMetrics()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	g := buildGraph(metricList, req.IncludeRules, req.Root)
+	if req.Format == "dot" {
+		return ctx.Blob(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(g.dot()))
+	}
+	return ctx.JSON(http.StatusOK, g)
+}
@@ -0,0 +1,542 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/parquet-go/parquet-go"
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/metrics-usage/database"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	parquetExport "github.com/perses/metrics-usage/pkg/export/parquet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListMetricsAsNDJSON(t *testing.T) {
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	db.EnqueueMetricList([]string{"foo", "bar", "baz"})
+	require.Eventually(t, func() bool {
+		metrics, _ := db.ListMetrics()
+		return len(metrics) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	e := echo.New()
+	NewAPI(db).RegisterRoute(e)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/metrics?metric_name=ba", nil)
+	req.Header.Set(echo.HeaderAccept, ndjsonContentType)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, ndjsonContentType, rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, "{\"name\":\"bar\"}\n{\"name\":\"baz\"}\n", rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/metrics?metric_name=ar&mode=suffix", nil)
+	req.Header.Set(echo.HeaderAccept, ndjsonContentType)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "{\"name\":\"bar\"}\n", rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/metrics?metric_name=ba&mode=regex", nil)
+	req.Header.Set(echo.HeaderAccept, ndjsonContentType)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/metrics?used=false&transitive=true", nil)
+	req.Header.Set(echo.HeaderAccept, ndjsonContentType)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestListMetricsSorted(t *testing.T) {
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	db.EnqueueMetricList([]string{"foo", "bar", "baz"})
+	db.EnqueueUsage(map[string]*v1.MetricUsage{"foo": {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "dashboard"})}})
+	require.Eventually(t, func() bool {
+		metric := db.GetMetric("foo")
+		return metric != nil && metric.Usage != nil && db.GetMetric("bar") != nil && db.GetMetric("baz") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	e := echo.New()
+	NewAPI(db).RegisterRoute(e)
+	testSuite := []struct {
+		title        string
+		query        string
+		accept       string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			title:        "sort by name desc",
+			query:        "order=desc&fields=usage.dashboards",
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"name":"foo","usage":{"dashboards":[{"uid":"dashboard","title":"","url":""}]}},{"name":"baz"},{"name":"bar"}]`,
+		},
+		{
+			title:        "used first",
+			query:        "sort=unused_first&order=desc&fields=name",
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"name":"foo"},{"name":"bar"},{"name":"baz"}]`,
+		},
+		{
+			title:        "series count not available",
+			query:        "sort=series_count",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			title:        "unknown order",
+			query:        "sort=name&order=random",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			title:        "stream sorted by name",
+			query:        "sort=name&fields=name",
+			accept:       ndjsonContentType,
+			expectedCode: http.StatusOK,
+		},
+		{
+			title:        "stream sorted by dashboard count",
+			query:        "sort=dashboard_count",
+			accept:       ndjsonContentType,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/metrics?"+test.query, nil)
+			if len(test.accept) > 0 {
+				req.Header.Set(echo.HeaderAccept, test.accept)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, test.expectedCode, rec.Code)
+			if len(test.expectedBody) > 0 {
+				assert.JSONEq(t, test.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestListMetricsByTag(t *testing.T) {
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	prodEU := v1.NewTags(map[string]string{"cluster": "prod-eu", "env": "prod"})
+	prodUS := v1.NewTags(map[string]string{"cluster": "prod-us", "env": "prod"})
+	db.EnqueueMetricList([]string{"up", "node_load1", "http_requests_total"})
+	db.EnqueueUsage(map[string]*v1.MetricUsage{
+		"up":                  {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "eu", Tags: prodEU}, v1.DashboardUsage{ID: "us", Tags: prodUS})},
+		"node_load1":          {AlertRules: v1.NewSet(v1.RuleUsage{Name: "HighLoad", Tags: prodUS})},
+		"http_requests_total": {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "untagged"})},
+	})
+	require.Eventually(t, func() bool {
+		metric := db.GetMetric("http_requests_total")
+		return metric != nil && metric.Usage != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	e := echo.New()
+	NewAPI(db).RegisterRoute(e)
+	testSuite := []struct {
+		title        string
+		query        string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			title:        "used in a cluster",
+			query:        "tag=cluster=prod-eu&used=true&fields=usage",
+			expectedCode: http.StatusOK,
+			expectedBody: `{"up":{"usage":{"dashboards":[{"uid":"eu","title":"","url":"","tags":{"cluster":"prod-eu","env":"prod"}}]}}}`,
+		},
+		{
+			title:        "unused in a cluster",
+			query:        "tag=cluster=prod-eu&used=false&sort=name&fields=name",
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"name":"http_requests_total"},{"name":"node_load1"}]`,
+		},
+		{
+			title:        "every tag must match",
+			query:        "tag=env=prod&tag=cluster=prod-us&used=true&sort=name&fields=usageCount",
+			expectedCode: http.StatusOK,
+			expectedBody: `[{"name":"node_load1","usageCount":{"alertRules":1}},{"name":"up","usageCount":{"dashboards":1}}]`,
+		},
+		{
+			title:        "invalid tag",
+			query:        "tag=prod",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/metrics?"+test.query, nil))
+			assert.Equal(t, test.expectedCode, rec.Code)
+			if len(test.expectedBody) > 0 {
+				assert.JSONEq(t, test.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestExportPerses(t *testing.T) {
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	db.EnqueueMetricList([]string{"up", "export"})
+	db.EnqueueUsage(map[string]*v1.MetricUsage{"up": {
+		Dashboards: v1.NewSet(v1.DashboardUsage{ID: "perses/node", Name: "node", URL: "https://demo.perses.dev/api/v1/projects/perses/dashboards/node"}),
+	}})
+	require.Eventually(t, func() bool {
+		metric := db.GetMetric("up")
+		return metric != nil && metric.Usage != nil && db.GetMetric("export") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	e := echo.New()
+	NewAPI(db).RegisterRoute(e)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/metrics/export/perses?used=true", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `[{"kind":"MetricMetadata","metadata":{"name":"up"},"spec":{"used":true,"usage":{"dashboards":[{"project":"perses","dashboard":"node"}]}}}]`, rec.Body.String())
+
+	// A metric named export is still available.
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/metrics/export", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestGetMetricUsageConfirmed(t *testing.T) {
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	db.EnqueueMetricList([]string{"up"})
+	require.Eventually(t, func() bool {
+		return db.GetMetric("up") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	db.EnqueueUsage(map[string]*v1.MetricUsage{"up": {
+		Dashboards: v1.NewSet(
+			v1.DashboardUsage{ID: "old", URL: "http://grafana/d/old"},
+			v1.DashboardUsage{ID: "new", URL: "http://grafana/d/new"},
+			v1.DashboardUsage{ID: "unknown", URL: "http://grafana-2/d/unknown"},
+		),
+		LastConfirmed: map[string]time.Time{
+			"dashboard:http://grafana/d/old": time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC),
+			"dashboard:http://grafana/d/new": time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}})
+	require.Eventually(t, func() bool {
+		usage, _, _ := db.GetMetricUsage("up")
+		return usage != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	e := echo.New()
+	NewAPI(db).RegisterRoute(e)
+	testSuite := []struct {
+		title    string
+		query    string
+		expected []string
+	}{
+		{
+			title:    "no filter",
+			expected: []string{"unknown", "new", "old"},
+		},
+		{
+			title:    "confirmed before",
+			query:    "confirmed_before=2024-11-15T00:00:00Z",
+			expected: []string{"old"},
+		},
+		{
+			title:    "confirmed in a range",
+			query:    "confirmed_after=2024-11-15T00:00:00Z&confirmed_before=2025-01-01T00:00:00Z",
+			expected: []string{"new"},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/metrics/up/usage?"+test.query, nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code)
+			var page struct {
+				Items []v1.UsageItem `json:"items"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+			var ids []string
+			for _, item := range page.Items {
+				ids = append(ids, item.Dashboard.ID)
+			}
+			assert.Equal(t, test.expected, ids)
+		})
+	}
+}
+
+func TestGetMetricUsage(t *testing.T) {
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	db.EnqueueMetricList([]string{"foo_total", "bar"})
+	require.Eventually(t, func() bool {
+		return db.GetMetric("foo_total") != nil && db.GetMetric("bar") != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	db.EnqueueUsage(map[string]*v1.MetricUsage{"foo_total": {
+		Dashboards:     v1.NewSet(v1.DashboardUsage{ID: "b", Name: "b"}, v1.DashboardUsage{ID: "a", Name: "a"}),
+		RecordingRules: v1.NewSet(v1.RuleUsage{GroupName: "group", Name: "record"}),
+		AlertRules:     v1.NewSet(v1.RuleUsage{GroupName: "group", Name: "alert"}),
+	}})
+	db.EnqueuePartialMetricsUsage(map[string]*v1.MetricUsage{
+		"foo_.+": {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "c", Name: "c"})},
+	})
+	require.Eventually(t, func() bool {
+		usage, partialUsages, _ := db.GetMetricUsage("foo_total")
+		return usage != nil && len(partialUsages) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	e := echo.New()
+	NewAPI(db).RegisterRoute(e)
+	testSuite := []struct {
+		title         string
+		metric        string
+		query         string
+		expectedCode  int
+		expectedTotal int
+		expected      []string
+	}{
+		{
+			title:         "sorted by kind",
+			metric:        "foo_total",
+			expectedCode:  http.StatusOK,
+			expectedTotal: 5,
+			expected:      []string{"dashboard:a", "dashboard:b", "dashboard:c:foo_.+", "recordingRule:record", "alertRule:alert"},
+		},
+		{
+			title:         "second page",
+			metric:        "foo_total",
+			query:         "page=2&size=2",
+			expectedCode:  http.StatusOK,
+			expectedTotal: 5,
+			expected:      []string{"dashboard:c:foo_.+", "recordingRule:record"},
+		},
+		{
+			title:         "page out of range",
+			metric:        "foo_total",
+			query:         "page=4&size=2",
+			expectedCode:  http.StatusOK,
+			expectedTotal: 5,
+			expected:      []string{},
+		},
+		{
+			title:        "metric without usage",
+			metric:       "bar",
+			expectedCode: http.StatusOK,
+			expected:     []string{},
+		},
+		{
+			title:        "unknown metric",
+			metric:       "unknown",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			title:        "invalid page",
+			metric:       "foo_total",
+			query:        "page=first",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/metrics/"+test.metric+"/usage?"+test.query, nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			require.Equal(t, test.expectedCode, rec.Code)
+			if test.expectedCode != http.StatusOK {
+				return
+			}
+			var page v1.PaginatedList[v1.UsageItem]
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+			assert.Equal(t, test.expectedTotal, page.Total)
+			items := []string{}
+			for _, item := range page.Items {
+				var name string
+				switch {
+				case item.Dashboard != nil:
+					name = item.Dashboard.Name
+				case item.Rule != nil:
+					name = item.Rule.Name
+				}
+				if item.PartialMetric != "" {
+					name += ":" + item.PartialMetric
+				}
+				items = append(items, string(item.Kind)+":"+name)
+			}
+			assert.Equal(t, test.expected, items)
+		})
+	}
+}
+
+func TestListPartialMetrics(t *testing.T) {
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	db.EnqueuePartialMetricsUsage(map[string]*v1.MetricUsage{
+		"foo_.+": {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "a"})},
+		"bar_.+": {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "b"})},
+		"baz_.+": {Dashboards: v1.NewSet(v1.DashboardUsage{ID: "c"})},
+	})
+	require.Eventually(t, func() bool {
+		partialMetrics, _ := db.ListPartialMetrics()
+		return len(partialMetrics) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	e := echo.New()
+	NewAPI(db).RegisterRoute(e)
+
+	// Without pagination, the partial metrics are returned as a map indexed by their name.
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/partial_metrics", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	partialMetrics := make(map[string]*v1.PartialMetric)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &partialMetrics))
+	assert.Len(t, partialMetrics, 3)
+	assert.Contains(t, partialMetrics, "foo_.+")
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/partial_metrics?offset=1&limit=1", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var page v1.OffsetPaginatedList[v1.NamedPartialMetric]
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, 1, page.Offset)
+	assert.Equal(t, 1, page.Limit)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "baz_.+", page.Items[0].Name)
+
+	// An offset alone returns every remaining partial metric.
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/partial_metrics?offset=2", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	page = v1.OffsetPaginatedList[v1.NamedPartialMetric]{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+	assert.Equal(t, 3, page.Total)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "foo_.+", page.Items[0].Name)
+}
+
+func TestIsRedundant(t *testing.T) {
+	d1 := v1.DashboardUsage{ID: "d1", Name: "dashboard 1"}
+	d2 := v1.DashboardUsage{ID: "d2", Name: "dashboard 2"}
+	metricList := map[string]*v1.Metric{
+		"http_requests_total":        {Usage: &v1.MetricUsage{Dashboards: v1.NewSet(d1, d2)}},
+		"http_requests_failed_total": {Usage: &v1.MetricUsage{Dashboards: v1.NewSet(d1)}},
+		"http_requests_in_flight":    {},
+	}
+	testSuites := []struct {
+		title         string
+		partialMetric *v1.PartialMetric
+		result        bool
+	}{
+		{
+			title: "no matching metric",
+			partialMetric: &v1.PartialMetric{
+				Usage: &v1.MetricUsage{Dashboards: v1.NewSet(d1)},
+			},
+			result: false,
+		},
+		{
+			title: "usage covered by every matching metric",
+			partialMetric: &v1.PartialMetric{
+				Usage:           &v1.MetricUsage{Dashboards: v1.NewSet(d1)},
+				MatchingMetrics: v1.NewSet("http_requests_total", "http_requests_failed_total"),
+			},
+			result: true,
+		},
+		{
+			title: "usage not covered by a matching metric",
+			partialMetric: &v1.PartialMetric{
+				Usage:           &v1.MetricUsage{Dashboards: v1.NewSet(d2)},
+				MatchingMetrics: v1.NewSet("http_requests_total", "http_requests_failed_total"),
+			},
+			result: false,
+		},
+		{
+			title: "matching metric without usage",
+			partialMetric: &v1.PartialMetric{
+				Usage:           &v1.MetricUsage{Dashboards: v1.NewSet(d1)},
+				MatchingMetrics: v1.NewSet("http_requests_total", "http_requests_in_flight"),
+			},
+			result: false,
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.result, isRedundant(test.partialMetric, metricList))
+		})
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	db.EnqueueMetricList([]string{"foo", "bar", "baz"})
+	require.Eventually(t, func() bool {
+		metrics, _ := db.ListMetrics()
+		return len(metrics) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	e := echo.New()
+	NewAPI(db).RegisterRoute(e)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/metrics/export/parquet?metric_name=ba&mode=prefix", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, parquetExport.ContentType, rec.Header().Get(echo.HeaderContentType))
+	rows, err := parquet.Read[parquetExport.Row](bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "bar", rows[0].Name)
+	assert.Equal(t, "baz", rows[1].Name)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/metrics/export/parquet?sort=usage_count", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Once the status is sent, a failure aborts the response instead of writing an error after a part of the file.
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/metrics/export/parquet", nil)
+	failing := &failingResponseWriter{ResponseRecorder: httptest.NewRecorder()}
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		e.ServeHTTP(failing, req)
+	})
+}
+
+// failingResponseWriter fails to write the body, like when the connection with the client is lost.
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *failingResponseWriter) Write(_ []byte) (int, error) {
+	return 0, errors.New("connection reset by peer")
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// fieldsProjection is the list of the JSON paths to keep in a response, like usage.dashboards.
+// Each path is split on the dots.
+type fieldsProjection [][]string
+
+// parseFields is parsing the query parameter fields. It can be repeated and/or contain a comma-separated list of paths.
+func parseFields(fields []string) fieldsProjection {
+	var result fieldsProjection
+	for _, field := range fields {
+		for _, path := range strings.Split(field, ",") {
+			path = strings.TrimSpace(path)
+			if len(path) > 0 {
+				result = append(result, strings.Split(path, "."))
+			}
+		}
+	}
+	return result
+}
+
+// with returns a new projection including the given top-level field.
+func (f fieldsProjection) with(field string) fieldsProjection {
+	if len(f) == 0 {
+		return f
+	}
+	return append(fieldsProjection{{field}}, f...)
+}
+
+// apply returns the value projected on the paths. The value is returned as is when there is no path.
+func (f fieldsProjection) apply(value interface{}) (interface{}, error) {
+	if len(f) == 0 {
+		return value, nil
+	}
+	decoded, err := toJSONValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return project(decoded, f), nil
+}
+
+// applyOnEach is like apply, but on each value of the map. It is used for the list of metrics indexed by their name.
+func (f fieldsProjection) applyOnEach(value interface{}) (interface{}, error) {
+	if len(f) == 0 {
+		return value, nil
+	}
+	decoded, err := toJSONValue(value)
+	if err != nil {
+		return nil, err
+	}
+	if obj, ok := decoded.(map[string]interface{}); ok {
+		for key, v := range obj {
+			obj[key] = project(v, f)
+		}
+	}
+	return decoded, nil
+}
+
+func toJSONValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	return result, json.Unmarshal(data, &result)
+}
+
+// project keeps only the paths in the JSON value. When the value is a list, the projection is applied on each element.
+// The paths not matching anything are ignored.
+func project(value interface{}, paths [][]string) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		for i, item := range v {
+			v[i] = project(item, paths)
+		}
+		return v
+	case map[string]interface{}:
+		whole := make(map[string]bool)
+		subPaths := make(map[string][][]string)
+		for _, path := range paths {
+			if len(path) == 1 {
+				whole[path[0]] = true
+			} else {
+				subPaths[path[0]] = append(subPaths[path[0]], path[1:])
+			}
+		}
+		result := make(map[string]interface{})
+		for key, item := range v {
+			if whole[key] {
+				result[key] = item
+			} else if sub, ok := subPaths[key]; ok {
+				projected := project(item, sub)
+				// An empty object means the sub-paths don't exist, so the key is not kept either.
+				if obj, isObject := projected.(map[string]interface{}); !isObject || len(obj) > 0 {
+					result[key] = projected
+				}
+			}
+		}
+		return result
+	default:
+		return value
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"encoding/json"
+	"testing"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFieldsProjection(t *testing.T) {
+	metric := &v1.Metric{
+		Labels: v1.NewSet("job"),
+		Usage: &v1.MetricUsage{
+			Dashboards: v1.NewSet(v1.DashboardUsage{ID: "d1", Name: "Node", URL: "https://grafana/d/d1"}),
+			AlertRules: v1.NewSet(v1.RuleUsage{Name: "NodeDown"}),
+		},
+	}
+	testSuites := []struct {
+		title  string
+		fields []string
+		result string
+	}{
+		{
+			title:  "no field",
+			result: `{"labels":["job"],"usage":{"dashboards":[{"uid":"d1","title":"Node","url":"https://grafana/d/d1"}],"alertRules":[{"prom_link":"","group_name":"","name":"NodeDown","expression":""}]}}`,
+		},
+		{
+			title:  "nested field",
+			fields: []string{"usage.dashboards"},
+			result: `{"usage":{"dashboards":[{"title":"Node","uid":"d1","url":"https://grafana/d/d1"}]}}`,
+		},
+		{
+			title:  "comma-separated fields and field of the list items",
+			fields: []string{"labels, usage.dashboards.title"},
+			result: `{"labels":["job"],"usage":{"dashboards":[{"title":"Node"}]}}`,
+		},
+		{
+			title:  "unknown fields",
+			fields: []string{"statistics.series_count", "usage.recordingRules"},
+			result: `{}`,
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			result, err := parseFields(test.fields).apply(metric)
+			require.NoError(t, err)
+			data, err := json.Marshal(result)
+			require.NoError(t, err)
+			assert.JSONEq(t, test.result, string(data))
+		})
+	}
+}
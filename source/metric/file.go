@@ -0,0 +1,186 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/perses/common/async"
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/metrics-usage/database"
+	"github.com/perses/metrics-usage/pkg/analyze/prometheus"
+	"github.com/perses/metrics-usage/utils/instrumentation"
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+)
+
+func NewFileCollector(db database.Database, cfg *config.MetricFileCollector) (async.SimpleTask, error) {
+	result := &metricFileCollector{
+		db:         db,
+		path:       cfg.Path,
+		instance:   cfg.Path,
+		runTimeout: time.Duration(cfg.RunTimeout),
+		logger:     logrus.StandardLogger().WithField("collector", "metrics_file"),
+	}
+	if cfg.HTTPClient != nil {
+		httpClient, err := config.NewHTTPClient(*cfg.HTTPClient, "metrics_file")
+		if err != nil {
+			return nil, err
+		}
+		result.httpClient = httpClient
+		result.url = cfg.HTTPClient.URL.String()
+		result.instance = cfg.HTTPClient.URL.Redacted()
+	}
+	return result, nil
+}
+
+// metricFileCollector is reading the list of metrics from a file, or from a URL when the httpClient is set.
+type metricFileCollector struct {
+	async.SimpleTask
+	db         database.Database
+	path       string
+	httpClient *http.Client
+	url        string
+	// instance is the path or the URL without its password of the file, identifying the collector in its metrics.
+	instance   string
+	runTimeout time.Duration
+	logger     *logrus.Entry
+}
+
+func (c *metricFileCollector) Execute(ctx context.Context, _ context.CancelFunc) error {
+	run := instrumentation.StartRun(c.String(), c.instance)
+	defer run.End()
+	ctx, cancel := context.WithTimeout(ctx, c.runTimeout)
+	defer cancel()
+	data, err := c.read(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.logger.WithError(err).Errorf("the run has been interrupted, the list of metrics couldn't be read within the run timeout of %s", c.runTimeout)
+		run.Timeout()
+		return nil
+	}
+	if err != nil {
+		c.logger.WithError(err).Error("failed to read the list of metrics")
+		run.Fail()
+		return nil
+	}
+	result, rejected, err := parseMetricList(data)
+	if err != nil {
+		c.logger.WithError(err).Error("failed to parse the list of metrics")
+		run.Fail()
+		return nil
+	}
+	if len(rejected) > 0 {
+		c.logger.Warningf("%d invalid metric names have been ignored: %s", len(rejected), strings.Join(rejected, ", "))
+	}
+	run.Extracted(len(result))
+	if len(result) > 0 {
+		c.logger.Infof("saving %d metrics", len(result))
+		c.db.EnqueueMetricList(result)
+	}
+	return nil
+}
+
+func (c *metricFileCollector) read(ctx context.Context) ([]byte, error) {
+	if c.httpClient == nil {
+		return os.ReadFile(c.path)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d when getting %q", resp.StatusCode, c.url)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (c *metricFileCollector) String() string {
+	return "metric file collector"
+}
+
+// parseMetricList is decoding the list of metrics. Three formats are supported:
+//   - a JSON array of metric names.
+//   - the response of the Prometheus API /api/v1/label/__name__/values, with the metric names in the field "data".
+//   - one metric name per line. Empty lines and lines starting with # are ignored.
+//
+// The invalid metric names are not returned. They are reported in the second list, with their line or their position in the JSON array.
+func parseMetricList(data []byte) ([]string, []string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil, nil
+	}
+	var names []string
+	switch data[0] {
+	case '[':
+		if err := json.Unmarshal(data, &names); err != nil {
+			return nil, nil, err
+		}
+		result, rejected := validateMetricNames(names, "item %d: %q")
+		return result, rejected, nil
+	case '{':
+		var response struct {
+			Data []string `json:"data"`
+		}
+		if err := json.Unmarshal(data, &response); err != nil {
+			return nil, nil, err
+		}
+		result, rejected := validateMetricNames(response.Data, "item %d: %q")
+		return result, rejected, nil
+	}
+	var result, rejected []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !isValidMetricName(line) {
+			rejected = append(rejected, fmt.Sprintf("line %d: %q", i+1, line))
+			continue
+		}
+		result = append(result, line)
+	}
+	return result, rejected, nil
+}
+
+// validateMetricNames returns the valid metric names, and the invalid ones described with the given format and their position (starting at 1).
+func validateMetricNames(names []string, format string) ([]string, []string) {
+	var result, rejected []string
+	for i, name := range names {
+		if !isValidMetricName(name) {
+			rejected = append(rejected, fmt.Sprintf(format, i+1, name))
+			continue
+		}
+		result = append(result, name)
+	}
+	return result, rejected
+}
+
+// isValidMetricName also accepts the dotted names when the analyzers are in UTF-8 mode, like the names found in the dashboards.
+func isValidMetricName(name string) bool {
+	return model.IsValidMetricName(model.LabelValue(name)) || prometheus.IsValidMetricName(name)
+}
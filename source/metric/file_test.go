@@ -0,0 +1,79 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetricList(t *testing.T) {
+	testSuites := []struct {
+		title     string
+		data      string
+		result    []string
+		rejected  []string
+		expectErr bool
+	}{
+		{
+			title: "empty file",
+			data:  "\n",
+		},
+		{
+			title:  "JSON array",
+			data:   `["up", "node_load1"]`,
+			result: []string{"up", "node_load1"},
+		},
+		{
+			title:  "Prometheus API response",
+			data:   `{"status":"success","data":["up","node_load1"]}`,
+			result: []string{"up", "node_load1"},
+		},
+		{
+			title:  "one metric per line",
+			data:   "# metrics of the production\nup\r\n\n  node_load1\n",
+			result: []string{"up", "node_load1"},
+		},
+		{
+			title:    "invalid names in the lines",
+			data:     "up\nnode load1\n# comment\n1st_metric\nnode_load5\n",
+			result:   []string{"up", "node_load5"},
+			rejected: []string{`line 2: "node load1"`, `line 4: "1st_metric"`},
+		},
+		{
+			title:    "invalid names in the Prometheus API response",
+			data:     `{"status":"success","data":["up","{job=\"api\"}"]}`,
+			result:   []string{"up"},
+			rejected: []string{`item 2: "{job=\"api\"}"`},
+		},
+		{
+			title:     "invalid JSON",
+			data:      `["up",`,
+			expectErr: true,
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			result, rejected, err := parseMetricList([]byte(test.data))
+			if test.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.result, result)
+			assert.Equal(t, test.rejected, rejected)
+		})
+	}
+}
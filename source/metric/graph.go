@@ -0,0 +1,175 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+type nodeKind string
+
+const (
+	metricNodeKind    nodeKind = "metric"
+	dashboardNodeKind nodeKind = "dashboard"
+	ruleGroupNodeKind nodeKind = "ruleGroup"
+	// grafanaAlertNodeKind is a Grafana-managed alert rule. Like the rule groups, it is only part of the graph when the rules are included.
+	grafanaAlertNodeKind nodeKind = "grafanaAlert"
+)
+
+type graphNode struct {
+	ID    string   `json:"id"`
+	Kind  nodeKind `json:"kind"`
+	Label string   `json:"label"`
+	URL   string   `json:"url,omitempty"`
+}
+
+// graphEdge is going from the node using the metric (a dashboard, a rule group or a Grafana alert) to the metric.
+type graphEdge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+}
+
+type graph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+}
+
+func nodeID(kind nodeKind, name string) string {
+	return fmt.Sprintf("%s:%s", kind, name)
+}
+
+// buildGraph returns the bipartite graph between the metrics and the dashboards (and the rule groups and the Grafana alerts if includeRules is true) using them.
+// When root is set, only the nodes connected to the root node, directly or through other nodes, are returned.
+func buildGraph(metrics map[string]*v1.Metric, includeRules bool, root string) *graph {
+	nodes := make(map[string]graphNode)
+	neighbours := make(map[string]v1.Set[string])
+	var edges []graphEdge
+	addEdge := func(source graphNode, metricID string) {
+		nodes[source.ID] = source
+		if neighbours[source.ID] == nil {
+			neighbours[source.ID] = v1.NewSet[string]()
+		}
+		if neighbours[metricID].Contains(source.ID) {
+			return
+		}
+		neighbours[source.ID].Add(metricID)
+		neighbours[metricID].Add(source.ID)
+		edges = append(edges, graphEdge{Source: source.ID, Target: metricID})
+	}
+	for name, metric := range metrics {
+		metricID := nodeID(metricNodeKind, name)
+		nodes[metricID] = graphNode{ID: metricID, Kind: metricNodeKind, Label: name}
+		neighbours[metricID] = v1.NewSet[string]()
+		if metric.Usage == nil {
+			continue
+		}
+		for dashboard := range metric.Usage.Dashboards {
+			id := dashboard.ID
+			if len(id) == 0 {
+				id = dashboard.URL
+			}
+			label := dashboard.Name
+			if len(label) == 0 {
+				label = id
+			}
+			addEdge(graphNode{ID: nodeID(dashboardNodeKind, id), Kind: dashboardNodeKind, Label: label, URL: dashboard.URL}, metricID)
+		}
+		if !includeRules {
+			continue
+		}
+		for _, rules := range []v1.Set[v1.RuleUsage]{metric.Usage.RecordingRules, metric.Usage.AlertRules} {
+			for rule := range rules {
+				id := fmt.Sprintf("%s/%s", rule.PromLink, rule.GroupName)
+				addEdge(graphNode{ID: nodeID(ruleGroupNodeKind, id), Kind: ruleGroupNodeKind, Label: rule.GroupName, URL: rule.PromLink}, metricID)
+			}
+		}
+		for alert := range metric.Usage.GrafanaAlerts {
+			addEdge(graphNode{ID: nodeID(grafanaAlertNodeKind, alert.URL), Kind: grafanaAlertNodeKind, Label: alert.Name, URL: alert.URL}, metricID)
+		}
+	}
+	if len(root) > 0 {
+		reachable := reachableNodes(root, neighbours)
+		for id := range nodes {
+			if !reachable.Contains(id) {
+				delete(nodes, id)
+			}
+		}
+		edges = slices.DeleteFunc(edges, func(e graphEdge) bool {
+			return !reachable.Contains(e.Source)
+		})
+	}
+	result := &graph{Nodes: make([]graphNode, 0, len(nodes)), Edges: edges}
+	for _, node := range nodes {
+		result.Nodes = append(result.Nodes, node)
+	}
+	slices.SortFunc(result.Nodes, func(a, b graphNode) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	slices.SortFunc(result.Edges, func(a, b graphEdge) int {
+		return cmp.Or(cmp.Compare(a.Source, b.Source), cmp.Compare(a.Target, b.Target))
+	})
+	if result.Edges == nil {
+		result.Edges = []graphEdge{}
+	}
+	return result
+}
+
+func reachableNodes(root string, neighbours map[string]v1.Set[string]) v1.Set[string] {
+	result := v1.NewSet[string]()
+	if _, exists := neighbours[root]; !exists {
+		return result
+	}
+	queue := []string{root}
+	result.Add(root)
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for next := range neighbours[current] {
+			if !result.Contains(next) {
+				result.Add(next)
+				queue = append(queue, next)
+			}
+		}
+	}
+	return result
+}
+
+// dot returns the graph in the DOT language, so it can be rendered by Graphviz.
+func (g *graph) dot() string {
+	var b strings.Builder
+	b.WriteString("digraph metrics_usage {\n")
+	b.WriteString("  rankdir=LR;\n")
+	for _, node := range g.Nodes {
+		shape := "ellipse"
+		if node.Kind != metricNodeKind {
+			shape = "box"
+		}
+		fmt.Fprintf(&b, "  %s [label=%s, shape=%s];\n", dotQuote(node.ID), dotQuote(node.Label), shape)
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  %s -> %s;\n", dotQuote(edge.Source), dotQuote(edge.Target))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
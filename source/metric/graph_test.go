@@ -0,0 +1,115 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"testing"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func graphTestMetrics() map[string]*v1.Metric {
+	nodeDashboard := v1.DashboardUsage{ID: "perses/node", Name: "Node", URL: "https://demo.perses.dev/node"}
+	return map[string]*v1.Metric{
+		"node_load1": {
+			Usage: &v1.MetricUsage{
+				Dashboards: v1.NewSet(nodeDashboard),
+				AlertRules: v1.NewSet(v1.RuleUsage{PromLink: "https://prometheus", GroupName: "node", Name: "HighLoad"}),
+			},
+		},
+		"node_cpu_seconds_total": {
+			Usage: &v1.MetricUsage{
+				Dashboards: v1.NewSet(nodeDashboard),
+			},
+		},
+		"up": {
+			Usage: &v1.MetricUsage{
+				Dashboards: v1.NewSet(v1.DashboardUsage{ID: "perses/targets", Name: "Targets \"all\""}),
+			},
+		},
+		"unused": {},
+	}
+}
+
+func TestBuildGraph(t *testing.T) {
+	testSuite := []struct {
+		title        string
+		includeRules bool
+		root         string
+		nodes        []string
+		edges        []graphEdge
+	}{
+		{
+			title: "dashboards only",
+			nodes: []string{
+				"dashboard:perses/node",
+				"dashboard:perses/targets",
+				"metric:node_cpu_seconds_total",
+				"metric:node_load1",
+				"metric:unused",
+				"metric:up",
+			},
+			edges: []graphEdge{
+				{Source: "dashboard:perses/node", Target: "metric:node_cpu_seconds_total"},
+				{Source: "dashboard:perses/node", Target: "metric:node_load1"},
+				{Source: "dashboard:perses/targets", Target: "metric:up"},
+			},
+		},
+		{
+			title:        "with rules from a root",
+			includeRules: true,
+			root:         "metric:node_cpu_seconds_total",
+			nodes: []string{
+				"dashboard:perses/node",
+				"metric:node_cpu_seconds_total",
+				"metric:node_load1",
+				"ruleGroup:https://prometheus/node",
+			},
+			edges: []graphEdge{
+				{Source: "dashboard:perses/node", Target: "metric:node_cpu_seconds_total"},
+				{Source: "dashboard:perses/node", Target: "metric:node_load1"},
+				{Source: "ruleGroup:https://prometheus/node", Target: "metric:node_load1"},
+			},
+		},
+		{
+			title: "unknown root",
+			root:  "metric:foo",
+			edges: []graphEdge{},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			g := buildGraph(graphTestMetrics(), test.includeRules, test.root)
+			var nodes []string
+			for _, node := range g.Nodes {
+				nodes = append(nodes, node.ID)
+			}
+			assert.Equal(t, test.nodes, nodes)
+			assert.Equal(t, test.edges, g.Edges)
+		})
+	}
+}
+
+func TestGraphDot(t *testing.T) {
+	g := buildGraph(graphTestMetrics(), false, "metric:up")
+	expected := `digraph metrics_usage {
+  rankdir=LR;
+  "dashboard:perses/targets" [label="Targets \"all\"", shape=box];
+  "metric:up" [label="up", shape=ellipse];
+  "dashboard:perses/targets" -> "metric:up";
+}
+`
+	assert.Equal(t, expected, g.dot())
+}
@@ -15,11 +15,15 @@ package metric
 
 import (
 	"context"
+	"errors"
+	"slices"
 	"time"
 
 	"github.com/perses/common/async"
 	"github.com/perses/metrics-usage/config"
 	"github.com/perses/metrics-usage/database"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/perses/metrics-usage/utils/instrumentation"
 	"github.com/perses/metrics-usage/utils/prometheus"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
@@ -27,15 +31,20 @@ import (
 )
 
 func NewCollector(db database.Database, cfg config.MetricCollector) (async.SimpleTask, error) {
-	promClient, err := prometheus.NewClient(cfg.HTTPClient)
+	promClient, err := prometheus.NewClient(cfg.HTTPClient, "metrics")
 	if err != nil {
 		return nil, err
 	}
 	return &metricCollector{
-		client: promClient,
-		db:     db,
-		period: cfg.Period,
-		logger: logrus.StandardLogger().WithField("collector", "metrics"),
+		client:         promClient,
+		db:             db,
+		source:         prometheus.NormalizeSourceURL(cfg.HTTPClient.URL.URL),
+		instance:       cfg.HTTPClient.URL.Redacted(),
+		lookback:       cfg.Lookback,
+		runTimeout:     time.Duration(cfg.RunTimeout),
+		discovery:      cfg.Discovery,
+		seriesMatchers: cfg.SeriesMatchers,
+		logger:         logrus.StandardLogger().WithField("collector", "metrics"),
 	}, nil
 }
 
@@ -43,28 +52,146 @@ type metricCollector struct {
 	async.SimpleTask
 	client v1.API
 	db     database.Database
-	period model.Duration
-	logger *logrus.Entry
+	// source is the URL of the Prometheus, recorded as the source of the metrics collected.
+	source string
+	// instance is the URL of the Prometheus without its password, identifying the collector in its metrics.
+	instance   string
+	lookback   model.Duration
+	runTimeout time.Duration
+	// discovery is the API used to get the metric names.
+	// It is switched to the metadata when the endpoint cannot be queried, like a Prometheus running in agent mode.
+	discovery      config.MetricDiscovery
+	seriesMatchers []string
+	logger         *logrus.Entry
 }
 
 func (c *metricCollector) Execute(ctx context.Context, _ context.CancelFunc) error {
+	run := instrumentation.StartRun(c.String(), c.instance)
+	defer run.End()
+	ctx, cancel := context.WithTimeout(ctx, c.runTimeout)
+	defer cancel()
+	var result []string
+	// fromMetadata is true when the metric names are derived from the metadata for this run.
+	fromMetadata := c.discovery == config.MetadataDiscovery
+	if !fromMetadata {
+		metricNames, err := c.queryMetricNames(ctx)
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			c.logger.WithError(err).Errorf("the run has been interrupted, the metrics couldn't be retrieved within the run timeout of %s", c.runTimeout)
+			run.Timeout()
+			return nil
+		} else if prometheus.IsAgentMode(err) {
+			// Switching to the agent mode, so it is only logged once.
+			c.logger.WithError(err).Info("the endpoint is a Prometheus running in agent mode, the metric names are now derived from the metadata")
+			c.discovery = config.MetadataDiscovery
+			fromMetadata = true
+		} else if prometheus.IsUnsupported(err) {
+			// The endpoint can be temporarily not found (a proxy being reconfigured for example), so the discovery is kept for the next run.
+			c.logger.WithError(err).Warning("the endpoint cannot be queried, the metric names are derived from the metadata for this run")
+			fromMetadata = true
+		} else if err != nil {
+			c.logger.WithError(err).Error("failed to query metrics")
+			run.Fail()
+			return nil
+		} else {
+			result = metricNames
+		}
+	}
+	metadata, err := c.client.Metadata(ctx, "", "")
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.logger.WithError(err).Errorf("the metadata couldn't be retrieved within the run timeout of %s", c.runTimeout)
+		run.Timeout()
+		if fromMetadata {
+			return nil
+		}
+	} else if err != nil {
+		c.logger.WithError(err).Warning("failed to query metrics metadata")
+		if fromMetadata {
+			// The metadata is the only source of the metric names.
+			run.Fail()
+			return nil
+		}
+	}
+	if fromMetadata {
+		result = metricNamesFromMetadata(metadata)
+	}
+	run.Extracted(len(result))
+	// Finally, send the metric collected to the database; db will take care to store these data properly
+	if len(result) > 0 {
+		logrus.Infof("saving %d metrics", len(result))
+		c.db.EnqueueMetricListFrom(c.source, result)
+	}
+	c.saveMetadata(metadata)
+	return nil
+}
+
+// queryMetricNames returns the metric names, using the API selected by the discovery.
+func (c *metricCollector) queryMetricNames(ctx context.Context) ([]string, error) {
 	now := time.Now()
-	start := now.Add(time.Duration(-c.period))
+	start := now.Add(time.Duration(-c.lookback))
+	if c.discovery == config.SeriesDiscovery {
+		return c.queryMetricNamesFromSeries(ctx, start, now)
+	}
 	labelValues, _, err := c.client.LabelValues(ctx, "__name__", nil, start, now)
 	if err != nil {
-		c.logger.WithError(err).Error("failed to query metrics")
-		return nil
+		return nil, err
 	}
 	result := make([]string, 0, len(labelValues))
 	for _, metricName := range labelValues {
 		result = append(result, string(metricName))
 	}
-	// Finally, send the metric collected to the database; db will take care to store these data properly
+	return result, nil
+}
+
+// queryMetricNamesFromSeries returns the names of the series matching the series matchers.
+func (c *metricCollector) queryMetricNamesFromSeries(ctx context.Context, start time.Time, end time.Time) ([]string, error) {
+	series, _, err := c.client.Series(ctx, c.seriesMatchers, start, end)
+	if err != nil {
+		return nil, err
+	}
+	names := modelAPIV1.NewSet[string]()
+	for _, labelSet := range series {
+		if name, ok := labelSet[model.MetricNameLabel]; ok {
+			names.Add(string(name))
+		}
+	}
+	result := names.TransformAsSlice()
+	slices.Sort(result)
+	return result, nil
+}
+
+// saveMetadata is saving the type and the help of every metric family returned by the Prometheus metadata API.
+// The database is setting them on the series of each family.
+// A failure to get the metadata is not blocking as the list of metrics has already been saved.
+func (c *metricCollector) saveMetadata(metadata map[string][]v1.Metadata) {
+	result := make(map[string]modelAPIV1.MetricMetadata, len(metadata))
+	for metricName, list := range metadata {
+		if len(list) == 0 {
+			continue
+		}
+		// A metric can have different metadata depending on the targets exposing it. We are keeping the first one.
+		result[metricName] = modelAPIV1.MetricMetadata{
+			Type: string(list[0].Type),
+			Help: list[0].Help,
+		}
+	}
 	if len(result) > 0 {
-		logrus.Infof("saving %d metrics", len(result))
-		c.db.EnqueueMetricList(result)
+		c.logger.Infof("saving metadata for %d metrics", len(result))
+		c.db.EnqueueMetadata(result)
 	}
-	return nil
+}
+
+// metricNamesFromMetadata returns the names of the series exposed by the metric families returned by the metadata API.
+// The metadata is given per family, so the classic histograms and the summaries are expanded with their suffixes.
+func metricNamesFromMetadata(metadata map[string][]v1.Metadata) []string {
+	var result []string
+	for family, list := range metadata {
+		if len(list) == 0 {
+			continue
+		}
+		result = append(result, modelAPIV1.MetricMetadata{Type: string(list[0].Type)}.SeriesNames(family)...)
+	}
+	slices.Sort(result)
+	return result
 }
 
 func (c *metricCollector) String() string {
@@ -0,0 +1,123 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/perses/metrics-usage/config"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAPI is serving the same metrics through the label values and the series APIs.
+type fakeAPI struct {
+	v1.API
+	series         []model.LabelSet
+	seriesMatchers []string
+}
+
+func (f *fakeAPI) LabelValues(_ context.Context, _ string, _ []string, _ time.Time, _ time.Time, _ ...v1.Option) (model.LabelValues, v1.Warnings, error) {
+	var result model.LabelValues
+	for _, series := range f.series {
+		if !slices.Contains(result, series[model.MetricNameLabel]) {
+			result = append(result, series[model.MetricNameLabel])
+		}
+	}
+	return result, nil, nil
+}
+
+func (f *fakeAPI) Series(_ context.Context, matches []string, _ time.Time, _ time.Time, _ ...v1.Option) ([]model.LabelSet, v1.Warnings, error) {
+	f.seriesMatchers = matches
+	return f.series, nil, nil
+}
+
+func TestMetricNamesFromMetadata(t *testing.T) {
+	testSuites := []struct {
+		title    string
+		metadata map[string][]v1.Metadata
+		result   []string
+	}{
+		{
+			title: "empty metadata",
+		},
+		{
+			title: "counter and gauge",
+			metadata: map[string][]v1.Metadata{
+				"http_requests_total": {{Type: v1.MetricTypeCounter}},
+				"up":                  {{Type: v1.MetricTypeGauge}},
+			},
+			result: []string{"http_requests_total", "up"},
+		},
+		{
+			title: "histogram and summary",
+			metadata: map[string][]v1.Metadata{
+				"http_request_duration_seconds": {{Type: v1.MetricTypeHistogram}},
+				"go_gc_duration_seconds":        {{Type: v1.MetricTypeSummary}},
+			},
+			result: []string{
+				"go_gc_duration_seconds",
+				"go_gc_duration_seconds_count",
+				"go_gc_duration_seconds_sum",
+				"http_request_duration_seconds_bucket",
+				"http_request_duration_seconds_count",
+				"http_request_duration_seconds_sum",
+			},
+		},
+		{
+			title: "family without metadata",
+			metadata: map[string][]v1.Metadata{
+				"up":      {{Type: v1.MetricTypeGauge}},
+				"missing": {},
+			},
+			result: []string{"up"},
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.result, metricNamesFromMetadata(test.metadata))
+		})
+	}
+}
+
+func TestQueryMetricNames(t *testing.T) {
+	api := &fakeAPI{
+		series: []model.LabelSet{
+			{model.MetricNameLabel: "up", "job": "api"},
+			{model.MetricNameLabel: "up", "job": "node"},
+			{model.MetricNameLabel: "node_load1", "job": "node"},
+		},
+	}
+	c := &metricCollector{
+		client:    api,
+		discovery: config.LabelValuesDiscovery,
+		logger:    logrus.StandardLogger().WithField("collector", "metrics"),
+	}
+	names, err := c.queryMetricNames(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"up", "node_load1"}, names)
+
+	c.discovery = config.SeriesDiscovery
+	c.seriesMatchers = []string{`{job="node"}`}
+	names, err = c.queryMetricNames(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"node_load1", "up"}, names)
+	assert.Equal(t, []string{`{job="node"}`}, api.seriesMatchers)
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"math"
+	"slices"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// partialMetricsBucket is a group of partial metrics sharing the same kind of resolution.
+type partialMetricsBucket struct {
+	Count int `json:"count"`
+	// Percentage is the share of the partial metrics in the bucket, between 0 and 100.
+	Percentage float64 `json:"percentage"`
+	// Names is the sorted list of the partial metrics in the bucket. It is only returned when requested.
+	Names []string `json:"names,omitempty"`
+}
+
+// partialMetricsStats tells how well the partial metrics are resolved to the metrics.
+type partialMetricsStats struct {
+	// Total is the number of partial metrics.
+	Total int `json:"total"`
+	// Metrics is the number of metrics the partial metrics are matched against.
+	Metrics int `json:"metrics"`
+	// Resolved are the partial metrics matching at least one metric, but not all of them.
+	Resolved partialMetricsBucket `json:"resolved"`
+	// NoMatch are the partial metrics matching nothing, which are likely badly extracted.
+	NoMatch partialMetricsBucket `json:"no_match"`
+	// MatchAll are the partial metrics matching every metric, which are too broad, like .+
+	MatchAll partialMetricsBucket `json:"match_all"`
+	// TooBroad are the partial metrics matching more metrics than the maximum configured in the database.
+	TooBroad partialMetricsBucket `json:"too_broad"`
+	// NoRegexp are the partial metrics that couldn't be converted into a regexp, so they can't match any metric.
+	NoRegexp partialMetricsBucket `json:"no_regexp"`
+}
+
+type partialMetricsStatsRequest struct {
+	// IncludeNames is used to return the name of the partial metrics in each bucket.
+	IncludeNames bool `query:"include_names"`
+}
+
+// computePartialMetricsStats puts each partial metric in a bucket, depending on the number of metrics it is matching.
+// nbMetrics is the total number of metrics.
+func computePartialMetricsStats(partialMetricList map[string]*v1.PartialMetric, nbMetrics int, includeNames bool) *partialMetricsStats {
+	result := &partialMetricsStats{
+		Total:   len(partialMetricList),
+		Metrics: nbMetrics,
+	}
+	for name, partialMetric := range partialMetricList {
+		var bucket *partialMetricsBucket
+		nbMatches := len(partialMetric.MatchingMetrics)
+		switch {
+		case partialMetric.MatchingRegexp == nil:
+			bucket = &result.NoRegexp
+		case partialMetric.TooBroad:
+			bucket = &result.TooBroad
+		case nbMatches == 0:
+			bucket = &result.NoMatch
+		case nbMatches >= nbMetrics:
+			bucket = &result.MatchAll
+		default:
+			bucket = &result.Resolved
+		}
+		bucket.Count++
+		if includeNames {
+			bucket.Names = append(bucket.Names, name)
+		}
+	}
+	for _, bucket := range []*partialMetricsBucket{&result.Resolved, &result.NoMatch, &result.MatchAll, &result.TooBroad, &result.NoRegexp} {
+		slices.Sort(bucket.Names)
+		if result.Total > 0 {
+			bucket.Percentage = math.Round(float64(bucket.Count)*10000/float64(result.Total)) / 100
+		}
+	}
+	return result
+}
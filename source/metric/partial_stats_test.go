@@ -0,0 +1,65 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"testing"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestPartialMetric(re string, matchingMetrics ...string) *v1.PartialMetric {
+	r := common.MustNewRegexp(re)
+	result := &v1.PartialMetric{MatchingRegexp: &r}
+	if len(matchingMetrics) > 0 {
+		result.MatchingMetrics = v1.NewSet(matchingMetrics...)
+	}
+	return result
+}
+
+func TestComputePartialMetricsStats(t *testing.T) {
+	partialMetrics := map[string]*v1.PartialMetric{
+		"http_requests_.+":     newTestPartialMetric("^http_requests_.+$", "http_requests_total"),
+		"node_cpu_.+":          newTestPartialMetric("^node_cpu_.+$", "node_cpu_seconds_total", "node_cpu_guest_seconds_total"),
+		"foo_${instance}":      newTestPartialMetric("^foo_.+$"),
+		".+":                   newTestPartialMetric("^.+$", "http_requests_total", "node_cpu_seconds_total", "node_cpu_guest_seconds_total"),
+		"broken_${expression}": {},
+		"node_.+":              {MatchingRegexp: newTestPartialMetric("^node_.+$").MatchingRegexp, TooBroad: true},
+	}
+	result := computePartialMetricsStats(partialMetrics, 3, true)
+	assert.Equal(t, &partialMetricsStats{
+		Total:    6,
+		Metrics:  3,
+		Resolved: partialMetricsBucket{Count: 2, Percentage: 33.33, Names: []string{"http_requests_.+", "node_cpu_.+"}},
+		NoMatch:  partialMetricsBucket{Count: 1, Percentage: 16.67, Names: []string{"foo_${instance}"}},
+		MatchAll: partialMetricsBucket{Count: 1, Percentage: 16.67, Names: []string{".+"}},
+		TooBroad: partialMetricsBucket{Count: 1, Percentage: 16.67, Names: []string{"node_.+"}},
+		NoRegexp: partialMetricsBucket{Count: 1, Percentage: 16.67, Names: []string{"broken_${expression}"}},
+	}, result)
+
+	result = computePartialMetricsStats(map[string]*v1.PartialMetric{
+		"a_.+": newTestPartialMetric("^a_.+$", "a_total"),
+		"b_.+": newTestPartialMetric("^b_.+$"),
+		"c_.+": newTestPartialMetric("^c_.+$"),
+	}, 2, false)
+	assert.Equal(t, 33.33, result.Resolved.Percentage)
+	assert.Equal(t, 66.67, result.NoMatch.Percentage)
+	assert.Nil(t, result.NoMatch.Names)
+}
+
+func TestComputePartialMetricsStatsEmpty(t *testing.T) {
+	assert.Equal(t, &partialMetricsStats{}, computePartialMetricsStats(nil, 0, true))
+}
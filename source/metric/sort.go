@@ -0,0 +1,142 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// sortKey defines how the list of metrics is sorted. When it is set, the metrics are returned as a list instead of a map.
+type sortKey string
+
+const (
+	nameSort           sortKey = "name"
+	dashboardCountSort sortKey = "dashboard_count"
+	// recordingRuleCountSort and alertRuleCountSort are counting the rules, deduped or not depending on the request.
+	recordingRuleCountSort sortKey = "recording_rule_count"
+	alertRuleCountSort     sortKey = "alert_rule_count"
+	// usageCountSort is counting every usage of the metric, whatever its kind.
+	usageCountSort sortKey = "usage_count"
+	// unusedFirstSort returns the unused metrics first, then the used ones. The metrics are sorted by name in each group.
+	unusedFirstSort sortKey = "unused_first"
+	// seriesCountSort is not available as the number of series per metric is not collected.
+	seriesCountSort sortKey = "series_count"
+)
+
+var sortKeys = []sortKey{nameSort, dashboardCountSort, recordingRuleCountSort, alertRuleCountSort, usageCountSort, unusedFirstSort}
+
+func (k sortKey) verify() error {
+	if k == seriesCountSort {
+		return fmt.Errorf("the sort %q is not available, the number of series per metric is not collected", k)
+	}
+	if !slices.Contains(sortKeys, k) {
+		return fmt.Errorf("unknown sort %q, it must be one of %q", k, sortKeys)
+	}
+	return nil
+}
+
+type sortOrder string
+
+const (
+	ascOrder  sortOrder = "asc"
+	descOrder sortOrder = "desc"
+)
+
+func (o sortOrder) verify() error {
+	if o != ascOrder && o != descOrder {
+		return fmt.Errorf("unknown order %q, it must be %q or %q", o, ascOrder, descOrder)
+	}
+	return nil
+}
+
+// sortMetrics returns the metrics as a list sorted on the given key.
+// The metrics having the same key are always sorted by name in ascending order, so the result is stable.
+func sortMetrics(metrics map[string]*v1.Metric, key sortKey, order sortOrder) []v1.NamedMetric {
+	result := make([]v1.NamedMetric, 0, len(metrics))
+	for name, metric := range metrics {
+		result = append(result, v1.NamedMetric{Name: name, Metric: metric})
+	}
+	slices.SortFunc(result, func(a, b v1.NamedMetric) int {
+		c := compareMetrics(a, b, key)
+		if order == descOrder {
+			c = -c
+		}
+		return cmp.Or(c, cmp.Compare(a.Name, b.Name))
+	})
+	return result
+}
+
+func compareMetrics(a, b v1.NamedMetric, key sortKey) int {
+	switch key {
+	case dashboardCountSort:
+		return cmp.Compare(dashboardCount(a.Metric), dashboardCount(b.Metric))
+	case recordingRuleCountSort:
+		return cmp.Compare(recordingRuleCount(a.Metric), recordingRuleCount(b.Metric))
+	case alertRuleCountSort:
+		return cmp.Compare(alertRuleCount(a.Metric), alertRuleCount(b.Metric))
+	case usageCountSort:
+		return cmp.Compare(usageCount(a.Metric).Total(), usageCount(b.Metric).Total())
+	case unusedFirstSort:
+		return compareBool(a.Usage != nil, b.Usage != nil)
+	default:
+		return cmp.Compare(a.Name, b.Name)
+	}
+}
+
+// usageCount returns the usage count of the metric, computed from its usage when it is not set yet.
+func usageCount(metric *v1.Metric) *v1.UsageCount {
+	if metric.UsageCount != nil {
+		return metric.UsageCount
+	}
+	return metric.Usage.Count()
+}
+
+// dashboardCount returns the number of dashboards using the metric.
+// A dashboard is counted once, even when it is reported once per expression.
+func dashboardCount(metric *v1.Metric) int {
+	if count := usageCount(metric); count != nil {
+		return count.Dashboards
+	}
+	return 0
+}
+
+func recordingRuleCount(metric *v1.Metric) int {
+	if count := usageCount(metric); count != nil {
+		return count.RecordingRules
+	}
+	return 0
+}
+
+func alertRuleCount(metric *v1.Metric) int {
+	if count := usageCount(metric); count != nil {
+		return count.AlertRules
+	}
+	return 0
+}
+
+// compareBool considers false lower than true.
+func compareBool(a, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case a:
+		return 1
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"testing"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortMetrics(t *testing.T) {
+	metrics := map[string]*v1.Metric{
+		"up": {Usage: &v1.MetricUsage{Dashboards: v1.NewSet(v1.DashboardUsage{ID: "a"}, v1.DashboardUsage{ID: "b"})}},
+		"node_load1": {Usage: &v1.MetricUsage{
+			// The same dashboard using the metric in two queries is counted once.
+			Dashboards:     v1.NewSet(v1.DashboardUsage{ID: "a", Expression: "node_load1"}, v1.DashboardUsage{ID: "a", Expression: "node_load1 > 1"}),
+			RecordingRules: v1.NewSet(v1.RuleUsage{Name: "rule"}),
+		}},
+		"go_goroutines":  {},
+		"node_load5":     {Usage: &v1.MetricUsage{RecordingRules: v1.NewSet(v1.RuleUsage{Name: "rule"})}},
+		"process_starts": {},
+	}
+	testSuite := []struct {
+		title    string
+		key      sortKey
+		order    sortOrder
+		expected []string
+	}{
+		{
+			title:    "name asc",
+			key:      nameSort,
+			order:    ascOrder,
+			expected: []string{"go_goroutines", "node_load1", "node_load5", "process_starts", "up"},
+		},
+		{
+			title:    "name desc",
+			key:      nameSort,
+			order:    descOrder,
+			expected: []string{"up", "process_starts", "node_load5", "node_load1", "go_goroutines"},
+		},
+		{
+			title:    "dashboard count desc",
+			key:      dashboardCountSort,
+			order:    descOrder,
+			expected: []string{"up", "node_load1", "go_goroutines", "node_load5", "process_starts"},
+		},
+		{
+			title:    "recording rule count desc",
+			key:      recordingRuleCountSort,
+			order:    descOrder,
+			expected: []string{"node_load1", "node_load5", "go_goroutines", "process_starts", "up"},
+		},
+		{
+			title:    "usage count desc",
+			key:      usageCountSort,
+			order:    descOrder,
+			expected: []string{"node_load1", "up", "node_load5", "go_goroutines", "process_starts"},
+		},
+		{
+			title:    "unused first",
+			key:      unusedFirstSort,
+			order:    ascOrder,
+			expected: []string{"go_goroutines", "process_starts", "node_load1", "node_load5", "up"},
+		},
+		{
+			title:    "used first",
+			key:      unusedFirstSort,
+			order:    descOrder,
+			expected: []string{"node_load1", "node_load5", "up", "go_goroutines", "process_starts"},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			var names []string
+			for _, metric := range sortMetrics(metrics, test.key, test.order) {
+				names = append(names, metric.Name)
+			}
+			assert.Equal(t, test.expected, names)
+		})
+	}
+}
+
+func TestSortKeyVerify(t *testing.T) {
+	assert.NoError(t, dashboardCountSort.verify())
+	assert.EqualError(t, seriesCountSort.verify(), `the sort "series_count" is not available, the number of series per metric is not collected`)
+	assert.EqualError(t, sortKey("size").verify(), `unknown sort "size", it must be one of ["name" "dashboard_count" "recording_rule_count" "alert_rule_count" "usage_count" "unused_first"]`)
+	assert.EqualError(t, sortOrder("up").verify(), `unknown order "up", it must be "asc" or "desc"`)
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import v1 "github.com/perses/metrics-usage/pkg/api/v1"
+
+// transitiveUsageResolver is looking at the chains of recording rules to know if a metric is actually used.
+// A metric used by a recording rule is only used if the metric produced by the rule is itself used by a dashboard or an alert rule,
+// directly or through another recording rule.
+type transitiveUsageResolver struct {
+	metrics    map[string]*v1.Metric
+	used       map[string]bool
+	inProgress v1.Set[string]
+}
+
+// transitivelyUsedMetrics returns the list of metrics that are used by a dashboard or an alert rule, directly or through recording rules.
+func transitivelyUsedMetrics(metrics map[string]*v1.Metric) v1.Set[string] {
+	r := &transitiveUsageResolver{
+		metrics:    metrics,
+		used:       make(map[string]bool),
+		inProgress: v1.NewSet[string](),
+	}
+	result := v1.NewSet[string]()
+	for metricName := range metrics {
+		if r.isUsed(metricName) {
+			result.Add(metricName)
+		}
+	}
+	return result
+}
+
+func (r *transitiveUsageResolver) isUsed(metricName string) bool {
+	if used, ok := r.used[metricName]; ok {
+		return used
+	}
+	if r.inProgress.Contains(metricName) {
+		// Recording rules are depending on each other in a cycle. It's not a usage by itself.
+		return false
+	}
+	metric, ok := r.metrics[metricName]
+	if !ok || metric == nil || metric.Usage == nil {
+		r.used[metricName] = false
+		return false
+	}
+	if len(metric.Usage.Dashboards) > 0 || len(metric.Usage.AlertRules) > 0 || len(metric.Usage.GrafanaAlerts) > 0 {
+		r.used[metricName] = true
+		return true
+	}
+	r.inProgress.Add(metricName)
+	defer r.inProgress.Remove(metricName)
+	used := false
+	for rule := range metric.Usage.RecordingRules {
+		// The name of a recording rule is the name of the metric it produces.
+		if r.isUsed(rule.Name) {
+			used = true
+			break
+		}
+	}
+	r.used[metricName] = used
+	return used
+}
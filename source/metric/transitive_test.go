@@ -0,0 +1,47 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metric
+
+import (
+	"testing"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func usedByRecordingRule(ruleName string) *v1.Metric {
+	return &v1.Metric{
+		Usage: &v1.MetricUsage{
+			RecordingRules: v1.NewSet(v1.RuleUsage{Name: ruleName}),
+		},
+	}
+}
+
+func TestTransitivelyUsedMetrics(t *testing.T) {
+	metrics := map[string]*v1.Metric{
+		// foo -> level:foo:sum -> level:foo:sum:rate -> dashboard
+		"foo":                usedByRecordingRule("level:foo:sum"),
+		"level:foo:sum":      usedByRecordingRule("level:foo:sum:rate"),
+		"level:foo:sum:rate": {Usage: &v1.MetricUsage{Dashboards: v1.NewSet(v1.DashboardUsage{ID: "dashboard"})}},
+		// bar -> level:bar:sum which is not used
+		"bar":           usedByRecordingRule("level:bar:sum"),
+		"level:bar:sum": {},
+		// cycle between a and b
+		"a": usedByRecordingRule("b"),
+		"b": usedByRecordingRule("a"),
+		// recording rule producing a metric not collected
+		"john": usedByRecordingRule("doe"),
+	}
+	assert.Equal(t, v1.NewSet("foo", "level:foo:sum", "level:foo:sum:rate"), transitivelyUsedMetrics(metrics))
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perses
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/perses/common/async"
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/metrics-usage/database"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/perses/metrics-usage/pkg/client"
+	"github.com/perses/metrics-usage/usageclient"
+	"github.com/perses/metrics-usage/utils/instrumentation"
+	v1 "github.com/perses/perses/pkg/model/api/v1"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+func NewFileCollector(db database.Database, cfg config.PersesFileCollector) (async.SimpleTask, error) {
+	var metricUsageClient client.Client
+	var err error
+	if cfg.MetricUsageClient != nil {
+		metricUsageClient, err = client.New(*cfg.MetricUsageClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+	logger := logrus.StandardLogger().WithField("collector", "perses_file")
+	return &persesFileCollector{
+		metricUsageClient: &usageclient.Client{
+			DB:                db,
+			MetricUsageClient: metricUsageClient,
+			Logger:            logger,
+			Tags:              modelAPIV1.NewTags(cfg.UsageTags),
+		},
+		paths:             cfg.Paths,
+		runTimeout:        time.Duration(cfg.RunTimeout),
+		recordExpressions: cfg.RecordExpressions,
+		logger:            logger,
+	}, nil
+}
+
+type persesFileCollector struct {
+	async.SimpleTask
+	metricUsageClient *usageclient.Client
+	paths             []string
+	runTimeout        time.Duration
+	recordExpressions bool
+	logger            *logrus.Entry
+}
+
+func (c *persesFileCollector) Execute(ctx context.Context, _ context.CancelFunc) error {
+	run := instrumentation.StartRun(c.String(), c.source())
+	defer run.End()
+	ctx, cancel := context.WithTimeout(ctx, c.runTimeout)
+	defer cancel()
+	var brokenQueries []modelAPIV1.DashboardBrokenQueries
+	// analyzedFiles are the files analyzed, to replace only their broken queries when the run is not complete.
+	var analyzedFiles []string
+	isComplete := true
+	for _, pattern := range c.paths {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			c.logger.WithError(err).Errorf("invalid pattern %q", pattern)
+			run.Fail()
+			isComplete = false
+			continue
+		}
+		for _, file := range files {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				// The usage of the files already read has been sent, the remaining ones are read by the next run.
+				c.logger.Errorf("the run has been interrupted before reading the file %q, it reached the run timeout of %s", file, c.runTimeout)
+				run.Timeout()
+				c.metricUsageClient.MergeBrokenQueries(c.source(), analyzedFiles, brokenQueries)
+				return nil
+			}
+			dash, readErr := readDashboard(file)
+			if readErr != nil {
+				c.logger.WithError(readErr).Errorf("failed to read the dashboard in the file %q", file)
+				run.Fail()
+				isComplete = false
+				continue
+			}
+			analyzedFiles = append(analyzedFiles, file)
+			metrics, partialMetrics, expressions, errs := analyze(dash, c.recordExpressions)
+			for _, logErr := range errs {
+				logErr.Log(c.logger)
+			}
+			if queries := modelAPIV1.NewBrokenQueries(errs); len(queries) > 0 {
+				brokenQueries = append(brokenQueries, modelAPIV1.DashboardBrokenQueries{Dashboard: newDashboardUsage(dash, file), Queries: queries})
+			}
+			partialMetrics.Log(c.logger.WithField("file", file))
+			metricUsage := generateUsage(metrics, dash, file, expressions)
+			partialMetricUsage := generateUsage(partialMetrics.Metrics(), dash, file, expressions)
+			c.logger.Infof("%d metrics usage has been collected for the dashboard %s/%s in the file %q", len(metricUsage), dash.Metadata.Project, dash.Metadata.Name, file)
+			c.logger.Infof("%d metrics containing regexp or variable has been collected for the dashboard %s/%s in the file %q", len(partialMetricUsage), dash.Metadata.Project, dash.Metadata.Name, file)
+			run.Extracted(len(metricUsage))
+			c.metricUsageClient.SendUsage(metricUsage, partialMetricUsage)
+		}
+	}
+	if isComplete {
+		c.metricUsageClient.SetBrokenQueries(c.source(), brokenQueries)
+	} else {
+		c.metricUsageClient.MergeBrokenQueries(c.source(), analyzedFiles, brokenQueries)
+	}
+	return nil
+}
+
+// source identifies the dashboards read by the collector among the broken queries.
+func (c *persesFileCollector) source() string {
+	return "file://" + strings.Join(c.paths, ",")
+}
+
+func (c *persesFileCollector) String() string {
+	return "perses file collector"
+}
+
+func readDashboard(file string) (*v1.Dashboard, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	dash := &v1.Dashboard{}
+	switch filepath.Ext(file) {
+	case ".json":
+		err = json.Unmarshal(data, dash)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, dash)
+	default:
+		err = fmt.Errorf("unsupported file extension %q, only JSON and YAML files are supported", filepath.Ext(file))
+	}
+	return dash, err
+}
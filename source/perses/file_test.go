@@ -0,0 +1,160 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package perses
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/metrics-usage/database"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCollectorKeepsBrokenQueriesOnTimeout(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dashboard.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"kind":"Dashboard","metadata":{"name":"demo","project":"perses"},"spec":{"duration":"1h","panels":{},"layouts":[]}}`), 0600))
+	inMemory := true
+	db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+	task, err := NewFileCollector(db, config.PersesFileCollector{Paths: []string{file}, RunTimeout: model.Duration(time.Minute)})
+	require.NoError(t, err)
+	collector := task.(*persesFileCollector)
+	brokenQuery := modelAPIV1.BrokenQuery{Message: "failed to extract metric names", Error: "parse error", Expression: "up{"}
+	db.SetBrokenQueries(collector.source(), []modelAPIV1.DashboardBrokenQueries{{Dashboard: modelAPIV1.DashboardUsage{URL: file}, Queries: []modelAPIV1.BrokenQuery{brokenQuery}}})
+
+	// The run is interrupted before reading the file, so the broken queries found previously are kept.
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	require.NoError(t, collector.Execute(ctx, cancel))
+	assert.Len(t, db.ListBrokenQueries(), 1)
+
+	// A complete run finds the dashboard fixed.
+	require.NoError(t, collector.Execute(context.Background(), func() {}))
+	assert.Empty(t, db.ListBrokenQueries())
+}
+
+func TestReadDashboard(t *testing.T) {
+	dir := t.TempDir()
+	testSuites := []struct {
+		title        string
+		file         string
+		content      string
+		expectedName string
+		expectedErr  string
+	}{
+		{
+			title:        "JSON file",
+			file:         "dashboard.json",
+			content:      `{"kind":"Dashboard","metadata":{"name":"demo","project":"perses"},"spec":{"duration":"1h","panels":{},"layouts":[]}}`,
+			expectedName: "demo",
+		},
+		{
+			title:        "YAML file",
+			file:         "dashboard.yaml",
+			content:      "kind: Dashboard\nmetadata:\n  name: demo\n  project: perses\nspec:\n  duration: 1h\n  panels: {}\n  layouts: []\n",
+			expectedName: "demo",
+		},
+		{
+			title:        "YAML file with the short extension",
+			file:         "dashboard.yml",
+			content:      "kind: Dashboard\nmetadata:\n  name: short\n  project: perses\nspec:\n  duration: 1h\n  panels: {}\n  layouts: []\n",
+			expectedName: "short",
+		},
+		{
+			title:       "unsupported extension",
+			file:        "dashboard.txt",
+			content:     "kind: Dashboard",
+			expectedErr: `unsupported file extension ".txt", only JSON and YAML files are supported`,
+		},
+		{
+			title:       "invalid JSON",
+			file:        "invalid.json",
+			content:     `{"kind":`,
+			expectedErr: "unexpected end of JSON input",
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			file := filepath.Join(dir, test.file)
+			require.NoError(t, os.WriteFile(file, []byte(test.content), 0600))
+			dash, err := readDashboard(file)
+			if len(test.expectedErr) > 0 {
+				assert.EqualError(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedName, dash.Metadata.Name)
+			assert.Equal(t, "perses", dash.Metadata.Project)
+		})
+	}
+}
+
+func TestFileCollectorUsage(t *testing.T) {
+	dir := t.TempDir()
+	dashboard := `{"kind":"Dashboard","metadata":{"name":"demo","project":"perses"},"spec":{"duration":"1h","layouts":[],"panels":{"panel":{"kind":"Panel","spec":{"display":{"name":"panel"},"plugin":{"kind":"TimeSeriesChart","spec":{}},"queries":[{"kind":"TimeSeriesQuery","spec":{"plugin":{"kind":"PrometheusTimeSeriesQuery","spec":{"query":"sum(rate(http_requests_total[5m]))"}}}}]}}}}}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "demo.json"), []byte(dashboard), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"kind":`), 0600))
+	testSuites := []struct {
+		title             string
+		paths             []string
+		recordExpressions bool
+		expected          []modelAPIV1.DashboardUsage
+	}{
+		{
+			title:    "usage collected from the files matching the pattern",
+			paths:    []string{filepath.Join(dir, "*.json")},
+			expected: []modelAPIV1.DashboardUsage{{ID: "perses/demo", Name: "demo", URL: filepath.Join(dir, "demo.json")}},
+		},
+		{
+			title:             "usage collected with the expressions",
+			paths:             []string{filepath.Join(dir, "demo.json")},
+			recordExpressions: true,
+			expected:          []modelAPIV1.DashboardUsage{{ID: "perses/demo", Name: "demo", URL: filepath.Join(dir, "demo.json"), Expression: "sum(rate(http_requests_total[5m]))"}},
+		},
+		{
+			title: "no file matching the pattern",
+			paths: []string{filepath.Join(dir, "*.yaml")},
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			inMemory := true
+			db := database.New(config.Database{InMemory: &inMemory}, config.Classification{})
+			db.EnqueueMetricList([]string{"http_requests_total"})
+			require.Eventually(t, func() bool {
+				return db.GetMetric("http_requests_total") != nil
+			}, 5*time.Second, 10*time.Millisecond)
+			task, err := NewFileCollector(db, config.PersesFileCollector{Paths: test.paths, RunTimeout: model.Duration(time.Minute), RecordExpressions: test.recordExpressions})
+			require.NoError(t, err)
+			require.NoError(t, task.Execute(context.Background(), func() {}))
+			if len(test.expected) == 0 {
+				// The usage is sent asynchronously, so give it a chance to be wrongly stored before checking.
+				time.Sleep(50 * time.Millisecond)
+				assert.Nil(t, db.GetMetric("http_requests_total").Usage)
+				return
+			}
+			require.Eventually(t, func() bool {
+				metric := db.GetMetric("http_requests_total")
+				return metric.Usage != nil
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.ElementsMatch(t, test.expected, db.GetMetric("http_requests_total").Usage.Dashboards.TransformAsSlice())
+		})
+	}
+}
@@ -15,7 +15,9 @@ package perses
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/perses/common/async"
 	"github.com/perses/metrics-usage/config"
@@ -24,6 +26,7 @@ import (
 	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
 	"github.com/perses/metrics-usage/pkg/client"
 	"github.com/perses/metrics-usage/usageclient"
+	"github.com/perses/metrics-usage/utils/instrumentation"
 	persesClientV1 "github.com/perses/perses/pkg/client/api/v1"
 	persesClientConfig "github.com/perses/perses/pkg/client/config"
 	v1 "github.com/perses/perses/pkg/model/api/v1"
@@ -50,9 +53,15 @@ func NewCollector(db database.Database, cfg config.PersesCollector) (async.Simpl
 			DB:                db,
 			MetricUsageClient: metricUsageClient,
 			Logger:            logger,
+			Tags:              modelAPIV1.NewTags(cfg.UsageTags),
 		},
-		persesURL: cfg.HTTPClient.URL.String(),
-		logger:    logger,
+		persesURL:         cfg.HTTPClient.URL.String(),
+		instance:          cfg.HTTPClient.URL.Redacted(),
+		runTimeout:        time.Duration(cfg.RunTimeout),
+		recordExpressions: cfg.RecordExpressions,
+		reconcile:         cfg.Reconcile,
+		reconcileWindow:   time.Duration(cfg.ReconcileWindow),
+		logger:            logger,
 	}, nil
 }
 
@@ -61,53 +70,136 @@ type persesCollector struct {
 	persesClient      persesClientV1.DashboardInterface
 	metricUsageClient *usageclient.Client
 	persesURL         string
+	// instance is the URL of the Perses without its password, identifying the collector in its metrics.
+	instance          string
+	runTimeout        time.Duration
+	recordExpressions bool
+	reconcile         bool
+	reconcileWindow   time.Duration
 	logger            *logrus.Entry
 }
 
-func (c *persesCollector) Execute(_ context.Context, _ context.CancelFunc) error {
-	dashboards, err := c.persesClient.List("")
+func (c *persesCollector) Execute(ctx context.Context, _ context.CancelFunc) error {
+	run := instrumentation.StartRun(c.String(), c.instance)
+	defer run.End()
+	ctx, cancel := context.WithTimeout(ctx, c.runTimeout)
+	defer cancel()
+	dashboards, err := c.listDashboards(ctx)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.logger.WithError(err).Errorf("the run has been interrupted, the dashboards couldn't be retrieved within the run timeout of %s", c.runTimeout)
+		run.Timeout()
+		return nil
+	}
 	if err != nil {
 		c.logger.WithError(err).Error("Failed to get dashboards")
+		run.Fail()
 		return nil
 	}
 
+	// When reconciling, the usage of every dashboard is sent at once at the end of the run.
+	metricUsageCollected := make(map[string]*modelAPIV1.MetricUsage)
+	partialMetricUsageCollected := make(map[string]*modelAPIV1.MetricUsage)
+	var brokenQueries []modelAPIV1.DashboardBrokenQueries
 	for _, dash := range dashboards {
-		metrics, partialMetrics, errs := perses.Analyze(dash)
+		metrics, partialMetrics, expressions, errs := analyze(dash, c.recordExpressions)
 		for _, logErr := range errs {
 			logErr.Log(c.logger)
 		}
-		metricUsage := c.generateUsage(metrics, dash)
-		partialMetricUsage := c.generateUsage(partialMetrics, dash)
+		if queries := modelAPIV1.NewBrokenQueries(errs); len(queries) > 0 {
+			brokenQueries = append(brokenQueries, modelAPIV1.DashboardBrokenQueries{Dashboard: newDashboardUsage(dash, c.dashboardURL(dash)), Queries: queries})
+		}
+		partialMetrics.Log(c.logger.WithField("dashboard", fmt.Sprintf("%s/%s", dash.Metadata.Project, dash.Metadata.Name)))
+		metricUsage := c.generateUsage(metrics, dash, expressions)
+		partialMetricUsage := c.generateUsage(partialMetrics.Metrics(), dash, expressions)
 		c.logger.Infof("%d metrics usage has been collected for the dashboard %s/%s", len(metricUsage), dash.Metadata.Project, dash.Metadata.Name)
 		c.logger.Infof("%d metrics containing regexp or variable has been collected for the dashboard %s/%s", len(partialMetricUsage), dash.Metadata.Project, dash.Metadata.Name)
-		c.metricUsageClient.SendUsage(metricUsage, partialMetricUsage)
+		run.Extracted(len(metricUsage))
+		if c.reconcile {
+			modelAPIV1.MergeUsageInto(metricUsageCollected, metricUsage)
+			modelAPIV1.MergeUsageInto(partialMetricUsageCollected, partialMetricUsage)
+		} else {
+			c.metricUsageClient.SendUsage(metricUsage, partialMetricUsage)
+		}
+	}
+	c.metricUsageClient.SetBrokenQueries(c.persesURL, brokenQueries)
+	if c.reconcile {
+		c.metricUsageClient.ReconcileUsage(c.persesURL, metricUsageCollected, partialMetricUsageCollected, c.reconcileWindow)
 	}
 	return nil
 }
 
-func (c *persesCollector) generateUsage(metricNames modelAPIV1.Set[string], currentDashboard *v1.Dashboard) map[string]*modelAPIV1.MetricUsage {
+// listDashboards gets every dashboard from Perses.
+// The Perses client doesn't take a context, so the request is abandoned when the context is done.
+// It is still bounded by the timeout of the HTTP client.
+func (c *persesCollector) listDashboards(ctx context.Context) ([]*v1.Dashboard, error) {
+	type listResult struct {
+		dashboards []*v1.Dashboard
+		err        error
+	}
+	// The channel is buffered, so the goroutine doesn't leak when the result is abandoned.
+	resultChan := make(chan listResult, 1)
+	go func() {
+		dashboards, err := c.persesClient.List("")
+		resultChan <- listResult{dashboards: dashboards, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-resultChan:
+		return result.dashboards, result.err
+	}
+}
+
+func (c *persesCollector) generateUsage(metricNames modelAPIV1.Set[string], currentDashboard *v1.Dashboard, expressions modelAPIV1.MetricExpressions) map[string]*modelAPIV1.MetricUsage {
+	return generateUsage(metricNames, currentDashboard, c.dashboardURL(currentDashboard), expressions)
+}
+
+func (c *persesCollector) dashboardURL(dash *v1.Dashboard) string {
+	return fmt.Sprintf("%s/api/v1/projects/%s/dashboards/%s", c.persesURL, dash.Metadata.Project, dash.Metadata.Name)
+}
+
+// analyze extracts the metrics used by the dashboard. The expressions are only returned when they are recorded.
+func analyze(dash *v1.Dashboard, recordExpressions bool) (modelAPIV1.Set[string], modelAPIV1.PartialMetrics, modelAPIV1.MetricExpressions, []*modelAPIV1.LogError) {
+	if recordExpressions {
+		return perses.AnalyzeWithExpressions(dash)
+	}
+	metrics, partialMetrics, errs := perses.AnalyzeAndExplain(dash)
+	return metrics, partialMetrics, nil, errs
+}
+
+func (c *persesCollector) String() string {
+	return "perses collector"
+}
+
+// generateUsage returns the usage of the metrics by the dashboard.
+// When the expressions using a metric are known, there is one usage per expression.
+func generateUsage(metricNames modelAPIV1.Set[string], currentDashboard *v1.Dashboard, dashboardURL string, expressions modelAPIV1.MetricExpressions) map[string]*modelAPIV1.MetricUsage {
 	metricUsage := make(map[string]*modelAPIV1.MetricUsage)
-	dashboardURL := fmt.Sprintf("%s/api/v1/projects/%s/dashboards/%s", c.persesURL, currentDashboard.Metadata.Project, currentDashboard.Metadata.Name)
+	dashboardUsage := newDashboardUsage(currentDashboard, dashboardURL)
 	for metricName := range metricNames {
-		if usage, ok := metricUsage[metricName]; ok {
-			usage.Dashboards.Add(modelAPIV1.DashboardUsage{
-				ID:   fmt.Sprintf("%s/%s", currentDashboard.Metadata.Project, currentDashboard.Metadata.Name),
-				Name: currentDashboard.Metadata.Name,
-				URL:  dashboardURL,
-			})
-		} else {
-			metricUsage[metricName] = &modelAPIV1.MetricUsage{
-				Dashboards: modelAPIV1.NewSet(modelAPIV1.DashboardUsage{
-					ID:   fmt.Sprintf("%s/%s", currentDashboard.Metadata.Project, currentDashboard.Metadata.Name),
-					Name: currentDashboard.Metadata.Name,
-					URL:  dashboardURL,
-				}),
-			}
+		usage := &modelAPIV1.MetricUsage{Dashboards: modelAPIV1.NewSet[modelAPIV1.DashboardUsage]()}
+		if len(expressions[metricName]) == 0 {
+			usage.Dashboards.Add(dashboardUsage)
+		}
+		for expr := range expressions[metricName] {
+			withExpression := dashboardUsage
+			withExpression.Expression = expr
+			usage.Dashboards.Add(withExpression)
 		}
+		metricUsage[metricName] = usage
 	}
 	return metricUsage
 }
 
-func (c *persesCollector) String() string {
-	return "perses collector"
+func newDashboardUsage(dash *v1.Dashboard, dashboardURL string) modelAPIV1.DashboardUsage {
+	usage := modelAPIV1.DashboardUsage{
+		ID:      fmt.Sprintf("%s/%s", dash.Metadata.Project, dash.Metadata.Name),
+		Name:    dash.Metadata.Name,
+		URL:     dashboardURL,
+		Version: int64(dash.Metadata.Version),
+	}
+	if !dash.Metadata.UpdatedAt.IsZero() {
+		usage.Updated = dash.Metadata.UpdatedAt.UTC().Format(time.RFC3339)
+	}
+	return usage
 }
@@ -0,0 +1,132 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+
+	"github.com/perses/metrics-usage/config"
+	promUtils "github.com/perses/metrics-usage/utils/prometheus"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+type chunkedRulesResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Groups         []v1.RuleGroup `json:"groups"`
+		GroupNextToken string         `json:"groupNextToken,omitempty"`
+	} `json:"data"`
+	Error string `json:"error,omitempty"`
+}
+
+// chunkedRulesClient is getting the rules from Prometheus with multiple requests, to keep each response small enough.
+// The rules are fetched chunk by chunk, using the filters rule_group[] and file[] of the rules API,
+// and each chunk is paginated when a group limit is set.
+type chunkedRulesClient struct {
+	endpoint   *url.URL
+	httpClient *http.Client
+	chunks     []config.RulesChunk
+	groupLimit uint
+}
+
+func newChunkedRulesClient(cfg *config.RulesCollector) (rulesClient, error) {
+	httpClient, err := config.NewHTTPClient(cfg.HTTPClient, "rules")
+	if err != nil {
+		return nil, err
+	}
+	chunks := cfg.Chunks
+	if len(chunks) == 0 {
+		// No filter, only the pagination is used.
+		chunks = []config.RulesChunk{{}}
+	}
+	return &chunkedRulesClient{
+		endpoint:   cfg.HTTPClient.URL.URL,
+		httpClient: httpClient,
+		chunks:     chunks,
+		groupLimit: cfg.GroupLimit,
+	}, nil
+}
+
+// Rules returns the rules of every chunk. A rule group matching multiple chunks is returned only once.
+func (c *chunkedRulesClient) Rules(ctx context.Context) (v1.RulesResult, error) {
+	var result v1.RulesResult
+	seen := make(map[string]bool)
+	for _, chunk := range c.chunks {
+		nextToken := ""
+		for {
+			response, err := c.getRules(ctx, chunk, nextToken)
+			if err != nil {
+				return v1.RulesResult{}, err
+			}
+			for _, group := range response.Data.Groups {
+				key := group.File + "/" + group.Name
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+				result.Groups = append(result.Groups, group)
+			}
+			nextToken = response.Data.GroupNextToken
+			if len(nextToken) == 0 {
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+func (c *chunkedRulesClient) getRules(ctx context.Context, chunk config.RulesChunk, nextToken string) (*chunkedRulesResponse, error) {
+	u := *c.endpoint
+	u.Path = path.Join(c.endpoint.Path, "/api/v1/rules")
+	query := url.Values{}
+	for _, group := range chunk.RuleGroups {
+		query.Add("rule_group[]", group)
+	}
+	for _, file := range chunk.Files {
+		query.Add("file[]", file)
+	}
+	if c.groupLimit > 0 {
+		query.Set("group_limit", strconv.FormatUint(uint64(c.groupLimit), 10))
+	}
+	if len(nextToken) > 0 {
+		query.Set("group_next_token", nextToken)
+	}
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("when getting the rules: %w", promUtils.ErrUnsupported)
+	}
+	result := &chunkedRulesResponse{}
+	if decodeErr := json.NewDecoder(resp.Body).Decode(result); decodeErr != nil {
+		return nil, fmt.Errorf("when getting the rules, unable to decode the response (status code %d): %w", resp.StatusCode, decodeErr)
+	}
+	if resp.StatusCode != http.StatusOK || result.Status != "success" {
+		return nil, fmt.Errorf("when getting the rules, unexpected status code %d: %s", resp.StatusCode, result.Error)
+	}
+	return result, nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeRuleGroup struct {
+	Name  string        `json:"name"`
+	File  string        `json:"file"`
+	Rules []interface{} `json:"rules"`
+}
+
+// newFakeRulesServer is serving the given rule groups, supporting the filters rule_group[] and file[] and the pagination.
+func newFakeRulesServer(groups []fakeRuleGroup) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var filtered []fakeRuleGroup
+		for _, g := range groups {
+			if (query.Has("rule_group[]") && !slices.Contains(query["rule_group[]"], g.Name)) ||
+				(query.Has("file[]") && !slices.Contains(query["file[]"], g.File)) {
+				continue
+			}
+			filtered = append(filtered, g)
+		}
+		data := map[string]interface{}{}
+		start, _ := strconv.Atoi(query.Get("group_next_token"))
+		limit, _ := strconv.Atoi(query.Get("group_limit"))
+		end := len(filtered)
+		if limit > 0 && start+limit < end {
+			end = start + limit
+			data["groupNextToken"] = strconv.Itoa(end)
+		}
+		data["groups"] = filtered[start:end]
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "success", "data": data})
+	}))
+}
+
+func TestChunkedRulesClient(t *testing.T) {
+	recordingRule := map[string]interface{}{"type": "recording", "name": "job:up:sum", "query": "sum by (job) (up)", "health": "ok"}
+	server := newFakeRulesServer([]fakeRuleGroup{
+		{Name: "node", File: "node.yaml", Rules: []interface{}{recordingRule}},
+		{Name: "node-alerts", File: "node.yaml", Rules: []interface{}{recordingRule}},
+		{Name: "k8s", File: "k8s.yaml", Rules: []interface{}{recordingRule}},
+		{Name: "etcd", File: "etcd.yaml", Rules: []interface{}{recordingRule}},
+	})
+	defer server.Close()
+	u, err := common.ParseURL(server.URL)
+	require.NoError(t, err)
+
+	testSuites := []struct {
+		title      string
+		chunks     []config.RulesChunk
+		groupLimit uint
+		result     []string
+	}{
+		{
+			title:      "pagination only",
+			groupLimit: 1,
+			result:     []string{"node", "node-alerts", "k8s", "etcd"},
+		},
+		{
+			title: "chunks by file and by rule group, sharing a group",
+			chunks: []config.RulesChunk{
+				{Files: []string{"node.yaml"}},
+				{RuleGroups: []string{"node", "etcd"}},
+			},
+			result: []string{"node", "node-alerts", "etcd"},
+		},
+		{
+			title:      "paginated chunk",
+			chunks:     []config.RulesChunk{{Files: []string{"node.yaml", "k8s.yaml"}}},
+			groupLimit: 2,
+			result:     []string{"node", "node-alerts", "k8s"},
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			client, clientErr := newChunkedRulesClient(&config.RulesCollector{
+				Chunks:     test.chunks,
+				GroupLimit: test.groupLimit,
+				HTTPClient: config.HTTPClient{URL: u},
+			})
+			require.NoError(t, clientErr)
+			result, rulesErr := client.Rules(context.Background())
+			require.NoError(t, rulesErr)
+			var names []string
+			for _, group := range result.Groups {
+				names = append(names, group.Name)
+				assert.Len(t, group.Rules, 1)
+			}
+			assert.Equal(t, test.result, names)
+		})
+	}
+}
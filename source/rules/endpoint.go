@@ -20,6 +20,9 @@ import (
 	persesEcho "github.com/perses/common/echo"
 	"github.com/perses/metrics-usage/database"
 	"github.com/perses/metrics-usage/pkg/analyze/prometheus"
+	"github.com/perses/metrics-usage/utils/idempotency"
+	"github.com/perses/metrics-usage/utils/inflight"
+	"github.com/perses/metrics-usage/utils/jsonbody"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/sirupsen/logrus"
 )
@@ -41,13 +44,13 @@ type endpoint struct {
 
 func (e *endpoint) RegisterRoute(ech *echo.Echo) {
 	path := "/api/v1/rules"
-	ech.POST(path, e.PushRules)
+	ech.POST(path, e.PushRules, inflight.Middleware(), idempotency.Middleware(e.db))
 }
 
 func (e *endpoint) PushRules(ctx echo.Context) error {
 	var data request
-	if err := ctx.Bind(&data); err != nil {
-		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	if err := jsonbody.Bind(ctx, &data); err != nil {
+		return ctx.JSON(http.StatusBadRequest, err)
 	}
 	metricUsage, partialMetricUsage, errs := prometheus.Analyze(data.Groups, data.Source)
 	for _, logErr := range errs {
@@ -0,0 +1,55 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// filterRuleGroups drops the rule groups whose name is matching excludeGroups,
+// and in the remaining groups, the rules whose name is matching excludeRules.
+// A nil regexp doesn't exclude anything.
+func filterRuleGroups(groups []v1.RuleGroup, excludeGroups *common.Regexp, excludeRules *common.Regexp) []v1.RuleGroup {
+	if excludeGroups == nil && excludeRules == nil {
+		return groups
+	}
+	result := make([]v1.RuleGroup, 0, len(groups))
+	for _, group := range groups {
+		if excludeGroups != nil && excludeGroups.MatchString(group.Name) {
+			continue
+		}
+		if excludeRules != nil {
+			rules := make(v1.Rules, 0, len(group.Rules))
+			for _, rule := range group.Rules {
+				if !excludeRules.MatchString(ruleName(rule)) {
+					rules = append(rules, rule)
+				}
+			}
+			group.Rules = rules
+		}
+		result = append(result, group)
+	}
+	return result
+}
+
+func ruleName(rule interface{}) string {
+	switch v := rule.(type) {
+	case v1.RecordingRule:
+		return v.Name
+	case v1.AlertingRule:
+		return v.Name
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"testing"
+
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterRuleGroups(t *testing.T) {
+	groups := []v1.RuleGroup{
+		{
+			Name: "node",
+			Rules: v1.Rules{
+				v1.RecordingRule{Name: "instance:node_cpu:rate5m", Query: "rate(node_cpu_seconds_total[5m])"},
+				v1.AlertingRule{Name: "NodeDown", Query: "up{job=\"node\"} == 0"},
+				v1.AlertingRule{Name: "TestNodeAlert", Query: "vector(1)"},
+			},
+		},
+		{
+			Name: "generated-test-rules",
+			Rules: v1.Rules{
+				v1.RecordingRule{Name: "test:up", Query: "up"},
+			},
+		},
+	}
+	excludeGroups := common.MustNewRegexp("^generated-")
+	excludeRules := common.MustNewRegexp("^Test")
+	testSuite := []struct {
+		title         string
+		excludeGroups *common.Regexp
+		excludeRules  *common.Regexp
+		expected      map[string][]string
+	}{
+		{
+			title: "no filter",
+			expected: map[string][]string{
+				"node":                 {"instance:node_cpu:rate5m", "NodeDown", "TestNodeAlert"},
+				"generated-test-rules": {"test:up"},
+			},
+		},
+		{
+			title:         "exclude groups",
+			excludeGroups: &excludeGroups,
+			expected: map[string][]string{
+				"node": {"instance:node_cpu:rate5m", "NodeDown", "TestNodeAlert"},
+			},
+		},
+		{
+			title:        "exclude rules",
+			excludeRules: &excludeRules,
+			expected: map[string][]string{
+				"node":                 {"instance:node_cpu:rate5m", "NodeDown"},
+				"generated-test-rules": {"test:up"},
+			},
+		},
+		{
+			title:         "exclude groups and rules",
+			excludeGroups: &excludeGroups,
+			excludeRules:  &excludeRules,
+			expected: map[string][]string{
+				"node": {"instance:node_cpu:rate5m", "NodeDown"},
+			},
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			result := make(map[string][]string)
+			for _, group := range filterRuleGroups(groups, test.excludeGroups, test.excludeRules) {
+				names := []string{}
+				for _, rule := range group.Rules {
+					names = append(names, ruleName(rule))
+				}
+				result[group.Name] = names
+			}
+			assert.Equal(t, test.expected, result)
+		})
+	}
+	// the original groups must not be modified by the filter.
+	assert.Len(t, groups[0].Rules, 3)
+}
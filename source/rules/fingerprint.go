@@ -0,0 +1,65 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// fingerprintedRule is the definition of a rule, without its state.
+// The state (the alerts, the health, the last evaluation...) changes at every evaluation and must not change the fingerprint.
+type fingerprintedRule struct {
+	Type        string         `json:"type"`
+	Name        string         `json:"name"`
+	Query       string         `json:"query"`
+	Duration    float64        `json:"duration,omitempty"`
+	Labels      model.LabelSet `json:"labels,omitempty"`
+	Annotations model.LabelSet `json:"annotations,omitempty"`
+}
+
+type fingerprintedGroup struct {
+	Name     string              `json:"name"`
+	File     string              `json:"file"`
+	Interval float64             `json:"interval"`
+	Rules    []fingerprintedRule `json:"rules"`
+}
+
+// fingerprint returns a hash of the serialized rule groups.
+// Two lists of rule groups have the same fingerprint when their rules have the same definition, in the same order.
+func fingerprint(groups []v1.RuleGroup) (string, error) {
+	result := make([]fingerprintedGroup, 0, len(groups))
+	for _, group := range groups {
+		rules := make([]fingerprintedRule, 0, len(group.Rules))
+		for _, rule := range group.Rules {
+			switch v := rule.(type) {
+			case v1.RecordingRule:
+				rules = append(rules, fingerprintedRule{Type: string(v1.RuleTypeRecording), Name: v.Name, Query: v.Query, Labels: v.Labels})
+			case v1.AlertingRule:
+				rules = append(rules, fingerprintedRule{Type: string(v1.RuleTypeAlerting), Name: v.Name, Query: v.Query, Duration: v.Duration, Labels: v.Labels, Annotations: v.Annotations})
+			}
+		}
+		result = append(result, fingerprintedGroup{Name: group.Name, File: group.File, Interval: group.Interval, Rules: rules})
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
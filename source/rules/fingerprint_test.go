@@ -0,0 +1,87 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRuleGroups(query string, lastEvaluation time.Time) []v1.RuleGroup {
+	return []v1.RuleGroup{
+		{
+			Name: "node",
+			File: "/etc/prometheus/rules/node.yaml",
+			Rules: v1.Rules{
+				v1.RecordingRule{Name: "instance:node_cpu:rate5m", Query: query, LastEvaluation: lastEvaluation},
+				v1.AlertingRule{Name: "NodeDown", Query: "up{job=\"node\"} == 0", State: "inactive", LastEvaluation: lastEvaluation},
+			},
+		},
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	now := time.Now()
+	previous, err := fingerprint(testRuleGroups("rate(node_cpu_seconds_total[5m])", now.Add(-time.Minute)))
+	require.NoError(t, err)
+
+	// The evaluation of the rules doesn't change the fingerprint.
+	evaluated := testRuleGroups("rate(node_cpu_seconds_total[5m])", now)
+	evaluated[0].Rules[1] = v1.AlertingRule{Name: "NodeDown", Query: "up{job=\"node\"} == 0", State: "firing", Alerts: []*v1.Alert{{State: v1.AlertStateFiring}}, LastEvaluation: now}
+	fp, err := fingerprint(evaluated)
+	require.NoError(t, err)
+	assert.Equal(t, previous, fp)
+
+	// A change of the definition of a rule does.
+	fp, err = fingerprint(testRuleGroups("rate(node_cpu_seconds_total[1m])", now))
+	require.NoError(t, err)
+	assert.NotEqual(t, previous, fp)
+}
+
+func TestIsUnchanged(t *testing.T) {
+	c := &rulesCollector{logger: logrus.NewEntry(logrus.StandardLogger())}
+	// isUnchanged simulates a run: the fingerprint is recorded when the rules are analyzed and their usage sent.
+	isUnchanged := func(groups []v1.RuleGroup) bool {
+		fp, unchanged := c.isUnchanged(groups)
+		if !unchanged {
+			c.recordAnalysis(fp)
+		}
+		return unchanged
+	}
+	groups := testRuleGroups("rate(node_cpu_seconds_total[5m])", time.Now())
+	// Without skipUnchangedFor, the rules are always analyzed.
+	assert.False(t, isUnchanged(groups))
+	assert.False(t, isUnchanged(groups))
+
+	c.skipUnchangedFor = time.Hour
+	assert.False(t, isUnchanged(groups))
+	assert.True(t, isUnchanged(groups))
+	assert.False(t, isUnchanged(testRuleGroups("rate(node_cpu_seconds_total[1m])", time.Now())))
+	// Once the last analysis is too old, the rules are analyzed again even if they didn't change.
+	c.lastAnalysis = time.Now().Add(-2 * time.Hour)
+	assert.False(t, isUnchanged(testRuleGroups("rate(node_cpu_seconds_total[1m])", time.Now())))
+	assert.True(t, isUnchanged(testRuleGroups("rate(node_cpu_seconds_total[1m])", time.Now())))
+
+	// When the usage couldn't be sent, nothing is recorded and the rules are analyzed again at the next run.
+	changed := testRuleGroups("rate(node_cpu_seconds_total[10m])", time.Now())
+	_, unchanged := c.isUnchanged(changed)
+	assert.False(t, unchanged)
+	_, unchanged = c.isUnchanged(changed)
+	assert.False(t, unchanged)
+}
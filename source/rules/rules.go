@@ -15,21 +15,42 @@ package rules
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"github.com/perses/common/async"
 	"github.com/perses/metrics-usage/config"
 	"github.com/perses/metrics-usage/database"
 	"github.com/perses/metrics-usage/pkg/analyze/prometheus"
+	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
 	"github.com/perses/metrics-usage/pkg/client"
 	"github.com/perses/metrics-usage/usageclient"
+	"github.com/perses/metrics-usage/utils/instrumentation"
 	promUtils "github.com/perses/metrics-usage/utils/prometheus"
+	"github.com/perses/perses/pkg/model/api/v1/common"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/sirupsen/logrus"
 )
 
+// rulesClient is the part of the Prometheus API used to get the rules.
+// It allows getting the rules from other backends, like vmalert, that do not return exactly the same format.
+type rulesClient interface {
+	Rules(ctx context.Context) (v1.RulesResult, error)
+}
+
+func newRulesClient(cfg *config.RulesCollector) (rulesClient, error) {
+	// Thanos and Cortex are serving the same rules API as Prometheus.
+	if cfg.Flavor == config.VictoriaMetricsFlavor {
+		return newVMAlertClient(cfg.HTTPClient)
+	}
+	if len(cfg.Chunks) > 0 || cfg.GroupLimit > 0 {
+		return newChunkedRulesClient(cfg)
+	}
+	return promUtils.NewClient(cfg.HTTPClient, "rules")
+}
+
 func NewCollector(db database.Database, cfg *config.RulesCollector) (async.SimpleTask, error) {
-	promClient, err := promUtils.NewClient(cfg.HTTPClient)
+	promClient, err := newRulesClient(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -40,45 +61,132 @@ func NewCollector(db database.Database, cfg *config.RulesCollector) (async.Simpl
 			return nil, err
 		}
 	}
-	logger := logrus.StandardLogger().WithField("collector", "rules")
+	// The engine is part of every log, to know how the rules of this collector have been analyzed.
+	logger := logrus.StandardLogger().WithField("collector", "rules").WithField("engine", cfg.Engine)
 	return &rulesCollector{
 		promClient: promClient,
 		metricUsageClient: &usageclient.Client{
 			DB:                db,
 			MetricUsageClient: metricUsageClient,
 			Logger:            logger,
+			Tags:              modelAPIV1.NewTags(cfg.UsageTags),
 		},
-		promURL: cfg.HTTPClient.URL.String(),
-		logger:  logger,
-		retry:   cfg.RetryToGetRules,
+		promURL:          promUtils.NormalizeSourceURL(cfg.HTTPClient.URL.URL),
+		instance:         cfg.HTTPClient.URL.Redacted(),
+		logger:           logger,
+		retry:            cfg.RetryToGetRules,
+		runTimeout:       time.Duration(cfg.RunTimeout),
+		flavor:           cfg.Flavor,
+		reconcile:        cfg.Reconcile,
+		reconcileWindow:  time.Duration(cfg.ReconcileWindow),
+		excludeGroups:    cfg.ExcludeGroups,
+		excludeRules:     cfg.ExcludeRules,
+		skipUnchangedFor: time.Duration(cfg.SkipUnchangedFor),
 	}, nil
 }
 
 type rulesCollector struct {
 	async.SimpleTask
-	promClient        v1.API
+	promClient        rulesClient
 	metricUsageClient *usageclient.Client
 	promURL           string
+	instance          string
 	logger            *logrus.Entry
 	retry             uint
+	runTimeout        time.Duration
+	flavor            config.Flavor
+	reconcile         bool
+	reconcileWindow   time.Duration
+	excludeGroups     *common.Regexp
+	excludeRules      *common.Regexp
+	skipUnchangedFor  time.Duration
+	// lastFingerprint is the fingerprint of the rules analyzed by the last run, and lastAnalysis when they have been analyzed.
+	// They are only set when skipUnchangedFor is enabled, and once the usage has been sent.
+	lastFingerprint string
+	lastAnalysis    time.Time
+	// unsupportedLogged avoids logging at every run that the rules API is not served, like by a Prometheus running in agent mode.
+	unsupportedLogged bool
 }
 
 func (c *rulesCollector) Execute(ctx context.Context, _ context.CancelFunc) error {
+	run := instrumentation.StartRun(c.String(), c.instance)
+	defer run.End()
+	ctx, cancel := context.WithTimeout(ctx, c.runTimeout)
+	defer cancel()
 	result, err := c.getRules(ctx)
+	if promUtils.IsUnsupported(err) {
+		if !c.unsupportedLogged {
+			c.logger.WithError(err).Info("the rules API is not served by the endpoint (it can be a Prometheus running in agent mode), skipping the collect of the rules")
+			c.unsupportedLogged = true
+		}
+		return nil
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.logger.WithError(err).Errorf("the run has been interrupted, the rules couldn't be retrieved within the run timeout of %s", c.runTimeout)
+		run.Timeout()
+		return nil
+	}
 	if err != nil {
 		c.logger.WithError(err).Error("Failed to get rules")
+		run.Fail()
+		return nil
+	}
+	groups := filterRuleGroups(result.Groups, c.excludeGroups, c.excludeRules)
+	fp, unchanged := c.isUnchanged(groups)
+	if unchanged {
+		c.logger.Debug("the rules didn't change since the last run, skipping their analysis")
 		return nil
 	}
-	metricsUsage, partialMetricsUsage, errs := prometheus.Analyze(result.Groups, c.promURL)
+	metricsUsage, partialMetricsUsage, errs := prometheus.AnalyzeWithFlavor(groups, c.promURL, prometheus.Flavor(c.flavor))
 	for _, logErr := range errs {
 		logErr.Log(c.logger)
 	}
 	c.logger.Infof("%d metrics usage has been collected", len(metricsUsage))
 	c.logger.Infof("%d metrics containing regexp or variable has been collected", len(partialMetricsUsage))
-	c.metricUsageClient.SendUsage(metricsUsage, partialMetricsUsage)
+	run.Extracted(len(metricsUsage))
+	var sendErr error
+	if c.reconcile {
+		sendErr = c.metricUsageClient.ReconcileUsage(c.promURL, metricsUsage, partialMetricsUsage, c.reconcileWindow)
+	} else {
+		sendErr = c.metricUsageClient.SendUsage(metricsUsage, partialMetricsUsage)
+	}
+	if sendErr != nil {
+		// The error is already logged by the client. The rules will be analyzed again at the next run.
+		run.Fail()
+		return nil
+	}
+	c.recordAnalysis(fp)
 	return nil
 }
 
+// isUnchanged returns true when the rules have the same fingerprint than the ones analyzed during the last run,
+// and this last analysis is recent enough. Otherwise, it returns the fingerprint of the rules,
+// to be recorded by recordAnalysis once their usage has been sent.
+func (c *rulesCollector) isUnchanged(groups []v1.RuleGroup) (string, bool) {
+	if c.skipUnchangedFor <= 0 {
+		return "", false
+	}
+	fp, err := fingerprint(groups)
+	if err != nil {
+		// The rules are just analyzed again.
+		c.logger.WithError(err).Warning("unable to compute the fingerprint of the rules")
+		return "", false
+	}
+	if fp == c.lastFingerprint && time.Since(c.lastAnalysis) < c.skipUnchangedFor {
+		return fp, true
+	}
+	return fp, false
+}
+
+// recordAnalysis records the fingerprint of the rules whose usage has been sent successfully.
+func (c *rulesCollector) recordAnalysis(fp string) {
+	if c.skipUnchangedFor <= 0 {
+		return
+	}
+	c.lastFingerprint = fp
+	c.lastAnalysis = time.Now()
+}
+
 func (c *rulesCollector) String() string {
 	return "rules collector"
 }
@@ -91,11 +199,17 @@ func (c *rulesCollector) getRules(ctx context.Context) (v1.RulesResult, error) {
 	var result v1.RulesResult
 	for doRetry && retry > 0 {
 		result, err = c.promClient.Rules(ctx)
+		if promUtils.IsUnsupported(err) {
+			// The endpoint won't serve the rules, no matter how many times we are asking.
+			return result, err
+		}
 		if err != nil {
 			doRetry = true
 			retry--
 			c.logger.WithError(err).Debug("Failed to get rules, retrying...")
-			time.Sleep(waitDuration)
+			if retry > 0 && !promUtils.Wait(ctx, waitDuration) {
+				return result, err
+			}
 			waitDuration = waitDuration + 10*time.Second
 		} else {
 			c.logger.Infof("successfuly get the rules")
@@ -0,0 +1,122 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/perses/metrics-usage/config"
+	promUtils "github.com/perses/metrics-usage/utils/prometheus"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+const (
+	vmalertAlertingRuleType  = "alerting"
+	vmalertRecordingRuleType = "recording"
+)
+
+// vmalertRule is the rule returned by the vmalert API. Only the fields required to analyze the rule are decoded,
+// as some others (like the durations) are not encoded the same way as in Prometheus.
+type vmalertRule struct {
+	Type        string         `json:"type"`
+	Name        string         `json:"name"`
+	Query       string         `json:"query"`
+	Labels      model.LabelSet `json:"labels"`
+	Annotations model.LabelSet `json:"annotations"`
+}
+
+type vmalertRuleGroup struct {
+	Name  string        `json:"name"`
+	File  string        `json:"file"`
+	Rules []vmalertRule `json:"rules"`
+}
+
+type vmalertRulesResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Groups []vmalertRuleGroup `json:"groups"`
+	} `json:"data"`
+	Error string `json:"error,omitempty"`
+}
+
+// vmalertClient is getting the rules from the vmalert API and converts them into the Prometheus rules format.
+type vmalertClient struct {
+	endpoint   *url.URL
+	httpClient *http.Client
+}
+
+func newVMAlertClient(cfg config.HTTPClient) (rulesClient, error) {
+	httpClient, err := config.NewHTTPClient(cfg, "rules")
+	if err != nil {
+		return nil, err
+	}
+	return &vmalertClient{
+		endpoint:   cfg.URL.URL,
+		httpClient: httpClient,
+	}, nil
+}
+
+func (c *vmalertClient) Rules(ctx context.Context) (v1.RulesResult, error) {
+	u := *c.endpoint
+	u.Path = path.Join(c.endpoint.Path, "/api/v1/rules")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return v1.RulesResult{}, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return v1.RulesResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return v1.RulesResult{}, fmt.Errorf("when getting the vmalert rules: %w", promUtils.ErrUnsupported)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return v1.RulesResult{}, fmt.Errorf("when getting the vmalert rules, unexpected status code: %d", resp.StatusCode)
+	}
+	result := &vmalertRulesResponse{}
+	if decodeErr := json.NewDecoder(resp.Body).Decode(result); decodeErr != nil {
+		return v1.RulesResult{}, decodeErr
+	}
+	if result.Status != "success" {
+		return v1.RulesResult{}, fmt.Errorf("when getting the vmalert rules, unexpected status %q: %s", result.Status, result.Error)
+	}
+	return convertVMAlertRuleGroups(result.Data.Groups), nil
+}
+
+func convertVMAlertRuleGroups(groups []vmalertRuleGroup) v1.RulesResult {
+	result := v1.RulesResult{Groups: make([]v1.RuleGroup, 0, len(groups))}
+	for _, group := range groups {
+		ruleGroup := v1.RuleGroup{
+			Name: group.Name,
+			File: group.File,
+		}
+		for _, rule := range group.Rules {
+			switch rule.Type {
+			case vmalertAlertingRuleType:
+				ruleGroup.Rules = append(ruleGroup.Rules, v1.AlertingRule{Name: rule.Name, Query: rule.Query, Labels: rule.Labels, Annotations: rule.Annotations})
+			case vmalertRecordingRuleType:
+				ruleGroup.Rules = append(ruleGroup.Rules, v1.RecordingRule{Name: rule.Name, Query: rule.Query, Labels: rule.Labels})
+			}
+		}
+		result.Groups = append(result.Groups, ruleGroup)
+	}
+	return result
+}
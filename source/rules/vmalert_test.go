@@ -0,0 +1,109 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/perses/metrics-usage/config"
+	promUtils "github.com/perses/metrics-usage/utils/prometheus"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const vmalertRulesResponseBody = `{
+  "status": "success",
+  "data": {
+    "groups": [
+      {
+        "name": "node",
+        "type": "prometheus",
+        "file": "/etc/vmalert/rules.yaml",
+        "interval": 30,
+        "concurrency": 1,
+        "rules": [
+          {
+            "type": "recording",
+            "name": "instance:node_cpu:rate5m",
+            "query": "rate(node_cpu_seconds_total[5m])",
+            "health": "ok",
+            "lastEvaluation": "2024-10-10T10:00:00Z"
+          },
+          {
+            "type": "alerting",
+            "name": "NodeDown",
+            "query": "up{job=\"node\"} == 0",
+            "duration": 300,
+            "labels": {"severity": "critical"},
+            "annotations": {"summary": "{{ $labels.instance }} is down"},
+            "state": "inactive",
+            "alerts": []
+          }
+        ]
+      }
+    ]
+  }
+}`
+
+func TestVMAlertClientRules(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/rules" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(vmalertRulesResponseBody))
+	}))
+	defer server.Close()
+	u, err := common.ParseURL(server.URL)
+	require.NoError(t, err)
+	client, err := newVMAlertClient(config.HTTPClient{URL: u})
+	require.NoError(t, err)
+	result, err := client.Rules(context.Background())
+	require.NoError(t, err)
+	expected := v1.RulesResult{
+		Groups: []v1.RuleGroup{
+			{
+				Name: "node",
+				File: "/etc/vmalert/rules.yaml",
+				Rules: v1.Rules{
+					v1.RecordingRule{Name: "instance:node_cpu:rate5m", Query: "rate(node_cpu_seconds_total[5m])"},
+					v1.AlertingRule{
+						Name:        "NodeDown",
+						Query:       "up{job=\"node\"} == 0",
+						Labels:      model.LabelSet{"severity": "critical"},
+						Annotations: model.LabelSet{"summary": "{{ $labels.instance }} is down"},
+					},
+				},
+			},
+		},
+	}
+	assert.Equal(t, expected, result)
+}
+
+func TestVMAlertClientRulesNotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	u, err := common.ParseURL(server.URL)
+	require.NoError(t, err)
+	client, err := newVMAlertClient(config.HTTPClient{URL: u})
+	require.NoError(t, err)
+	_, err = client.Rules(context.Background())
+	assert.True(t, promUtils.IsUnsupported(err))
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"slices"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+)
+
+// usageDiff is the usage of a metric added or removed between two snapshots.
+type usageDiff struct {
+	Added   []v1.UsageItem `json:"added,omitempty"`
+	Removed []v1.UsageItem `json:"removed,omitempty"`
+}
+
+// snapshotDiff is the difference between two states of the metrics.
+type snapshotDiff struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// AddedMetrics are the metrics only known in the snapshot "to".
+	AddedMetrics []string `json:"added_metrics"`
+	// RemovedMetrics are the metrics only known in the snapshot "from".
+	RemovedMetrics []string `json:"removed_metrics"`
+	// NewlyUsedMetrics are the metrics used in the snapshot "to", that were unused or unknown in the snapshot "from".
+	NewlyUsedMetrics []string `json:"newly_used_metrics"`
+	// NewlyUnusedMetrics are the metrics unused in the snapshot "to", that were used in the snapshot "from".
+	NewlyUnusedMetrics []string `json:"newly_unused_metrics"`
+	// Usage is, for each metric whose usage changed, the dashboards, rules and Grafana alerts added or removed.
+	Usage map[string]*usageDiff `json:"usage"`
+}
+
+func diff(from, to map[string]*v1.Metric) *snapshotDiff {
+	result := &snapshotDiff{
+		AddedMetrics:       []string{},
+		RemovedMetrics:     []string{},
+		NewlyUsedMetrics:   []string{},
+		NewlyUnusedMetrics: []string{},
+		Usage:              make(map[string]*usageDiff),
+	}
+	for name, toMetric := range to {
+		fromMetric, exists := from[name]
+		if !exists {
+			result.AddedMetrics = append(result.AddedMetrics, name)
+		}
+		if isUsed(toMetric) && !isUsed(fromMetric) {
+			result.NewlyUsedMetrics = append(result.NewlyUsedMetrics, name)
+		} else if exists && !isUsed(toMetric) && isUsed(fromMetric) {
+			result.NewlyUnusedMetrics = append(result.NewlyUnusedMetrics, name)
+		}
+	}
+	for name := range from {
+		if _, exists := to[name]; !exists {
+			result.RemovedMetrics = append(result.RemovedMetrics, name)
+		}
+	}
+	for _, names := range [][]string{result.AddedMetrics, result.RemovedMetrics, result.NewlyUsedMetrics, result.NewlyUnusedMetrics} {
+		slices.Sort(names)
+	}
+	for name := range unionKeys(from, to) {
+		added, removed := diffUsage(usageOf(from[name]), usageOf(to[name]))
+		if len(added) > 0 || len(removed) > 0 {
+			result.Usage[name] = &usageDiff{Added: added, Removed: removed}
+		}
+	}
+	return result
+}
+
+// diffUsage returns the usages only present in "to" and the ones only present in "from".
+// The usages are compared on their key, so a dashboard renamed is not considered as a change.
+func diffUsage(from, to *v1.MetricUsage) ([]v1.UsageItem, []v1.UsageItem) {
+	fromItems := indexItems(from)
+	toItems := indexItems(to)
+	var added, removed []v1.UsageItem
+	for key, item := range toItems {
+		if _, ok := fromItems[key]; !ok {
+			added = append(added, item)
+		}
+	}
+	for key, item := range fromItems {
+		if _, ok := toItems[key]; !ok {
+			removed = append(removed, item)
+		}
+	}
+	sortItems(added)
+	sortItems(removed)
+	return added, removed
+}
+
+// indexItems returns the usages indexed by their key. The last confirmation is dropped, as it changes at every collection.
+func indexItems(usage *v1.MetricUsage) map[string]v1.UsageItem {
+	result := make(map[string]v1.UsageItem)
+	for _, item := range usage.Flatten("") {
+		item.LastConfirmed = nil
+		result[item.Key()] = item
+	}
+	return result
+}
+
+func sortItems(items []v1.UsageItem) {
+	slices.SortFunc(items, func(a, b v1.UsageItem) int {
+		if a.Key() < b.Key() {
+			return -1
+		}
+		if a.Key() > b.Key() {
+			return 1
+		}
+		return 0
+	})
+}
+
+func unionKeys(from, to map[string]*v1.Metric) v1.Set[string] {
+	result := v1.NewSet[string]()
+	for name := range from {
+		result.Add(name)
+	}
+	for name := range to {
+		result.Add(name)
+	}
+	return result
+}
+
+func usageOf(metric *v1.Metric) *v1.MetricUsage {
+	if metric == nil {
+		return nil
+	}
+	return metric.Usage
+}
+
+func isUsed(metric *v1.Metric) bool {
+	return !usageOf(metric).IsEmpty()
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"testing"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	dashboardA := v1.DashboardUsage{ID: "a", Name: "A", URL: "http://perses/a"}
+	dashboardB := v1.DashboardUsage{ID: "b", Name: "B", URL: "http://perses/b"}
+	rule := v1.RuleUsage{PromLink: "http://prometheus", GroupName: "node", Name: "NodeDown", Expression: "up == 0"}
+	from := map[string]*v1.Metric{
+		"up":             {Usage: &v1.MetricUsage{Dashboards: v1.NewSet(dashboardA), AlertRules: v1.NewSet(rule)}},
+		"node_load1":     {Usage: &v1.MetricUsage{Dashboards: v1.NewSet(dashboardA)}},
+		"node_load5":     {},
+		"process_uptime": {Usage: &v1.MetricUsage{Dashboards: v1.NewSet(dashboardB)}},
+	}
+	to := map[string]*v1.Metric{
+		// the dashboard has been renamed, it is not a change.
+		"up":          {Usage: &v1.MetricUsage{Dashboards: v1.NewSet(v1.DashboardUsage{ID: "a", Name: "A2", URL: "http://perses/a"}), AlertRules: v1.NewSet(rule)}},
+		"node_load1":  {},
+		"node_load5":  {Usage: &v1.MetricUsage{Dashboards: v1.NewSet(dashboardB)}},
+		"node_load15": {Usage: &v1.MetricUsage{Dashboards: v1.NewSet(dashboardB)}},
+	}
+
+	result := diff(from, to)
+	assert.Equal(t, []string{"node_load15"}, result.AddedMetrics)
+	assert.Equal(t, []string{"process_uptime"}, result.RemovedMetrics)
+	assert.Equal(t, []string{"node_load15", "node_load5"}, result.NewlyUsedMetrics)
+	assert.Equal(t, []string{"node_load1"}, result.NewlyUnusedMetrics)
+	assert.NotContains(t, result.Usage, "up")
+	assert.Equal(t, &usageDiff{Removed: []v1.UsageItem{{Kind: v1.DashboardUsageKind, Dashboard: &dashboardA}}}, result.Usage["node_load1"])
+	assert.Equal(t, &usageDiff{Added: []v1.UsageItem{{Kind: v1.DashboardUsageKind, Dashboard: &dashboardB}}}, result.Usage["node_load5"])
+	assert.Equal(t, &usageDiff{Removed: []v1.UsageItem{{Kind: v1.DashboardUsageKind, Dashboard: &dashboardB}}}, result.Usage["process_uptime"])
+}
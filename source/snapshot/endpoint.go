@@ -0,0 +1,114 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package snapshot
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	persesEcho "github.com/perses/common/echo"
+	"github.com/perses/metrics-usage/database"
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/perses/metrics-usage/utils/respcache"
+)
+
+// currentState is the name given in the diff to the current metrics, when the snapshot "to" is not set.
+const currentState = "current"
+
+func NewAPI(db database.Database) persesEcho.Register {
+	return &endpoint{
+		db: db,
+	}
+}
+
+type endpoint struct {
+	db database.Database
+}
+
+func (e *endpoint) RegisterRoute(ech *echo.Echo) {
+	path := "/api/v1/snapshots"
+	ech.GET(path, e.ListSnapshots)
+	ech.GET(fmt.Sprintf("%s/diff", path), e.Diff, respcache.Middleware(e.db))
+	ech.POST(fmt.Sprintf("%s/:name", path), e.SaveSnapshot)
+	ech.DELETE(fmt.Sprintf("%s/:name", path), e.DeleteSnapshot)
+}
+
+// SaveSnapshot captures the current metrics under the given name.
+func (e *endpoint) SaveSnapshot(ctx echo.Context) error {
+	name := ctx.Param("name")
+	if err := database.ValidateSnapshotName(name); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if err := e.db.SaveSnapshot(name); err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "OK"})
+}
+
+// DeleteSnapshot removes the snapshot saved under the given name.
+func (e *endpoint) DeleteSnapshot(ctx echo.Context) error {
+	name := ctx.Param("name")
+	found, err := e.db.DeleteSnapshot(name)
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	if !found {
+		return ctx.JSON(http.StatusNotFound, echo.Map{"message": fmt.Sprintf("snapshot %q not found", name)})
+	}
+	return ctx.JSON(http.StatusOK, echo.Map{"message": "OK"})
+}
+
+func (e *endpoint) ListSnapshots(ctx echo.Context) error {
+	return ctx.JSON(http.StatusOK, e.db.ListSnapshots())
+}
+
+type diffRequest struct {
+	From string `query:"from"`
+	// To is the snapshot compared with From. When it is not set, From is compared with the current metrics.
+	To string `query:"to"`
+}
+
+// Diff returns the metrics and the usages added or removed between two snapshots.
+func (e *endpoint) Diff(ctx echo.Context) error {
+	req := &diffRequest{}
+	if err := ctx.Bind(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if len(req.From) == 0 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "the query parameter from is required"})
+	}
+	from := e.db.GetSnapshot(req.From)
+	if from == nil {
+		return ctx.JSON(http.StatusNotFound, echo.Map{"message": fmt.Sprintf("snapshot %q not found", req.From)})
+	}
+	var to map[string]*v1.Metric
+	if len(req.To) == 0 {
+		req.To = currentState
+		metrics, err := e.db.ListMetrics()
+		if err != nil {
+			return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+		}
+		to = metrics
+	} else {
+		to = e.db.GetSnapshot(req.To)
+		if to == nil {
+			return ctx.JSON(http.StatusNotFound, echo.Map{"message": fmt.Sprintf("snapshot %q not found", req.To)})
+		}
+	}
+	result := diff(from, to)
+	result.From = req.From
+	result.To = req.To
+	return ctx.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,64 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestion
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	persesEcho "github.com/perses/common/echo"
+	"github.com/perses/metrics-usage/database"
+	"github.com/perses/metrics-usage/utils/respcache"
+)
+
+const defaultMinDashboards = 2
+
+func NewAPI(db database.Database) persesEcho.Register {
+	return &endpoint{
+		db: db,
+	}
+}
+
+type endpoint struct {
+	db database.Database
+}
+
+func (e *endpoint) RegisterRoute(ech *echo.Echo) {
+	ech.GET("/api/v1/suggestions/recording-rules", e.SuggestRecordingRules, respcache.Middleware(e.db))
+}
+
+type recordingRulesRequest struct {
+	// MinDashboards is the number of dashboards that must use the same aggregation for a recording rule to be suggested.
+	MinDashboards int `query:"min_dashboards"`
+}
+
+// SuggestRecordingRules returns the aggregations of counters and histograms repeated across the dashboards, that could be recording rules.
+func (e *endpoint) SuggestRecordingRules(ctx echo.Context) error {
+	req := &recordingRulesRequest{}
+	if err := ctx.Bind(req); err != nil {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if req.MinDashboards == 0 {
+		req.MinDashboards = defaultMinDashboards
+	}
+	if req.MinDashboards < 1 {
+		return ctx.JSON(http.StatusBadRequest, echo.Map{"message": fmt.Sprintf("invalid min_dashboards %d, it must be greater than 0", req.MinDashboards)})
+	}
+	metrics, err := e.db.ListMetrics()
+	if err != nil {
+		return ctx.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+	return ctx.JSON(http.StatusOK, suggestRecordingRules(metrics, req.MinDashboards))
+}
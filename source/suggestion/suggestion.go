@@ -0,0 +1,307 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestion
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// defaultRange replaces the ranges given by a variable, like $__rate_interval, as a recording rule needs a fixed range.
+const defaultRange = "5m"
+
+var (
+	// variableRangeRegexp is matching a range given by a variable, like [$__rate_interval].
+	variableRangeRegexp = regexp.MustCompile(`\[[^\]:]*\$[^\]:]*\]`)
+	// variableRegexp is matching a variable, like $job, ${job} or ${job:regex}.
+	variableRegexp = regexp.MustCompile(`\$(?:\{[^}]*\}|\w+)`)
+	// rateFunctions are the functions applied on a counter that can be precomputed by a recording rule.
+	rateFunctions = []string{"rate", "irate", "increase"}
+	// aggregations are the aggregations of the rates that can be precomputed by a recording rule.
+	aggregations = []parser.ItemType{parser.SUM, parser.AVG, parser.MIN, parser.MAX, parser.COUNT}
+)
+
+// variablePlaceholder replaces the variables used outside the strings, like in the grouping labels, so the expression can be parsed.
+// As it is not a label of the metric, it is removed from the grouping labels.
+const variablePlaceholder = "__variable__"
+
+type kind string
+
+const (
+	rateKind              kind = "rate"
+	histogramQuantileKind kind = "histogram_quantile"
+)
+
+// pattern is a normalized aggregation of a counter or of a histogram, that could be precomputed by a recording rule.
+type pattern struct {
+	kind       kind
+	expression string
+	record     string
+}
+
+type recordingRuleSuggestion struct {
+	Metric string `json:"metric"`
+	// Type is the type of the metric given by its metadata. It is empty when the metadata are unknown.
+	Type string `json:"type,omitempty"`
+	Kind kind   `json:"kind"`
+	// Record is the suggested name of the recording rule, following the convention level:metric:operations.
+	// The level is omitted when the aggregation has no grouping.
+	Record     string              `json:"record"`
+	Expression string              `json:"expression"`
+	Dashboards []v1.DashboardUsage `json:"dashboards"`
+}
+
+// suggestRecordingRules looks in the queries of the dashboards for the same aggregation of a counter or of a histogram,
+// used by at least minDashboards dashboards. The aggregations already computed by a recording rule are skipped.
+// It only works with the dashboards collected with their expressions.
+func suggestRecordingRules(metrics map[string]*v1.Metric, minDashboards int) []recordingRuleSuggestion {
+	result := []recordingRuleSuggestion{}
+	for name, metric := range metrics {
+		if metric.Usage == nil || len(metric.Usage.Dashboards) == 0 {
+			continue
+		}
+		metricType := resolveType(metrics, name)
+		if len(metricType) > 0 && metricType != "counter" && metricType != "histogram" {
+			continue
+		}
+		recorded := v1.NewSet[string]()
+		for rule := range metric.Usage.RecordingRules {
+			for _, p := range extractPatterns(rule.Expression, name) {
+				recorded.Add(p.expression)
+			}
+		}
+		dashboardsByPattern := make(map[pattern]v1.Set[v1.DashboardUsage])
+		for dashboard := range metric.Usage.Dashboards {
+			expression := dashboard.Expression
+			dashboard.Expression = ""
+			for _, p := range extractPatterns(expression, name) {
+				if recorded.Contains(p.expression) {
+					continue
+				}
+				if dashboardsByPattern[p] == nil {
+					dashboardsByPattern[p] = v1.NewSet[v1.DashboardUsage]()
+				}
+				dashboardsByPattern[p].Add(dashboard)
+			}
+		}
+		for p, dashboards := range dashboardsByPattern {
+			if len(dashboards) < minDashboards {
+				continue
+			}
+			list := dashboards.TransformAsSlice()
+			slices.SortFunc(list, func(a, b v1.DashboardUsage) int {
+				return cmp.Or(cmp.Compare(a.ID, b.ID), cmp.Compare(a.URL, b.URL))
+			})
+			result = append(result, recordingRuleSuggestion{
+				Metric:     name,
+				Type:       metricType,
+				Kind:       p.kind,
+				Record:     p.record,
+				Expression: p.expression,
+				Dashboards: list,
+			})
+		}
+	}
+	// The aggregations used by the most dashboards come first.
+	slices.SortFunc(result, func(a, b recordingRuleSuggestion) int {
+		return cmp.Or(cmp.Compare(len(b.Dashboards), len(a.Dashboards)), cmp.Compare(a.Metric, b.Metric), cmp.Compare(a.Expression, b.Expression))
+	})
+	return result
+}
+
+// resolveType returns the type of the metric. The series of a histogram, like foo_bucket, have the type of the histogram foo.
+func resolveType(metrics map[string]*v1.Metric, name string) string {
+	if metricType := metrics[name].Type; len(metricType) > 0 {
+		return metricType
+	}
+	for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+		if base, found := strings.CutSuffix(name, suffix); found && metrics[base] != nil {
+			return metrics[base].Type
+		}
+	}
+	return ""
+}
+
+// extractPatterns returns the aggregations of the metric found in the expression, normalized to be compared between dashboards:
+// the matchers and the grouping labels using a variable are removed, and the ranges using a variable are replaced by a fixed one.
+// The expressions that cannot be parsed, even once the variables are replaced, don't have any pattern.
+func extractPatterns(expression string, metric string) []pattern {
+	expr, err := parser.ParseExpr(replaceVariables(expression))
+	if err != nil {
+		return nil
+	}
+	var result []pattern
+	parser.Inspect(expr, func(node parser.Node, path []parser.Node) error {
+		call, ok := node.(*parser.Call)
+		if !ok || !slices.Contains(rateFunctions, call.Func.Name) || len(call.Args) != 1 {
+			return nil
+		}
+		matrix, ok := unwrapParens(call.Args[0]).(*parser.MatrixSelector)
+		if !ok {
+			return nil
+		}
+		selector, ok := matrix.VectorSelector.(*parser.VectorSelector)
+		if !ok || selector.Name != metric || selector.OriginalOffset != 0 || selector.Timestamp != nil || selector.StartOrEnd != 0 {
+			// A recording rule cannot replace a call looking at another time.
+			return nil
+		}
+		result = append(result, newPattern(call.Func.Name, metric, selector, matrix.Range, path))
+		return nil
+	})
+	return result
+}
+
+// newPattern returns the pattern of the call to the function on the selector of the metric, aggregated by its parent in the path if any.
+func newPattern(function string, metric string, selector *parser.VectorSelector, timeRange time.Duration, path []parser.Node) pattern {
+	formattedSelector := metric
+	if matchers := normalizeMatchers(selector.LabelMatchers); len(matchers) > 0 {
+		formattedSelector = fmt.Sprintf("%s{%s}", metric, matchers)
+	}
+	formattedRange := model.Duration(timeRange).String()
+	call := fmt.Sprintf("%s(%s[%s])", function, formattedSelector, formattedRange)
+	operations := function + formattedRange
+	level := ""
+	k := rateKind
+	aggregation := parentAggregation(path)
+	if aggregation != nil {
+		op := aggregation.Op.String()
+		labels := normalizeLabels(aggregation.Grouping)
+		if len(labels) > 0 {
+			grouping := "by"
+			if aggregation.Without {
+				grouping = "without"
+			} else {
+				level = strings.Join(labels, "_")
+			}
+			call = fmt.Sprintf("%s %s (%s) (%s)", op, grouping, strings.Join(labels, ", "), call)
+		} else {
+			call = fmt.Sprintf("%s(%s)", op, call)
+		}
+		operations = fmt.Sprintf("%s_%s", op, operations)
+		if strings.HasSuffix(metric, "_bucket") && slices.Contains(labels, "le") && isInHistogramQuantile(path) {
+			k = histogramQuantileKind
+		}
+	}
+	record := fmt.Sprintf("%s:%s", strings.TrimSuffix(metric, "_total"), operations)
+	if len(level) > 0 {
+		record = fmt.Sprintf("%s:%s", level, record)
+	}
+	return pattern{kind: k, expression: call, record: record}
+}
+
+// parentAggregation returns the aggregation directly applied on the last node of the path, ignoring the parentheses.
+// It returns nil when the node is part of a bigger expression, like a binary operation, as only the call can then be recorded.
+func parentAggregation(path []parser.Node) *parser.AggregateExpr {
+	for i := len(path) - 1; i >= 0; i-- {
+		switch n := path[i].(type) {
+		case *parser.ParenExpr:
+			continue
+		case *parser.AggregateExpr:
+			if slices.Contains(aggregations, n.Op) {
+				return n
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// isInHistogramQuantile returns true when one of the nodes of the path is a call to histogram_quantile.
+func isInHistogramQuantile(path []parser.Node) bool {
+	return slices.ContainsFunc(path, func(node parser.Node) bool {
+		call, ok := node.(*parser.Call)
+		return ok && call.Func.Name == "histogram_quantile"
+	})
+}
+
+func unwrapParens(expr parser.Expr) parser.Expr {
+	for {
+		paren, ok := expr.(*parser.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.Expr
+	}
+}
+
+// replaceVariables makes the expression parsable: the ranges given by a variable are replaced by the default range,
+// and the other variables outside the strings by a placeholder. The variables in the strings, like in the matchers, are kept.
+func replaceVariables(expression string) string {
+	expression = variableRangeRegexp.ReplaceAllLiteralString(expression, fmt.Sprintf("[%s]", defaultRange))
+	var builder strings.Builder
+	var quote rune
+	start := 0
+	for i, char := range expression {
+		switch {
+		case quote != 0 && char == quote && !isEscaped(expression, i):
+			quote = 0
+			builder.WriteString(expression[start : i+1])
+			start = i + 1
+		case quote == 0 && (char == '"' || char == '\'' || char == '`'):
+			builder.WriteString(variableRegexp.ReplaceAllLiteralString(expression[start:i], variablePlaceholder))
+			quote = char
+			start = i
+		}
+	}
+	if quote != 0 {
+		builder.WriteString(expression[start:])
+	} else {
+		builder.WriteString(variableRegexp.ReplaceAllLiteralString(expression[start:], variablePlaceholder))
+	}
+	return builder.String()
+}
+
+// isEscaped returns true when the character at the given index is preceded by an odd number of backslashes.
+func isEscaped(expression string, index int) bool {
+	count := 0
+	for i := index - 1; i >= 0 && expression[i] == '\\'; i-- {
+		count++
+	}
+	return count%2 == 1
+}
+
+// normalizeMatchers keeps the matchers not using a variable, sorted, without the one on the metric name.
+func normalizeMatchers(matchers []*labels.Matcher) string {
+	var result []string
+	for _, m := range matchers {
+		if m.Name == labels.MetricName || strings.Contains(m.Value, "$") {
+			continue
+		}
+		result = append(result, fmt.Sprintf(`%s%s%q`, m.Name, m.Type, m.Value))
+	}
+	slices.Sort(result)
+	return strings.Join(result, ", ")
+}
+
+// normalizeLabels returns the grouping labels not using a variable, sorted.
+func normalizeLabels(grouping []string) []string {
+	var result []string
+	for _, label := range grouping {
+		if label == variablePlaceholder {
+			continue
+		}
+		result = append(result, label)
+	}
+	slices.Sort(result)
+	return slices.Compact(result)
+}
@@ -0,0 +1,196 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package suggestion
+
+import (
+	"testing"
+
+	v1 "github.com/perses/metrics-usage/pkg/api/v1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractPatterns(t *testing.T) {
+	testSuite := []struct {
+		title      string
+		expression string
+		metric     string
+		expected   []pattern
+	}{
+		{
+			title:      "aggregation with the grouping first",
+			expression: `sum by (job, $grouping) (rate(http_requests_total{code=~"5..", instance=~"$instance"}[$__rate_interval]))`,
+			metric:     "http_requests_total",
+			expected: []pattern{{
+				kind:       rateKind,
+				expression: `sum by (job) (rate(http_requests_total{code=~"5.."}[5m]))`,
+				record:     "job:http_requests:sum_rate5m",
+			}},
+		},
+		{
+			title:      "aggregation with the grouping last",
+			expression: `sum(rate(http_requests_total{job="$job"}[1m])) by (job)`,
+			metric:     "http_requests_total",
+			expected: []pattern{{
+				kind:       rateKind,
+				expression: `sum by (job) (rate(http_requests_total[1m]))`,
+				record:     "job:http_requests:sum_rate1m",
+			}},
+		},
+		{
+			title:      "aggregation of a bigger expression",
+			expression: `sum(rate(errors_total[5m]) / rate(requests_total[5m]))`,
+			metric:     "errors_total",
+			expected: []pattern{{
+				kind:       rateKind,
+				expression: `rate(errors_total[5m])`,
+				record:     "errors:rate5m",
+			}},
+		},
+		{
+			title:      "histogram quantile",
+			expression: `histogram_quantile(0.99, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))`,
+			metric:     "http_request_duration_seconds_bucket",
+			expected: []pattern{{
+				kind:       histogramQuantileKind,
+				expression: `sum by (le) (rate(http_request_duration_seconds_bucket[5m]))`,
+				record:     "le:http_request_duration_seconds_bucket:sum_rate5m",
+			}},
+		},
+		{
+			title:      "irate in nested functions",
+			expression: `round(abs(max by (${instance:regex}, job) (irate(http_requests_total{job=~"api|web"}[1m]))), 0.1)`,
+			metric:     "http_requests_total",
+			expected: []pattern{{
+				kind:       rateKind,
+				expression: `max by (job) (irate(http_requests_total{job=~"api|web"}[1m]))`,
+				record:     "job:http_requests:max_irate1m",
+			}},
+		},
+		{
+			title:      "increase with parentheses and without",
+			expression: `sum without (instance) ((increase(http_requests_total[1h])))`,
+			metric:     "http_requests_total",
+			expected: []pattern{{
+				kind:       rateKind,
+				expression: `sum without (instance) (increase(http_requests_total[1h]))`,
+				record:     "http_requests:sum_increase1h",
+			}},
+		},
+		{
+			title:      "histogram quantile with the grouping last",
+			expression: `histogram_quantile(0.9, sum(rate(http_request_duration_seconds_bucket{handler="/api"}[$__rate_interval])) by (le))`,
+			metric:     "http_request_duration_seconds_bucket",
+			expected: []pattern{{
+				kind:       histogramQuantileKind,
+				expression: `sum by (le) (rate(http_request_duration_seconds_bucket{handler="/api"}[5m]))`,
+				record:     "le:http_request_duration_seconds_bucket:sum_rate5m",
+			}},
+		},
+		{
+			title:      "several calls on the metric",
+			expression: `sum(rate(http_requests_total{code="500"}[5m])) / sum(rate(http_requests_total[5m]))`,
+			metric:     "http_requests_total",
+			expected: []pattern{
+				{
+					kind:       rateKind,
+					expression: `sum(rate(http_requests_total{code="500"}[5m]))`,
+					record:     "http_requests:sum_rate5m",
+				},
+				{
+					kind:       rateKind,
+					expression: `sum(rate(http_requests_total[5m]))`,
+					record:     "http_requests:sum_rate5m",
+				},
+			},
+		},
+		{
+			title:      "call with an offset",
+			expression: `sum(rate(http_requests_total[5m] offset 1d))`,
+			metric:     "http_requests_total",
+		},
+		{
+			title:      "other aggregation",
+			expression: `topk(5, rate(http_requests_total[5m]))`,
+			metric:     "http_requests_total",
+			expected: []pattern{{
+				kind:       rateKind,
+				expression: `rate(http_requests_total[5m])`,
+				record:     "http_requests:rate5m",
+			}},
+		},
+		{
+			title:      "expression that cannot be parsed",
+			expression: `sum(rate(http_requests_total[5m])`,
+			metric:     "http_requests_total",
+		},
+		{
+			title:      "other metric",
+			expression: `sum(rate(other_total[5m]))`,
+			metric:     "http_requests_total",
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.expected, extractPatterns(test.expression, test.metric))
+		})
+	}
+}
+
+func TestSuggestRecordingRules(t *testing.T) {
+	metrics := map[string]*v1.Metric{
+		"http_requests_total": {
+			Type: "counter",
+			Usage: &v1.MetricUsage{
+				Dashboards: v1.NewSet(
+					v1.DashboardUsage{ID: "a", Expression: `sum by (job) (rate(http_requests_total{job="$job"}[$__rate_interval]))`},
+					v1.DashboardUsage{ID: "a", Expression: `sum(rate(http_requests_total[5m])) by (job)`},
+					v1.DashboardUsage{ID: "b", Expression: `sum by (job) (rate(http_requests_total[$__interval]))`},
+					v1.DashboardUsage{ID: "c", Expression: `sum(rate(http_requests_total[5m]))`},
+				),
+			},
+		},
+		"http_request_duration_seconds": {Type: "histogram"},
+		"http_request_duration_seconds_bucket": {
+			Usage: &v1.MetricUsage{
+				Dashboards: v1.NewSet(
+					v1.DashboardUsage{ID: "a", Expression: `histogram_quantile(0.9, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))`},
+					v1.DashboardUsage{ID: "b", Expression: `histogram_quantile(0.99, sum by (le) (rate(http_request_duration_seconds_bucket[5m])))`},
+				),
+				// The aggregation is already recorded.
+				RecordingRules: v1.NewSet(v1.RuleUsage{Name: "le:http_request_duration_seconds_bucket:sum_rate5m", Expression: `sum by (le) (rate(http_request_duration_seconds_bucket[5m]))`}),
+			},
+		},
+		"node_load1": {
+			Type: "gauge",
+			Usage: &v1.MetricUsage{
+				Dashboards: v1.NewSet(
+					v1.DashboardUsage{ID: "a", Expression: `sum(rate(node_load1[5m]))`},
+					v1.DashboardUsage{ID: "b", Expression: `sum(rate(node_load1[5m]))`},
+				),
+			},
+		},
+	}
+	assert.Equal(t, []recordingRuleSuggestion{
+		{
+			Metric:     "http_requests_total",
+			Type:       "counter",
+			Kind:       rateKind,
+			Record:     "job:http_requests:sum_rate5m",
+			Expression: `sum by (job) (rate(http_requests_total[5m]))`,
+			Dashboards: []v1.DashboardUsage{{ID: "a"}, {ID: "b"}},
+		},
+	}, suggestRecordingRules(metrics, 2))
+	assert.Len(t, suggestRecordingRules(metrics, 1), 2)
+	assert.Empty(t, suggestRecordingRules(metrics, 3))
+}
@@ -14,6 +14,9 @@
 package usageclient
 
 import (
+	"errors"
+	"time"
+
 	"github.com/perses/metrics-usage/database"
 	modelAPIV1 "github.com/perses/metrics-usage/pkg/api/v1"
 	"github.com/perses/metrics-usage/pkg/client"
@@ -24,37 +27,107 @@ type Client struct {
 	DB                database.Database
 	MetricUsageClient client.Client
 	Logger            *logrus.Entry
+	// Tags are set on every dashboard, rule and Grafana alert sent, like the cluster the collector is looking at.
+	Tags modelAPIV1.Tags
 }
 
-func (c *Client) SendUsage(metricUsage map[string]*modelAPIV1.MetricUsage, invalidMetricUsage map[string]*modelAPIV1.MetricUsage) {
-	c.sendMetricUsage(metricUsage)
-	c.sendPartialMetricUsage(invalidMetricUsage)
+// SendUsage sends the usage to the remote server, or stores it in the database.
+// The errors are logged, and they are also returned for the collectors that must know the usage has not been sent.
+func (c *Client) SendUsage(metricUsage map[string]*modelAPIV1.MetricUsage, invalidMetricUsage map[string]*modelAPIV1.MetricUsage) error {
+	return errors.Join(
+		c.sendMetricUsage(c.tag(metricUsage)),
+		c.sendPartialMetricUsage(c.tag(invalidMetricUsage)),
+	)
 }
 
-func (c *Client) sendMetricUsage(usage map[string]*modelAPIV1.MetricUsage) {
-	if len(usage) == 0 {
+// tag returns the usage with the tags of the client.
+func (c *Client) tag(usage map[string]*modelAPIV1.MetricUsage) map[string]*modelAPIV1.MetricUsage {
+	if len(c.Tags) == 0 {
+		return usage
+	}
+	result := make(map[string]*modelAPIV1.MetricUsage, len(usage))
+	for metricName, u := range usage {
+		result[metricName] = u.WithTags(c.Tags)
+	}
+	return result
+}
+
+// ReconcileUsage replaces the usage collected previously from the source by the given one.
+// The usage not collected anymore is kept during the window, then removed.
+// It is only supported with the local database, so the usage is just merged when it must be sent to a remote server.
+func (c *Client) ReconcileUsage(source string, metricUsage map[string]*modelAPIV1.MetricUsage, partialMetricUsage map[string]*modelAPIV1.MetricUsage, window time.Duration) error {
+	if c.MetricUsageClient != nil {
+		return c.SendUsage(metricUsage, partialMetricUsage)
+	}
+	c.DB.EnqueueReconciliation(&database.Reconciliation{
+		Source:              source,
+		Usage:               c.tag(metricUsage),
+		PartialMetricsUsage: c.tag(partialMetricUsage),
+		Window:              window,
+	})
+	return nil
+}
+
+// SetBrokenQueries replaces the broken queries found previously in the source by the given ones.
+// It is only supported with the local database, so the broken queries are only logged when the usage is sent to a remote server.
+func (c *Client) SetBrokenQueries(source string, dashboards []modelAPIV1.DashboardBrokenQueries) {
+	if c.MetricUsageClient != nil {
+		return
+	}
+	c.DB.SetBrokenQueries(source, dashboards)
+}
+
+// MergeBrokenQueries replaces the broken queries of the dashboards analyzed, when the run didn't analyze every dashboard of the source.
+// Like SetBrokenQueries, it is only supported with the local database.
+func (c *Client) MergeBrokenQueries(source string, analyzedURLs []string, dashboards []modelAPIV1.DashboardBrokenQueries) {
+	if c.MetricUsageClient != nil {
 		return
 	}
+	c.DB.MergeBrokenQueries(source, analyzedURLs, dashboards)
+}
+
+func (c *Client) sendMetricUsage(usage map[string]*modelAPIV1.MetricUsage) error {
+	if len(usage) == 0 {
+		return nil
+	}
 	if c.MetricUsageClient != nil {
 		// In this case, that means we have to send the data to a remote server.
 		if sendErr := c.MetricUsageClient.Usage(usage); sendErr != nil {
 			c.Logger.WithError(sendErr).Error("Failed to send usage for metric")
+			return sendErr
 		}
 	} else {
 		c.DB.EnqueueUsage(usage)
 	}
+	return nil
 }
 
-func (c *Client) sendPartialMetricUsage(usage map[string]*modelAPIV1.MetricUsage) {
+func (c *Client) sendPartialMetricUsage(usage map[string]*modelAPIV1.MetricUsage) error {
 	if len(usage) == 0 {
-		return
+		return nil
 	}
 	if c.MetricUsageClient != nil {
 		// In this case, that means we have to send the data to a remote server.
 		if sendErr := c.MetricUsageClient.PartialMetricsUsage(usage); sendErr != nil {
 			c.Logger.WithError(sendErr).Error("Failed to send usage for invalid_metric")
+			return sendErr
 		}
 	} else {
 		c.DB.EnqueuePartialMetricsUsage(usage)
 	}
+	return nil
+}
+
+func (c *Client) SendUsedLabels(usedLabels *modelAPIV1.UsedLabels) {
+	if len(usedLabels.ByMetric) == 0 && len(usedLabels.Global) == 0 {
+		return
+	}
+	if c.MetricUsageClient != nil {
+		// In this case, that means we have to send the data to a remote server.
+		if sendErr := c.MetricUsageClient.UsedLabels(usedLabels); sendErr != nil {
+			c.Logger.WithError(sendErr).Error("Failed to send used labels")
+		}
+	} else {
+		c.DB.EnqueueUsedLabels(usedLabels)
+	}
 }
@@ -0,0 +1,68 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package idempotency provides the middleware skipping the retries of the requests already processed.
+package idempotency
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Header is the header carrying the key identifying a request, kept identical when the request is retried.
+const Header = "Idempotency-Key"
+
+// Store records the keys of the requests received.
+type Store interface {
+	// ClaimIdempotencyKey returns false if the key has already been claimed, and then whether the request having claimed it has been processed.
+	ClaimIdempotencyKey(key string) (claimed bool, processed bool)
+	CompleteIdempotencyKey(key string)
+	ReleaseIdempotencyKey(key string)
+}
+
+// Middleware returns a middleware processing only once the requests having the same idempotency key on the same route.
+// A request whose key has already been processed is acknowledged without being processed again.
+// A request whose key is still being processed is rejected with the status 409, so the client retries it later.
+// When the request fails, the key is released, so the client can retry it.
+// The requests without the header are always processed.
+func Middleware(store Store) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			key := ctx.Request().Header.Get(Header)
+			if len(key) == 0 {
+				return next(ctx)
+			}
+			key = ctx.Path() + " " + key
+			if claimed, processed := store.ClaimIdempotencyKey(key); !claimed {
+				if processed {
+					return ctx.JSON(http.StatusAccepted, echo.Map{"message": "already processed"})
+				}
+				return ctx.JSON(http.StatusConflict, echo.Map{"message": "a request with the same idempotency key is being processed"})
+			}
+			succeeded := false
+			// The key is released in a defer, so it is not kept claimed when the handler panics.
+			defer func() {
+				if succeeded {
+					store.CompleteIdempotencyKey(key)
+				} else {
+					store.ReleaseIdempotencyKey(key)
+				}
+			}()
+			err := next(ctx)
+			status := ctx.Response().Status
+			succeeded = err == nil && status >= http.StatusOK && status < http.StatusMultipleChoices
+			return err
+		}
+	}
+}
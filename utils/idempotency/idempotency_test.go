@@ -0,0 +1,86 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// store records whether the key has been processed.
+type store map[string]bool
+
+func (s store) ClaimIdempotencyKey(key string) (bool, bool) {
+	if processed, exists := s[key]; exists {
+		return false, processed
+	}
+	s[key] = false
+	return true, false
+}
+
+func (s store) CompleteIdempotencyKey(key string) {
+	s[key] = true
+}
+
+func (s store) ReleaseIdempotencyKey(key string) {
+	delete(s, key)
+}
+
+func TestMiddleware(t *testing.T) {
+	e := echo.New()
+	keys := store{}
+	processed := 0
+	fail := false
+	e.POST("/api/v1/metrics", func(ctx echo.Context) error {
+		if fail {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "invalid usage"})
+		}
+		processed++
+		return ctx.JSON(http.StatusAccepted, echo.Map{"message": "OK"})
+	}, Middleware(keys))
+	send := func(key string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/metrics", nil)
+		if len(key) > 0 {
+			req.Header.Set(Header, key)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusAccepted, send("a"))
+	assert.Equal(t, http.StatusAccepted, send("a"))
+	assert.Equal(t, 1, processed)
+
+	// The requests without key are always processed.
+	send("")
+	send("")
+	assert.Equal(t, 3, processed)
+
+	// A failed request can be retried with the same key.
+	fail = true
+	assert.Equal(t, http.StatusBadRequest, send("b"))
+	fail = false
+	assert.Equal(t, http.StatusAccepted, send("b"))
+	assert.Equal(t, 4, processed)
+
+	// A request still being processed is rejected, so it can be retried once the first one is done.
+	keys["/api/v1/metrics c"] = false
+	assert.Equal(t, http.StatusConflict, send("c"))
+	assert.Equal(t, 4, processed)
+}
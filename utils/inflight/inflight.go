@@ -0,0 +1,67 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package inflight provides the middleware bounding the number of push requests processed at the same time.
+package inflight
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var limiter = &inFlightLimiter{}
+
+type inFlightLimiter struct {
+	mutex sync.RWMutex
+	// semaphore has one slot per request allowed at the same time. The requests are not limited when it is nil.
+	semaphore  chan struct{}
+	retryAfter time.Duration
+}
+
+// Enable allows at most maxRequests requests going through the middleware at the same time.
+// The others are rejected, asking the client to retry after the given delay. By default, the requests are not limited.
+func Enable(maxRequests int, retryAfter time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.semaphore = make(chan struct{}, maxRequests)
+	limiter.retryAfter = retryAfter
+}
+
+// Middleware returns a middleware rejecting the request with the status 503 and the header Retry-After
+// when too many requests are already processed, instead of letting them pile up in memory.
+func Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			limiter.mutex.RLock()
+			semaphore, retryAfter := limiter.semaphore, limiter.retryAfter
+			limiter.mutex.RUnlock()
+			if semaphore == nil {
+				return next(ctx)
+			}
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+				return next(ctx)
+			default:
+				// Retry-After is a number of seconds, it is rounded up so the client never retries too early.
+				seconds := int((retryAfter + time.Second - 1) / time.Second)
+				ctx.Response().Header().Set(echo.HeaderRetryAfter, strconv.Itoa(seconds))
+				return ctx.JSON(http.StatusServiceUnavailable, echo.Map{"message": "too many push requests in progress, retry later"})
+			}
+		}
+	}
+}
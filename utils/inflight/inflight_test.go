@@ -0,0 +1,61 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package inflight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	e := echo.New()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	e.POST("/api/v1/metrics", func(ctx echo.Context) error {
+		if ctx.QueryParam("block") == "true" {
+			started <- struct{}{}
+			<-release
+		}
+		return ctx.JSON(http.StatusAccepted, echo.Map{"message": "OK"})
+	}, Middleware())
+	send := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec
+	}
+
+	// The requests are not limited by default.
+	assert.Equal(t, http.StatusAccepted, send("/api/v1/metrics").Code)
+
+	Enable(1, 1500*time.Millisecond)
+	t.Cleanup(func() { limiter.semaphore = nil })
+	done := make(chan int)
+	go func() {
+		done <- send("/api/v1/metrics?block=true").Code
+	}()
+	<-started
+	rejected := send("/api/v1/metrics")
+	assert.Equal(t, http.StatusServiceUnavailable, rejected.Code)
+	assert.Equal(t, "2", rejected.Header().Get(echo.HeaderRetryAfter))
+
+	// The slot is freed once the request in progress is done.
+	close(release)
+	assert.Equal(t, http.StatusAccepted, <-done)
+	assert.Equal(t, http.StatusAccepted, send("/api/v1/metrics").Code)
+}
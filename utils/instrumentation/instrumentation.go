@@ -0,0 +1,117 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package instrumentation provides the Prometheus metrics describing the activity of the collectors.
+// The metrics are registered in the default registry, exposed by the HTTP server of the application.
+package instrumentation
+
+import (
+	"time"
+
+	"github.com/perses/metrics-usage/utils/pubsub"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	successResult = "success"
+	errorResult   = "error"
+	timeoutResult = "timeout"
+)
+
+var (
+	collectorRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "collector_runs_total",
+		Help: "The number of times a collector has been executed, by result.",
+	}, []string{"collector", "instance", "result"})
+	collectorDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "collector_duration_seconds",
+		Help:    "The duration of the execution of a collector.",
+		Buckets: []float64{0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"collector", "instance"})
+	collectorMetricsExtracted = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collector_metrics_extracted",
+		Help: "The number of metrics extracted by the last execution of a collector.",
+	}, []string{"collector", "instance"})
+	collectorMetricsFailed = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "collector_metrics_failed",
+		Help: "The number of metrics a collector failed to process during its last execution.",
+	}, []string{"collector", "instance"})
+)
+
+// Run is recording the execution of a collector.
+type Run struct {
+	collector string
+	// instance identifies the collector among the ones of the same kind, with the source it is collecting from.
+	instance  string
+	start     time.Time
+	extracted int
+	// failedMetrics is the number of metrics that couldn't be processed, without failing the whole execution.
+	failedMetrics int
+	failed        bool
+	timedOut      bool
+}
+
+// StartRun starts recording the execution of the given collector. Run.End must be called when the execution is over.
+// The instance is the source of the collector, like the URL of the Prometheus or of the Grafana. It must not contain any secret.
+func StartRun(collector string, instance string) *Run {
+	return &Run{
+		collector: collector,
+		instance:  instance,
+		start:     time.Now(),
+	}
+}
+
+// Extracted adds the given number of metrics to the number of metrics extracted during the execution.
+func (r *Run) Extracted(nbMetrics int) {
+	r.extracted += nbMetrics
+}
+
+// FailedMetrics adds the given number of metrics to the number of metrics that couldn't be processed during the execution.
+func (r *Run) FailedMetrics(nbMetrics int) {
+	r.failedMetrics += nbMetrics
+}
+
+// Fail flags the execution as failed.
+func (r *Run) Fail() {
+	r.failed = true
+}
+
+// Timeout flags the execution as interrupted because it has reached its timeout.
+func (r *Run) Timeout() {
+	r.timedOut = true
+}
+
+// End records the result, the duration and the number of metrics extracted and failed of the execution.
+func (r *Run) End() {
+	result := successResult
+	if r.timedOut {
+		result = timeoutResult
+	} else if r.failed {
+		result = errorResult
+	}
+	duration := time.Since(r.start).Seconds()
+	collectorRuns.WithLabelValues(r.collector, r.instance, result).Inc()
+	collectorDuration.WithLabelValues(r.collector, r.instance).Observe(duration)
+	collectorMetricsExtracted.WithLabelValues(r.collector, r.instance).Set(float64(r.extracted))
+	collectorMetricsFailed.WithLabelValues(r.collector, r.instance).Set(float64(r.failedMetrics))
+	pubsub.Publish(pubsub.Event{
+		Kind:      pubsub.CollectorCompletedKind,
+		Collector: r.collector,
+		Instance:  r.instance,
+		Result:    result,
+		Duration:  duration,
+		Extracted: r.extracted,
+		Failed:    r.failedMetrics,
+	})
+}
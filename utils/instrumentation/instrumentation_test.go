@@ -0,0 +1,53 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instrumentation
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun(t *testing.T) {
+	const instance = "http://prometheus-a"
+	run := StartRun("test collector", instance)
+	run.Extracted(3)
+	run.Extracted(2)
+	run.FailedMetrics(1)
+	run.End()
+
+	failedRun := StartRun("test collector", instance)
+	failedRun.Fail()
+	failedRun.End()
+
+	timedOutRun := StartRun("test collector", instance)
+	timedOutRun.Fail()
+	timedOutRun.Timeout()
+	timedOutRun.End()
+
+	// Another collector of the same kind has its own series.
+	otherRun := StartRun("test collector", "http://prometheus-b")
+	otherRun.Extracted(4)
+	otherRun.End()
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(collectorRuns.WithLabelValues("test collector", instance, successResult)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collectorRuns.WithLabelValues("test collector", instance, errorResult)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collectorRuns.WithLabelValues("test collector", instance, timeoutResult)))
+	// The gauge is reporting the last execution only.
+	assert.Equal(t, float64(0), testutil.ToFloat64(collectorMetricsExtracted.WithLabelValues("test collector", instance)))
+	assert.Equal(t, float64(0), testutil.ToFloat64(collectorMetricsFailed.WithLabelValues("test collector", instance)))
+	assert.Equal(t, float64(4), testutil.ToFloat64(collectorMetricsExtracted.WithLabelValues("test collector", "http://prometheus-b")))
+	assert.Equal(t, 2, testutil.CollectAndCount(collectorDuration))
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package jsonbody decodes the JSON body of the requests pushing data, reporting where the body is invalid.
+package jsonbody
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// StrictParam is the query parameter rejecting the fields not known by the API, to catch the typos in the payloads.
+const StrictParam = "strict"
+
+// Error is the first error found in the body. It is returned as is in the response, so the sender knows where to look.
+type Error struct {
+	Message string `json:"message"`
+	// Offset is the position of the error in the body, in bytes from the beginning of the body.
+	// Line and Column are the same position, starting at 1. They are omitted when the position is unknown, like for an unknown field.
+	Offset *int64 `json:"offset,omitempty"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	// Field is the path of the field having a wrong type or not being known, when the error is about a field.
+	Field string `json:"field,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Bind decodes the JSON body of the request into data. An empty body leaves data untouched, like echo.Context.Bind.
+// The unknown fields are rejected when the query parameter strict is true.
+// The error returned is always an *Error, so it can be sent as the response.
+func Bind(ctx echo.Context, data any) error {
+	body, err := io.ReadAll(ctx.Request().Body)
+	if err != nil {
+		return &Error{Message: fmt.Sprintf("unable to read the body: %s", err)}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+	strict, _ := strconv.ParseBool(ctx.QueryParam(StrictParam))
+	return Decode(body, data, strict)
+}
+
+// Decode decodes the JSON body into data. When disallowUnknownFields is true, a field not known by data is an error.
+func Decode(body []byte, data any, disallowUnknownFields bool) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if disallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(data); err != nil {
+		return newError(body, err)
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		result := &Error{Message: "invalid JSON body: unexpected data after the JSON value"}
+		result.setPosition(body, decoder.InputOffset()-1)
+		return result
+	}
+	return nil
+}
+
+func newError(body []byte, err error) *Error {
+	result := &Error{Message: fmt.Sprintf("invalid JSON body: %s", err)}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		// The offset is counting the invalid character.
+		result.setPosition(body, syntaxErr.Offset-1)
+	case errors.As(err, &typeErr):
+		// The offset is the end of the value having the wrong type.
+		result.setPosition(body, typeErr.Offset-1)
+		result.Field = typeErr.Field
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		result.setPosition(body, int64(len(body)))
+	default:
+		// The error about an unknown field only carries its name, like json: unknown field "name".
+		var field string
+		if _, scanErr := fmt.Sscanf(err.Error(), "json: unknown field %q", &field); scanErr == nil {
+			result.Field = field
+		}
+	}
+	return result
+}
+
+// setPosition sets the position of the error at the byte of the body at the given offset.
+func (e *Error) setPosition(body []byte, offset int64) {
+	offset = min(max(offset, 0), int64(len(body)))
+	before := body[:offset]
+	e.Offset = &offset
+	e.Line = bytes.Count(before, []byte("\n")) + 1
+	e.Column = len(before) - bytes.LastIndexByte(before, '\n')
+}
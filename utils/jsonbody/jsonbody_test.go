@@ -0,0 +1,91 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package jsonbody
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type usage struct {
+	Dashboards []string `json:"dashboards"`
+}
+
+func offset(o int64) *int64 {
+	return &o
+}
+
+func TestDecode(t *testing.T) {
+	testSuites := []struct {
+		title    string
+		body     string
+		strict   bool
+		expected *Error
+	}{
+		{
+			title: "valid body",
+			body:  `{"up": {"dashboards": ["a"]}}`,
+		},
+		{
+			title: "unknown field ignored",
+			body:  `{"up": {"dashboard": ["a"]}}`,
+		},
+		{
+			title:    "syntax error",
+			body:     "{\n  \"up\": {\"dashboards\": [\"a\"],}\n}",
+			expected: &Error{Message: "invalid character '}' looking for beginning of object key string", Offset: offset(31), Line: 2, Column: 30},
+		},
+		{
+			title:    "wrong type",
+			body:     "{\n  \"up\": {\"dashboards\": \"a\"}\n}",
+			expected: &Error{Message: "cannot unmarshal string", Offset: offset(27), Line: 2, Column: 26, Field: "up.dashboards"},
+		},
+		{
+			title:    "unknown field rejected",
+			body:     `{"up": {"dashboard": ["a"]}}`,
+			strict:   true,
+			expected: &Error{Message: `unknown field "dashboard"`, Field: "dashboard"},
+		},
+		{
+			title:    "truncated body",
+			body:     `{"up": {"dashboards": [`,
+			expected: &Error{Message: "unexpected EOF", Offset: offset(23), Line: 1, Column: 24},
+		},
+		{
+			title:    "trailing data",
+			body:     `{} {}`,
+			expected: &Error{Message: "unexpected data after the JSON value", Offset: offset(3), Line: 1, Column: 4},
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			data := make(map[string]*usage)
+			err := Decode([]byte(test.body), &data, test.strict)
+			if test.expected == nil {
+				require.NoError(t, err)
+				return
+			}
+			var decodeErr *Error
+			require.ErrorAs(t, err, &decodeErr)
+			// The messages of the errors returned by encoding/json are not stable, so only a part of them is checked.
+			assert.Contains(t, decodeErr.Message, test.expected.Message)
+			assert.Equal(t, test.expected.Offset, decodeErr.Offset)
+			assert.Equal(t, test.expected.Line, decodeErr.Line)
+			assert.Equal(t, test.expected.Column, decodeErr.Column)
+			assert.Equal(t, test.expected.Field, decodeErr.Field)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pathprefix provides the middleware mounting the server under a path prefix.
+package pathprefix
+
+import (
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Middleware returns a middleware removing the prefix from the path of the requests, before the routing is done.
+// Like that, every route, including the API, the health and the Prometheus metrics, is available under the prefix.
+// The routes stay available without the prefix, for example for a Prometheus scraping the server directly.
+// It must be registered as a pre-middleware, as the routing would already be done otherwise.
+func Middleware(prefix string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			if p, found := strings.CutPrefix(req.URL.Path, prefix); found && (len(p) == 0 || strings.HasPrefix(p, "/")) {
+				if len(p) == 0 {
+					p = "/"
+				}
+				req.URL.Path = p
+				if len(req.URL.RawPath) > 0 {
+					req.URL.RawPath = strings.TrimPrefix(req.URL.RawPath, prefix)
+				}
+			}
+			return next(ctx)
+		}
+	}
+}
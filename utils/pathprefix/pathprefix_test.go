@@ -0,0 +1,75 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pathprefix
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	e := echo.New()
+	e.Pre(Middleware("/metrics-usage"))
+	ok := func(ctx echo.Context) error {
+		return ctx.String(http.StatusOK, ctx.Request().URL.Path)
+	}
+	e.GET("/", ok)
+	e.GET("/api/v1/metrics", ok)
+	e.GET("/metrics", ok)
+
+	testSuite := []struct {
+		title  string
+		path   string
+		status int
+		body   string
+	}{
+		{
+			title:  "route under the prefix",
+			path:   "/metrics-usage/api/v1/metrics",
+			status: http.StatusOK,
+			body:   "/api/v1/metrics",
+		},
+		{
+			title:  "prefix only",
+			path:   "/metrics-usage",
+			status: http.StatusOK,
+			body:   "/",
+		},
+		{
+			title:  "route without the prefix",
+			path:   "/metrics",
+			status: http.StatusOK,
+			body:   "/metrics",
+		},
+		{
+			title:  "path only starting like the prefix",
+			path:   "/metrics-usage-v2/api/v1/metrics",
+			status: http.StatusNotFound,
+		},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.path, nil))
+			assert.Equal(t, test.status, rec.Code)
+			if test.status == http.StatusOK {
+				assert.Equal(t, test.body, rec.Body.String())
+			}
+		})
+	}
+}
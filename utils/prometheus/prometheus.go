@@ -14,13 +14,16 @@
 package prometheus
 
 import (
+	"net/url"
+	"strings"
+
 	"github.com/perses/metrics-usage/config"
 	"github.com/prometheus/client_golang/api"
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 )
 
-func NewClient(cfg config.HTTPClient) (v1.API, error) {
-	httpClient, err := config.NewHTTPClient(cfg)
+func NewClient(cfg config.HTTPClient, component string) (v1.API, error) {
+	httpClient, err := config.NewHTTPClient(cfg, component)
 	if err != nil {
 		return nil, err
 	}
@@ -33,3 +36,16 @@ func NewClient(cfg config.HTTPClient) (v1.API, error) {
 	}
 	return v1.NewAPI(promHTTPClient), nil
 }
+
+// NormalizeSourceURL returns the URL of a Prometheus as it must be recorded as the source of the metrics and of the rules,
+// so the same Prometheus is always identified by the same string: the host is lowercased and the trailing slashes are removed.
+func NormalizeSourceURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	normalized := *u
+	normalized.Host = strings.ToLower(normalized.Host)
+	normalized.Path = strings.TrimRight(normalized.Path, "/")
+	normalized.RawPath = strings.TrimRight(normalized.RawPath, "/")
+	return normalized.String()
+}
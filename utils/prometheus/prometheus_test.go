@@ -0,0 +1,59 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeSourceURL(t *testing.T) {
+	testSuites := []struct {
+		title  string
+		url    string
+		result string
+	}{
+		{
+			title:  "already normalized",
+			url:    "http://prometheus:9090",
+			result: "http://prometheus:9090",
+		},
+		{
+			title:  "trailing slash",
+			url:    "http://prometheus:9090/",
+			result: "http://prometheus:9090",
+		},
+		{
+			title:  "uppercase host",
+			url:    "https://Prometheus.Example.COM/",
+			result: "https://prometheus.example.com",
+		},
+		{
+			title:  "path prefix",
+			url:    "http://prometheus:9090/Tenant-A//",
+			result: "http://prometheus:9090/Tenant-A",
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			u, err := url.Parse(test.url)
+			require.NoError(t, err)
+			assert.Equal(t, test.result, NormalizeSourceURL(u))
+		})
+	}
+	assert.Empty(t, NormalizeSourceURL(nil))
+}
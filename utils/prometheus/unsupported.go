@@ -0,0 +1,57 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// agentModeErrorMessage is the error returned by a Prometheus running in agent mode
+// when calling an endpoint that requires the TSDB or the rule manager, like the query, the labels or the rules APIs.
+const agentModeErrorMessage = "unavailable with Prometheus Agent"
+
+// ErrUnsupported can be wrapped by the clients not relying on the Prometheus client to flag an endpoint that is not served.
+var ErrUnsupported = errors.New("endpoint not supported")
+
+// IsUnsupported returns true when the error means the endpoint is not served by the backend:
+// either it doesn't exist (404) or it is disabled, like with a Prometheus running in agent mode.
+// In such case, retrying is pointless.
+func IsUnsupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrUnsupported) {
+		return true
+	}
+	var apiErr *v1.Error
+	if errors.As(err, &apiErr) && apiErr.Msg == fmt.Sprintf("client error: %d", http.StatusNotFound) {
+		return true
+	}
+	return IsAgentMode(err)
+}
+
+// IsAgentMode returns true when the error is the one explicitly returned by a Prometheus running in agent mode.
+// Unlike a 404 that can be caused by a proxy or a misconfigured path, it is safe to assume it won't change at the next call.
+func IsAgentMode(err error) bool {
+	if err == nil {
+		return false
+	}
+	// The agent error message is also kept by the clients decoding the Prometheus API response by themselves.
+	return strings.Contains(err.Error(), agentModeErrorMessage)
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/perses/metrics-usage/config"
+	"github.com/perses/perses/pkg/model/api/v1/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsUnsupported(t *testing.T) {
+	testSuites := []struct {
+		title      string
+		statusCode int
+		body       string
+		result     bool
+		agentMode  bool
+	}{
+		{
+			title:      "endpoint not found",
+			statusCode: http.StatusNotFound,
+			body:       "404 page not found",
+			result:     true,
+		},
+		{
+			title:      "Prometheus in agent mode",
+			statusCode: http.StatusUnprocessableEntity,
+			body:       `{"status":"error","errorType":"execution","error":"unavailable with Prometheus Agent"}`,
+			result:     true,
+			agentMode:  true,
+		},
+		{
+			title:      "other execution error",
+			statusCode: http.StatusUnprocessableEntity,
+			body:       `{"status":"error","errorType":"execution","error":"query timed out"}`,
+			result:     false,
+		},
+		{
+			title:      "server error",
+			statusCode: http.StatusInternalServerError,
+			body:       "internal error",
+			result:     false,
+		},
+	}
+	for _, test := range testSuites {
+		t.Run(test.title, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				w.WriteHeader(test.statusCode)
+				_, _ = w.Write([]byte(test.body))
+			}))
+			defer server.Close()
+			u, err := common.ParseURL(server.URL)
+			require.NoError(t, err)
+			client, err := NewClient(config.HTTPClient{URL: u}, "test")
+			require.NoError(t, err)
+			_, _, err = client.LabelValues(context.Background(), "__name__", nil, time.Now().Add(-time.Hour), time.Now())
+			require.Error(t, err)
+			assert.Equal(t, test.result, IsUnsupported(err))
+			assert.Equal(t, test.agentMode, IsAgentMode(err))
+		})
+	}
+}
+
+func TestIsUnsupportedWrapped(t *testing.T) {
+	assert.True(t, IsUnsupported(fmt.Errorf("when getting the rules: %w", ErrUnsupported)))
+	assert.False(t, IsUnsupported(errors.New("connection refused")))
+	assert.False(t, IsUnsupported(nil))
+}
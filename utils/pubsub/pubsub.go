@@ -0,0 +1,114 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package pubsub provides the events published by the collectors and the database, for the clients following the activity live.
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// subscriberBufferSize is the number of events kept for a subscriber not reading fast enough. The next events are dropped.
+	subscriberBufferSize = 100
+	// subscriptionDisabled is the maximum number of subscribers until the events are enabled.
+	subscriptionDisabled = -1
+)
+
+type Kind string
+
+const (
+	// CollectorCompletedKind is published at the end of every execution of a collector.
+	CollectorCompletedKind Kind = "collector_completed"
+	MetricsAddedKind       Kind = "metrics_added"
+	// MetricsRemovedKind is published when the unused metrics are removed by the retention.
+	MetricsRemovedKind Kind = "metrics_removed"
+	DatabaseResetKind  Kind = "database_reset"
+)
+
+type Event struct {
+	Kind Kind      `json:"kind"`
+	Time time.Time `json:"time"`
+	// Collector, Instance, Result, Duration, Extracted and Failed are set for the kind collector_completed.
+	Collector string  `json:"collector,omitempty"`
+	Instance  string  `json:"instance,omitempty"`
+	Result    string  `json:"result,omitempty"`
+	Duration  float64 `json:"duration_seconds,omitempty"`
+	Extracted int     `json:"extracted,omitempty"`
+	Failed    int     `json:"failed,omitempty"`
+	// Metrics is set for the kinds metrics_added and metrics_removed.
+	Metrics []string `json:"metrics,omitempty"`
+}
+
+var broker = &pubsub{
+	subscribers:    make(map[chan Event]struct{}),
+	maxSubscribers: subscriptionDisabled,
+}
+
+type pubsub struct {
+	mutex          sync.RWMutex
+	subscribers    map[chan Event]struct{}
+	maxSubscribers int
+}
+
+// Enable allows at most maxSubscribers clients to subscribe to the events. By default, nobody can subscribe.
+func Enable(maxSubscribers int) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	broker.maxSubscribers = maxSubscribers
+}
+
+// Subscribe returns the channel receiving the events, and the function to call to unsubscribe.
+func Subscribe() (<-chan Event, func(), error) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+	if broker.maxSubscribers == subscriptionDisabled {
+		return nil, nil, fmt.Errorf("the events are disabled")
+	}
+	if len(broker.subscribers) >= broker.maxSubscribers {
+		return nil, nil, fmt.Errorf("too many subscribers, the maximum is %d", broker.maxSubscribers)
+	}
+	ch := make(chan Event, subscriberBufferSize)
+	broker.subscribers[ch] = struct{}{}
+	unsubscribe := func() {
+		broker.mutex.Lock()
+		defer broker.mutex.Unlock()
+		delete(broker.subscribers, ch)
+	}
+	return ch, unsubscribe, nil
+}
+
+// Publish sends the event to every subscriber. It never blocks: the event is dropped for the subscribers whose buffer is full.
+func Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	broker.mutex.RLock()
+	defer broker.mutex.RUnlock()
+	for ch := range broker.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// PublishMetrics publishes an event with the given metrics. Nothing is published when the list is empty.
+func PublishMetrics(kind Kind, metrics []string) {
+	if len(metrics) == 0 {
+		return
+	}
+	Publish(Event{Kind: kind, Metrics: metrics})
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe(t *testing.T) {
+	_, _, err := Subscribe()
+	assert.EqualError(t, err, "the events are disabled")
+
+	Enable(1)
+	t.Cleanup(func() { Enable(subscriptionDisabled) })
+	events, unsubscribe, err := Subscribe()
+	require.NoError(t, err)
+	_, _, err = Subscribe()
+	assert.EqualError(t, err, "too many subscribers, the maximum is 1")
+
+	PublishMetrics(MetricsAddedKind, nil)
+	PublishMetrics(MetricsAddedKind, []string{"up"})
+	event := <-events
+	assert.Equal(t, MetricsAddedKind, event.Kind)
+	assert.Equal(t, []string{"up"}, event.Metrics)
+	assert.False(t, event.Time.IsZero())
+	assert.Empty(t, events)
+
+	// The events are dropped when the subscriber doesn't read them, instead of blocking the publishers.
+	for i := 0; i < subscriberBufferSize+1; i++ {
+		Publish(Event{Kind: DatabaseResetKind})
+	}
+	assert.Len(t, events, subscriberBufferSize)
+
+	unsubscribe()
+	_, unsubscribe, err = Subscribe()
+	require.NoError(t, err)
+	unsubscribe()
+}
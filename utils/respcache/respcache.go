@@ -0,0 +1,126 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package respcache provides the middleware caching the responses of the endpoints computing a report over the whole database.
+package respcache
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Source gives the generation of the data the responses are computed from.
+// A response cached is only served while the generation is the same as when it has been computed.
+type Source interface {
+	Generation() uint64
+}
+
+var cache = &responseCache{}
+
+type responseCache struct {
+	mutex sync.Mutex
+	// ttl is how long a response is kept. The responses are not cached when it is 0.
+	ttl     time.Duration
+	entries map[string]*entry
+}
+
+type entry struct {
+	generation  uint64
+	expiration  time.Time
+	contentType string
+	body        []byte
+}
+
+// Enable caches the responses going through the middleware during the given time to live. By default, nothing is cached.
+func Enable(ttl time.Duration) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.ttl = ttl
+	cache.entries = make(map[string]*entry)
+}
+
+func (c *responseCache) get(key string, generation uint64, now time.Time) (*entry, time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ttl <= 0 {
+		return nil, 0
+	}
+	e, ok := c.entries[key]
+	if !ok || e.generation != generation || !now.Before(e.expiration) {
+		return nil, c.ttl
+	}
+	return e, c.ttl
+}
+
+func (c *responseCache) set(key string, e *entry, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// The expired responses are removed at the same time, so the cache doesn't grow with the queries not repeated.
+	for k, previous := range c.entries {
+		if !now.Before(previous.expiration) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = e
+}
+
+// recorder is writing the response to the client, while keeping a copy of the body.
+type recorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Middleware returns a middleware serving the same response to the requests having the same path and the same query parameters,
+// as long as the generation of the source hasn't changed and the response is not older than the time to live.
+// Only the successful responses are cached.
+func Middleware(source Source) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			// The generation is read before computing the response, so a change happening in between is not hidden by the cache.
+			generation := source.Generation()
+			now := time.Now()
+			// Encode sorts the query parameters, so their order doesn't matter.
+			key := ctx.Request().URL.Path + "?" + ctx.QueryParams().Encode()
+			e, ttl := cache.get(key, generation, now)
+			if ttl <= 0 {
+				return next(ctx)
+			}
+			if e != nil {
+				return ctx.Blob(http.StatusOK, e.contentType, e.body)
+			}
+			response := ctx.Response()
+			rec := &recorder{ResponseWriter: response.Writer}
+			response.Writer = rec
+			err := next(ctx)
+			response.Writer = rec.ResponseWriter
+			if err == nil && response.Status == http.StatusOK {
+				cache.set(key, &entry{
+					generation:  generation,
+					expiration:  now.Add(ttl),
+					contentType: response.Header().Get(echo.HeaderContentType),
+					body:        rec.body.Bytes(),
+				}, now)
+			}
+			return err
+		}
+	}
+}
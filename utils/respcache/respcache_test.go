@@ -0,0 +1,81 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package respcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource struct {
+	generation uint64
+}
+
+func (f *fakeSource) Generation() uint64 {
+	return f.generation
+}
+
+func TestMiddleware(t *testing.T) {
+	e := echo.New()
+	source := &fakeSource{}
+	calls := 0
+	e.GET("/api/v1/graph", func(ctx echo.Context) error {
+		calls++
+		if ctx.QueryParam("fail") == "true" {
+			return ctx.JSON(http.StatusBadRequest, echo.Map{"message": "invalid"})
+		}
+		return ctx.JSON(http.StatusOK, echo.Map{"calls": calls})
+	}, Middleware(source))
+	send := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// Nothing is cached by default.
+	send("/api/v1/graph")
+	assert.JSONEq(t, `{"calls":2}`, send("/api/v1/graph").Body.String())
+
+	Enable(time.Minute)
+	t.Cleanup(func() { Enable(0) })
+	assert.JSONEq(t, `{"calls":3}`, send("/api/v1/graph?metric=up&depth=2").Body.String())
+	// The order of the query parameters doesn't matter.
+	rec := send("/api/v1/graph?depth=2&metric=up")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, echo.MIMEApplicationJSON, rec.Header().Get(echo.HeaderContentType))
+	assert.JSONEq(t, `{"calls":3}`, rec.Body.String())
+	// Other query parameters are another response.
+	assert.JSONEq(t, `{"calls":4}`, send("/api/v1/graph?metric=up").Body.String())
+
+	// The failures are not cached.
+	send("/api/v1/graph?fail=true")
+	assert.Equal(t, http.StatusBadRequest, send("/api/v1/graph?fail=true").Code)
+	assert.Equal(t, 6, calls)
+
+	// A change of the data invalidates the responses.
+	source.generation++
+	assert.JSONEq(t, `{"calls":7}`, send("/api/v1/graph?metric=up&depth=2").Body.String())
+	assert.JSONEq(t, `{"calls":7}`, send("/api/v1/graph?metric=up&depth=2").Body.String())
+
+	// As well as their age.
+	for _, cached := range cache.entries {
+		cached.expiration = time.Now()
+	}
+	assert.JSONEq(t, `{"calls":8}`, send("/api/v1/graph?metric=up&depth=2").Body.String())
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package schedule spreads the executions of the periodic tasks, so the collectors sharing the same period don't all query their backend at the same time.
+// It also chains the tasks that must run after a collection, like the notifier.
+package schedule
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+
+	"github.com/perses/common/async"
+)
+
+type delayedTask struct {
+	async.SimpleTask
+	startOffset time.Duration
+	jitter      time.Duration
+	started     bool
+}
+
+// Delay returns the task with every execution delayed: the first one by the start offset, then each of them by a random duration lower than the jitter.
+// The task is returned as is when there is nothing to delay.
+func Delay(task async.SimpleTask, startOffset time.Duration, jitter time.Duration) async.SimpleTask {
+	if startOffset <= 0 && jitter <= 0 {
+		return task
+	}
+	return &delayedTask{
+		SimpleTask:  task,
+		startOffset: startOffset,
+		jitter:      jitter,
+	}
+}
+
+func (t *delayedTask) Execute(ctx context.Context, cancelFunc context.CancelFunc) error {
+	// The runner is calling Execute sequentially, so started doesn't need to be protected.
+	delay := t.randomJitter()
+	if !t.started {
+		delay += t.startOffset
+		t.started = true
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return t.SimpleTask.Execute(ctx, cancelFunc)
+}
+
+func (t *delayedTask) randomJitter() time.Duration {
+	if t.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(t.jitter)))
+}
+
+type chainedTask struct {
+	async.SimpleTask
+	hooks []async.SimpleTask
+}
+
+// After returns the task followed by the hooks: they are executed one after the other at the end of every execution of the task.
+// The task is returned as is when there is no hook.
+func After(task async.SimpleTask, hooks ...async.SimpleTask) async.SimpleTask {
+	if len(hooks) == 0 {
+		return task
+	}
+	return &chainedTask{
+		SimpleTask: task,
+		hooks:      hooks,
+	}
+}
+
+func (t *chainedTask) Execute(ctx context.Context, cancelFunc context.CancelFunc) error {
+	err := t.SimpleTask.Execute(ctx, cancelFunc)
+	if ctx.Err() != nil {
+		return err
+	}
+	for _, hook := range t.hooks {
+		if hookErr := hook.Execute(ctx, cancelFunc); hookErr != nil && err == nil {
+			err = hookErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingTask struct {
+	executions []time.Time
+}
+
+func (t *countingTask) String() string {
+	return "counting"
+}
+
+func (t *countingTask) Execute(_ context.Context, _ context.CancelFunc) error {
+	t.executions = append(t.executions, time.Now())
+	return nil
+}
+
+func TestDelay(t *testing.T) {
+	task := &countingTask{}
+	assert.Same(t, task, Delay(task, 0, 0))
+
+	delayed := Delay(task, 50*time.Millisecond, 10*time.Millisecond)
+	assert.Equal(t, "counting", delayed.String())
+	start := time.Now()
+	assert.NoError(t, delayed.Execute(context.Background(), nil))
+	assert.GreaterOrEqual(t, task.executions[0].Sub(start), 50*time.Millisecond)
+	// The start offset is only applied to the first execution.
+	start = time.Now()
+	assert.NoError(t, delayed.Execute(context.Background(), nil))
+	assert.Less(t, task.executions[1].Sub(start), 50*time.Millisecond)
+}
+
+func TestDelayCanceled(t *testing.T) {
+	task := &countingTask{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, Delay(task, time.Hour, 0).Execute(ctx, cancel))
+	assert.Empty(t, task.executions)
+}
+
+func TestAfter(t *testing.T) {
+	task := &countingTask{}
+	assert.Same(t, task, After(task))
+
+	hook := &countingTask{}
+	chained := After(task, hook)
+	assert.Equal(t, "counting", chained.String())
+	assert.NoError(t, chained.Execute(context.Background(), nil))
+	assert.Len(t, task.executions, 1)
+	assert.Len(t, hook.executions, 1)
+	assert.False(t, hook.executions[0].Before(task.executions[0]))
+
+	// The hooks are skipped when the execution has been canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, chained.Execute(ctx, cancel))
+	assert.Len(t, task.executions, 2)
+	assert.Len(t, hook.executions, 1)
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/lithammer/fuzzysearch/fuzzy"
+)
+
+// Mode defines how the parameter metric_name is matched against the name of the metrics.
+type Mode string
+
+const (
+	// FuzzyMode matches the metrics containing the characters of the search, in the same order. It is case-sensitive.
+	FuzzyMode Mode = "fuzzy"
+	// FuzzyInsensitiveMode is like FuzzyMode, but ignoring the case.
+	FuzzyInsensitiveMode Mode = "fuzzy_insensitive"
+	PrefixMode           Mode = "prefix"
+	SuffixMode           Mode = "suffix"
+	ContainsMode         Mode = "contains"
+)
+
+var modes = []Mode{FuzzyMode, FuzzyInsensitiveMode, PrefixMode, SuffixMode, ContainsMode}
+
+func (m Mode) Verify() error {
+	if !slices.Contains(modes, m) {
+		return fmt.Errorf("unknown mode %q, it must be one of %q", m, modes)
+	}
+	return nil
+}
+
+func (m Mode) Match(search string, name string) bool {
+	switch m {
+	case FuzzyInsensitiveMode:
+		return fuzzy.MatchFold(search, name)
+	case PrefixMode:
+		return strings.HasPrefix(name, search)
+	case SuffixMode:
+		return strings.HasSuffix(name, search)
+	case ContainsMode:
+		return strings.Contains(name, search)
+	default:
+		return fuzzy.Match(search, name)
+	}
+}
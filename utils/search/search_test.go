@@ -0,0 +1,47 @@
+// Copyright 2024 The Perses Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMode(t *testing.T) {
+	testSuite := []struct {
+		title    string
+		mode     Mode
+		search   string
+		name     string
+		expected bool
+	}{
+		{title: "fuzzy", mode: FuzzyMode, search: "htreq", name: "http_requests_total", expected: true},
+		{title: "fuzzy is case-sensitive", mode: FuzzyMode, search: "http", name: "HTTP_requests", expected: false},
+		{title: "fuzzy_insensitive", mode: FuzzyInsensitiveMode, search: "http", name: "HTTP_requests", expected: true},
+		{title: "prefix", mode: PrefixMode, search: "http_", name: "http_requests_total", expected: true},
+		{title: "prefix not matching", mode: PrefixMode, search: "requests", name: "http_requests_total", expected: false},
+		{title: "suffix", mode: SuffixMode, search: "_total", name: "http_requests_total", expected: true},
+		{title: "suffix not matching", mode: SuffixMode, search: "http", name: "http_requests_total", expected: false},
+		{title: "contains", mode: ContainsMode, search: "requests", name: "http_requests_total", expected: true},
+		{title: "contains is not fuzzy", mode: ContainsMode, search: "htreq", name: "http_requests_total", expected: false},
+	}
+	for _, test := range testSuite {
+		t.Run(test.title, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.mode.Match(test.search, test.name))
+		})
+	}
+	assert.NoError(t, ContainsMode.Verify())
+	assert.EqualError(t, Mode("regex").Verify(), `unknown mode "regex", it must be one of ["fuzzy" "fuzzy_insensitive" "prefix" "suffix" "contains"]`)
+}